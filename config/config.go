@@ -17,7 +17,9 @@ limitations under the License.
 package config
 
 import (
+	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 
 	yaml "gopkg.in/yaml.v2"
@@ -26,6 +28,12 @@ import (
 const (
 	// currentVersion represents the current configuration version.
 	currentVersion = "v2.0.0"
+
+	// defaultMetricsEndpoint represents the default Prometheus metrics endpoint of the health check server.
+	defaultMetricsEndpoint = "/metrics"
+
+	// defaultReadinessEndpoint represents the default readiness endpoint of the health check server.
+	defaultReadinessEndpoint = "/readyz"
 )
 
 // Config represents the configuration (config.yaml) of client sidecar.
@@ -45,14 +53,35 @@ type Config struct {
 	// RoleToken represents the configuration to retrieve role token from the Athenz server.
 	RoleToken RoleToken `yaml:"roleToken"`
 
+	// IDToken represents the configuration to retrieve OIDC ID token from the Athenz server.
+	IDToken IDToken `yaml:"idToken"`
+
 	// ServiceCert represents the configuration to retrieve short-lived X.509 service certificates from the Athenz server.
 	ServiceCert ServiceCert `yaml:"serviceCert"`
 
+	// RoleCert represents the configuration to retrieve short-lived X.509 role certificates from the Athenz server.
+	RoleCert RoleCert `yaml:"roleCert"`
+
+	// AWSCredentials represents the configuration to retrieve AWS temporary credentials of the Athenz-managed AWS roles from the Athenz server.
+	AWSCredentials AWSCredentials `yaml:"awsCredentials"`
+
+	// Verify represents the configuration to verify the role tokens and the access tokens with the public keys of the Athenz server.
+	Verify Verify `yaml:"verify"`
+
 	// Proxy represents the configuration of the forward proxy that automatically injects N-token or role token to the requests.
 	Proxy Proxy `yaml:"proxy"`
 
 	// Log represents the logger configuration.
 	Log Log `yaml:"log"`
+
+	// Reload represents the configuration file hot reload configuration.
+	Reload Reload `yaml:"reload"`
+
+	// Identities represents the additional Athenz identities served by the client sidecar, which are selected by the callers per request.
+	Identities []Identity `yaml:"identities"`
+
+	// CacheSnapshot represents the configuration to persist the token and certificate caches to the local file.
+	CacheSnapshot CacheSnapshot `yaml:"cacheSnapshot"`
 }
 
 // Server represents the client sidecar and the health check server configuration.
@@ -72,6 +101,9 @@ type Server struct {
 	// ShutdownDelay represents the delay duration between the health check server shutdown and the client sidecar server shutdown.
 	ShutdownDelay string `yaml:"shutdownDelay"`
 
+	// PrefetchTimeout represents the maximum duration to fetch the prefetch tokens and the service certificate on startup and reload. Default: 30s.
+	PrefetchTimeout string `yaml:"prefetchTimeout"`
+
 	// TLS represents the TLS configuration of the client sidecar server.
 	TLS TLS `yaml:"tls"`
 
@@ -104,6 +136,12 @@ type HealthCheck struct {
 
 	// Endpoint represents the health check endpoint (pattern).
 	Endpoint string `yaml:"endpoint"`
+
+	// MetricsEndpoint represents the Prometheus metrics endpoint (pattern). Default: "/metrics".
+	MetricsEndpoint string `yaml:"metricsEndpoint"`
+
+	// ReadinessEndpoint represents the readiness endpoint (pattern), which reports the availability of the credentials. Default: "/readyz".
+	ReadinessEndpoint string `yaml:"readinessEndpoint"`
 }
 
 // NToken represents the configuration to generate N-token for connecting to the Athenz server.
@@ -164,6 +202,39 @@ type AccessToken struct {
 
 	// Retry represents the retry configuration.
 	Retry Retry `yaml:"retry"`
+
+	// Grace represents the configuration to serve the cached access tokens while refreshing them in background.
+	Grace Grace `yaml:"grace"`
+
+	// NegativeCache represents the configuration to cache the client errors returned by the Athenz server.
+	NegativeCache NegativeCache `yaml:"negativeCache"`
+
+	// CacheLimit represents the limits of the access tokens cache.
+	CacheLimit CacheLimit `yaml:"cacheLimit"`
+
+	// Schedule represents the configuration to refresh each cached token by its remaining lifetime.
+	Schedule Schedule `yaml:"schedule"`
+
+	// RefreshPool represents the concurrency and the rate limit of the background refreshes.
+	RefreshPool RefreshPool `yaml:"refreshPool"`
+
+	// Prefetch represents the access tokens to fetch at startup and keep refreshed regardless of requests.
+	Prefetch []AccessTokenPrefetch `yaml:"prefetch"`
+}
+
+// AccessTokenPrefetch represents an access token to fetch at startup and keep refreshed.
+type AccessTokenPrefetch struct {
+	// Domain represents the Athenz domain of the access token.
+	Domain string `yaml:"domain"`
+
+	// Roles represents the Athenz role names of the access token. Empty implies all the roles in the domain.
+	Roles []string `yaml:"roles"`
+
+	// ProxyForPrincipal represents the principal to request the access token for.
+	ProxyForPrincipal string `yaml:"proxyForPrincipal"`
+
+	// Expiry represents the duration before the access token expires. Empty implies accessToken.expiry.
+	Expiry string `yaml:"expiry"`
 }
 
 // RoleToken represents the configuration to retrieve role token from the Athenz server.
@@ -194,6 +265,78 @@ type RoleToken struct {
 
 	// Retry represents the retry configuration.
 	Retry Retry `yaml:"retry"`
+
+	// Grace represents the configuration to serve the cached role tokens while refreshing them in background.
+	Grace Grace `yaml:"grace"`
+
+	// NegativeCache represents the configuration to cache the client errors returned by the Athenz server.
+	NegativeCache NegativeCache `yaml:"negativeCache"`
+
+	// CacheLimit represents the limits of the role tokens cache.
+	CacheLimit CacheLimit `yaml:"cacheLimit"`
+
+	// Schedule represents the configuration to refresh each cached token by its remaining lifetime.
+	Schedule Schedule `yaml:"schedule"`
+
+	// RefreshPool represents the concurrency and the rate limit of the background refreshes.
+	RefreshPool RefreshPool `yaml:"refreshPool"`
+
+	// Prefetch represents the role tokens to fetch at startup and keep refreshed regardless of requests.
+	Prefetch []RoleTokenPrefetch `yaml:"prefetch"`
+}
+
+// RoleTokenPrefetch represents a role token to fetch at startup and keep refreshed.
+type RoleTokenPrefetch struct {
+	// Domain represents the Athenz domain of the role token.
+	Domain string `yaml:"domain"`
+
+	// Roles represents the Athenz role names of the role token. Empty implies all the roles in the domain.
+	Roles []string `yaml:"roles"`
+
+	// ProxyForPrincipal represents the principal to request the role token for.
+	ProxyForPrincipal string `yaml:"proxyForPrincipal"`
+
+	// MinExpiry represents the minimum duration before the role token expires. Empty implies roleToken.expiry.
+	MinExpiry string `yaml:"minExpiry"`
+
+	// MaxExpiry represents the maximum duration before the role token expires. Empty implies unspecified.
+	MaxExpiry string `yaml:"maxExpiry"`
+}
+
+// IDToken represents the configuration to retrieve OIDC ID token from the Athenz server.
+type IDToken struct {
+	// Enable represents whether to enable retrieving endpoint.
+	Enable bool `yaml:"enable"`
+
+	// PrincipalAuthHeader represents the HTTP header for injecting N-token.
+	PrincipalAuthHeader string `yaml:"principalAuthHeader"`
+
+	// AthenzURL represents the Athenz API URL.
+	AthenzURL string `yaml:"athenzURL"`
+
+	// AthenzCAPath represents the Athenz CA certificate chain file path.
+	AthenzCAPath string `yaml:"athenzCAPath"`
+
+	// CertPath represents the client certificate file path.
+	CertPath string `yaml:"certPath"`
+
+	// CertKeyPath represents the client certificate's private key file path.
+	CertKeyPath string `yaml:"certKeyPath"`
+
+	// Expiry represents the duration before expires. Empty implies the default of the Athenz server.
+	Expiry string `yaml:"expiry"`
+
+	// RefreshPeriod represents the duration of the refresh period.
+	RefreshPeriod string `yaml:"refreshPeriod"`
+
+	// RedirectURI represents the redirect URI of the ID token requests, which is registered for the audience in Athenz. The requests may override it.
+	RedirectURI string `yaml:"redirectURI"`
+
+	// Retry represents the retry configuration.
+	Retry Retry `yaml:"retry"`
+
+	// RefreshPool represents the concurrency and the rate limit of the background refreshes.
+	RefreshPool RefreshPool `yaml:"refreshPool"`
 }
 
 // ServiceCert represents the configuration to retrieve short-lived X.509 service certificates from the Athenz server.
@@ -230,6 +373,150 @@ type ServiceCert struct {
 
 	// Subject represents the certificate subject field.
 	Subject Subject `yaml:"subject"`
+
+	// KeyType represents the type of the private key generated on each refresh, "ECDSA" or "RSA".
+	// The private key of nToken.privateKeyPath is used for all the certificates if it is empty,
+	// except that "ECDSA" is used if output.enable is true, so that the N-token signing key is never written to output.keyPath.
+	KeyType string `yaml:"keyType"`
+
+	// KeySize represents the size of the generated private key, the curve size (256, 384 or 521) for ECDSA or the bits (at least 2048) for RSA. Default: 256 for ECDSA, 2048 for RSA.
+	KeySize int `yaml:"keySize"`
+
+	// Output represents the configuration to write the certificate and the private key to files.
+	Output CertOutput `yaml:"output"`
+
+	// Retry represents the retry configuration after the scheduled refresh is failed.
+	// Attempts 0 means retrying until the refresh succeeds. Default Delay: 1m, MaxDelay: 10m.
+	Retry Retry `yaml:"retry"`
+}
+
+// RoleCert represents the configuration to retrieve short-lived X.509 role certificates from the Athenz server.
+// The role certificates are requested by the service identity of nToken.athenzDomain and nToken.serviceName.
+type RoleCert struct {
+	// Enable represents whether to enable retrieving endpoint.
+	Enable bool `yaml:"enable"`
+
+	// PrincipalAuthHeader represents the HTTP header for injecting N-token.
+	PrincipalAuthHeader string `yaml:"principalAuthHeader"`
+
+	// AthenzURL represents the Athenz API URL.
+	AthenzURL string `yaml:"athenzURL"`
+
+	// AthenzCAPath represents the Athenz CA certificate chain file path.
+	AthenzCAPath string `yaml:"athenzCAPath"`
+
+	// Expiry represents the duration before expires. Empty implies the default of the Athenz server.
+	Expiry string `yaml:"expiry"`
+
+	// RefreshPeriod represents the duration of the refresh period. Default: 1h.
+	RefreshPeriod string `yaml:"refreshPeriod"`
+
+	// ExpiryMargin represents the certificate ("Not After" field) expiry margin to refresh certificates beforehand. Default: 1h.
+	ExpiryMargin string `yaml:"expiryMargin"`
+
+	// DNSSuffix represents the domain of the email SAN identifying the principal, e.g. "athenz.cloud". It is required, since ZTS identifies the principal by the email SAN.
+	DNSSuffix string `yaml:"dnsSuffix"`
+
+	// Subject represents the certificate subject field.
+	Subject Subject `yaml:"subject"`
+
+	// KeyType represents the type of the private key generated for each role certificate, "ECDSA" or "RSA".
+	// The private key of nToken.privateKeyPath is used for all the certificates if it is empty.
+	KeyType string `yaml:"keyType"`
+
+	// KeySize represents the size of the generated private key, see ServiceCert.KeySize.
+	KeySize int `yaml:"keySize"`
+
+	// Retry represents the retry configuration of the background refreshes.
+	Retry Retry `yaml:"retry"`
+
+	// RefreshPool represents the concurrency and the rate limit of the background refreshes.
+	RefreshPool RefreshPool `yaml:"refreshPool"`
+}
+
+// AWSCredentials represents the configuration to retrieve AWS temporary credentials from the Athenz server.
+type AWSCredentials struct {
+	// Enable represents whether to enable retrieving endpoint.
+	Enable bool `yaml:"enable"`
+
+	// PrincipalAuthHeader represents the HTTP header for injecting N-token.
+	PrincipalAuthHeader string `yaml:"principalAuthHeader"`
+
+	// AthenzURL represents the Athenz API URL.
+	AthenzURL string `yaml:"athenzURL"`
+
+	// AthenzCAPath represents the Athenz CA certificate chain file path.
+	AthenzCAPath string `yaml:"athenzCAPath"`
+
+	// CertPath represents the client certificate file path.
+	CertPath string `yaml:"certPath"`
+
+	// CertKeyPath represents the client certificate's private key file path.
+	CertKeyPath string `yaml:"certKeyPath"`
+
+	// Expiry represents the duration of the AWS temporary credentials. Empty implies the default of the Athenz server.
+	Expiry string `yaml:"expiry"`
+
+	// RefreshPeriod represents the duration of the refresh period.
+	RefreshPeriod string `yaml:"refreshPeriod"`
+
+	// Retry represents the retry configuration.
+	Retry Retry `yaml:"retry"`
+
+	// RefreshPool represents the concurrency and the rate limit of the background refreshes.
+	RefreshPool RefreshPool `yaml:"refreshPool"`
+}
+
+// Verify represents the configuration to verify the role tokens and the access tokens locally.
+// The ZTS public keys and the JWKS are fetched from the Athenz server, and refreshed periodically.
+type Verify struct {
+	// Enable represents whether to enable verifying endpoint.
+	Enable bool `yaml:"enable"`
+
+	// AthenzURL represents the Athenz API URL.
+	AthenzURL string `yaml:"athenzURL"`
+
+	// AthenzCAPath represents the Athenz CA certificate chain file path.
+	AthenzCAPath string `yaml:"athenzCAPath"`
+
+	// Issuer represents the issuer of the access tokens, which must match their iss claim. Default: AthenzURL with the https scheme.
+	Issuer string `yaml:"issuer"`
+
+	// RefreshPeriod represents the duration of the refresh period of the public keys. Default: 1h.
+	RefreshPeriod string `yaml:"refreshPeriod"`
+
+	// Retry represents the retry configuration of the background refreshes.
+	Retry Retry `yaml:"retry"`
+}
+
+// CertOutput represents the configuration to write the service certificate, the CA certificate bundle and the private key to files after every successful refresh.
+type CertOutput struct {
+	// Enable represents whether to write the files.
+	Enable bool `yaml:"enable"`
+
+	// CertPath represents the file path of the certificate. The intermediate certificates are included if IntermediateCert is true.
+	CertPath string `yaml:"certPath"`
+
+	// CABundlePath represents the file path of the CA certificate bundle returned by Athenz. It is not written if it is empty.
+	CABundlePath string `yaml:"caBundlePath"`
+
+	// KeyPath represents the file path of the private key.
+	KeyPath string `yaml:"keyPath"`
+
+	// CertMode represents the file permission of the certificate and the CA certificate bundle in octal. Default: "0644".
+	CertMode string `yaml:"certMode"`
+
+	// KeyMode represents the file permission of the private key in octal. Default: "0600".
+	KeyMode string `yaml:"keyMode"`
+
+	// ReloadCommand represents the command to run with "/bin/sh -c" after the files are written.
+	ReloadCommand string `yaml:"reloadCommand"`
+
+	// Signal represents the signal name to send to the process in PIDFile after the files are written, e.g. "SIGHUP".
+	Signal string `yaml:"signal"`
+
+	// PIDFile represents the file path containing the process ID to send the signal.
+	PIDFile string `yaml:"pidFile"`
 }
 
 // Subject represents the certificate subject field.
@@ -260,6 +547,96 @@ type Proxy struct {
 
 	// BufferSize represents the forward proxy buffer size.
 	BufferSize uint64 `yaml:"bufferSize"`
+
+	// MTLS represents the configuration of the proxy authenticating to the upstream servers with the service certificate.
+	MTLS ProxyMTLS `yaml:"mtls"`
+
+	// Forward represents the configuration of the forward proxy for the clients using the client sidecar as the HTTP proxy.
+	Forward ForwardProxy `yaml:"forward"`
+
+	// Routes represents the upstream servers of the reverse proxy, selected by the path prefix or the host of the requests.
+	Routes []ProxyRoute `yaml:"routes"`
+}
+
+// ProxyRoute represents an upstream server of the reverse proxy, and the token to inject into the requests to it,
+// so that the applications can call the upstream server through the client sidecar without knowing about Athenz.
+type ProxyRoute struct {
+	// Name represents the unique name of the route, which is used as the metrics label.
+	Name string `yaml:"name"`
+
+	// Host represents the host of the requests to the client sidecar to select the route, e.g. "payments.local". Empty implies any host.
+	Host string `yaml:"host"`
+
+	// PathPrefix represents the path of the requests to select the route. The path ending with "/" matches all the paths under it, e.g. "/payments/". Default: "/".
+	PathPrefix string `yaml:"pathPrefix"`
+
+	// StripPrefix represents whether to remove PathPrefix from the path before forwarding the request.
+	StripPrefix bool `yaml:"stripPrefix"`
+
+	// Upstream represents the URL of the upstream server. The request path is appended to the URL path.
+	Upstream string `yaml:"upstream"`
+
+	// Token represents the type of the token to inject. Values: "roletoken", "accesstoken", "ntoken", or empty not to inject any token.
+	Token string `yaml:"token"`
+
+	// Domain represents the Athenz domain of the role token or the access token.
+	Domain string `yaml:"domain"`
+
+	// Roles represents the Athenz role names of the role token or the access token. Empty implies all the roles in the domain.
+	Roles []string `yaml:"roles"`
+
+	// ProxyForPrincipal represents the principal to request the role token or the access token for.
+	ProxyForPrincipal string `yaml:"proxyForPrincipal"`
+
+	// Header represents the HTTP header to set the token. The access token is set as the bearer token.
+	// Default: proxy.roleAuthHeader for the role token, "Authorization" for the access token, and proxy.principalAuthHeader for the N-token.
+	Header string `yaml:"header"`
+
+	// Timeout represents the timeout of the request to the upstream server, including the token retrieval. Default: server.timeout.
+	Timeout string `yaml:"timeout"`
+}
+
+// ForwardProxy represents the configuration of the forward proxy, which handles the absolute-form requests and the CONNECT requests
+// sent by the clients setting the client sidecar as the HTTP proxy, e.g. by HTTP_PROXY.
+type ForwardProxy struct {
+	// Enable represents whether to handle the forward proxy requests.
+	Enable bool `yaml:"enable"`
+
+	// AllowedHosts represents the destination hosts to forward the requests and the CONNECT tunnels to without tokens, in the same format as ForwardProxyRule.Host.
+	// The requests to the hosts neither in AllowedHosts nor in Rules are refused.
+	AllowedHosts []string `yaml:"allowedHosts"`
+
+	// Rules represents the tokens to inject into the requests, selected by the destination host. The first matched rule is used.
+	// The requests matched by a rule are forwarded over HTTPS, not to send the token in plain text.
+	// The requests to the other allowed hosts, and the CONNECT tunnels, are forwarded without tokens.
+	Rules []ForwardProxyRule `yaml:"rules"`
+}
+
+// ForwardProxyRule represents the token to inject into the forward proxy requests to the destination host.
+type ForwardProxyRule struct {
+	// Host represents the destination host, e.g. "api.foo", "api.foo:8080" or "*.foo". The host without port matches any port, and "*." matches any subdomain.
+	Host string `yaml:"host"`
+
+	// Token represents the type of the token to inject. Values: "roletoken", "accesstoken", "ntoken".
+	Token string `yaml:"token"`
+
+	// Domain represents the Athenz domain of the role token or the access token.
+	Domain string `yaml:"domain"`
+
+	// Roles represents the Athenz role names of the role token or the access token. Empty implies all the roles in the domain.
+	Roles []string `yaml:"roles"`
+
+	// ProxyForPrincipal represents the principal to request the role token or the access token for.
+	ProxyForPrincipal string `yaml:"proxyForPrincipal"`
+}
+
+// ProxyMTLS represents the configuration of the proxy presenting the service certificate as the client certificate to the mTLS-protected upstream servers.
+type ProxyMTLS struct {
+	// Enable represents whether to enable the mTLS proxy endpoint. It requires serviceCert.enable.
+	Enable bool `yaml:"enable"`
+
+	// CAPath represents the CA certificate bundle file path to verify the upstream servers, in addition to the system root CAs.
+	CAPath string `yaml:"caPath"`
 }
 
 // Log represents the logger configuration.
@@ -271,21 +648,146 @@ type Log struct {
 	Color bool `yaml:"color"`
 }
 
+// Reload represents the configuration file hot reload configuration.
+type Reload struct {
+	// Enable represents whether to watch the configuration file and reload the client sidecar when the file is changed.
+	// The client sidecar always reloads the configuration file when it receives SIGHUP.
+	Enable bool `yaml:"enable"`
+
+	// CheckPeriod represents the duration between each configuration file check. Default: 10s.
+	CheckPeriod string `yaml:"checkPeriod"`
+}
+
+// CacheSnapshot represents the configuration to persist the role token, access token and svccert caches to an encrypted local file.
+// The cached entries still valid are restored on startup, so that the restarted client sidecars do not fetch all the tokens at once.
+type CacheSnapshot struct {
+	// Enable represents whether to save the caches to the snapshot file and restore them on startup.
+	Enable bool `yaml:"enable"`
+
+	// Path represents the snapshot file path.
+	Path string `yaml:"path"`
+
+	// KeyPath represents the file path of the secret to encrypt the snapshot file. The encryption key is derived from the file content.
+	KeyPath string `yaml:"keyPath"`
+
+	// Period represents the duration between each snapshot. The snapshot is also saved on shutdown. Default: 5m.
+	Period string `yaml:"period"`
+}
+
+// Identity represents an additional Athenz identity. The access token, role token and svccert services of the identity
+// use the same configuration as the default identity, except the N-token, the client certificate and the svccert output.
+type Identity struct {
+	// Name represents the identity name specified by the callers.
+	Name string `yaml:"name"`
+
+	// NToken represents the configuration to generate N-token of the identity.
+	NToken NToken `yaml:"nToken"`
+
+	// CertPath represents the client certificate file path of the identity to retrieve access token and role token.
+	CertPath string `yaml:"certPath"`
+
+	// CertKeyPath represents the client certificate key file path of the identity to retrieve access token and role token.
+	CertKeyPath string `yaml:"certKeyPath"`
+
+	// ServiceCertOutput represents the configuration to write the service certificate of the identity to files.
+	ServiceCertOutput CertOutput `yaml:"serviceCertOutput"`
+}
+
 // Retry represents the retry configuration.
 type Retry struct {
 	// Attempts represents number of attempts to retry.
 	Attempts int `yaml:"attempts"`
 
-	// Delay represents the duration between each retry.
+	// Delay represents the duration before the first retry. The duration is multiplied by Multiplier after each retry.
 	Delay string `yaml:"delay"`
+
+	// MaxDelay represents the maximum duration between each retry. A longer Retry-After from the Athenz server is still honoured.
+	MaxDelay string `yaml:"maxDelay"`
+
+	// Multiplier represents the factor to increase the delay after each retry. 1 means constant delay. Default: 2.
+	Multiplier float64 `yaml:"multiplier"`
+
+	// Jitter represents the ratio of the random variation of each delay, from 0 to 1. Default: 0.2.
+	Jitter float64 `yaml:"jitter"`
+}
+
+// Grace represents the stale-while-revalidate configuration of the token cache.
+// The cached token past its refresh point is returned immediately while it is refreshed in background, until the token expires.
+type Grace struct {
+	// Enable represents whether to serve the cached token past its refresh point.
+	Enable bool `yaml:"enable"`
+
+	// RefreshBefore represents the duration before the token expiry to start refreshing the token in background. Default: 1m.
+	RefreshBefore string `yaml:"refreshBefore"`
+}
+
+// NegativeCache represents the configuration to cache the client errors returned by the Athenz server, e.g. 403 when the principal is not a member of the role.
+// The cached error is returned without requesting the Athenz server until it expires.
+type NegativeCache struct {
+	// Enable represents whether to cache the client errors.
+	Enable bool `yaml:"enable"`
+
+	// TTL represents the duration to cache the client error. Default: 10s.
+	// 429 is cached for the duration of its Retry-After header instead, and not cached without it.
+	TTL string `yaml:"ttl"`
+}
+
+// CacheLimit represents the limits of the token cache, so that the tokens no one requests any longer are not kept and refreshed forever.
+// The prefetch tokens are never evicted, and do not count toward MaxEntries.
+type CacheLimit struct {
+	// MaxEntries represents the maximum number of the cached tokens. The least recently requested token is evicted when it is exceeded. 0 implies unlimited.
+	MaxEntries int `yaml:"maxEntries"`
+
+	// IdlePeriods represents the number of the refresh periods to keep the token not requested. The idle token is dropped instead of refreshed. 0 implies never dropped.
+	IdlePeriods int `yaml:"idlePeriods"`
+}
+
+// Schedule represents the configuration to refresh each cached token at a fraction of its lifetime, instead of refreshing all the cached tokens every refresh period.
+// The refresh period is still used to drop the idle tokens and to fetch the missing prefetch tokens.
+type Schedule struct {
+	// Enable represents whether to refresh each cached token by its remaining lifetime.
+	Enable bool `yaml:"enable"`
+
+	// Ratio represents the fraction of the remaining lifetime after which the token is refreshed, from 0 to 1. Default: 0.75.
+	Ratio float64 `yaml:"ratio"`
+
+	// Jitter represents the random variation of the refresh time as a fraction of the remaining lifetime, from 0 to 1. Default: 0.1.
+	Jitter float64 `yaml:"jitter"`
+}
+
+// RefreshPool represents the workers refreshing the cached tokens in background, so that a slow domain does not delay the refreshes of the other tokens.
+type RefreshPool struct {
+	// Concurrency represents the maximum number of the tokens refreshed at the same time. Default: 4.
+	Concurrency int `yaml:"concurrency"`
+
+	// RateLimit represents the maximum number of the requests per second sent to the Athenz server by the background refreshes, including the retries. 0 implies unlimited.
+	RateLimit float64 `yaml:"rateLimit"`
+
+	// Burst represents the maximum number of the requests sent at once within the rate limit. Default: 1.
+	Burst int `yaml:"burst"`
 }
 
 // New returns *Config or error when decode the configuration file to actually *Config struct.
+// The unknown keys are ignored, and they are reported by UnknownKeys and Validate.
 func New(path string) (*Config, error) {
-	f, err := os.OpenFile(path, os.O_RDONLY, 0600)
+	cfg, _, err := decode(path)
+	return cfg, err
+}
+
+// decode decodes the configuration file, and returns the configuration with the warnings of the unknown keys found in the file.
+func decode(path string) (*Config, []error, error) {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, nil, err
 	}
+	v := new(validator)
+	v.unknownKeys(raw, reflect.TypeOf(Config{}), "")
+
 	cfg := &Config{
 		NToken: NToken{
 			Enable: true,
@@ -297,11 +799,32 @@ func New(path string) (*Config, error) {
 			Enable: true,
 		},
 	}
-	err = yaml.NewDecoder(f).Decode(&cfg)
-	if err != nil {
-		return nil, err
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, v.errs, err
 	}
-	return cfg, nil
+	return cfg, v.errs, nil
+}
+
+// IdentityConfig returns the configuration of the services for the additional identity, which is derived from cfg.
+// The prefetch configurations are not inherited, since they are declared for the default identity.
+func IdentityConfig(cfg Config, id Identity) Config {
+	c := cfg
+	c.NToken = id.NToken
+	c.AccessToken.CertPath = id.CertPath
+	c.AccessToken.CertKeyPath = id.CertKeyPath
+	c.AccessToken.Prefetch = nil
+	c.RoleToken.CertPath = id.CertPath
+	c.RoleToken.CertKeyPath = id.CertKeyPath
+	c.RoleToken.Prefetch = nil
+	c.IDToken.CertPath = id.CertPath
+	c.IDToken.CertKeyPath = id.CertKeyPath
+	c.AWSCredentials.CertPath = id.CertPath
+	c.AWSCredentials.CertKeyPath = id.CertKeyPath
+	c.ServiceCert.Output = id.ServiceCertOutput
+	// the public keys of the Athenz server are shared by all the identities
+	c.Verify = Verify{}
+	c.Identities = nil
+	return c
 }
 
 // GetVersion returns the current version of the client sidecar version.
@@ -181,6 +181,25 @@ func TestNew(t *testing.T) {
 			},
 			wantErr: fmt.Errorf("yaml: line "),
 		},
+		{
+			name: "Read config file with unknown keys",
+			args: args{
+				path: "../test/data/unknown_key_config.yaml",
+			},
+			want: &Config{
+				Version: "v2.0.0",
+				NToken: NToken{
+					Enable: false,
+				},
+				RoleToken: RoleToken{
+					Enable:        false,
+					RefreshPeriod: "30m",
+				},
+				Proxy: Proxy{
+					Enable: false,
+				},
+			},
+		},
 		{
 			name: "Read non-existing config file",
 			args: args{
@@ -273,6 +292,108 @@ func TestGetActualValue(t *testing.T) {
 	}
 }
 
+func TestIdentityConfig(t *testing.T) {
+	type args struct {
+		cfg Config
+		id  Identity
+	}
+	tests := []struct {
+		name string
+		args args
+		want Config
+	}{
+		{
+			name: "Derive identity config from the default config",
+			args: args{
+				cfg: Config{
+					Version: "v2.0.0",
+					NToken: NToken{
+						AthenzDomain: "domain",
+						ServiceName:  "service",
+					},
+					AccessToken: AccessToken{
+						Enable:   true,
+						CertPath: "default.crt",
+						Prefetch: []AccessTokenPrefetch{
+							{Domain: "domain"},
+						},
+					},
+					RoleToken: RoleToken{
+						Enable:      true,
+						CertKeyPath: "default.key",
+						Prefetch: []RoleTokenPrefetch{
+							{Domain: "domain"},
+						},
+					},
+					ServiceCert: ServiceCert{
+						Enable: true,
+						Output: CertOutput{
+							Enable: true,
+						},
+					},
+					Verify: Verify{
+						Enable: true,
+					},
+					Identities: []Identity{
+						{Name: "other"},
+					},
+				},
+				id: Identity{
+					Name: "other",
+					NToken: NToken{
+						AthenzDomain: "other-domain",
+						ServiceName:  "other-service",
+					},
+					CertPath:    "other.crt",
+					CertKeyPath: "other.key",
+					ServiceCertOutput: CertOutput{
+						CertPath: "other.pem",
+					},
+				},
+			},
+			want: Config{
+				Version: "v2.0.0",
+				NToken: NToken{
+					AthenzDomain: "other-domain",
+					ServiceName:  "other-service",
+				},
+				AccessToken: AccessToken{
+					Enable:      true,
+					CertPath:    "other.crt",
+					CertKeyPath: "other.key",
+				},
+				RoleToken: RoleToken{
+					Enable:      true,
+					CertPath:    "other.crt",
+					CertKeyPath: "other.key",
+				},
+				IDToken: IDToken{
+					CertPath:    "other.crt",
+					CertKeyPath: "other.key",
+				},
+				ServiceCert: ServiceCert{
+					Enable: true,
+					Output: CertOutput{
+						CertPath: "other.pem",
+					},
+				},
+				AWSCredentials: AWSCredentials{
+					CertPath:    "other.crt",
+					CertKeyPath: "other.key",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IdentityConfig(tt.args.cfg, tt.args.id); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IdentityConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_checkPrefixAndSuffix(t *testing.T) {
 	type args struct {
 		str  string
@@ -0,0 +1,775 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ValidationError represents an invalid configuration value with its YAML path.
+type ValidationError struct {
+	// Path represents the YAML path of the invalid value, e.g. "roleToken.retry.delay".
+	Path string
+
+	// Message represents the reason why the value is invalid.
+	Message string
+
+	// Warning represents that the value is likely a mistake, e.g. an unknown key, but it does not prevent the client sidecar from starting.
+	// It is an error only in the -validate mode.
+	Warning bool
+}
+
+// Error returns the YAML path and the reason of the invalid value.
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// validator collects the validation errors.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) warn(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+		Warning: true,
+	})
+}
+
+// Validate decodes the configuration file strictly, and returns all the problems found in the configuration file, including the warnings.
+// Unknown keys are reported in addition to the problems reported by ValidateConfig.
+func Validate(path string) []error {
+	cfg, unknown, err := decode(path)
+	if err != nil {
+		if te, ok := err.(*yaml.TypeError); ok {
+			v := &validator{
+				errs: unknown,
+			}
+			for _, msg := range te.Errors {
+				v.add("", "%s", msg)
+			}
+			return v.errs
+		}
+		return append(unknown, err)
+	}
+
+	return append(unknown, ValidateConfig(*cfg)...)
+}
+
+// ValidateConfig returns all the problems found in the configuration, e.g. invalid durations, invalid URLs, non-existing files, conflicting ports and inconsistent Enable flags.
+// The version mismatch is reported as a warning. The values are read with GetActualValue, so the environment variables must be set before validation.
+func ValidateConfig(cfg Config) []error {
+	v := new(validator)
+
+	if cfg.Version != GetVersion() {
+		v.warn("version", "must be %q, got %q", GetVersion(), cfg.Version)
+	}
+
+	v.server(cfg.Server)
+	v.nToken("nToken", cfg)
+	if cfg.AccessToken.Enable {
+		v.tokenService("accessToken", requiresNToken(cfg), cfg.AccessToken.PrincipalAuthHeader, cfg.AccessToken.AthenzURL, cfg.AccessToken.AthenzCAPath,
+			cfg.AccessToken.CertPath, cfg.AccessToken.CertKeyPath, cfg.AccessToken.Expiry, cfg.AccessToken.RefreshPeriod, cfg.AccessToken.Retry)
+		v.grace("accessToken.grace", cfg.AccessToken.Grace)
+		v.negativeCache("accessToken.negativeCache", cfg.AccessToken.NegativeCache)
+		v.cacheLimit("accessToken.cacheLimit", cfg.AccessToken.CacheLimit)
+		v.schedule("accessToken.schedule", cfg.AccessToken.Schedule)
+		v.refreshPool("accessToken.refreshPool", cfg.AccessToken.RefreshPool)
+		for i, p := range cfg.AccessToken.Prefetch {
+			path := fmt.Sprintf("accessToken.prefetch[%d]", i)
+			v.prefetch(path, p.Domain, p.Roles)
+			v.duration(path+".expiry", p.Expiry, false)
+		}
+	}
+	if cfg.RoleToken.Enable {
+		v.tokenService("roleToken", requiresNToken(cfg), cfg.RoleToken.PrincipalAuthHeader, cfg.RoleToken.AthenzURL, cfg.RoleToken.AthenzCAPath,
+			cfg.RoleToken.CertPath, cfg.RoleToken.CertKeyPath, cfg.RoleToken.Expiry, cfg.RoleToken.RefreshPeriod, cfg.RoleToken.Retry)
+		v.grace("roleToken.grace", cfg.RoleToken.Grace)
+		v.negativeCache("roleToken.negativeCache", cfg.RoleToken.NegativeCache)
+		v.cacheLimit("roleToken.cacheLimit", cfg.RoleToken.CacheLimit)
+		v.schedule("roleToken.schedule", cfg.RoleToken.Schedule)
+		v.refreshPool("roleToken.refreshPool", cfg.RoleToken.RefreshPool)
+		for i, p := range cfg.RoleToken.Prefetch {
+			path := fmt.Sprintf("roleToken.prefetch[%d]", i)
+			v.prefetch(path, p.Domain, p.Roles)
+			min, minOk := v.duration(path+".minExpiry", p.MinExpiry, false)
+			max, maxOk := v.duration(path+".maxExpiry", p.MaxExpiry, false)
+			if minOk && maxOk && p.MinExpiry != "" && p.MaxExpiry != "" && min > max {
+				v.add(path+".minExpiry", "must not be greater than %s.maxExpiry", path)
+			}
+		}
+	}
+	if cfg.IDToken.Enable {
+		v.tokenService("idToken", requiresNToken(cfg), cfg.IDToken.PrincipalAuthHeader, cfg.IDToken.AthenzURL, cfg.IDToken.AthenzCAPath,
+			cfg.IDToken.CertPath, cfg.IDToken.CertKeyPath, cfg.IDToken.Expiry, cfg.IDToken.RefreshPeriod, cfg.IDToken.Retry)
+		v.refreshPool("idToken.refreshPool", cfg.IDToken.RefreshPool)
+	}
+	v.serviceCert(cfg)
+	v.roleCert(cfg)
+	v.awsCredentials(cfg)
+	v.verify(cfg)
+	v.proxy(cfg)
+	v.identities(cfg)
+
+	switch cfg.Log.Level {
+	case "", "fatal", "error", "warn", "info", "debug":
+	default:
+		v.add("log.level", "must be one of \"debug\", \"info\", \"warn\", \"error\", \"fatal\" or empty, got %q", cfg.Log.Level)
+	}
+
+	if cfg.Reload.Enable {
+		v.duration("reload.checkPeriod", cfg.Reload.CheckPeriod, false)
+	}
+
+	if cfg.CacheSnapshot.Enable {
+		if cfg.CacheSnapshot.Path == "" {
+			v.add("cacheSnapshot.path", "must not be empty")
+		}
+		v.requiredFile("cacheSnapshot.keyPath", cfg.CacheSnapshot.KeyPath)
+		if p, ok := v.duration("cacheSnapshot.period", cfg.CacheSnapshot.Period, false); ok && cfg.CacheSnapshot.Period != "" && p == 0 {
+			v.add("cacheSnapshot.period", "must be positive")
+		}
+	}
+
+	return v.errs
+}
+
+func (v *validator) server(cfg Server) {
+	v.duration("server.timeout", cfg.Timeout, false)
+	v.duration("server.shutdownTimeout", cfg.ShutdownTimeout, false)
+	v.duration("server.shutdownDelay", cfg.ShutdownDelay, false)
+	if d, ok := v.duration("server.prefetchTimeout", cfg.PrefetchTimeout, false); ok && cfg.PrefetchTimeout != "" && d <= 0 {
+		v.add("server.prefetchTimeout", "must be positive")
+	}
+	v.port("server.port", cfg.Port)
+	v.port("server.healthCheck.port", cfg.HealthCheck.Port)
+
+	if cfg.HealthCheck.Port > 0 {
+		if cfg.HealthCheck.Port == cfg.Port && overlaps(cfg.Address, cfg.HealthCheck.Address) {
+			v.add("server.healthCheck.port", "conflicts with server.port %d", cfg.Port)
+		}
+		if cfg.HealthCheck.Endpoint == "" {
+			v.add("server.healthCheck.endpoint", "must not be empty when the health check server is enabled")
+		}
+		// the endpoints are compared with their defaults, since the conflicting endpoint is not registered
+		metrics, readiness := cfg.HealthCheck.MetricsEndpoint, cfg.HealthCheck.ReadinessEndpoint
+		if metrics == "" {
+			metrics = defaultMetricsEndpoint
+		}
+		if readiness == "" {
+			readiness = defaultReadinessEndpoint
+		}
+		if metrics == cfg.HealthCheck.Endpoint {
+			v.add("server.healthCheck.metricsEndpoint", "conflicts with server.healthCheck.endpoint %q", cfg.HealthCheck.Endpoint)
+		}
+		switch readiness {
+		case cfg.HealthCheck.Endpoint:
+			v.add("server.healthCheck.readinessEndpoint", "conflicts with server.healthCheck.endpoint %q", cfg.HealthCheck.Endpoint)
+		case metrics:
+			v.add("server.healthCheck.readinessEndpoint", "conflicts with server.healthCheck.metricsEndpoint %q", metrics)
+		}
+	}
+
+	if cfg.TLS.Enable {
+		v.requiredFile("server.tls.certPath", cfg.TLS.CertPath)
+		v.requiredFile("server.tls.keyPath", cfg.TLS.KeyPath)
+		v.file("server.tls.caPath", cfg.TLS.CAPath)
+	}
+}
+
+func (v *validator) nToken(prefix string, cfg Config) {
+	if !requiresNToken(cfg) {
+		return
+	}
+
+	if cfg.NToken.AthenzDomain == "" || GetActualValue(cfg.NToken.AthenzDomain) == "" {
+		v.add(prefix+".athenzDomain", "must not be empty")
+	}
+	if cfg.NToken.ServiceName == "" || GetActualValue(cfg.NToken.ServiceName) == "" {
+		v.add(prefix+".serviceName", "must not be empty")
+	}
+	v.duration(prefix+".expiry", cfg.NToken.Expiry, true)
+	v.duration(prefix+".refreshPeriod", cfg.NToken.RefreshPeriod, true)
+
+	if cfg.NToken.ExistingTokenPath != "" {
+		v.file(prefix+".privateKeyPath", cfg.NToken.PrivateKeyPath)
+	} else {
+		v.requiredFile(prefix+".privateKeyPath", cfg.NToken.PrivateKeyPath)
+	}
+}
+
+// identities validates the additional identities with the configuration derived for each of them.
+func (v *validator) identities(cfg Config) {
+	names := make(map[string]bool, len(cfg.Identities))
+	for i, id := range cfg.Identities {
+		path := fmt.Sprintf("identities[%d]", i)
+		switch {
+		case id.Name == "":
+			v.add(path+".name", "must not be empty")
+		case names[id.Name]:
+			v.add(path+".name", "duplicated identity name %q", id.Name)
+		}
+		names[id.Name] = true
+
+		idCfg := IdentityConfig(cfg, id)
+		v.nToken(path+".nToken", idCfg)
+		if id.CertPath != "" || id.CertKeyPath != "" {
+			v.requiredFile(path+".certPath", id.CertPath)
+			v.requiredFile(path+".certKeyPath", id.CertKeyPath)
+		}
+		if cfg.ServiceCert.Enable && id.ServiceCertOutput.Enable {
+			v.certOutput(path+".serviceCertOutput", id.ServiceCertOutput)
+		}
+	}
+}
+
+// tokenService validates the common configuration of the access token, role token and ID token services.
+// N-token has priority over the client certificate, so the client certificate is validated only if N-token is not used.
+func (v *validator) tokenService(prefix string, useNToken bool, principalAuthHeader, athenzURL, athenzCAPath, certPath, certKeyPath, expiry, refreshPeriod string, retry Retry) {
+	v.athenzURL(prefix+".athenzURL", athenzURL, false)
+	v.file(prefix+".athenzCAPath", athenzCAPath)
+
+	if useNToken {
+		if principalAuthHeader == "" {
+			v.add(prefix+".principalAuthHeader", "must not be empty when N-token is used")
+		}
+	} else {
+		v.requiredFile(prefix+".certPath", certPath)
+		v.requiredFile(prefix+".certKeyPath", certKeyPath)
+	}
+
+	exp, expOk := v.duration(prefix+".expiry", expiry, false)
+	rp, rpOk := v.duration(prefix+".refreshPeriod", refreshPeriod, false)
+	if expOk && rpOk && expiry != "" && refreshPeriod != "" && rp > exp {
+		v.add(prefix+".refreshPeriod", "must not be greater than %s.expiry", prefix)
+	}
+
+	v.retry(prefix+".retry", retry)
+}
+
+// retry validates the retry configuration.
+func (v *validator) retry(prefix string, retry Retry) {
+	if retry.Attempts < 0 {
+		v.add(prefix+".attempts", "must not be negative")
+	}
+	delay, delayOk := v.duration(prefix+".delay", retry.Delay, false)
+	max, maxOk := v.duration(prefix+".maxDelay", retry.MaxDelay, false)
+	if delayOk && maxOk && retry.Delay != "" && retry.MaxDelay != "" && delay > max {
+		v.add(prefix+".delay", "must not be greater than %s.maxDelay", prefix)
+	}
+	if retry.Multiplier != 0 && retry.Multiplier < 1 {
+		v.add(prefix+".multiplier", "must be at least 1, got %v", retry.Multiplier)
+	}
+	if retry.Jitter < 0 || retry.Jitter > 1 {
+		v.add(prefix+".jitter", "must be between 0 and 1, got %v", retry.Jitter)
+	}
+}
+
+// grace validates the stale-while-revalidate configuration.
+func (v *validator) grace(prefix string, grace Grace) {
+	if !grace.Enable {
+		return
+	}
+	if d, ok := v.duration(prefix+".refreshBefore", grace.RefreshBefore, false); ok && grace.RefreshBefore != "" && d == 0 {
+		v.add(prefix+".refreshBefore", "must be positive")
+	}
+}
+
+// negativeCache validates the negative cache configuration.
+func (v *validator) negativeCache(prefix string, nc NegativeCache) {
+	if !nc.Enable {
+		return
+	}
+	if d, ok := v.duration(prefix+".ttl", nc.TTL, false); ok && nc.TTL != "" && d == 0 {
+		v.add(prefix+".ttl", "must be positive")
+	}
+}
+
+// cacheLimit validates the token cache limits.
+func (v *validator) cacheLimit(prefix string, cl CacheLimit) {
+	if cl.MaxEntries < 0 {
+		v.add(prefix+".maxEntries", "must not be negative")
+	}
+	if cl.IdlePeriods < 0 {
+		v.add(prefix+".idlePeriods", "must not be negative")
+	}
+}
+
+// schedule validates the token refresh schedule.
+func (v *validator) schedule(prefix string, sc Schedule) {
+	if !sc.Enable {
+		return
+	}
+	if sc.Ratio < 0 || sc.Ratio > 1 {
+		v.add(prefix+".ratio", "must be between 0 and 1, got %v", sc.Ratio)
+	}
+	if sc.Jitter < 0 || sc.Jitter > 1 {
+		v.add(prefix+".jitter", "must be between 0 and 1, got %v", sc.Jitter)
+	}
+}
+
+// refreshPool validates the concurrency and the rate limit of the background refreshes.
+func (v *validator) refreshPool(prefix string, rp RefreshPool) {
+	if rp.Concurrency < 0 {
+		v.add(prefix+".concurrency", "must not be negative")
+	}
+	if rp.RateLimit < 0 {
+		v.add(prefix+".rateLimit", "must not be negative")
+	}
+	if rp.Burst < 0 {
+		v.add(prefix+".burst", "must not be negative")
+	}
+}
+
+// prefetch validates the domain and the roles of the access token and role token prefetch entries, and the proxy tokens.
+func (v *validator) prefetch(path, domain string, roles []string) {
+	if domain == "" {
+		v.add(path+".domain", "must not be empty")
+	}
+	for i, r := range roles {
+		if r == "" || strings.Contains(r, ",") {
+			v.add(fmt.Sprintf("%s.roles[%d]", path, i), "must be a non-empty role name, got %q", r)
+		}
+	}
+}
+
+func (v *validator) serviceCert(cfg Config) {
+	if !cfg.ServiceCert.Enable {
+		return
+	}
+
+	sc := cfg.ServiceCert
+	v.athenzURL("serviceCert.athenzURL", sc.AthenzURL, true)
+	v.file("serviceCert.athenzCAPath", sc.AthenzCAPath)
+	if sc.PrincipalAuthHeader == "" {
+		v.add("serviceCert.principalAuthHeader", "must not be empty")
+	}
+
+	v.duration("serviceCert.expiry", sc.Expiry, false)
+	v.duration("serviceCert.refreshPeriod", sc.RefreshPeriod, false)
+	v.duration("serviceCert.expiryMargin", sc.ExpiryMargin, false)
+	v.retry("serviceCert.retry", sc.Retry)
+
+	v.keyType("serviceCert", sc.KeyType, sc.KeySize)
+
+	if sc.Output.Enable {
+		v.certOutput("serviceCert.output", sc.Output)
+	}
+}
+
+// roleCert validates the role certificate configuration. The private key and the principal are validated with the N-token configuration.
+func (v *validator) roleCert(cfg Config) {
+	if !cfg.RoleCert.Enable {
+		return
+	}
+
+	rc := cfg.RoleCert
+	v.athenzURL("roleCert.athenzURL", rc.AthenzURL, true)
+	v.file("roleCert.athenzCAPath", rc.AthenzCAPath)
+	if rc.PrincipalAuthHeader == "" {
+		v.add("roleCert.principalAuthHeader", "must not be empty")
+	}
+	if rc.DNSSuffix == "" {
+		v.add("roleCert.dnsSuffix", "must not be empty")
+	}
+
+	v.duration("roleCert.expiry", rc.Expiry, false)
+	v.duration("roleCert.refreshPeriod", rc.RefreshPeriod, false)
+	v.duration("roleCert.expiryMargin", rc.ExpiryMargin, false)
+	v.retry("roleCert.retry", rc.Retry)
+	v.refreshPool("roleCert.refreshPool", rc.RefreshPool)
+	v.keyType("roleCert", rc.KeyType, rc.KeySize)
+}
+
+// awsCredentials validates the AWS temporary credentials configuration. The duration of the credentials must be accepted by AWS STS.
+func (v *validator) awsCredentials(cfg Config) {
+	if !cfg.AWSCredentials.Enable {
+		return
+	}
+
+	ac := cfg.AWSCredentials
+	v.tokenService("awsCredentials", requiresNToken(cfg), ac.PrincipalAuthHeader, ac.AthenzURL, ac.AthenzCAPath,
+		ac.CertPath, ac.CertKeyPath, ac.Expiry, ac.RefreshPeriod, ac.Retry)
+	v.refreshPool("awsCredentials.refreshPool", ac.RefreshPool)
+
+	if exp, err := time.ParseDuration(ac.Expiry); err == nil && (exp < 15*time.Minute || exp > 12*time.Hour) {
+		v.add("awsCredentials.expiry", "must be between 15m and 12h, got %s", ac.Expiry)
+	}
+}
+
+// verify validates the token verification configuration. The public keys are fetched without the credentials.
+func (v *validator) verify(cfg Config) {
+	if !cfg.Verify.Enable {
+		return
+	}
+
+	vc := cfg.Verify
+	v.athenzURL("verify.athenzURL", vc.AthenzURL, true)
+	v.file("verify.athenzCAPath", vc.AthenzCAPath)
+	v.duration("verify.refreshPeriod", vc.RefreshPeriod, false)
+	v.retry("verify.retry", vc.Retry)
+}
+
+// keyType validates the type and the size of the private key generated by the client sidecar.
+func (v *validator) keyType(prefix, keyType string, size int) {
+	switch keyType {
+	case "":
+	case "ECDSA":
+		switch size {
+		case 0, 256, 384, 521:
+		default:
+			v.add(prefix+".keySize", "must be 256, 384 or 521 for ECDSA, got %d", size)
+		}
+	case "RSA":
+		if size != 0 && size < 2048 {
+			v.add(prefix+".keySize", "must be at least 2048 for RSA, got %d", size)
+		}
+	default:
+		v.add(prefix+".keyType", "must be \"ECDSA\", \"RSA\" or empty, got %q", keyType)
+	}
+}
+
+func (v *validator) certOutput(path string, cfg CertOutput) {
+	if cfg.CertPath == "" {
+		v.add(path+".certPath", "must not be empty")
+	}
+	if cfg.KeyPath == "" {
+		v.add(path+".keyPath", "must not be empty")
+	}
+	for _, m := range []struct {
+		path string
+		val  string
+	}{
+		{path + ".certMode", cfg.CertMode},
+		{path + ".keyMode", cfg.KeyMode},
+	} {
+		if m.val == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(m.val, 8, 32); err != nil {
+			v.add(m.path, "invalid file mode %q, must be in octal", m.val)
+		}
+	}
+
+	switch cfg.Signal {
+	case "":
+		if cfg.PIDFile != "" {
+			v.add(path+".signal", "must not be empty when pidFile is set")
+		}
+	case "SIGHUP", "SIGINT", "SIGQUIT", "SIGTERM", "SIGUSR1", "SIGUSR2":
+		if cfg.PIDFile == "" {
+			v.add(path+".pidFile", "must not be empty when signal is set")
+		}
+	default:
+		v.add(path+".signal", "must be one of \"SIGHUP\", \"SIGINT\", \"SIGQUIT\", \"SIGTERM\", \"SIGUSR1\", \"SIGUSR2\" or empty, got %q", cfg.Signal)
+	}
+}
+
+func (v *validator) proxy(cfg Config) {
+	if !cfg.Proxy.Enable {
+		return
+	}
+
+	if !cfg.RoleToken.Enable {
+		v.add("proxy.enable", "requires roleToken.enable")
+	}
+	if cfg.Proxy.PrincipalAuthHeader == "" {
+		v.add("proxy.principalAuthHeader", "must not be empty")
+	}
+	if cfg.Proxy.RoleAuthHeader == "" {
+		v.add("proxy.roleAuthHeader", "must not be empty")
+	}
+	if cfg.Proxy.MTLS.Enable {
+		if !cfg.ServiceCert.Enable {
+			v.add("proxy.mtls.enable", "requires serviceCert.enable")
+		}
+		v.file("proxy.mtls.caPath", cfg.Proxy.MTLS.CAPath)
+	}
+	if cfg.Proxy.Forward.Enable {
+		for i, h := range cfg.Proxy.Forward.AllowedHosts {
+			if h == "" {
+				v.add(fmt.Sprintf("proxy.forward.allowedHosts[%d]", i), "must not be empty")
+			}
+		}
+		for i, r := range cfg.Proxy.Forward.Rules {
+			path := fmt.Sprintf("proxy.forward.rules[%d]", i)
+			if r.Host == "" {
+				v.add(path+".host", "must not be empty")
+			}
+			v.proxyToken(path, cfg, r.Token, r.Domain, r.Roles, false)
+		}
+	}
+
+	names := make(map[string]bool, len(cfg.Proxy.Routes))
+	patterns := make(map[string]bool, len(cfg.Proxy.Routes))
+	for i, r := range cfg.Proxy.Routes {
+		path := fmt.Sprintf("proxy.routes[%d]", i)
+		switch {
+		case r.Name == "":
+			v.add(path+".name", "must not be empty")
+		case names[r.Name]:
+			v.add(path+".name", "duplicated route name %q", r.Name)
+		}
+		names[r.Name] = true
+
+		if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+			v.add(path+".pathPrefix", "must start with \"/\", got %q", r.PathPrefix)
+		}
+		if r.Host == "" && (reservedPaths[r.PathPrefix] || strings.HasPrefix(r.PathPrefix, "/proxy/")) {
+			v.add(path+".pathPrefix", "conflicts with the endpoint of the client sidecar, got %q", r.PathPrefix)
+		}
+		if pattern := r.Host + r.PathPrefix; patterns[pattern] {
+			v.add(path, "duplicated host %q and pathPrefix %q", r.Host, r.PathPrefix)
+		} else {
+			patterns[pattern] = true
+		}
+		if r.Upstream == "" {
+			v.add(path+".upstream", "must not be empty")
+		} else if u, err := url.Parse(r.Upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add(path+".upstream", "must be an absolute http or https URL, got %q", r.Upstream)
+		}
+		v.proxyToken(path, cfg, r.Token, r.Domain, r.Roles, true)
+		if d, ok := v.duration(path+".timeout", r.Timeout, false); ok && r.Timeout != "" && d == 0 {
+			v.add(path+".timeout", "must be positive")
+		}
+	}
+}
+
+// reservedPaths represents the endpoints of the client sidecar, which the proxy routes without the host cannot use.
+var reservedPaths = map[string]bool{
+	"/ntoken":      true,
+	"/accesstoken": true,
+	"/roletoken":   true,
+	"/svccert":     true,
+	"/idtoken":     true,
+	"/rolecert":    true,
+	"/awscreds":    true,
+	"/verify":      true,
+}
+
+// proxyToken validates the token to inject into the proxy requests. The empty token type is allowed only if optional is true.
+func (v *validator) proxyToken(path string, cfg Config, token, domain string, roles []string, optional bool) {
+	switch token {
+	case "roletoken":
+		if !cfg.RoleToken.Enable {
+			v.add(path+".token", "roletoken requires roleToken.enable")
+		}
+		v.prefetch(path, domain, roles)
+	case "accesstoken":
+		if !cfg.AccessToken.Enable {
+			v.add(path+".token", "accesstoken requires accessToken.enable")
+		}
+		v.prefetch(path, domain, roles)
+	case "ntoken":
+	case "":
+		if optional {
+			return
+		}
+		fallthrough
+	default:
+		v.add(path+".token", "must be one of \"roletoken\", \"accesstoken\" or \"ntoken\", got %q", token)
+	}
+}
+
+// duration validates the duration value and returns the parsed duration, and whether it is valid.
+func (v *validator) duration(path, val string, required bool) (time.Duration, bool) {
+	if val == "" {
+		if required {
+			v.add(path, "must not be empty")
+			return 0, false
+		}
+		return 0, true
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		v.add(path, "invalid duration %q", val)
+		return 0, false
+	}
+	if d < 0 {
+		v.add(path, "must not be negative")
+		return 0, false
+	}
+	return d, true
+}
+
+func (v *validator) port(path string, port int) {
+	if port < 0 || port > 65535 {
+		v.add(path, "must be between 0 and 65535, got %d", port)
+	}
+}
+
+// athenzURL validates the Athenz API URL. The scheme can be omitted unless requireScheme is true.
+func (v *validator) athenzURL(path, val string, requireScheme bool) {
+	if val == "" {
+		v.add(path, "must not be empty")
+		return
+	}
+	raw := val
+	if !strings.Contains(val, "://") {
+		if requireScheme {
+			v.add(path, "must start with \"https://\" or \"http://\", got %q", val)
+			return
+		}
+		raw = "https://" + val
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		v.add(path, "invalid URL %q", val)
+		return
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		v.add(path, "must start with \"https://\" or \"http://\", got %q", val)
+		return
+	}
+	if u.Host == "" {
+		v.add(path, "host must not be empty, got %q", val)
+	}
+}
+
+func (v *validator) requiredFile(path, val string) {
+	if val == "" {
+		v.add(path, "must not be empty")
+		return
+	}
+	v.file(path, val)
+}
+
+// file validates the file exists if the value is not empty.
+func (v *validator) file(path, val string) {
+	if val == "" {
+		return
+	}
+	p := GetActualValue(val)
+	if p == "" {
+		v.add(path, "environment variable of %q is not set", val)
+		return
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		v.add(path, "file %q not found", p)
+		return
+	}
+	if fi.IsDir() {
+		v.add(path, "%q is a directory", p)
+	}
+}
+
+// UnknownKeys returns the warnings of the keys in the configuration file which do not match any configuration field, which are usually misspelled keys.
+func UnknownKeys(path string) []error {
+	_, unknown, err := decode(path)
+	if err != nil {
+		return nil
+	}
+	return unknown
+}
+
+// unknownKeys reports the keys which do not match any YAML field of the type as warnings.
+func (v *validator) unknownKeys(node interface{}, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		fields := make(map[string]reflect.Type, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			fields[name] = f.Type
+		}
+		keys := make([]string, 0, len(m))
+		vals := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			key := fmt.Sprint(k)
+			keys = append(keys, key)
+			vals[key] = val
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ft, ok := fields[key]
+			if !ok {
+				v.warn(join(path, key), "unknown key")
+				continue
+			}
+			v.unknownKeys(vals[key], ft, join(path, key))
+		}
+	case reflect.Slice:
+		s, ok := node.([]interface{})
+		if !ok {
+			return
+		}
+		for i, val := range s {
+			v.unknownKeys(val, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// overlaps returns whether the two listening addresses may conflict with each other.
+func overlaps(a, b string) bool {
+	isAny := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		return addr == "" || (ip != nil && ip.IsUnspecified())
+	}
+	return a == b || isAny(a) || isAny(b)
+}
+
+// requiresNToken returns whether the configuration requires N-token to interact with the Athenz server.
+func requiresNToken(cfg Config) bool {
+	return cfg.NToken.Enable ||
+		(cfg.AccessToken.Enable && cfg.AccessToken.CertPath == "") ||
+		(cfg.RoleToken.Enable && cfg.RoleToken.CertPath == "") ||
+		(cfg.IDToken.Enable && cfg.IDToken.CertPath == "") ||
+		cfg.ServiceCert.Enable ||
+		cfg.RoleCert.Enable ||
+		(cfg.AWSCredentials.Enable && cfg.AWSCredentials.CertPath == "") ||
+		cfg.Proxy.Enable
+}
@@ -0,0 +1,735 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func errorStrings(errs []error) []string {
+	if len(errs) == 0 {
+		return nil
+	}
+	res := make([]string, len(errs))
+	for i, err := range errs {
+		res[i] = err.Error()
+	}
+	return res
+}
+
+func TestValidate(t *testing.T) {
+	type test struct {
+		name    string
+		content string
+		want    []string
+	}
+	tests := []test{
+		{
+			name: "Validate valid config file",
+			content: `
+version: v2.0.0
+nToken:
+  enable: false
+roleToken:
+  enable: false
+proxy:
+  enable: false
+`,
+		},
+		{
+			name: "Validate config file with unknown keys",
+			content: `
+version: v2.0.0
+unknown: true
+server:
+  tls:
+    enabled: true
+nToken:
+  enable: false
+roleToken:
+  enable: false
+  retry:
+    attempt: 3
+proxy:
+  enable: false
+`,
+			want: []string{
+				"roleToken.retry.attempt: unknown key",
+				"server.tls.enabled: unknown key",
+				"unknown: unknown key",
+			},
+		},
+		{
+			name: "Validate config file with invalid type",
+			content: `
+version: v2.0.0
+server:
+  port: abc
+`,
+			want: []string{
+				"line 4: cannot unmarshal !!str `abc` into int",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got := errorStrings(Validate(path))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	nToken := NToken{
+		Enable:         true,
+		AthenzDomain:   "domain",
+		ServiceName:    "service",
+		PrivateKeyPath: "../test/data/dummyServer.key",
+		Expiry:         "30m",
+		RefreshPeriod:  "25m",
+	}
+	type test struct {
+		name string
+		cfg  Config
+		want []string
+	}
+	tests := []test{
+		{
+			name: "Validate valid config",
+			cfg: Config{
+				Version: "v2.0.0",
+				Server: Server{
+					Port:    8080,
+					Timeout: "10s",
+					HealthCheck: HealthCheck{
+						Port:     6080,
+						Endpoint: "/healthz",
+					},
+				},
+				NToken: nToken,
+				RoleToken: RoleToken{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					AthenzURL:           "athenz.io:4443/zts/v1",
+					AthenzCAPath:        "../test/data/dummyCa.pem",
+				},
+				Proxy: Proxy{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					RoleAuthHeader:      "Athenz-Role-Auth",
+				},
+				Log: Log{
+					Level: "info",
+				},
+			},
+		},
+		{
+			name: "Validate readiness endpoint conflicting with the default metrics endpoint",
+			cfg: Config{
+				Version: "v2.0.0",
+				Server: Server{
+					Port: 8080,
+					HealthCheck: HealthCheck{
+						Port:              6080,
+						Endpoint:          "/healthz",
+						ReadinessEndpoint: "/metrics",
+					},
+				},
+				NToken: nToken,
+			},
+			want: []string{
+				`server.healthCheck.readinessEndpoint: conflicts with server.healthCheck.metricsEndpoint "/metrics"`,
+			},
+		},
+		{
+			name: "Validate invalid config reports all problems",
+			cfg: Config{
+				Version: "v1.0.0",
+				Server: Server{
+					Port:            8080,
+					Timeout:         "10",
+					ShutdownTimeout: "-1s",
+					PrefetchTimeout: "0s",
+					HealthCheck: HealthCheck{
+						Port:              8080,
+						Endpoint:          "/healthz",
+						MetricsEndpoint:   "/healthz",
+						ReadinessEndpoint: "/healthz",
+					},
+					TLS: TLS{
+						Enable:   true,
+						CertPath: "../test/data/non_exist.crt",
+					},
+				},
+				NToken: NToken{
+					Enable: true,
+				},
+				AccessToken: AccessToken{
+					Enable:        true,
+					AthenzURL:     "ftp://athenz.io",
+					Expiry:        "1m",
+					RefreshPeriod: "1h",
+					Retry: Retry{
+						Attempts: -1,
+					},
+				},
+				ServiceCert: ServiceCert{
+					Enable:              true,
+					AthenzURL:           "athenz.io/zts/v1",
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					ExpiryMargin:        "invalid",
+				},
+				Proxy: Proxy{
+					Enable: true,
+				},
+				Log: Log{
+					Level: "trace",
+				},
+				Reload: Reload{
+					Enable: true,
+				},
+			},
+			want: []string{
+				`version: must be "v2.0.0", got "v1.0.0"`,
+				`server.timeout: invalid duration "10"`,
+				`server.shutdownTimeout: must not be negative`,
+				`server.prefetchTimeout: must be positive`,
+				`server.healthCheck.port: conflicts with server.port 8080`,
+				`server.healthCheck.metricsEndpoint: conflicts with server.healthCheck.endpoint "/healthz"`,
+				`server.healthCheck.readinessEndpoint: conflicts with server.healthCheck.endpoint "/healthz"`,
+				`server.tls.certPath: file "../test/data/non_exist.crt" not found`,
+				`server.tls.keyPath: must not be empty`,
+				`nToken.athenzDomain: must not be empty`,
+				`nToken.serviceName: must not be empty`,
+				`nToken.expiry: must not be empty`,
+				`nToken.refreshPeriod: must not be empty`,
+				`nToken.privateKeyPath: must not be empty`,
+				`accessToken.athenzURL: must start with "https://" or "http://", got "ftp://athenz.io"`,
+				`accessToken.principalAuthHeader: must not be empty when N-token is used`,
+				`accessToken.refreshPeriod: must not be greater than accessToken.expiry`,
+				`accessToken.retry.attempts: must not be negative`,
+				`serviceCert.athenzURL: must start with "https://" or "http://", got "athenz.io/zts/v1"`,
+				`serviceCert.expiryMargin: invalid duration "invalid"`,
+				`proxy.enable: requires roleToken.enable`,
+				`proxy.principalAuthHeader: must not be empty`,
+				`proxy.roleAuthHeader: must not be empty`,
+				`log.level: must be one of "debug", "info", "warn", "error", "fatal" or empty, got "trace"`,
+			},
+		},
+		{
+			name: "Validate client certificate is used when N-token is not required",
+			cfg: Config{
+				Version: "v2.0.0",
+				RoleToken: RoleToken{
+					Enable:      true,
+					AthenzURL:   "https://athenz.io:4443/zts/v1",
+					CertPath:    "../test/data/dummyClient.crt",
+					CertKeyPath: "../test/data/non_exist.key",
+				},
+			},
+			want: []string{
+				`roleToken.certKeyPath: file "../test/data/non_exist.key" not found`,
+			},
+		},
+		{
+			name: "Validate service certificate output",
+			cfg: Config{
+				Version: "v2.0.0",
+				NToken:  nToken,
+				ServiceCert: ServiceCert{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					AthenzURL:           "https://athenz.io:4443/zts/v1",
+					KeyType:             "RSA",
+					KeySize:             1024,
+					Output: CertOutput{
+						Enable:   true,
+						CertPath: "/tmp/cert.pem",
+						KeyMode:  "600",
+						CertMode: "rw-r--r--",
+						Signal:   "HUP",
+					},
+				},
+			},
+			want: []string{
+				`serviceCert.keySize: must be at least 2048 for RSA, got 1024`,
+				`serviceCert.output.keyPath: must not be empty`,
+				`serviceCert.output.certMode: invalid file mode "rw-r--r--", must be in octal`,
+				`serviceCert.output.signal: must be one of "SIGHUP", "SIGINT", "SIGQUIT", "SIGTERM", "SIGUSR1", "SIGUSR2" or empty, got "HUP"`,
+			},
+		},
+		{
+			name: "Validate service certificate output signal without PID file",
+			cfg: Config{
+				Version: "v2.0.0",
+				NToken:  nToken,
+				ServiceCert: ServiceCert{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					AthenzURL:           "https://athenz.io:4443/zts/v1",
+					Output: CertOutput{
+						Enable:   true,
+						CertPath: "/tmp/cert.pem",
+						KeyPath:  "/tmp/key.pem",
+						Signal:   "SIGHUP",
+					},
+				},
+			},
+			want: []string{
+				`serviceCert.output.pidFile: must not be empty when signal is set`,
+			},
+		},
+		{
+			name: "Validate identities",
+			cfg: Config{
+				Version: "v2.0.0",
+				NToken:  nToken,
+				RoleToken: RoleToken{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					AthenzURL:           "https://athenz.io:4443/zts/v1",
+				},
+				Identities: []Identity{
+					{
+						Name:   "other",
+						NToken: nToken,
+					},
+					{
+						Name: "other",
+						NToken: NToken{
+							Enable:         true,
+							ServiceName:    "service",
+							PrivateKeyPath: "../test/data/dummyServer.key",
+							Expiry:         "30m",
+							RefreshPeriod:  "25m",
+						},
+					},
+					{
+						NToken:      nToken,
+						CertPath:    "../test/data/dummyClient.crt",
+						CertKeyPath: "../test/data/non_exist.key",
+					},
+				},
+			},
+			want: []string{
+				`identities[1].name: duplicated identity name "other"`,
+				`identities[1].nToken.athenzDomain: must not be empty`,
+				`identities[2].name: must not be empty`,
+				`identities[2].certKeyPath: file "../test/data/non_exist.key" not found`,
+			},
+		},
+		{
+			name: "Validate cache snapshot",
+			cfg: Config{
+				Version: "v2.0.0",
+				CacheSnapshot: CacheSnapshot{
+					Enable:  true,
+					KeyPath: "../test/data/non_exist.key",
+					Period:  "0s",
+				},
+			},
+			want: []string{
+				`cacheSnapshot.path: must not be empty`,
+				`cacheSnapshot.keyPath: file "../test/data/non_exist.key" not found`,
+				`cacheSnapshot.period: must be positive`,
+			},
+		},
+		{
+			name: "Validate retry",
+			cfg: Config{
+				Version: "v2.0.0",
+				RoleToken: RoleToken{
+					Enable:      true,
+					AthenzURL:   "https://athenz.io:4443/zts/v1",
+					CertPath:    "../test/data/dummyClient.crt",
+					CertKeyPath: "../test/data/dummyClient.key",
+					Retry: Retry{
+						Delay:      "1m",
+						MaxDelay:   "10s",
+						Multiplier: 0.5,
+						Jitter:     2,
+					},
+				},
+			},
+			want: []string{
+				`roleToken.retry.delay: must not be greater than roleToken.retry.maxDelay`,
+				`roleToken.retry.multiplier: must be at least 1, got 0.5`,
+				`roleToken.retry.jitter: must be between 0 and 1, got 2`,
+			},
+		},
+		{
+			name: "Validate grace",
+			cfg: Config{
+				Version: "v2.0.0",
+				RoleToken: RoleToken{
+					Enable:      true,
+					AthenzURL:   "https://athenz.io:4443/zts/v1",
+					CertPath:    "../test/data/dummyClient.crt",
+					CertKeyPath: "../test/data/dummyClient.key",
+					Grace: Grace{
+						Enable:        true,
+						RefreshBefore: "0s",
+					},
+				},
+			},
+			want: []string{
+				`roleToken.grace.refreshBefore: must be positive`,
+			},
+		},
+		{
+			name: "Validate negative cache",
+			cfg: Config{
+				Version: "v2.0.0",
+				AccessToken: AccessToken{
+					Enable:      true,
+					AthenzURL:   "https://athenz.io:4443/zts/v1",
+					CertPath:    "../test/data/dummyClient.crt",
+					CertKeyPath: "../test/data/dummyClient.key",
+					NegativeCache: NegativeCache{
+						Enable: true,
+						TTL:    "0s",
+					},
+				},
+			},
+			want: []string{
+				`accessToken.negativeCache.ttl: must be positive`,
+			},
+		},
+		{
+			name: "Validate cache limit",
+			cfg: Config{
+				Version: "v2.0.0",
+				RoleToken: RoleToken{
+					Enable:      true,
+					AthenzURL:   "https://athenz.io:4443/zts/v1",
+					CertPath:    "../test/data/dummyClient.crt",
+					CertKeyPath: "../test/data/dummyClient.key",
+					CacheLimit: CacheLimit{
+						MaxEntries:  -1,
+						IdlePeriods: -1,
+					},
+				},
+			},
+			want: []string{
+				`roleToken.cacheLimit.maxEntries: must not be negative`,
+				`roleToken.cacheLimit.idlePeriods: must not be negative`,
+			},
+		},
+		{
+			name: "Validate schedule",
+			cfg: Config{
+				Version: "v2.0.0",
+				AccessToken: AccessToken{
+					Enable:      true,
+					AthenzURL:   "https://athenz.io:4443/zts/v1",
+					CertPath:    "../test/data/dummyClient.crt",
+					CertKeyPath: "../test/data/dummyClient.key",
+					Schedule: Schedule{
+						Enable: true,
+						Ratio:  1.5,
+						Jitter: -0.1,
+					},
+				},
+			},
+			want: []string{
+				`accessToken.schedule.ratio: must be between 0 and 1, got 1.5`,
+				`accessToken.schedule.jitter: must be between 0 and 1, got -0.1`,
+			},
+		},
+		{
+			name: "Validate refresh pool",
+			cfg: Config{
+				Version: "v2.0.0",
+				RoleToken: RoleToken{
+					Enable:      true,
+					AthenzURL:   "https://athenz.io:4443/zts/v1",
+					CertPath:    "../test/data/dummyClient.crt",
+					CertKeyPath: "../test/data/dummyClient.key",
+					RefreshPool: RefreshPool{
+						Concurrency: -1,
+						RateLimit:   -0.5,
+						Burst:       -1,
+					},
+				},
+			},
+			want: []string{
+				`roleToken.refreshPool.concurrency: must not be negative`,
+				`roleToken.refreshPool.rateLimit: must not be negative`,
+				`roleToken.refreshPool.burst: must not be negative`,
+			},
+		},
+		{
+			name: "Validate mTLS proxy",
+			cfg: Config{
+				Version: "v2.0.0",
+				NToken: NToken{
+					AthenzDomain:   "dummyDomain",
+					ServiceName:    "dummyService",
+					Expiry:         "20m",
+					RefreshPeriod:  "10m",
+					PrivateKeyPath: "../test/data/dummyServer.key",
+				},
+				RoleToken: RoleToken{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					AthenzURL:           "https://athenz.io:4443/zts/v1",
+				},
+				Proxy: Proxy{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					RoleAuthHeader:      "Athenz-Role-Auth",
+					MTLS: ProxyMTLS{
+						Enable: true,
+						CAPath: "../test/data/non_exist.pem",
+					},
+				},
+			},
+			want: []string{
+				`proxy.mtls.enable: requires serviceCert.enable`,
+				`proxy.mtls.caPath: file "../test/data/non_exist.pem" not found`,
+			},
+		},
+		{
+			name: "Validate forward proxy rules",
+			cfg: Config{
+				Version: "v2.0.0",
+				NToken: NToken{
+					AthenzDomain:   "dummyDomain",
+					ServiceName:    "dummyService",
+					Expiry:         "20m",
+					RefreshPeriod:  "10m",
+					PrivateKeyPath: "../test/data/dummyServer.key",
+				},
+				RoleToken: RoleToken{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					AthenzURL:           "https://athenz.io:4443/zts/v1",
+				},
+				Proxy: Proxy{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					RoleAuthHeader:      "Athenz-Role-Auth",
+					Forward: ForwardProxy{
+						Enable:       true,
+						AllowedHosts: []string{"api.baz", ""},
+						Rules: []ForwardProxyRule{
+							{
+								Host:   "api.foo",
+								Token:  "roletoken",
+								Domain: "dummyDomain",
+							},
+							{
+								Token: "accesstoken",
+							},
+							{
+								Host:  "api.bar",
+								Token: "ztoken",
+							},
+						},
+					},
+				},
+			},
+			want: []string{
+				`proxy.forward.allowedHosts[1]: must not be empty`,
+				`proxy.forward.rules[1].host: must not be empty`,
+				`proxy.forward.rules[1].token: accesstoken requires accessToken.enable`,
+				`proxy.forward.rules[1].domain: must not be empty`,
+				`proxy.forward.rules[2].token: must be one of "roletoken", "accesstoken" or "ntoken", got "ztoken"`,
+			},
+		},
+		{
+			name: "Validate proxy routes",
+			cfg: Config{
+				Version: "v2.0.0",
+				NToken: NToken{
+					AthenzDomain:   "dummyDomain",
+					ServiceName:    "dummyService",
+					Enable:         true,
+					Expiry:         "20m",
+					RefreshPeriod:  "10m",
+					PrivateKeyPath: "../test/data/dummyServer.key",
+				},
+				RoleToken: RoleToken{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					AthenzURL:           "https://athenz.io:4443/zts/v1",
+				},
+				Proxy: Proxy{
+					Enable:              true,
+					PrincipalAuthHeader: "Athenz-Principal-Auth",
+					RoleAuthHeader:      "Athenz-Role-Auth",
+					Routes: []ProxyRoute{
+						{
+							Name:       "payments",
+							PathPrefix: "/payments/",
+							Upstream:   "https://payments.example.com/api",
+							Token:      "roletoken",
+							Domain:     "dummyDomain",
+							Timeout:    "5s",
+						},
+						{
+							Name:     "payments",
+							Host:     "payments.local",
+							Upstream: "payments.example.com",
+						},
+						{
+							Name:       "other",
+							PathPrefix: "/payments/",
+							Upstream:   "http://other.example.com",
+							Token:      "accesstoken",
+							Timeout:    "0s",
+						},
+						{
+							PathPrefix: "/roletoken",
+							Upstream:   "http://other.example.com",
+						},
+					},
+				},
+			},
+			want: []string{
+				`proxy.routes[1].name: duplicated route name "payments"`,
+				`proxy.routes[1].upstream: must be an absolute http or https URL, got "payments.example.com"`,
+				`proxy.routes[2]: duplicated host "" and pathPrefix "/payments/"`,
+				`proxy.routes[2].token: accesstoken requires accessToken.enable`,
+				`proxy.routes[2].domain: must not be empty`,
+				`proxy.routes[2].timeout: must be positive`,
+				`proxy.routes[3].name: must not be empty`,
+				`proxy.routes[3].pathPrefix: conflicts with the endpoint of the client sidecar, got "/roletoken"`,
+			},
+		},
+		{
+			name: "Validate ID token",
+			cfg: Config{
+				Version: "v2.0.0",
+				IDToken: IDToken{
+					Enable:        true,
+					AthenzURL:     "https://athenz.io:4443/zts/v1",
+					CertPath:      "../test/data/dummyServer.crt",
+					CertKeyPath:   "../test/data/non_exist.key",
+					Expiry:        "10m",
+					RefreshPeriod: "1h",
+					RefreshPool: RefreshPool{
+						Concurrency: -1,
+					},
+				},
+			},
+			want: []string{
+				`idToken.certKeyPath: file "../test/data/non_exist.key" not found`,
+				`idToken.refreshPeriod: must not be greater than idToken.expiry`,
+				`idToken.refreshPool.concurrency: must not be negative`,
+			},
+		},
+		{
+			name: "Validate role certificate",
+			cfg: Config{
+				Version: "v2.0.0",
+				NToken: NToken{
+					AthenzDomain:   "dummyDomain",
+					ServiceName:    "dummyService",
+					Expiry:         "20m",
+					RefreshPeriod:  "10m",
+					PrivateKeyPath: "../test/data/dummyServer.key",
+				},
+				RoleCert: RoleCert{
+					Enable:       true,
+					AthenzURL:    "https://athenz.io:4443/zts/v1",
+					ExpiryMargin: "1 hour",
+					KeyType:      "RSA",
+					KeySize:      1024,
+					RefreshPool: RefreshPool{
+						Burst: -1,
+					},
+				},
+			},
+			want: []string{
+				`roleCert.principalAuthHeader: must not be empty`,
+				`roleCert.dnsSuffix: must not be empty`,
+				`roleCert.expiryMargin: invalid duration "1 hour"`,
+				`roleCert.refreshPool.burst: must not be negative`,
+				`roleCert.keySize: must be at least 2048 for RSA, got 1024`,
+			},
+		},
+		{
+			name: "Validate AWS credentials",
+			cfg: Config{
+				Version: "v2.0.0",
+				AWSCredentials: AWSCredentials{
+					Enable:        true,
+					AthenzURL:     "https://athenz.io:4443/zts/v1",
+					CertPath:      "../test/data/dummyServer.crt",
+					CertKeyPath:   "../test/data/dummyServer.key",
+					Expiry:        "13h",
+					RefreshPeriod: "30m",
+					Retry: Retry{
+						Attempts: -1,
+					},
+				},
+			},
+			want: []string{
+				`awsCredentials.retry.attempts: must not be negative`,
+				`awsCredentials.expiry: must be between 15m and 12h, got 13h`,
+			},
+		},
+		{
+			name: "Validate verify",
+			cfg: Config{
+				Version: "v2.0.0",
+				Verify: Verify{
+					Enable:        true,
+					AthenzCAPath:  "../test/data/not-exist.crt",
+					RefreshPeriod: "1 hour",
+				},
+			},
+			want: []string{
+				`verify.athenzURL: must not be empty`,
+				`verify.athenzCAPath: file "../test/data/not-exist.crt" not found`,
+				`verify.refreshPeriod: invalid duration "1 hour"`,
+			},
+		},
+		{
+			name: "Validate health check server on different address",
+			cfg: Config{
+				Version: "v2.0.0",
+				Server: Server{
+					Address: "127.0.0.1",
+					Port:    8080,
+					HealthCheck: HealthCheck{
+						Address:  "127.0.0.2",
+						Port:     8080,
+						Endpoint: "/healthz",
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorStrings(ValidateConfig(tt.cfg))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
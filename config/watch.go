@@ -0,0 +1,77 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// defaultCheckPeriod represents the default duration between each configuration file check.
+	defaultCheckPeriod = time.Second * 10
+)
+
+// Watch returns a channel which receives a notification whenever the content of the configuration file is changed.
+// The file content is compared every period (default: 10s) instead of watching file system events,
+// so that the files replaced by symbolic link swap (e.g. Kubernetes ConfigMap volume) are also detected.
+// The channel is closed when the context is canceled.
+func Watch(ctx context.Context, path string, period time.Duration) <-chan struct{} {
+	if period <= 0 {
+		period = defaultCheckPeriod
+	}
+
+	last := checksum(path)
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sum := checksum(path)
+				if sum == nil || bytes.Equal(sum, last) {
+					continue
+				}
+				last = sum
+				select {
+				case ch <- struct{}{}:
+				default:
+					// a notification is already pending
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// checksum returns the checksum of the file content, or nil if the file cannot be read.
+func checksum(path string) []byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
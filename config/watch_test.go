@@ -0,0 +1,86 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	type test struct {
+		name       string
+		beforeFunc func(path string) error
+		want       bool
+	}
+	tests := []test{
+		{
+			name: "Notify when file content is changed",
+			beforeFunc: func(path string) error {
+				return ioutil.WriteFile(path, []byte("version: changed"), 0600)
+			},
+			want: true,
+		},
+		{
+			name: "Not notify when file content is not changed",
+			beforeFunc: func(path string) error {
+				return ioutil.WriteFile(path, []byte("version: v2.0.0"), 0600)
+			},
+			want: false,
+		},
+		{
+			name: "Not notify when file is removed",
+			beforeFunc: func(path string) error {
+				return os.Remove(path)
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(path, []byte("version: v2.0.0"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch := Watch(ctx, path, time.Millisecond*10)
+
+			if err := tt.beforeFunc(path); err != nil {
+				t.Fatal(err)
+			}
+
+			got := false
+			select {
+			case <-ch:
+				got = true
+			case <-time.After(time.Millisecond * 200):
+			}
+			if got != tt.want {
+				t.Errorf("Watch() notified = %v, want %v", got, tt.want)
+			}
+
+			cancel()
+			for range ch {
+			}
+		})
+	}
+}
@@ -6,18 +6,28 @@ replace github.com/AthenZ/athenz => github.com/AthenZ/athenz v1.11.14
 
 require (
 	github.com/AthenZ/athenz v1.11.14
+	github.com/ardielle/ardielle-go v1.5.2
 	github.com/kpango/fastime v1.1.4
 	github.com/kpango/gache v1.2.8
 	github.com/kpango/glg v1.6.13
 	github.com/kpango/ntokend v1.0.12
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.14.0
 	golang.org/x/sync v0.1.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/ardielle/ardielle-go v1.5.2 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/goccy/go-json v0.9.11 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
 	github.com/zeebo/xxh3 v1.0.2 // indirect
+	golang.org/x/sys v0.1.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
 )
@@ -0,0 +1,227 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+)
+
+const (
+	// proxyTokenRole represents the proxy rule or route injecting the role token.
+	proxyTokenRole = "roletoken"
+
+	// proxyTokenAccess represents the proxy rule or route injecting the access token.
+	proxyTokenAccess = "accesstoken"
+
+	// proxyTokenN represents the proxy rule or route injecting the N-token.
+	proxyTokenN = "ntoken"
+
+	// roleSeparator represents the separator of the role names requested to the token services.
+	roleSeparator = ","
+)
+
+var (
+	// ErrForwardProxyDisabled represents an error that the forward proxy is requested while it is not enabled.
+	ErrForwardProxyDisabled = errors.New("forward proxy is not enabled")
+
+	// ErrHijackNotSupported represents an error that the connection of the CONNECT request cannot be taken over for tunneling.
+	ErrHijackNotSupported = errors.New("connection hijacking is not supported")
+
+	// ErrForwardHostNotAllowed represents an error that the destination host of the forward proxy request is not allowed.
+	ErrForwardHostNotAllowed = errors.New("destination host is not allowed")
+)
+
+// newForwardProxy returns the reverse proxy forwarding the absolute-form requests to their destinations as they are.
+// The environment proxy settings are ignored, not to send the requests back to the client sidecar.
+func newForwardProxy(bp httputil.BufferPool) *httputil.ReverseProxy {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	return &httputil.ReverseProxy{
+		BufferPool: bp,
+		Director:   func(*http.Request) {},
+		Transport:  t,
+	}
+}
+
+// ForwardProxy handles the requests sent by the clients using the client sidecar as the HTTP proxy.
+// Only the destination hosts of the allowed hosts and the rules are served, so that the client sidecar is not an open proxy.
+// The CONNECT requests are tunneled to the destination, and the absolute-form requests are forwarded with the token of the first rule matched by the destination host.
+// The requests with the token are forwarded over HTTPS, even if they are requested by http://, not to send the token in plain text.
+func (h *handler) ForwardProxy(w http.ResponseWriter, r *http.Request) error {
+	if h.forwardProxy == nil {
+		flushAndClose(r.Body)
+		return ErrForwardProxyDisabled
+	}
+	// r.Host is the destination of the CONNECT request, since it has no URL
+	host := r.URL.Host
+	if r.Method == http.MethodConnect {
+		host = r.Host
+	}
+	if !forwardAllowed(h.cfg.Forward, host) {
+		flushAndClose(r.Body)
+		return errors.Wrapf(ErrForwardHostNotAllowed, "host %q", host)
+	}
+	if r.Method == http.MethodConnect {
+		return h.tunnel(w, r)
+	}
+
+	defer flushAndClose(r.Body)
+
+	if rule, ok := matchForwardRule(h.cfg.Forward.Rules, r.URL.Host); ok {
+		if err := h.injectToken(r, rule.Token, rule.Domain, rule.Roles, rule.ProxyForPrincipal, ""); err != nil {
+			return err
+		}
+		r.URL.Scheme = "https"
+	}
+	h.forwardProxy.ServeHTTP(w, r)
+	return nil
+}
+
+// injectToken sets the token of the type to the request header. The empty header implies the default header of the token type.
+func (h *handler) injectToken(r *http.Request, token, domain string, roles []string, proxyForPrincipal, header string) error {
+	role := strings.Join(roles, roleSeparator)
+	switch token {
+	case proxyTokenRole:
+		tok, err := h.role(r.Context(), domain, role, proxyForPrincipal, 0, 0)
+		if err != nil {
+			return err
+		}
+		r.Header.Set(headerOr(header, h.cfg.RoleAuthHeader), tok.Token)
+	case proxyTokenAccess:
+		tok, err := h.access(r.Context(), domain, role, proxyForPrincipal, 0)
+		if err != nil {
+			return err
+		}
+		r.Header.Set(headerOr(header, "Authorization"), "Bearer "+tok.AccessToken)
+	case proxyTokenN:
+		tok, err := h.token()
+		if err != nil {
+			return err
+		}
+		r.Header.Set(headerOr(header, h.cfg.PrincipalAuthHeader), tok)
+	}
+	return nil
+}
+
+// headerOr returns header, or def if header is empty.
+func headerOr(header, def string) string {
+	if header == "" {
+		return def
+	}
+	return header
+}
+
+// tunnel connects to the destination of the CONNECT request, and relays the bytes between the client and the destination until either side closes the connection.
+// The tunneled traffic, e.g. TLS, is not modified, so no token is injected.
+func (h *handler) tunnel(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return ErrHijackNotSupported
+	}
+
+	dst, err := new(net.Dialer).DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		return err
+	}
+	src, buf, err := hj.Hijack()
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	// the deadlines of the server are not applied to the tunnel
+	src.SetDeadline(time.Time{})
+	if _, err := src.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		src.Close()
+		dst.Close()
+		glg.Debugf("failed to establish tunnel to %s: %s", r.Host, err.Error())
+		return nil
+	}
+
+	go func() {
+		defer dst.Close()
+		defer src.Close()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			// the bytes sent by the client may be buffered already
+			if _, err := io.Copy(dst, buf.Reader); err != nil {
+				glg.Debugf("tunnel to %s closed: %s", r.Host, err.Error())
+			}
+			if tc, ok := dst.(*net.TCPConn); ok {
+				tc.CloseWrite()
+			}
+		}()
+		if _, err := io.Copy(src, dst); err != nil {
+			glg.Debugf("tunnel from %s closed: %s", r.Host, err.Error())
+		}
+		src.Close()
+		<-done
+	}()
+	return nil
+}
+
+// forwardAllowed returns whether the destination host, which may contain the port, matches the allowed hosts or the hosts of the rules.
+func forwardAllowed(cfg config.ForwardProxy, hostport string) bool {
+	for _, pattern := range cfg.AllowedHosts {
+		if matchForwardHost(pattern, hostport) {
+			return true
+		}
+	}
+	_, ok := matchForwardRule(cfg.Rules, hostport)
+	return ok
+}
+
+// matchForwardRule returns the first rule matching the destination host, which may contain the port.
+func matchForwardRule(rules []config.ForwardProxyRule, hostport string) (config.ForwardProxyRule, bool) {
+	for _, r := range rules {
+		if matchForwardHost(r.Host, hostport) {
+			return r, true
+		}
+	}
+	return config.ForwardProxyRule{}, false
+}
+
+// matchForwardHost returns whether the host pattern matches the destination host, which may contain the port.
+// The pattern without port matches any port, and "*." matches any subdomain.
+func matchForwardHost(pattern, hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	pattern = strings.ToLower(pattern)
+	target := strings.ToLower(host)
+	if _, _, err := net.SplitHostPort(pattern); err == nil {
+		target = strings.ToLower(hostport)
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(target, pattern[1:])
+	}
+	return pattern == target
+}
@@ -17,18 +17,42 @@ limitations under the License.
 package handler
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
+	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
 	"github.com/AthenZ/athenz-client-sidecar/v2/model"
 	"github.com/AthenZ/athenz-client-sidecar/v2/service"
+	"github.com/kpango/glg"
 	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
 )
 
+// ErrMTLSProxyDisabled represents an error that the mTLS proxy is requested while it is not enabled.
+var ErrMTLSProxyDisabled = errors.New("mTLS proxy is not enabled")
+
+// BadRequestError represents an error that the request is malformed, e.g. the request body is not valid JSON or the query parameter is invalid.
+type BadRequestError struct {
+	// Err represents the error of parsing the request.
+	Err error
+}
+
+// Error returns the message of Err.
+func (e *BadRequestError) Error() string {
+	return "invalid request: " + e.Err.Error()
+}
+
+// Unwrap returns Err.
+func (e *BadRequestError) Unwrap() error {
+	return e.Err
+}
+
 // Handler for handling a set of HTTP requests.
 type Handler interface {
 	// NToken handles get N-token requests.
@@ -37,12 +61,28 @@ type Handler interface {
 	NTokenProxy(http.ResponseWriter, *http.Request) error
 	// AccessToken handles post access token requests.
 	AccessToken(http.ResponseWriter, *http.Request) error
+	// AccessTokenProxy handles proxy requests that require an access token.
+	AccessTokenProxy(http.ResponseWriter, *http.Request) error
 	// RoleToken handles post role token requests.
 	RoleToken(http.ResponseWriter, *http.Request) error
 	// RoleTokenProxy handles proxy requests that require a role token.
 	RoleTokenProxy(http.ResponseWriter, *http.Request) error
+	// IDToken handles post ID token requests.
+	IDToken(http.ResponseWriter, *http.Request) error
 	// ServiceCert handles get svccert requests.
 	ServiceCert(http.ResponseWriter, *http.Request) error
+	// RoleCert handles post rolecert requests.
+	RoleCert(http.ResponseWriter, *http.Request) error
+	// AWSCredentials handles get AWS temporary credentials requests.
+	AWSCredentials(http.ResponseWriter, *http.Request) error
+	// Verify handles post token verification requests.
+	Verify(http.ResponseWriter, *http.Request) error
+	// MTLSProxy handles proxy requests to the upstream servers requiring the service certificate as the client certificate.
+	MTLSProxy(http.ResponseWriter, *http.Request) error
+	// ForwardProxy handles absolute-form and CONNECT requests sent to the client sidecar as the HTTP proxy.
+	ForwardProxy(http.ResponseWriter, *http.Request) error
+	// RouteProxy returns the handler of proxy requests to the upstream server of the named proxy route.
+	RouteProxy(name string) Func
 }
 
 // Func is http.HandlerFunc with error return.
@@ -50,26 +90,66 @@ type Func func(http.ResponseWriter, *http.Request) error
 
 // handler is internal implementation of Handler interface.
 type handler struct {
-	proxy   *httputil.ReverseProxy
-	token   ntokend.TokenProvider
-	access  service.AccessProvider
-	role    service.RoleProvider
-	svcCert service.SvcCertProvider
-	cfg     config.Proxy
+	proxy *httputil.ReverseProxy
+	// mtlsProxy presents the service certificate to the upstream servers. It is nil if the mTLS proxy is disabled.
+	mtlsProxy *httputil.ReverseProxy
+	// forwardProxy forwards the absolute-form requests to their destinations. It is nil if the forward proxy is disabled.
+	forwardProxy *httputil.ReverseProxy
+	// routes forwards the requests to the upstream servers of the proxy routes by the route name.
+	routes   map[string]*proxyRoute
+	token    ntokend.TokenProvider
+	access   service.AccessProvider
+	role     service.RoleProvider
+	svcCert  service.SvcCertKeyProvider
+	idToken  service.IDTokenProvider
+	roleCert service.RoleCertProvider
+	awsCreds service.AWSCredentialsProvider
+	verify   service.TokenVerifier
+	// mtls is the TLS configuration of mtlsProxy.
+	mtls *tls.Config
+	cfg  config.Proxy
 }
 
-// New creates a handler for handling different HTTP requests based on the given services. It also contains a reverse proxy for handling proxy request.
-func New(cfg config.Proxy, bp httputil.BufferPool, token ntokend.TokenProvider, access service.AccessProvider, role service.RoleProvider, svcCert service.SvcCertProvider) Handler {
-	return &handler{
+// New creates a handler for handling different HTTP requests based on the services given by the options. It also contains a reverse proxy for handling proxy request.
+// If the mTLS configuration is given by WithMTLSConfig, the mTLS proxy forwards the requests over HTTPS with it, which presents the service certificate as the client certificate.
+func New(cfg config.Proxy, bp httputil.BufferPool, opts ...Option) Handler {
+	h := &handler{
 		proxy: &httputil.ReverseProxy{
 			BufferPool: bp,
 		},
-		token:   token,
-		access:  access,
-		role:    role,
-		cfg:     cfg,
-		svcCert: svcCert,
+		cfg: cfg,
 	}
+	for _, o := range opts {
+		o(h)
+	}
+	if h.mtls != nil {
+		h.mtlsProxy = &httputil.ReverseProxy{
+			BufferPool: bp,
+			// the callers send plain HTTP requests to the proxy, and the proxy upgrades them to mTLS
+			Director: func(r *http.Request) {
+				r.URL.Scheme = "https"
+			},
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: h.mtls,
+			},
+		}
+	}
+	if cfg.Forward.Enable {
+		h.forwardProxy = newForwardProxy(bp)
+	}
+	if len(cfg.Routes) > 0 {
+		h.routes = make(map[string]*proxyRoute, len(cfg.Routes))
+		for _, route := range cfg.Routes {
+			rp, err := newProxyRoute(bp, route)
+			if err != nil {
+				glg.Errorf("proxy route %s is disabled: %s", route.Name, err.Error())
+				continue
+			}
+			h.routes[route.Name] = rp
+		}
+	}
+	return h
 }
 
 // NToken handles N-token requests and responses the corresponding N-token. Depends on token service.
@@ -107,15 +187,43 @@ func (h *handler) AccessToken(w http.ResponseWriter, r *http.Request) error {
 	var data model.AccessRequest
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		return err
+		return &BadRequestError{Err: err}
 	}
 	tok, err := h.access(r.Context(), data.Domain, data.Role, data.ProxyForPrincipal, data.Expiry)
 	if err != nil {
 		return err
 	}
 
+	var res interface{} = tok
+	if verbose(r) {
+		claims, err := service.ParseAccessTokenClaims(tok.AccessToken)
+		if err != nil {
+			return err
+		}
+		res = model.VerboseAccessResponse{
+			AccessResponse: tok,
+			Claims:         claims,
+		}
+	}
+
 	w.Header().Set("Content-type", "application/json; charset=utf-8")
-	return json.NewEncoder(w).Encode(tok)
+	return json.NewEncoder(w).Encode(res)
+}
+
+// AccessTokenProxy attaches access token to HTTP requests as the bearer token and proxies it. Depends on access token service.
+func (h *handler) AccessTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	role := r.Header.Get("Athenz-Role")
+	domain := r.Header.Get("Athenz-Domain")
+	principal := r.Header.Get("Athenz-Proxy-Principal")
+	tok, err := h.access(r.Context(), domain, role, principal, 0)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	h.proxy.ServeHTTP(w, r)
+	return nil
 }
 
 // RoleToken handles role token requests and responses the corresponding role token. Depends on role token service.
@@ -125,15 +233,27 @@ func (h *handler) RoleToken(w http.ResponseWriter, r *http.Request) error {
 	var data model.RoleRequest
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		return err
+		return &BadRequestError{Err: err}
 	}
 	tok, err := h.role(r.Context(), data.Domain, data.Role, data.ProxyForPrincipal, data.MinExpiry, data.MaxExpiry)
 	if err != nil {
 		return err
 	}
 
+	var res interface{} = tok
+	if verbose(r) {
+		claims, err := service.ParseRoleTokenClaims(tok.Token)
+		if err != nil {
+			return err
+		}
+		res = model.VerboseRoleResponse{
+			RoleResponse: tok,
+			Claims:       claims,
+		}
+	}
+
 	w.Header().Set("Content-type", "application/json; charset=utf-8")
-	return json.NewEncoder(w).Encode(tok)
+	return json.NewEncoder(w).Encode(res)
 }
 
 // RoleTokenProxy attaches role token to HTTP requests and proxies it. Depends on role token service.
@@ -152,6 +272,30 @@ func (h *handler) RoleTokenProxy(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// IDToken handles ID token requests and responses the corresponding ID token. Depends on ID token service.
+func (h *handler) IDToken(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	var data model.IDTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return &BadRequestError{Err: err}
+	}
+	tok, err := h.idToken(r.Context(), data.Audience, data.Domain, data.Role, data.RedirectURI, data.Expiry)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(tok)
+}
+
+// verbose returns whether the request asks for the claims of the token by the query "verbose=true".
+func verbose(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("verbose"))
+	return v
+}
+
 // flushAndClose helps to flush and close a ReadCloser. Used for request body internal.
 // Returns if there is any errors.
 func flushAndClose(rc io.ReadCloser) error {
@@ -171,7 +315,7 @@ func flushAndClose(rc io.ReadCloser) error {
 func (h *handler) ServiceCert(w http.ResponseWriter, r *http.Request) error {
 	defer flushAndClose(r.Body)
 
-	cert, err := h.svcCert()
+	cert, key, err := h.svcCert()
 	if err != nil {
 		return err
 	}
@@ -179,5 +323,92 @@ func (h *handler) ServiceCert(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(model.SvcCertResponse{
 		Cert: cert,
+		Key:  key,
+	})
+}
+
+// RoleCert handles role certificate requests and responses the corresponding role certificate. Depends on roleCert service.
+func (h *handler) RoleCert(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	var data model.RoleCertRequest
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return &BadRequestError{Err: err}
+	}
+	cert, key, err := h.roleCert(r.Context(), data.Domain, data.Role)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(model.RoleCertResponse{
+		Cert: cert,
+		Key:  key,
+	})
+}
+
+// AWSCredentials handles AWS temporary credentials requests and responses the credentials of the AWS role in the credential_process format.
+// The domain, the role, the external ID and the duration in second are given by the query. Depends on AWS credentials service.
+func (h *handler) AWSCredentials(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	q := r.URL.Query()
+	var duration int64
+	if d := q.Get("durationSeconds"); d != "" {
+		var err error
+		if duration, err = strconv.ParseInt(d, 10, 64); err != nil {
+			return &BadRequestError{Err: errors.Wrap(err, "durationSeconds")}
+		}
+	}
+	creds, err := h.awsCreds(r.Context(), q.Get("domain"), q.Get("role"), q.Get("externalId"), duration)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(model.AWSCredentialsResponse{
+		Version:         1,
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
 	})
 }
+
+// Verify handles token verification requests and responses the result of the verification with the claims of the valid token. Depends on verify service.
+// The invalid token is not an error of the request, and it is responded with the reason.
+func (h *handler) Verify(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	var data model.VerifyRequest
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return &BadRequestError{Err: err}
+	}
+	res := model.VerifyResponse{}
+	claims, err := h.verify(r.Context(), data.Token, data.Audience)
+	switch {
+	case err == nil:
+		res.Valid = true
+		res.Claims = claims
+	case errors.Is(err, service.ErrInvalidToken):
+		res.Message = err.Error()
+	default:
+		return err
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(res)
+}
+
+// MTLSProxy proxies HTTP requests to the upstream servers over HTTPS, presenting the service certificate as the client certificate. Depends on svcCert service.
+func (h *handler) MTLSProxy(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	if h.mtlsProxy == nil {
+		return ErrMTLSProxyDisabled
+	}
+	h.mtlsProxy.ServeHTTP(w, r)
+	return nil
+}
@@ -18,6 +18,7 @@ package handler
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -33,6 +34,7 @@ import (
 	"github.com/AthenZ/athenz-client-sidecar/v2/infra"
 	"github.com/AthenZ/athenz-client-sidecar/v2/service"
 	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
 )
 
 // NotEqualError reports the name of the field having different value and their values.
@@ -81,7 +83,7 @@ func TestNew(t *testing.T) {
 		token   ntokend.TokenProvider
 		access  service.AccessProvider
 		role    service.RoleProvider
-		svcCert service.SvcCertProvider
+		svcCert service.SvcCertKeyProvider
 	}
 	type testcase struct {
 		name      string
@@ -107,8 +109,8 @@ func TestNew(t *testing.T) {
 						ExpiryTime: 90,
 					}, fmt.Errorf("get-role-token-error-91")
 				},
-				svcCert: func() ([]byte, error) {
-					return []byte("svccert"), fmt.Errorf("svccert-error")
+				svcCert: func() ([]byte, []byte, error) {
+					return []byte("svccert"), nil, fmt.Errorf("svccert-error")
 				},
 			},
 			want: &handler{
@@ -148,7 +150,7 @@ func TestNew(t *testing.T) {
 				}
 
 				// svccert
-				gotSvcCert, gotError := got.svcCert()
+				gotSvcCert, _, gotError := got.svcCert()
 				wantSvcCert, wantError := []byte("svccert"), fmt.Errorf("svccert-error")
 				if !reflect.DeepEqual(gotSvcCert, wantSvcCert) {
 					return &NotEqualError{"svccert()", gotSvcCert, wantSvcCert}
@@ -164,7 +166,7 @@ func TestNew(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := New(tt.args.cfg, tt.args.bp, tt.args.token, tt.args.access, tt.args.role, tt.args.svcCert)
+			got := New(tt.args.cfg, tt.args.bp, WithNTokenProvider(tt.args.token), WithAccessProvider(tt.args.access), WithRoleProvider(tt.args.role), WithSvcCertProvider(tt.args.svcCert))
 			if err := tt.checkFunc(got.(*handler), tt.want); err != nil {
 				t.Errorf("New() %v", err)
 				return
@@ -540,7 +542,7 @@ func Test_handler_AccessToken(t *testing.T) {
 				header: map[string]string{},
 				body:   []byte{},
 			},
-			wantError: fmt.Errorf("invalid character 'b' looking for beginning of value"),
+			wantError: fmt.Errorf("invalid request: invalid character 'b' looking for beginning of value"),
 		},
 		{
 			name: "Check handler AccessToken, on access provider error",
@@ -631,6 +633,51 @@ func Test_handler_AccessToken(t *testing.T) {
 				body: []byte(`{"access_token":"access-token-614","token_type":"Bearer","expires_in":615}` + "\n"),
 			},
 		},
+		{
+			name: "Check handler AccessToken, request got access token with claims",
+			fields: fields{
+				access: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (accessTokenResponse *service.AccessTokenResponse, err error) {
+					return &service.AccessTokenResponse{
+						// {"kid":"0","typ":"at+jwt","alg":"RS256"}.{"aud":"domain","scp":["reader","writer"],"sub":"client.service","iat":1600000000,"exp":1600003600,"proxy":"proxy.service"}
+						AccessToken: "eyJraWQiOiIwIiwidHlwIjoiYXQrand0IiwiYWxnIjoiUlMyNTYifQ.eyJhdWQiOiJkb21haW4iLCJzY3AiOlsicmVhZGVyIiwid3JpdGVyIl0sInN1YiI6ImNsaWVudC5zZXJ2aWNlIiwiaWF0IjoxNjAwMDAwMDAwLCJleHAiOjE2MDAwMDM2MDAsInByb3h5IjoicHJveHkuc2VydmljZSJ9.signature",
+						TokenType:   "Bearer",
+						ExpiresIn:   3600,
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url/accesstoken?verbose=true", strings.NewReader(`{"domain":"domain","role":"reader,writer"}`)),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"access_token":"eyJraWQiOiIwIiwidHlwIjoiYXQrand0IiwiYWxnIjoiUlMyNTYifQ.eyJhdWQiOiJkb21haW4iLCJzY3AiOlsicmVhZGVyIiwid3JpdGVyIl0sInN1YiI6ImNsaWVudC5zZXJ2aWNlIiwiaWF0IjoxNjAwMDAwMDAwLCJleHAiOjE2MDAwMDM2MDAsInByb3h5IjoicHJveHkuc2VydmljZSJ9.signature","token_type":"Bearer","expires_in":3600,` +
+					`"claims":{"domain":"domain","roles":["reader","writer"],"principal":"client.service","issueTime":1600000000,"expiryTime":1600003600,"proxyPrincipal":"proxy.service","keyId":"0"}}` + "\n"),
+			},
+		},
+		{
+			name: "Check handler AccessToken, on parse access token error",
+			fields: fields{
+				access: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (accessTokenResponse *service.AccessTokenResponse, err error) {
+					return &service.AccessTokenResponse{
+						AccessToken: "invalid",
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url/accesstoken?verbose=true", strings.NewReader(`{}`)),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("access token is not a JWT: Invalid token"),
+		},
 		func() testcase {
 			requestClosed := false
 			return testcase{
@@ -658,7 +705,7 @@ func Test_handler_AccessToken(t *testing.T) {
 					header: map[string]string{},
 					body:   []byte{},
 				},
-				wantError: fmt.Errorf("invalid character 'b' looking for beginning of value"),
+				wantError: fmt.Errorf("invalid request: invalid character 'b' looking for beginning of value"),
 			}
 		}(),
 	}
@@ -733,7 +780,7 @@ func Test_handler_RoleToken(t *testing.T) {
 				header: map[string]string{},
 				body:   []byte{},
 			},
-			wantError: fmt.Errorf("invalid character 'b' looking for beginning of value"),
+			wantError: fmt.Errorf("invalid request: invalid character 'b' looking for beginning of value"),
 		},
 		{
 			name: "Check handler RoleToken, on role error",
@@ -822,6 +869,49 @@ func Test_handler_RoleToken(t *testing.T) {
 				body: []byte(`{"token":"role-token-629","expiryTime":630}` + "\n"),
 			},
 		},
+		{
+			name: "Check handler RoleToken, request got role token with claims",
+			fields: fields{
+				role: func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (roleToken *service.RoleToken, err error) {
+					return &service.RoleToken{
+						Token:      "v=Z1;d=" + domain + ";r=" + role + ";p=client.service;h=host;a=salt;t=1600000000;e=1600003600;k=0;proxy=proxy.service;s=signature",
+						ExpiryTime: 1600003600,
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url/roletoken?verbose=true", strings.NewReader(`{"domain":"domain","role":"reader,writer"}`)),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"token":"v=Z1;d=domain;r=reader,writer;p=client.service;h=host;a=salt;t=1600000000;e=1600003600;k=0;proxy=proxy.service;s=signature","expiryTime":1600003600,` +
+					`"claims":{"domain":"domain","roles":["reader","writer"],"principal":"client.service","issueTime":1600000000,"expiryTime":1600003600,"proxyPrincipal":"proxy.service","keyId":"0"}}` + "\n"),
+			},
+		},
+		{
+			name: "Check handler RoleToken, on parse role token error",
+			fields: fields{
+				role: func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (roleToken *service.RoleToken, err error) {
+					return &service.RoleToken{
+						Token: "invalid",
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url/roletoken?verbose=true", strings.NewReader(`{}`)),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("malformed role token field: Invalid token"),
+		},
 		func() testcase {
 			requestClosed := false
 			return testcase{
@@ -849,7 +939,7 @@ func Test_handler_RoleToken(t *testing.T) {
 					header: map[string]string{},
 					body:   []byte{},
 				},
-				wantError: fmt.Errorf("invalid character 'b' looking for beginning of value"),
+				wantError: fmt.Errorf("invalid request: invalid character 'b' looking for beginning of value"),
 			}
 		}(),
 	}
@@ -891,6 +981,346 @@ func Test_handler_RoleToken(t *testing.T) {
 	}
 }
 
+func Test_handler_IDToken(t *testing.T) {
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		idToken   service.IDTokenProvider
+		r         *http.Request
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name: "Check handler IDToken, on decode request body error",
+			r:    httptest.NewRequest(http.MethodPost, "http://url", strings.NewReader("body")),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("invalid request: invalid character 'b' looking for beginning of value"),
+		},
+		{
+			name: "Check handler IDToken, on ID token error",
+			idToken: func(ctx context.Context, audience, domain, role, redirectURI string, expiry int64) (*service.IDTokenResponse, error) {
+				return nil, fmt.Errorf("get-id-token-error")
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url", strings.NewReader(`{}`)),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-id-token-error"),
+		},
+		{
+			name: "Check handler IDToken, get ID token success",
+			idToken: func(ctx context.Context, audience, domain, role, redirectURI string, expiry int64) (*service.IDTokenResponse, error) {
+				return &service.IDTokenResponse{
+					IDToken:        strings.Join([]string{audience, domain, role, redirectURI, fmt.Sprint(expiry)}, "-"),
+					TokenType:      "id-token-type",
+					ExpirationTime: 100,
+				}, nil
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url", strings.NewReader(`{"audience":"aud","domain":"domain","role":"role","redirect_uri":"uri","expiry":60}`)),
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"id_token":"aud-domain-role-uri-60","token_type":"id-token-type","expiration_time":100}` + "\n"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := &handler{
+				idToken: tt.idToken,
+			}
+
+			gotError := h.IDToken(w, tt.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				if gotError == nil || tt.wantError == nil || gotError.Error() != tt.wantError.Error() {
+					t.Errorf("handler.IDToken() %v", &NotEqualError{"error", gotError, tt.wantError})
+					return
+				}
+			}
+			if err := EqualResponse(w, tt.want.code, tt.want.header, tt.want.body); err != nil {
+				t.Errorf("handler.IDToken() %v", err)
+			}
+		})
+	}
+}
+
+func Test_handler_RoleCert(t *testing.T) {
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		roleCert  service.RoleCertProvider
+		r         *http.Request
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name: "Check handler RoleCert, on decode request body error",
+			r:    httptest.NewRequest(http.MethodPost, "http://url", strings.NewReader("body")),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("invalid request: invalid character 'b' looking for beginning of value"),
+		},
+		{
+			name: "Check handler RoleCert, on role certificate error",
+			roleCert: func(ctx context.Context, domain, role string) ([]byte, []byte, error) {
+				return nil, nil, fmt.Errorf("get-role-cert-error")
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url", strings.NewReader(`{}`)),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-role-cert-error"),
+		},
+		{
+			name: "Check handler RoleCert, get role certificate success",
+			roleCert: func(ctx context.Context, domain, role string) ([]byte, []byte, error) {
+				return []byte(domain + "-" + role), nil, nil
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url", strings.NewReader(`{"domain":"domain","role":"role"}`)),
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"cert":"ZG9tYWluLXJvbGU="}` + "\n"),
+			},
+		},
+		{
+			name: "Check handler RoleCert, get role certificate with private key success",
+			roleCert: func(ctx context.Context, domain, role string) ([]byte, []byte, error) {
+				return []byte(domain + "-" + role), []byte("key"), nil
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url", strings.NewReader(`{"domain":"domain","role":"role"}`)),
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"cert":"ZG9tYWluLXJvbGU=","key":"a2V5"}` + "\n"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := &handler{
+				roleCert: tt.roleCert,
+			}
+
+			gotError := h.RoleCert(w, tt.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				if gotError == nil || tt.wantError == nil || gotError.Error() != tt.wantError.Error() {
+					t.Errorf("handler.RoleCert() %v", &NotEqualError{"error", gotError, tt.wantError})
+					return
+				}
+			}
+			if err := EqualResponse(w, tt.want.code, tt.want.header, tt.want.body); err != nil {
+				t.Errorf("handler.RoleCert() %v", err)
+			}
+		})
+	}
+}
+
+func Test_handler_AWSCredentials(t *testing.T) {
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		awsCreds  service.AWSCredentialsProvider
+		r         *http.Request
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name: "Check handler AWSCredentials, on invalid duration error",
+			r:    httptest.NewRequest(http.MethodGet, "http://url/awscreds?domain=domain&role=role&durationSeconds=1h", nil),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf(`invalid request: durationSeconds: strconv.ParseInt: parsing "1h": invalid syntax`),
+		},
+		{
+			name: "Check handler AWSCredentials, on AWS credentials error",
+			awsCreds: func(ctx context.Context, domain, role, externalID string, duration int64) (*service.AWSCredentials, error) {
+				return nil, fmt.Errorf("get-aws-credentials-error")
+			},
+			r: httptest.NewRequest(http.MethodGet, "http://url/awscreds?domain=domain&role=role", nil),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-aws-credentials-error"),
+		},
+		{
+			name: "Check handler AWSCredentials, get AWS credentials success",
+			awsCreds: func(ctx context.Context, domain, role, externalID string, duration int64) (*service.AWSCredentials, error) {
+				return &service.AWSCredentials{
+					AccessKeyID:     "id",
+					SecretAccessKey: "secret",
+					SessionToken:    strings.Join([]string{domain, role, externalID, fmt.Sprint(duration)}, "-"),
+					Expiration:      time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				}, nil
+			},
+			r: httptest.NewRequest(http.MethodGet, "http://url/awscreds?domain=domain&role=role&externalId=external&durationSeconds=900", nil),
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"Version":1,"AccessKeyId":"id","SecretAccessKey":"secret","SessionToken":"domain-role-external-900","Expiration":"2020-01-01T00:00:00Z"}` + "\n"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := &handler{
+				awsCreds: tt.awsCreds,
+			}
+
+			gotError := h.AWSCredentials(w, tt.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				if gotError == nil || tt.wantError == nil || gotError.Error() != tt.wantError.Error() {
+					t.Errorf("handler.AWSCredentials() %v", &NotEqualError{"error", gotError, tt.wantError})
+					return
+				}
+			}
+			if err := EqualResponse(w, tt.want.code, tt.want.header, tt.want.body); err != nil {
+				t.Errorf("handler.AWSCredentials() %v", err)
+			}
+		})
+	}
+}
+
+func Test_handler_Verify(t *testing.T) {
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		verify    service.TokenVerifier
+		r         *http.Request
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name: "Check handler Verify, on decode request body error",
+			r:    httptest.NewRequest(http.MethodPost, "http://url/verify", strings.NewReader("invalid")),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("invalid request: invalid character 'i' looking for beginning of value"),
+		},
+		{
+			name: "Check handler Verify, on verify error",
+			verify: func(ctx context.Context, token, audience string) (*service.TokenClaims, error) {
+				return nil, fmt.Errorf("verify-error")
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url/verify", strings.NewReader(`{"token":"token","audience":"domain"}`)),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("verify-error"),
+		},
+		{
+			name: "Check handler Verify, invalid token",
+			verify: func(ctx context.Context, token, audience string) (*service.TokenClaims, error) {
+				return nil, errors.Wrap(service.ErrInvalidToken, "token is expired")
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url/verify", strings.NewReader(`{"token":"token","audience":"domain"}`)),
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"valid":false,"message":"token is expired: Invalid token"}` + "\n"),
+			},
+		},
+		{
+			name: "Check handler Verify, valid token",
+			verify: func(ctx context.Context, token, audience string) (*service.TokenClaims, error) {
+				return &service.TokenClaims{
+					Domain:     audience,
+					Roles:      []string{"reader"},
+					Principal:  token,
+					IssueTime:  1600000000,
+					ExpiryTime: 1600003600,
+					KeyID:      "0",
+				}, nil
+			},
+			r: httptest.NewRequest(http.MethodPost, "http://url/verify", strings.NewReader(`{"token":"token","audience":"domain"}`)),
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"valid":true,"claims":{"domain":"domain","roles":["reader"],"principal":"token","issueTime":1600000000,"expiryTime":1600003600,"keyId":"0"}}` + "\n"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h := &handler{
+				verify: tt.verify,
+			}
+
+			gotError := h.Verify(w, tt.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				if gotError == nil || tt.wantError == nil || gotError.Error() != tt.wantError.Error() {
+					t.Errorf("handler.Verify() %v", &NotEqualError{"error", gotError, tt.wantError})
+					return
+				}
+			}
+			if err := EqualResponse(w, tt.want.code, tt.want.header, tt.want.body); err != nil {
+				t.Errorf("handler.Verify() %v", err)
+			}
+		})
+	}
+}
+
 func Test_handler_RoleTokenProxy(t *testing.T) {
 	type fields struct {
 		proxy *httputil.ReverseProxy
@@ -1169,6 +1599,202 @@ func Test_handler_RoleTokenProxy(t *testing.T) {
 	}
 }
 
+func Test_handler_AccessTokenProxy(t *testing.T) {
+	type fields struct {
+		proxy  *httputil.ReverseProxy
+		access service.AccessProvider
+	}
+	type args struct {
+		w http.ResponseWriter
+		r *http.Request
+	}
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		fields    fields
+		args      args
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name: "Check handler AccessTokenProxy, on access error",
+			fields: fields{
+				access: func(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+					return nil, fmt.Errorf("get-access-token-error-1201")
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-1205", nil),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-access-token-error-1201"),
+		},
+		{
+			name: "Check handler AccessTokenProxy, request got access token and proxied",
+			fields: fields{
+				// mock proxy, mirror header, prepends prefix to response
+				proxy: &httputil.ReverseProxy{
+					Director: func(*http.Request) {},
+					Transport: &roundTripperMock{
+						roundTripMock: func(request *http.Request) (response *http.Response, err error) {
+							var reqBody []byte
+							if request.Body != nil {
+								reqBody, err = ioutil.ReadAll(request.Body)
+							}
+							if err != nil {
+								return nil, err
+							}
+							return &http.Response{
+								StatusCode: http.StatusOK,
+								Header:     request.Header,
+								Body:       ioutil.NopCloser(strings.NewReader("proxied-1231" + "-" + string(reqBody))),
+							}, nil
+						},
+					},
+				},
+				access: func(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+					return &service.AccessTokenResponse{
+						AccessToken: strings.Join([]string{
+							"access-token-1239",
+							domain,
+							role,
+							proxyForPrincipal,
+						}, "-"),
+						TokenType: "Bearer",
+						ExpiresIn: 1245,
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: func() *http.Request {
+					request := httptest.NewRequest(http.MethodGet, "http://url-1252", nil)
+					request.Header.Set("Athenz-Role", "athenz-role-1253")
+					request.Header.Set("Athenz-Domain", "athenz-domain-1254")
+					request.Header.Set("Athenz-Proxy-Principal", "athenz-proxy-principal-1255")
+					return request
+				}(),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Authorization": "Bearer " + strings.Join([]string{
+						"access-token-1239",
+						"athenz-domain-1254",
+						"athenz-role-1253",
+						"athenz-proxy-principal-1255",
+					}, "-"),
+				},
+				body: []byte(`proxied-1231-`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var err error
+			h := &handler{
+				proxy:  tt.fields.proxy,
+				access: tt.fields.access,
+			}
+
+			gotError := h.AccessTokenProxy(tt.args.w, tt.args.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				err = &NotEqualError{"error", gotError, tt.wantError}
+			}
+			if err != nil {
+				t.Errorf("handler.AccessTokenProxy() %v", err)
+				return
+			}
+
+			err = EqualResponse(tt.args.w, tt.want.code, tt.want.header, tt.want.body)
+			if err != nil {
+				t.Errorf("handler.AccessTokenProxy() %v", err)
+			}
+		})
+	}
+}
+
+func Test_handler_MTLSProxy(t *testing.T) {
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		mtlsProxy *httputil.ReverseProxy
+		r         *http.Request
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name: "Check handler MTLSProxy, on mTLS proxy disabled",
+			r:    httptest.NewRequest(http.MethodGet, "http://url-1301", nil),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: ErrMTLSProxyDisabled,
+		},
+		{
+			name: "Check handler MTLSProxy, request upgraded to HTTPS and proxied",
+			mtlsProxy: func() *httputil.ReverseProxy {
+				// the director of the mTLS proxy created by New
+				p := New(config.Proxy{}, nil, WithMTLSConfig(&tls.Config{})).(*handler).mtlsProxy
+				p.Transport = &roundTripperMock{
+					roundTripMock: func(request *http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: http.StatusOK,
+							Header:     http.Header{},
+							Body:       ioutil.NopCloser(strings.NewReader("proxied-1319-" + request.URL.String())),
+						}, nil
+					},
+				}
+				return p
+			}(),
+			r: httptest.NewRequest(http.MethodGet, "http://url-1325/path", nil),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte(`proxied-1319-https://url-1325/path`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{
+				mtlsProxy: tt.mtlsProxy,
+			}
+			w := httptest.NewRecorder()
+
+			gotError := h.MTLSProxy(w, tt.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				t.Errorf("handler.MTLSProxy() %v", &NotEqualError{"error", gotError, tt.wantError})
+				return
+			}
+
+			if err := EqualResponse(w, tt.want.code, tt.want.header, tt.want.body); err != nil {
+				t.Errorf("handler.MTLSProxy() %v", err)
+			}
+		})
+	}
+}
+
 func Test_flushAndClose(t *testing.T) {
 	type args struct {
 		readCloser io.ReadCloser
@@ -1256,7 +1882,7 @@ func Test_flushAndClose(t *testing.T) {
 
 func Test_handler_ServiceCert(t *testing.T) {
 	type fields struct {
-		cert service.SvcCertProvider
+		cert service.SvcCertKeyProvider
 	}
 	type args struct {
 		w http.ResponseWriter
@@ -1278,8 +1904,8 @@ func Test_handler_ServiceCert(t *testing.T) {
 		{
 			name: "Check ServiceCert, get svccert success",
 			fields: fields{
-				cert: func() (cert []byte, err error) {
-					return []byte("Test cert"), nil
+				cert: func() (cert []byte, key []byte, err error) {
+					return []byte("Test cert"), nil, nil
 				},
 			},
 			args: args{
@@ -1293,11 +1919,29 @@ func Test_handler_ServiceCert(t *testing.T) {
 			},
 			wantError: nil,
 		},
+		{
+			name: "Check ServiceCert, get svccert with generated key success",
+			fields: fields{
+				cert: func() (cert []byte, key []byte, err error) {
+					return []byte("Test cert"), []byte("Test key"), nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-336", nil),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+				body:   []byte("{\"cert\":\"VGVzdCBjZXJ0\",\"key\":\"VGVzdCBrZXk=\"}\n"),
+			},
+			wantError: nil,
+		},
 		{
 			name: "Check ServiceCert, get svccert fail",
 			fields: fields{
-				cert: func() (cert []byte, err error) {
-					return nil, fmt.Errorf("svccert error")
+				cert: func() (cert []byte, key []byte, err error) {
+					return nil, nil, fmt.Errorf("svccert error")
 				},
 			},
 			args: args{
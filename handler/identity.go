@@ -0,0 +1,157 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// IdentityHeader represents the HTTP header to select the identity of the request.
+const IdentityHeader = "Athenz-Sidecar-Identity"
+
+// ErrUnknownIdentity represents an error that the requested identity is not configured.
+var ErrUnknownIdentity = errors.New("unknown identity")
+
+// identityHandler dispatches requests to the handler of the identity selected by the request.
+type identityHandler struct {
+	def        Handler
+	identities map[string]Handler
+}
+
+// identityRequest represents the identity field of the access token and role token request body.
+type identityRequest struct {
+	Identity string `json:"identity"`
+}
+
+// NewIdentityHandler returns a Handler dispatching requests to the handler of the identity, which is selected by IdentityHeader,
+// or the "identity" field of the access token and role token request body. The requests without identity are handled by def.
+func NewIdentityHandler(def Handler, identities map[string]Handler) Handler {
+	return &identityHandler{
+		def:        def,
+		identities: identities,
+	}
+}
+
+// NToken dispatches N-token requests by the header.
+func (h *identityHandler) NToken(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.NToken)
+}
+
+// NTokenProxy dispatches proxy requests that require a N-token by the header.
+func (h *identityHandler) NTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.NTokenProxy)
+}
+
+// AccessToken dispatches access token requests by the header or the request body.
+func (h *identityHandler) AccessToken(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, true, Handler.AccessToken)
+}
+
+// AccessTokenProxy dispatches proxy requests that require an access token by the header.
+func (h *identityHandler) AccessTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.AccessTokenProxy)
+}
+
+// RoleToken dispatches role token requests by the header or the request body.
+func (h *identityHandler) RoleToken(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, true, Handler.RoleToken)
+}
+
+// RoleTokenProxy dispatches proxy requests that require a role token by the header.
+func (h *identityHandler) RoleTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.RoleTokenProxy)
+}
+
+// IDToken dispatches ID token requests by the header or the request body.
+func (h *identityHandler) IDToken(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, true, Handler.IDToken)
+}
+
+// ServiceCert dispatches svccert requests by the header.
+func (h *identityHandler) ServiceCert(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.ServiceCert)
+}
+
+// RoleCert dispatches rolecert requests by the header or the request body.
+func (h *identityHandler) RoleCert(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, true, Handler.RoleCert)
+}
+
+// AWSCredentials dispatches AWS temporary credentials requests by the header.
+func (h *identityHandler) AWSCredentials(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.AWSCredentials)
+}
+
+// Verify handles token verification requests with the default handler, since the verification does not depend on the identity.
+func (h *identityHandler) Verify(w http.ResponseWriter, r *http.Request) error {
+	return h.def.Verify(w, r)
+}
+
+// MTLSProxy dispatches proxy requests that require the service certificate by the header.
+func (h *identityHandler) MTLSProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.MTLSProxy)
+}
+
+// ForwardProxy dispatches forward proxy requests by the header.
+func (h *identityHandler) ForwardProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.dispatch(w, r, false, Handler.ForwardProxy)
+}
+
+// RouteProxy returns the handler dispatching proxy requests to the upstream server of the named proxy route by the header.
+func (h *identityHandler) RouteProxy(name string) Func {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return h.dispatch(w, r, false, func(h Handler, w http.ResponseWriter, r *http.Request) error {
+			return h.RouteProxy(name)(w, r)
+		})
+	}
+}
+
+// dispatch calls f with the handler of the identity selected by the request. The identity header is removed not to be proxied.
+// If readBody is true and the header is not set, the identity is read from the request body, and the body is restored for the handler.
+func (h *identityHandler) dispatch(w http.ResponseWriter, r *http.Request, readBody bool, f func(Handler, http.ResponseWriter, *http.Request) error) error {
+	name := r.Header.Get(IdentityHeader)
+	r.Header.Del(IdentityHeader)
+
+	if name == "" && readBody && r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// the malformed body is reported by the handler
+		var req identityRequest
+		if json.Unmarshal(body, &req) == nil {
+			name = req.Identity
+		}
+	}
+
+	if name == "" {
+		return f(h.def, w, r)
+	}
+	ih, ok := h.identities[name]
+	if !ok {
+		return errors.Wrapf(ErrUnknownIdentity, "identity %q", name)
+	}
+	return f(ih, w, r)
+}
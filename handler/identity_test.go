@@ -0,0 +1,203 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// namedHandler is a Handler writing its name and the request body to the response.
+type namedHandler string
+
+func (h namedHandler) write(w http.ResponseWriter, r *http.Request) error {
+	body := []byte{}
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	_, err := w.Write([]byte(string(h) + ":" + r.Header.Get(IdentityHeader) + ":" + string(body)))
+	return err
+}
+
+func (h namedHandler) NToken(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) NTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) AccessToken(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) AccessTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) RoleToken(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) RoleTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) IDToken(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) ServiceCert(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) RoleCert(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) AWSCredentials(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) Verify(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) MTLSProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) ForwardProxy(w http.ResponseWriter, r *http.Request) error {
+	return h.write(w, r)
+}
+
+func (h namedHandler) RouteProxy(name string) Func {
+	return h.write
+}
+
+func Test_identityHandler(t *testing.T) {
+	type args struct {
+		f func(Handler, http.ResponseWriter, *http.Request) error
+		r *http.Request
+	}
+	type test struct {
+		name     string
+		args     args
+		wantCode int
+		wantBody string
+		wantErr  error
+	}
+	newRequest := func(header, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		if header != "" {
+			r.Header.Set(IdentityHeader, header)
+		}
+		return r
+	}
+	tests := []test{
+		{
+			name: "Check the default handler is used without identity",
+			args: args{
+				f: Handler.NToken,
+				r: newRequest("", ""),
+			},
+			wantCode: http.StatusOK,
+			wantBody: "default::",
+		},
+		{
+			name: "Check the identity handler is selected by the header, and the header is removed",
+			args: args{
+				f: Handler.NTokenProxy,
+				r: newRequest("other", ""),
+			},
+			wantCode: http.StatusOK,
+			wantBody: "other::",
+		},
+		{
+			name: "Check the identity handler is selected by the access token request body, and the body is restored",
+			args: args{
+				f: Handler.AccessToken,
+				r: newRequest("", `{"domain":"dummyDomain","identity":"other"}`),
+			},
+			wantCode: http.StatusOK,
+			wantBody: `other::{"domain":"dummyDomain","identity":"other"}`,
+		},
+		{
+			name: "Check the header has priority over the role token request body",
+			args: args{
+				f: Handler.RoleToken,
+				r: newRequest("other", `{"identity":"unknown"}`),
+			},
+			wantCode: http.StatusOK,
+			wantBody: `other::{"identity":"unknown"}`,
+		},
+		{
+			name: "Check the malformed body is passed to the default handler",
+			args: args{
+				f: Handler.RoleToken,
+				r: newRequest("", `{"identity":`),
+			},
+			wantCode: http.StatusOK,
+			wantBody: `default::{"identity":`,
+		},
+		{
+			name: "Check the request body is not read for the svccert request",
+			args: args{
+				f: Handler.ServiceCert,
+				r: newRequest("", `{"identity":"other"}`),
+			},
+			wantCode: http.StatusOK,
+			wantBody: `default::{"identity":"other"}`,
+		},
+		{
+			name: "Check unknown identity returns error",
+			args: args{
+				f: Handler.RoleTokenProxy,
+				r: newRequest("unknown", ""),
+			},
+			wantCode: http.StatusOK,
+			wantErr:  ErrUnknownIdentity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewIdentityHandler(namedHandler("default"), map[string]Handler{
+				"other": namedHandler("other"),
+			})
+			w := httptest.NewRecorder()
+			if err := tt.args.f(h, w, tt.args.r); !errors.Is(err, tt.wantErr) {
+				t.Errorf("identityHandler error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if w.Code != tt.wantCode {
+				t.Errorf("identityHandler code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("identityHandler body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
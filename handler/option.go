@@ -0,0 +1,91 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"crypto/tls"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/service"
+	"github.com/kpango/ntokend"
+)
+
+// Option represents the functional option implementation for handler.
+type Option func(*handler)
+
+// WithNTokenProvider set the N-token provider to handler.
+func WithNTokenProvider(p ntokend.TokenProvider) Option {
+	return func(h *handler) {
+		h.token = p
+	}
+}
+
+// WithAccessProvider set the access token provider to handler.
+func WithAccessProvider(p service.AccessProvider) Option {
+	return func(h *handler) {
+		h.access = p
+	}
+}
+
+// WithRoleProvider set the role token provider to handler.
+func WithRoleProvider(p service.RoleProvider) Option {
+	return func(h *handler) {
+		h.role = p
+	}
+}
+
+// WithSvcCertProvider set the service certificate provider to handler.
+func WithSvcCertProvider(p service.SvcCertKeyProvider) Option {
+	return func(h *handler) {
+		h.svcCert = p
+	}
+}
+
+// WithIDTokenProvider set the ID token provider to handler.
+func WithIDTokenProvider(p service.IDTokenProvider) Option {
+	return func(h *handler) {
+		h.idToken = p
+	}
+}
+
+// WithRoleCertProvider set the role certificate provider to handler.
+func WithRoleCertProvider(p service.RoleCertProvider) Option {
+	return func(h *handler) {
+		h.roleCert = p
+	}
+}
+
+// WithAWSCredentialsProvider set the AWS credentials provider to handler.
+func WithAWSCredentialsProvider(p service.AWSCredentialsProvider) Option {
+	return func(h *handler) {
+		h.awsCreds = p
+	}
+}
+
+// WithTokenVerifier set the token verifier to handler.
+func WithTokenVerifier(v service.TokenVerifier) Option {
+	return func(h *handler) {
+		h.verify = v
+	}
+}
+
+// WithMTLSConfig set the TLS configuration of the mTLS proxy to handler, which presents the service certificate as the client certificate.
+// The mTLS proxy is disabled if it is not set.
+func WithMTLSConfig(cfg *tls.Config) Option {
+	return func(h *handler) {
+		h.mtls = cfg
+	}
+}
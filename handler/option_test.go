@@ -0,0 +1,148 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/service"
+	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+)
+
+func TestWithNTokenProvider(t *testing.T) {
+	type args struct {
+		p ntokend.TokenProvider
+	}
+	tests := []struct {
+		name      string
+		args      args
+		checkFunc func(Option) error
+	}{
+		{
+			name: "set success",
+			args: args{
+				p: func() (string, error) {
+					return "ntoken", nil
+				},
+			},
+			checkFunc: func(o Option) error {
+				h := &handler{}
+				o(h)
+				if tok, err := h.token(); tok != "ntoken" || err != nil {
+					return errors.New("value cannot set")
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithNTokenProvider(tt.args.p)
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("WithNTokenProvider() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWithRoleProvider(t *testing.T) {
+	type args struct {
+		p service.RoleProvider
+	}
+	tests := []struct {
+		name      string
+		args      args
+		checkFunc func(Option) error
+	}{
+		{
+			name: "set success",
+			args: args{
+				p: func(ctx context.Context, domain, role, proxyForPrincipal string, minExpiry, maxExpiry int64) (*service.RoleToken, error) {
+					return &service.RoleToken{Token: "roletoken"}, nil
+				},
+			},
+			checkFunc: func(o Option) error {
+				h := &handler{}
+				o(h)
+				if tok, err := h.role(context.Background(), "", "", "", 0, 0); err != nil || tok.Token != "roletoken" {
+					return errors.New("value cannot set")
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithRoleProvider(tt.args.p)
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("WithRoleProvider() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWithMTLSConfig(t *testing.T) {
+	type args struct {
+		cfg *tls.Config
+	}
+	tests := []struct {
+		name      string
+		args      args
+		checkFunc func(Option) error
+	}{
+		{
+			name: "set success",
+			args: args{
+				cfg: &tls.Config{ServerName: "upstream"},
+			},
+			checkFunc: func(o Option) error {
+				h := &handler{}
+				o(h)
+				if h.mtls == nil || h.mtls.ServerName != "upstream" {
+					return errors.New("value cannot set")
+				}
+				return nil
+			},
+		},
+		{
+			name: "New enables the mTLS proxy",
+			args: args{
+				cfg: &tls.Config{ServerName: "upstream"},
+			},
+			checkFunc: func(o Option) error {
+				if New(config.Proxy{}, nil).(*handler).mtlsProxy != nil {
+					return errors.New("mTLS proxy enabled without the option")
+				}
+				if New(config.Proxy{}, nil, o).(*handler).mtlsProxy == nil {
+					return errors.New("mTLS proxy disabled with the option")
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithMTLSConfig(tt.args.cfg)
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("WithMTLSConfig() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/pkg/errors"
+)
+
+// ErrProxyRouteNotFound represents an error that the proxy route is not configured or disabled.
+var ErrProxyRouteNotFound = errors.New("proxy route not found")
+
+// proxyRoute forwards the requests to the upstream server of the route.
+type proxyRoute struct {
+	cfg   config.ProxyRoute
+	proxy *httputil.ReverseProxy
+}
+
+// newProxyRoute returns the proxy route rewriting the requests to the upstream URL of the route.
+func newProxyRoute(bp httputil.BufferPool, route config.ProxyRoute) (*proxyRoute, error) {
+	target, err := url.Parse(route.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, errors.Errorf("upstream is not an absolute URL: %q", route.Upstream)
+	}
+
+	return &proxyRoute{
+		cfg: route,
+		proxy: &httputil.ReverseProxy{
+			BufferPool: bp,
+			Director: func(r *http.Request) {
+				path := r.URL.Path
+				if route.StripPrefix {
+					path = strings.TrimPrefix(path, route.PathPrefix)
+				}
+				r.URL.Scheme = target.Scheme
+				r.URL.Host = target.Host
+				r.URL.Path = joinPath(target.Path, path)
+				r.URL.RawPath = ""
+				if target.RawQuery != "" {
+					if r.URL.RawQuery == "" {
+						r.URL.RawQuery = target.RawQuery
+					} else {
+						r.URL.RawQuery = target.RawQuery + "&" + r.URL.RawQuery
+					}
+				}
+				// the upstream server is called by its own host, not by the host of the client sidecar
+				r.Host = ""
+			},
+		},
+	}, nil
+}
+
+// RouteProxy returns the handler forwarding the requests to the upstream server of the named route, with the token of the route. Depends on the services of the token type.
+func (h *handler) RouteProxy(name string) Func {
+	rp, ok := h.routes[name]
+	return func(w http.ResponseWriter, r *http.Request) error {
+		defer flushAndClose(r.Body)
+
+		if !ok {
+			return errors.Wrap(ErrProxyRouteNotFound, name)
+		}
+		if err := h.injectToken(r, rp.cfg.Token, rp.cfg.Domain, rp.cfg.Roles, rp.cfg.ProxyForPrincipal, rp.cfg.Header); err != nil {
+			return err
+		}
+		rp.proxy.ServeHTTP(w, r)
+		return nil
+	}
+}
+
+// joinPath joins the upstream path and the request path with a single slash.
+func joinPath(base, path string) string {
+	switch {
+	case path == "":
+		if base == "" {
+			return "/"
+		}
+		return base
+	case base == "":
+		if strings.HasPrefix(path, "/") {
+			return path
+		}
+		return "/" + path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
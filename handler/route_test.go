@@ -0,0 +1,174 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/service"
+	"github.com/pkg/errors"
+)
+
+func Test_handler_RouteProxy(t *testing.T) {
+	// upstream server responding the requested URL, the host and the token headers
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s role=%s auth=%s custom=%s", r.Host, r.URL.RequestURI(), r.Header.Get("role-header"), r.Header.Get("Authorization"), r.Header.Get("X-Token"))
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	h := New(config.Proxy{
+		PrincipalAuthHeader: "principal-header",
+		RoleAuthHeader:      "role-header",
+		Routes: []config.ProxyRoute{
+			{
+				Name:              "role",
+				PathPrefix:        "/payments/",
+				Upstream:          upstream.URL + "/api?v=1",
+				Token:             "roletoken",
+				Domain:            "domain",
+				Roles:             []string{"reader", "writer"},
+				ProxyForPrincipal: "principal",
+			},
+			{
+				Name:        "access",
+				PathPrefix:  "/orders/",
+				StripPrefix: true,
+				Upstream:    upstream.URL,
+				Token:       "accesstoken",
+				Domain:      "domain",
+			},
+			{
+				Name:     "custom-header",
+				Upstream: upstream.URL,
+				Token:    "ntoken",
+				Header:   "X-Token",
+			},
+			{
+				Name:     "error",
+				Upstream: upstream.URL,
+				Token:    "roletoken",
+				Domain:   "error",
+			},
+			{
+				Name:     "invalid",
+				Upstream: "upstream",
+			},
+		},
+	}, nil, WithNTokenProvider(func() (string, error) {
+		return "ntoken", nil
+	}), WithAccessProvider(func(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+		return &service.AccessTokenResponse{
+			AccessToken: strings.Join([]string{"access-token", domain, role, proxyForPrincipal}, "-"),
+		}, nil
+	}), WithRoleProvider(func(ctx context.Context, domain, role, proxyForPrincipal string, minExpiry, maxExpiry int64) (*service.RoleToken, error) {
+		if domain == "error" {
+			return nil, fmt.Errorf("get-role-token-error")
+		}
+		return &service.RoleToken{
+			Token: strings.Join([]string{"role-token", domain, role, proxyForPrincipal}, "-"),
+		}, nil
+	}))
+
+	tests := []struct {
+		name      string
+		route     string
+		r         *http.Request
+		wantBody  string
+		wantError error
+	}{
+		{
+			name:     "Check role token injected, path and query appended to upstream",
+			route:    "role",
+			r:        httptest.NewRequest(http.MethodGet, "http://localhost:8080/payments/1?q=2", nil),
+			wantBody: host + " /api/payments/1?v=1&q=2 role=role-token-domain-reader,writer-principal auth= custom=",
+		},
+		{
+			name:     "Check access token injected, path prefix stripped",
+			route:    "access",
+			r:        httptest.NewRequest(http.MethodGet, "http://localhost:8080/orders/1", nil),
+			wantBody: host + " /1 role= auth=Bearer access-token-domain-- custom=",
+		},
+		{
+			name:     "Check N-token injected to the custom header",
+			route:    "custom-header",
+			r:        httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil),
+			wantBody: host + " / role= auth= custom=ntoken",
+		},
+		{
+			name:      "Check token error",
+			route:     "error",
+			r:         httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil),
+			wantError: fmt.Errorf("get-role-token-error"),
+		},
+		{
+			name:      "Check invalid upstream disables the route",
+			route:     "invalid",
+			r:         httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil),
+			wantError: errors.Wrap(ErrProxyRouteNotFound, "invalid"),
+		},
+		{
+			name:      "Check unknown route",
+			route:     "unknown",
+			r:         httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil),
+			wantError: errors.Wrap(ErrProxyRouteNotFound, "unknown"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			err := h.RouteProxy(tt.route)(w, tt.r)
+			if (err == nil) != (tt.wantError == nil) || (err != nil && err.Error() != tt.wantError.Error()) {
+				t.Errorf("handler.RouteProxy() %v", &NotEqualError{"error", err, tt.wantError})
+				return
+			}
+			if err != nil {
+				return
+			}
+			got, _ := ioutil.ReadAll(w.Body)
+			if string(got) != tt.wantBody {
+				t.Errorf("handler.RouteProxy() body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_joinPath(t *testing.T) {
+	tests := []struct {
+		base string
+		path string
+		want string
+	}{
+		{"", "", "/"},
+		{"/api", "", "/api"},
+		{"", "1", "/1"},
+		{"", "/1", "/1"},
+		{"/api/", "/1", "/api/1"},
+		{"/api", "1", "/api/1"},
+	}
+	for _, tt := range tests {
+		if got := joinPath(tt.base, tt.path); got != tt.want {
+			t.Errorf("joinPath(%q, %q) = %q, want %q", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
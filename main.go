@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"syscall"
+	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
 	"github.com/AthenZ/athenz-client-sidecar/v2/usecase"
@@ -36,6 +37,7 @@ var Version string
 type params struct {
 	configFilePath string
 	showVersion    bool
+	validate       bool
 }
 
 func parseParams() (*params, error) {
@@ -49,6 +51,10 @@ func parseParams() (*params, error) {
 		"version",
 		false,
 		"show athenz-client-sidecar version")
+	f.BoolVar(&p.validate,
+		"validate",
+		false,
+		"validate the client config yaml file and exit")
 
 	err := f.Parse(os.Args[1:])
 	if err != nil {
@@ -58,38 +64,13 @@ func parseParams() (*params, error) {
 	return p, nil
 }
 
-func run(cfg config.Config) []error {
-	g := glg.Get().SetMode(glg.NONE)
-
-	switch cfg.Log.Level {
-	case "":
-		// disable logging
-	case "fatal":
-		g = g.SetLevelMode(glg.FATAL, glg.STD)
-	case "error":
-		g = g.SetLevelMode(glg.FATAL, glg.STD).
-			SetLevelMode(glg.ERR, glg.STD)
-	case "warn":
-		g = g.SetLevelMode(glg.FATAL, glg.STD).
-			SetLevelMode(glg.ERR, glg.STD).
-			SetLevelMode(glg.WARN, glg.STD)
-	case "info":
-		g = g.SetLevelMode(glg.FATAL, glg.STD).
-			SetLevelMode(glg.ERR, glg.STD).
-			SetLevelMode(glg.WARN, glg.STD).
-			SetLevelMode(glg.INFO, glg.STD)
-	case "debug":
-		g = g.SetLevelMode(glg.FATAL, glg.STD).
-			SetLevelMode(glg.ERR, glg.STD).
-			SetLevelMode(glg.WARN, glg.STD).
-			SetLevelMode(glg.INFO, glg.STD).
-			SetLevelMode(glg.DEBG, glg.STD)
-	default:
-		return []error{errors.New("invalid log level")}
+func run(cfg config.Config, configFilePath string) []error {
+	if err := setLogger(cfg.Log); err != nil {
+		return []error{err}
 	}
 
-	if !cfg.Log.Color {
-		g.DisableColor()
+	if err := validate(cfg, configFilePath); err != nil {
+		return []error{err}
 	}
 
 	daemon, err := usecase.New(cfg)
@@ -103,16 +84,37 @@ func run(cfg config.Config) []error {
 	ech := daemon.Start(ctx)
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	var wch <-chan struct{}
+	if cfg.Reload.Enable && configFilePath != "" {
+		period, err := time.ParseDuration(cfg.Reload.CheckPeriod)
+		if err != nil && cfg.Reload.CheckPeriod != "" {
+			glg.Warn("Reload CheckPeriod: " + err.Error())
+		}
+		wch = config.Watch(ctx, configFilePath, period)
+	}
 
 	isSignal := false
 	for {
 		select {
 		case sig := <-sigCh:
 			glg.Infof("Athenz client sidecar received signal: %v", sig)
+			if sig == syscall.SIGHUP {
+				if err := reload(daemon, configFilePath); err != nil {
+					glg.Errorf("Athenz client sidecar configuration reload rejected: %v", err)
+				}
+				continue
+			}
 			isSignal = true
 			cancel()
 			glg.Warn("Athenz client sidecar shutdown...")
+		case <-wch:
+			glg.Info("Athenz client sidecar configuration file is changed")
+			if err := reload(daemon, configFilePath); err != nil {
+				glg.Errorf("Athenz client sidecar configuration reload rejected: %v", err)
+			}
 		case errs := <-ech:
 			if !isSignal || len(errs) != 1 || errs[0] != ctx.Err() {
 				return errs
@@ -122,6 +124,97 @@ func run(cfg config.Config) []error {
 	}
 }
 
+// reload reads and validates the configuration file, and reloads the daemon and the logger with it.
+// The running daemon and logger are kept if any error occurred.
+func reload(daemon usecase.Tenant, configFilePath string) error {
+	cfg, err := config.New(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := validate(*cfg, configFilePath); err != nil {
+		return err
+	}
+
+	if err := daemon.Reload(*cfg); err != nil {
+		return errors.Wrap(err, "tenant error")
+	}
+
+	return setLogger(cfg.Log)
+}
+
+// validate logs the warnings found in the configuration, e.g. the unknown keys of the configuration file, and returns an error reporting the other problems,
+// or nil if the configuration is valid. The warnings are errors only in the -validate mode, so that the configuration accepted by the previous versions keeps working.
+func validate(cfg config.Config, configFilePath string) error {
+	problems := config.ValidateConfig(cfg)
+	if configFilePath != "" {
+		problems = append(problems, config.UnknownKeys(configFilePath)...)
+	}
+
+	var errs []error
+	for _, p := range problems {
+		if ve, ok := p.(*config.ValidationError); ok && ve.Warning {
+			glg.Warnf("configuration warning: %v", p)
+			continue
+		}
+		errs = append(errs, p)
+	}
+	if len(errs) > 0 {
+		return errors.Errorf("invalid configuration: %v", errs)
+	}
+	return nil
+}
+
+// isValidLogLevel returns whether the logger output level is supported.
+func isValidLogLevel(level string) bool {
+	switch level {
+	case "", "fatal", "error", "warn", "info", "debug":
+		return true
+	}
+	return false
+}
+
+// setLogger sets the logger output level and color from the configuration.
+func setLogger(cfg config.Log) error {
+	if !isValidLogLevel(cfg.Level) {
+		return errors.New("invalid log level")
+	}
+
+	g := glg.Get().SetMode(glg.NONE)
+
+	switch cfg.Level {
+	case "":
+		// disable logging
+	case "fatal":
+		g = g.SetLevelMode(glg.FATAL, glg.STD)
+	case "error":
+		g = g.SetLevelMode(glg.FATAL, glg.STD).
+			SetLevelMode(glg.ERR, glg.STD)
+	case "warn":
+		g = g.SetLevelMode(glg.FATAL, glg.STD).
+			SetLevelMode(glg.ERR, glg.STD).
+			SetLevelMode(glg.WARN, glg.STD)
+	case "info":
+		g = g.SetLevelMode(glg.FATAL, glg.STD).
+			SetLevelMode(glg.ERR, glg.STD).
+			SetLevelMode(glg.WARN, glg.STD).
+			SetLevelMode(glg.INFO, glg.STD)
+	case "debug":
+		g = g.SetLevelMode(glg.FATAL, glg.STD).
+			SetLevelMode(glg.ERR, glg.STD).
+			SetLevelMode(glg.WARN, glg.STD).
+			SetLevelMode(glg.INFO, glg.STD).
+			SetLevelMode(glg.DEBG, glg.STD)
+	}
+
+	if cfg.Color {
+		g.EnableColor()
+	} else {
+		g.DisableColor()
+	}
+	return nil
+}
+
 func main() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -150,18 +243,26 @@ func main() {
 		return
 	}
 
-	cfg, err := config.New(p.configFilePath)
-	if err != nil {
-		glg.Fatal(err)
+	if p.validate {
+		errs := config.Validate(p.configFilePath)
+		for _, err := range errs {
+			glg.Error(err)
+		}
+		if len(errs) > 0 {
+			glg.Fatalf("%s: %d configuration problem(s) found", p.configFilePath, len(errs))
+			return
+		}
+		glg.Infof("%s: configuration is valid", p.configFilePath)
 		return
 	}
 
-	if cfg.Version != config.GetVersion() {
-		glg.Fatal(errors.New("invalid Athenz client proxy configuration version"))
+	cfg, err := config.New(p.configFilePath)
+	if err != nil {
+		glg.Fatal(err)
 		return
 	}
 
-	errs := run(*cfg)
+	errs := run(*cfg, p.configFilePath)
 	if len(errs) > 0 {
 		glg.Fatalf("%+v", errs)
 		return
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"reflect"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/usecase"
 	"github.com/kpango/glg"
 	"github.com/pkg/errors"
 )
@@ -57,7 +59,7 @@ func TestParseParams(t *testing.T) {
 			return test{
 				name: "check parseParams set user flags",
 				beforeFunc: func() {
-					os.Args = []string{"", "-f", "/dummy/path", "-version", "true"}
+					os.Args = []string{"", "-f", "/dummy/path", "-version", "-validate"}
 				},
 				checkFunc: func(p *params) error {
 					if p.configFilePath != "/dummy/path" {
@@ -66,6 +68,9 @@ func TestParseParams(t *testing.T) {
 					if p.showVersion != true {
 						return errors.Errorf("unexpected showVersion flag. got: %v, want: true", p.showVersion)
 					}
+					if p.validate != true {
+						return errors.Errorf("unexpected validate flag. got: %v, want: true", p.validate)
+					}
 
 					return nil
 				},
@@ -120,7 +125,7 @@ func Test_run(t *testing.T) {
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				wantExitingErr := `tenant error: ntokend error: invalid token refresh period invalid, time: invalid duration "invalid"`
+				wantExitingErr := `invalid configuration: [nToken.athenzDomain: must not be empty nToken.serviceName: must not be empty nToken.expiry: must not be empty nToken.refreshPeriod: invalid duration "invalid" nToken.privateKeyPath: must not be empty]`
 				if gotErrs == nil || gotErrs[0].Error() != wantExitingErr {
 					return errors.Errorf("Unexpected exit: %v", gotErrs)
 				}
@@ -160,7 +165,7 @@ func Test_run(t *testing.T) {
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				wantExitingErr := `tenant error: ntokend error: invalid token refresh period invalid, time: invalid duration "invalid"`
+				wantExitingErr := `invalid configuration: [nToken.athenzDomain: must not be empty nToken.serviceName: must not be empty nToken.expiry: must not be empty nToken.refreshPeriod: invalid duration "invalid" nToken.privateKeyPath: must not be empty]`
 				if gotErrs == nil || gotErrs[0].Error() != wantExitingErr {
 					return errors.Errorf("Unexpected exit: %v", gotErrs)
 				}
@@ -200,7 +205,7 @@ func Test_run(t *testing.T) {
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				wantExitingErr := `tenant error: ntokend error: invalid token refresh period invalid, time: invalid duration "invalid"`
+				wantExitingErr := `invalid configuration: [nToken.athenzDomain: must not be empty nToken.serviceName: must not be empty nToken.expiry: must not be empty nToken.refreshPeriod: invalid duration "invalid" nToken.privateKeyPath: must not be empty]`
 				if gotErrs == nil || gotErrs[0].Error() != wantExitingErr {
 					return errors.Errorf("Unexpected exit: %v", gotErrs)
 				}
@@ -240,7 +245,7 @@ func Test_run(t *testing.T) {
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				wantExitingErr := `tenant error: ntokend error: invalid token refresh period invalid, time: invalid duration "invalid"`
+				wantExitingErr := `invalid configuration: [nToken.athenzDomain: must not be empty nToken.serviceName: must not be empty nToken.expiry: must not be empty nToken.refreshPeriod: invalid duration "invalid" nToken.privateKeyPath: must not be empty]`
 				if gotErrs == nil || gotErrs[0].Error() != wantExitingErr {
 					return errors.Errorf("Unexpected exit: %v", gotErrs)
 				}
@@ -280,7 +285,7 @@ func Test_run(t *testing.T) {
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				wantExitingErr := `tenant error: ntokend error: invalid token refresh period invalid, time: invalid duration "invalid"`
+				wantExitingErr := `invalid configuration: [nToken.athenzDomain: must not be empty nToken.serviceName: must not be empty nToken.expiry: must not be empty nToken.refreshPeriod: invalid duration "invalid" nToken.privateKeyPath: must not be empty]`
 				if gotErrs == nil || gotErrs[0].Error() != wantExitingErr {
 					return errors.Errorf("Unexpected exit: %v", gotErrs)
 				}
@@ -320,7 +325,7 @@ func Test_run(t *testing.T) {
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				wantExitingErr := `tenant error: ntokend error: invalid token refresh period invalid, time: invalid duration "invalid"`
+				wantExitingErr := `invalid configuration: [nToken.athenzDomain: must not be empty nToken.serviceName: must not be empty nToken.expiry: must not be empty nToken.refreshPeriod: invalid duration "invalid" nToken.privateKeyPath: must not be empty]`
 				if gotErrs == nil || gotErrs[0].Error() != wantExitingErr {
 					return errors.Errorf("Unexpected exit: %v", gotErrs)
 				}
@@ -361,7 +366,7 @@ func Test_run(t *testing.T) {
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				wantExitingErr := `tenant error: ntokend error: invalid token refresh period invalid, time: invalid duration "invalid"`
+				wantExitingErr := `invalid configuration: [nToken.athenzDomain: must not be empty nToken.serviceName: must not be empty nToken.expiry: must not be empty nToken.refreshPeriod: invalid duration "invalid" nToken.privateKeyPath: must not be empty]`
 				if gotErrs == nil || gotErrs[0].Error() != wantExitingErr {
 					return errors.Errorf("Unexpected exit: %v", gotErrs)
 				}
@@ -390,10 +395,44 @@ func Test_run(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name: "invalid config",
+			args: args{
+				cfg: config.Config{
+					Version: config.GetVersion(),
+					NToken: config.NToken{
+						Enable:         true,
+						AthenzDomain:   "domain",
+						ServiceName:    "service",
+						RefreshPeriod:  "1h",
+						KeyVersion:     "keyId",
+						Expiry:         "1h",
+						PrivateKeyPath: "./test/data/dummyServer.key",
+					},
+					RoleToken: config.RoleToken{
+						Enable:              true,
+						AthenzURL:           "https://athenz.io/zts/v1",
+						PrincipalAuthHeader: "Athenz-Principal-Auth",
+						RefreshPeriod:       "dummy",
+					},
+				},
+			},
+			checkFunc: func(gotErrs []error) error {
+				want := `invalid configuration: [roleToken.refreshPeriod: invalid duration "dummy"]`
+				if len(gotErrs) != 1 {
+					return errors.New("len(gotErrs) != 1")
+				}
+				if gotErrs[0].Error() != want {
+					return errors.Errorf("gotErrs: %v, want: %v", gotErrs[0], want)
+				}
+				return nil
+			},
+		},
 		{
 			name: "run error",
 			args: args{
 				cfg: config.Config{
+					Version: config.GetVersion(),
 					NToken: config.NToken{
 						Enable:         true,
 						AthenzDomain:   "domain",
@@ -404,13 +443,15 @@ func Test_run(t *testing.T) {
 						PrivateKeyPath: "./test/data/dummyServer.key",
 					},
 					RoleToken: config.RoleToken{
-						Enable:        true,
-						RefreshPeriod: "dummy",
+						Enable:              true,
+						AthenzURL:           "https://athenz.io/zts/v1",
+						AthenzCAPath:        "./test/data/invalid_dummyCa.pem",
+						PrincipalAuthHeader: "Athenz-Principal-Auth",
 					},
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				want := `tenant error: role token service error: RefreshPeriod: time: invalid duration "dummy": Invalid config`
+				want := `tenant error: role token service error: Certification Failed: Invalid config`
 				if len(gotErrs) != 1 {
 					return errors.New("len(gotErrs) != 1")
 				}
@@ -424,27 +465,61 @@ func Test_run(t *testing.T) {
 			name: "daemon init error",
 			args: args{
 				cfg: config.Config{
+					Version: config.GetVersion(),
 					NToken: config.NToken{
-						Enable:        true,
-						RefreshPeriod: "dummy",
+						Enable:         true,
+						AthenzDomain:   "domain",
+						ServiceName:    "service",
+						RefreshPeriod:  "1h",
+						KeyVersion:     "keyId",
+						Expiry:         "1h",
+						PrivateKeyPath: "./test/data/invalid_dummyServer.key",
 					},
 				},
 			},
 			checkFunc: func(gotErrs []error) error {
-				want := `tenant error: ntokend error: invalid token refresh period dummy, time: invalid duration "dummy"`
+				want := `tenant error: ntokend error: failed to create ZMS SVC Token Builder`
 				if len(gotErrs) != 1 {
 					return errors.New("len(gotErrs) != 1")
 				}
-				if gotErrs[0].Error() != want {
+				if !strings.HasPrefix(gotErrs[0].Error(), want) {
 					return errors.Errorf("gotErrs: %v, want: %v", gotErrs[0], want)
 				}
 				return nil
 			},
 		},
+		{
+			name: "Keep running when reload by SIGHUP is rejected",
+			args: args{
+				cfg: config.Config{
+					Version: config.GetVersion(),
+					NToken: config.NToken{
+						Enable: false,
+					},
+					Server: config.Server{
+						ShutdownDelay:   "1s",
+						Timeout:         "10s",
+						ShutdownTimeout: "1s",
+					},
+				},
+			},
+			beforeFunc: func(proc *os.Process) {
+				proc.Signal(syscall.SIGHUP)
+				time.Sleep(time.Second)
+				proc.Signal(os.Interrupt)
+			},
+			checkFunc: func(gotErrs []error) error {
+				if len(gotErrs) >= 1 {
+					return errors.Errorf("Unexpected exit: %v", gotErrs)
+				}
+				return nil
+			},
+		},
 		{
 			name: "Detect no errors including context error with interrupt shutdown of Athenz Sidecar",
 			args: args{
 				cfg: config.Config{
+					Version: config.GetVersion(),
 					NToken: config.NToken{
 						Enable:            false,
 						PrivateKeyPath:    "./test/data/dummyServer.key",
@@ -481,20 +556,22 @@ func Test_run(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
+		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			proc, err := os.FindProcess(os.Getpid())
 
-			if (tt.beforeFunc != nil) {
-				time.AfterFunc(3 * time.Second, func() {
+			if tt.beforeFunc != nil {
+				timer := time.AfterFunc(3*time.Second, func() {
 					tt.beforeFunc(proc)
 				})
+				defer timer.Stop()
 			}
 			
 			if err != nil {
 				t.Fatalf("os.FindProcess(os.Getpid()) fails: %v", err)
 			}
 
-			gotErrs := run(tt.args.cfg)
+			gotErrs := run(tt.args.cfg, "")
 			if err := tt.checkFunc(gotErrs); err != nil {
 				t.Errorf("run() fails: %v", err)
 			}
@@ -502,6 +579,154 @@ func Test_run(t *testing.T) {
 	}
 }
 
+type tenantMock struct {
+	reloadFunc func(config.Config) error
+}
+
+func (t *tenantMock) Start(ctx context.Context) chan []error {
+	return nil
+}
+
+func (t *tenantMock) Reload(cfg config.Config) error {
+	return t.reloadFunc(cfg)
+}
+
+func Test_reload(t *testing.T) {
+	type args struct {
+		daemon         usecase.Tenant
+		configFilePath string
+	}
+	type test struct {
+		name    string
+		args    args
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "reload with non-existing config file",
+			args: args{
+				configFilePath: "./test/data/non_exist.yaml",
+			},
+			wantErr: "open ./test/data/non_exist.yaml: no such file or directory",
+		},
+		{
+			name: "reload with invalid log level",
+			args: args{
+				configFilePath: "./test/data/invalid_log_config.yaml",
+			},
+			wantErr: `invalid configuration: [log.level: must be one of "debug", "info", "warn", "error", "fatal" or empty, got "invalid"]`,
+		},
+		{
+			name: "reload with invalid config keeps the running daemon",
+			args: args{
+				daemon: &tenantMock{
+					reloadFunc: func(config.Config) error {
+						return errors.New("daemon must not be reloaded")
+					},
+				},
+				configFilePath: "./test/data/invalid_duration_config.yaml",
+			},
+			wantErr: `invalid configuration: [roleToken.refreshPeriod: invalid duration "1 minute"]`,
+		},
+		{
+			name: "reload with unknown keys",
+			args: args{
+				daemon: &tenantMock{
+					reloadFunc: func(config.Config) error {
+						return nil
+					},
+				},
+				configFilePath: "./test/data/unknown_key_config.yaml",
+			},
+		},
+		{
+			name: "reload rejected by daemon",
+			args: args{
+				daemon: &tenantMock{
+					reloadFunc: func(config.Config) error {
+						return errors.New("dummy error")
+					},
+				},
+				configFilePath: "./test/data/valid_config_false.yaml",
+			},
+			wantErr: "tenant error: dummy error",
+		},
+		{
+			name: "reload success",
+			args: args{
+				daemon: &tenantMock{
+					reloadFunc: func(cfg config.Config) error {
+						if cfg.Version != config.GetVersion() {
+							return errors.New("unexpected config")
+						}
+						return nil
+					},
+				},
+				configFilePath: "./test/data/valid_config_false.yaml",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := reload(tt.args.daemon, tt.args.configFilePath)
+			if err != nil {
+				if err.Error() != tt.wantErr {
+					t.Errorf("reload() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			} else if tt.wantErr != "" {
+				t.Errorf("reload() error = nil, wantErr %v", tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validate(t *testing.T) {
+	type args struct {
+		cfg            config.Config
+		configFilePath string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr string
+	}{
+		{
+			name: "version mismatch and unknown keys are warnings",
+			args: args{
+				cfg: config.Config{
+					Version: "v1.0.0",
+				},
+				configFilePath: "./test/data/unknown_key_config.yaml",
+			},
+		},
+		{
+			name: "invalid values are errors",
+			args: args{
+				cfg: config.Config{
+					Version: "v1.0.0",
+					Log: config.Log{
+						Level: "invalid",
+					},
+				},
+				configFilePath: "./test/data/unknown_key_config.yaml",
+			},
+			wantErr: `invalid configuration: [log.level: must be one of "debug", "info", "warn", "error", "fatal" or empty, got "invalid"]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.args.cfg, tt.args.configFilePath)
+			if err != nil {
+				if err.Error() != tt.wantErr {
+					t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			} else if tt.wantErr != "" {
+				t.Errorf("validate() error = nil, wantErr %v", tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_getVersion(t *testing.T) {
 	tests := []struct {
 		name string
@@ -583,6 +808,26 @@ func Test_mainExitCode(t *testing.T) {
 			signal:       nil,
 			wantExitCode: 1,
 		},
+		{
+			name: "validate valid config",
+			args: []string{
+				"-validate",
+				"-f",
+				"./test/data/valid_config_false.yaml",
+			},
+			signal:       nil,
+			wantExitCode: 0,
+		},
+		{
+			name: "validate invalid config",
+			args: []string{
+				"-validate",
+				"-f",
+				"./test/data/invalid_log_config.yaml",
+			},
+			signal:       nil,
+			wantExitCode: 1,
+		},
 		{
 			name: "run till termination SIGINT",
 			args: []string{
@@ -0,0 +1,165 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// namespace is the prefix of all the metric names.
+	namespace = "athenz_client_sidecar"
+
+	// RoleToken represents the role token subsystem label value.
+	RoleToken = "roletoken"
+
+	// AccessToken represents the access token subsystem label value.
+	AccessToken = "accesstoken"
+
+	// SvcCert represents the service certificate subsystem label value.
+	SvcCert = "svccert"
+
+	// IDToken represents the ID token subsystem label value.
+	IDToken = "idtoken"
+
+	// RoleCert represents the role certificate subsystem label value.
+	RoleCert = "rolecert"
+
+	// AWSCredentials represents the AWS temporary credentials subsystem label value.
+	AWSCredentials = "awscreds"
+
+	// Verify represents the token verification subsystem label value.
+	Verify = "verify"
+
+	// StatusError represents the status label value when no HTTP response is received from the Athenz server.
+	StatusError = "error"
+)
+
+var (
+	// registry holds all the client sidecar metrics.
+	registry = prometheus.NewRegistry()
+
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Number of token cache lookups, partitioned by token type and result (hit, stale, negative or miss).",
+	}, []string{"type", "result"})
+
+	athenzRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "athenz_requests_total",
+		Help:      "Number of requests sent to the Athenz server, partitioned by request type and response status code.",
+	}, []string{"type", "code"})
+
+	athenzRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "athenz_request_duration_seconds",
+		Help:      "Latency of the requests sent to the Athenz server, partitioned by request type and response status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "code"})
+
+	refreshFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refresh_failures_total",
+		Help:      "Number of scheduled background refreshes that did not succeed, partitioned by type.",
+	}, []string{"type"})
+
+	retryFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retry_failures_total",
+		Help:      "Number of failed attempts during background refreshes, partitioned by type.",
+	}, []string{"type"})
+
+	routeRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of requests handled by the client sidecar, partitioned by route, method and response status code.",
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		cacheRequests,
+		athenzRequests,
+		athenzRequestDuration,
+		refreshFailures,
+		retryFailures,
+		routeRequests,
+	)
+}
+
+// Handler returns a http.Handler which exposes the metrics in Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// CacheHit records a token cache hit of the given type.
+func CacheHit(typ string) {
+	cacheRequests.WithLabelValues(typ, "hit").Inc()
+}
+
+// CacheStale records a token cache hit of the given type, which is past its refresh point and served while being refreshed.
+func CacheStale(typ string) {
+	cacheRequests.WithLabelValues(typ, "stale").Inc()
+}
+
+// CacheNegative records a request of the given type, which is answered by the cached client error of the Athenz server.
+func CacheNegative(typ string) {
+	cacheRequests.WithLabelValues(typ, "negative").Inc()
+}
+
+// CacheMiss records a token cache miss of the given type.
+func CacheMiss(typ string) {
+	cacheRequests.WithLabelValues(typ, "miss").Inc()
+}
+
+// ObserveAthenzRequest records a request sent to the Athenz server with its status code and the latency since start.
+// The code should be StatusError when no response is received.
+func ObserveAthenzRequest(typ, code string, start time.Time) {
+	athenzRequests.WithLabelValues(typ, code).Inc()
+	athenzRequestDuration.WithLabelValues(typ, code).Observe(time.Since(start).Seconds())
+}
+
+// StatusCode returns the status label value of the HTTP status code.
+func StatusCode(code int) string {
+	return strconv.Itoa(code)
+}
+
+// RefreshFailure records a scheduled background refresh that did not succeed.
+func RefreshFailure(typ string) {
+	refreshFailures.WithLabelValues(typ).Inc()
+}
+
+// RetryFailure records a failed attempt during a background refresh.
+func RetryFailure(typ string) {
+	retryFailures.WithLabelValues(typ).Inc()
+}
+
+// InstrumentRoute wraps the handler to count the requests handled by the route.
+func InstrumentRoute(route string, h http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(routeRequests.MustCurryWith(prometheus.Labels{
+		"route": route,
+	}), h)
+}
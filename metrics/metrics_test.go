@@ -0,0 +1,131 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape() (string, error) {
+	rw := httptest.NewRecorder()
+	Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rw.Code != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %v", rw.Code)
+	}
+	return rw.Body.String(), nil
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		beforeFunc func()
+		want       []string
+	}{
+		{
+			name: "Check cache hit and miss are exported",
+			beforeFunc: func() {
+				CacheHit(RoleToken)
+				CacheMiss(AccessToken)
+				CacheStale(AccessToken)
+				CacheNegative(RoleToken)
+			},
+			want: []string{
+				`athenz_client_sidecar_cache_requests_total{result="hit",type="roletoken"}`,
+				`athenz_client_sidecar_cache_requests_total{result="miss",type="accesstoken"}`,
+				`athenz_client_sidecar_cache_requests_total{result="stale",type="accesstoken"}`,
+				`athenz_client_sidecar_cache_requests_total{result="negative",type="roletoken"}`,
+			},
+		},
+		{
+			name: "Check Athenz requests are exported",
+			beforeFunc: func() {
+				ObserveAthenzRequest(SvcCert, StatusCode(http.StatusOK), time.Now())
+				ObserveAthenzRequest(RoleToken, StatusError, time.Now())
+			},
+			want: []string{
+				`athenz_client_sidecar_athenz_requests_total{code="200",type="svccert"}`,
+				`athenz_client_sidecar_athenz_requests_total{code="error",type="roletoken"}`,
+				`athenz_client_sidecar_athenz_request_duration_seconds_bucket{code="200",type="svccert",le="+Inf"}`,
+			},
+		},
+		{
+			name: "Check refresh and retry failures are exported",
+			beforeFunc: func() {
+				RefreshFailure(AccessToken)
+				RetryFailure(RoleToken)
+			},
+			want: []string{
+				`athenz_client_sidecar_refresh_failures_total{type="accesstoken"}`,
+				`athenz_client_sidecar_retry_failures_total{type="roletoken"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.beforeFunc()
+			got, err := scrape()
+			if err != nil {
+				t.Errorf("Handler() error = %v", err)
+				return
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("Handler() output does not contain %v", w)
+				}
+			}
+		})
+	}
+}
+
+func TestInstrumentRoute(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		method string
+		status int
+		want   string
+	}{
+		{
+			name:   "Check route request is counted with status code",
+			route:  "RoleToken Handler",
+			method: http.MethodPost,
+			status: http.StatusInternalServerError,
+			want:   `athenz_client_sidecar_http_requests_total{code="500",method="post",route="RoleToken Handler"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := InstrumentRoute(tt.route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/", nil))
+
+			got, err := scrape()
+			if err != nil {
+				t.Errorf("Handler() error = %v", err)
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("InstrumentRoute() output does not contain %v", tt.want)
+			}
+		})
+	}
+}
@@ -33,6 +33,9 @@ type AccessRequest struct {
 
 	// Expiry represents the Expiry field of the request.
 	Expiry int64 `json:"expiry"`
+
+	// Identity represents the name of the identity to get the token. The default identity is used if it is empty.
+	Identity string `json:"identity,omitempty"`
 }
 
 // RoleRequest represents the request information to get the role token.
@@ -51,6 +54,51 @@ type RoleRequest struct {
 
 	// MaxExpiry represents the MaxExpiry field of the request.
 	MaxExpiry int64 `json:"max_expiry"`
+
+	// Identity represents the name of the identity to get the token. The default identity is used if it is empty.
+	Identity string `json:"identity,omitempty"`
+}
+
+// IDTokenRequest represents the request information to get the ID token.
+type IDTokenRequest struct {
+	// Audience represents the Athenz service name of the relying party, e.g. "domain.service".
+	Audience string `json:"audience"`
+
+	// Domain represents the domain of the roles included in the ID token. Empty implies no roles.
+	Domain string `json:"domain"`
+
+	// Role represents the role names included in the ID token, separated by comma. Empty implies all the roles in the domain.
+	Role string `json:"role"`
+
+	// RedirectURI represents the redirect URI registered for the audience. Empty implies the configured redirect URI.
+	RedirectURI string `json:"redirect_uri"`
+
+	// Expiry represents the Expiry field of the request.
+	Expiry int64 `json:"expiry"`
+
+	// Identity represents the name of the identity to get the token. The default identity is used if it is empty.
+	Identity string `json:"identity,omitempty"`
+}
+
+// RoleCertRequest represents the request information to get the role certificate.
+type RoleCertRequest struct {
+	// Domain represents the domain of the role.
+	Domain string `json:"domain"`
+
+	// Role represents the role name of the role certificate.
+	Role string `json:"role"`
+
+	// Identity represents the name of the identity to get the role certificate. The default identity is used if it is empty.
+	Identity string `json:"identity,omitempty"`
+}
+
+// VerifyRequest represents the request information to verify the role token or the access token.
+type VerifyRequest struct {
+	// Token represents the role token or the access token to verify.
+	Token string `json:"token"`
+
+	// Audience represents the domain the token must be issued for.
+	Audience string `json:"audience"`
 }
 
 // AccessResponse represents the AccessTokenResponse from postAccessTokenRequest.
@@ -59,6 +107,28 @@ type AccessResponse = service.AccessTokenResponse
 // RoleResponse represents the basic information of the role token.
 type RoleResponse = service.RoleToken
 
+// TokenClaims represents the claims parsed from the role token or the access token.
+type TokenClaims = service.TokenClaims
+
+// VerboseAccessResponse represents the access token response with the claims parsed from the access token, returned in the verbose mode.
+type VerboseAccessResponse struct {
+	*AccessResponse
+
+	// Claims represents the claims of the access token.
+	Claims *TokenClaims `json:"claims"`
+}
+
+// VerboseRoleResponse represents the role token response with the claims parsed from the role token, returned in the verbose mode.
+type VerboseRoleResponse struct {
+	*RoleResponse
+
+	// Claims represents the claims of the role token.
+	Claims *TokenClaims `json:"claims"`
+}
+
+// IDTokenResponse represents the ID token returned by the Athenz server.
+type IDTokenResponse = service.IDTokenResponse
+
 // NTokenResponse represents the response information of get N-token request.
 type NTokenResponse struct {
 	// NToken represents the N-token generated.
@@ -68,4 +138,45 @@ type NTokenResponse struct {
 // SvcCertResponse represents the response information of get svccert request.
 type SvcCertResponse struct {
 	Cert []byte `json:"cert"`
+
+	// Key represents the private key of the certificate in PEM format. It is only contained when the client sidecar generates the private key.
+	Key []byte `json:"key,omitempty"`
+}
+
+// RoleCertResponse represents the response information of post rolecert request.
+type RoleCertResponse struct {
+	Cert []byte `json:"cert"`
+
+	// Key represents the private key of the certificate in PEM format. It is only contained when the client sidecar generates the private key.
+	Key []byte `json:"key,omitempty"`
+}
+
+// AWSCredentialsResponse represents the AWS temporary credentials in the format of the AWS credential_process, so that the AWS SDK can use the client sidecar directly.
+type AWSCredentialsResponse struct {
+	// Version represents the version of the credential_process format, which is always 1.
+	Version int `json:"Version"`
+
+	// AccessKeyID represents the access key ID of the AWS temporary credentials.
+	AccessKeyID string `json:"AccessKeyId"`
+
+	// SecretAccessKey represents the secret access key of the AWS temporary credentials.
+	SecretAccessKey string `json:"SecretAccessKey"`
+
+	// SessionToken represents the session token of the AWS temporary credentials.
+	SessionToken string `json:"SessionToken"`
+
+	// Expiration represents the expiration time of the AWS temporary credentials in RFC 3339 format.
+	Expiration string `json:"Expiration"`
+}
+
+// VerifyResponse represents the result of the token verification.
+type VerifyResponse struct {
+	// Valid represents whether the token is valid.
+	Valid bool `json:"valid"`
+
+	// Message represents the reason why the token is invalid.
+	Message string `json:"message,omitempty"`
+
+	// Claims represents the claims of the valid token.
+	Claims *TokenClaims `json:"claims,omitempty"`
 }
@@ -0,0 +1,117 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/handler"
+	"github.com/AthenZ/athenz-client-sidecar/v2/service"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+)
+
+// errorResponse represents the response body of the handler error.
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+// errorDetail represents the detail of the handler error.
+type errorDetail struct {
+	// Code represents the HTTP status code of the response.
+	Code int `json:"code"`
+
+	// Status represents the HTTP status text of the response.
+	Status string `json:"status"`
+
+	// Message represents the error message.
+	Message string `json:"message"`
+
+	// UpstreamCode represents the HTTP status code returned by the Athenz server.
+	UpstreamCode int `json:"upstreamCode,omitempty"`
+
+	// UpstreamMessage represents the error message returned by the Athenz server.
+	UpstreamMessage string `json:"upstreamMessage,omitempty"`
+}
+
+// writeError writes the handler error to the response in JSON format, with the HTTP status code mapped from the error.
+func writeError(w http.ResponseWriter, err error) {
+	code := statusCode(err)
+	res := errorResponse{
+		Error: errorDetail{
+			Code:    code,
+			Status:  http.StatusText(code),
+			Message: err.Error(),
+		},
+	}
+	var uerr *service.UpstreamError
+	if errors.As(err, &uerr) {
+		res.Error.UpstreamCode = uerr.Code
+		res.Error.UpstreamMessage = uerr.Message
+	}
+
+	w.Header().Set(service.ContentType, fmt.Sprintf("%s;%s", service.ApplicationJSON, service.CharsetUTF8))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		glg.Error(err)
+	}
+}
+
+// statusCode returns the HTTP status code of the handler error.
+// The client errors of the Athenz server are passed through, the server errors and the transport errors are mapped to 502, 503 or 504,
+// the unknown identity and the malformed request are mapped to 400, the forward proxy destination not allowed is mapped to 403,
+// and the other errors are mapped to 500.
+func statusCode(err error) int {
+	var berr *handler.BadRequestError
+	if errors.Is(err, handler.ErrUnknownIdentity) || errors.As(err, &berr) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, handler.ErrForwardHostNotAllowed) {
+		return http.StatusForbidden
+	}
+
+	var uerr *service.UpstreamError
+	if errors.As(err, &uerr) {
+		switch c := uerr.Code; {
+		case c == http.StatusRequestTimeout || c == http.StatusGatewayTimeout:
+			return http.StatusGatewayTimeout
+		case c == http.StatusServiceUnavailable:
+			return http.StatusServiceUnavailable
+		case c >= 400 && c < 500:
+			return c
+		default:
+			return http.StatusBadGateway
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		if nerr.Timeout() {
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package router
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/handler"
+	"github.com/AthenZ/athenz-client-sidecar/v2/service"
+	"github.com/pkg/errors"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_writeError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name: "Check Athenz client error is passed through",
+			err: &service.UpstreamError{
+				Err:     service.ErrRoleTokenRequestFailed,
+				Code:    http.StatusNotFound,
+				Message: "role not found",
+			},
+			wantStatusCode: http.StatusNotFound,
+			wantBody:       `{"error":{"code":404,"status":"Not Found","message":"` + service.ErrRoleTokenRequestFailed.Error() + `: role not found","upstreamCode":404,"upstreamMessage":"role not found"}}` + "\n",
+		},
+		{
+			name: "Check wrapped Athenz client error is passed through",
+			err: errors.Wrap(&service.UpstreamError{
+				Err:  service.ErrAccessTokenRequestFailed,
+				Code: http.StatusForbidden,
+			}, "dummy"),
+			wantStatusCode: http.StatusForbidden,
+			wantBody:       `{"error":{"code":403,"status":"Forbidden","message":"dummy: ` + service.ErrAccessTokenRequestFailed.Error() + `","upstreamCode":403}}` + "\n",
+		},
+		{
+			name: "Check Athenz server error returns bad gateway",
+			err: &service.UpstreamError{
+				Err:  service.ErrRoleTokenRequestFailed,
+				Code: http.StatusInternalServerError,
+			},
+			wantStatusCode: http.StatusBadGateway,
+			wantBody:       `{"error":{"code":502,"status":"Bad Gateway","message":"` + service.ErrRoleTokenRequestFailed.Error() + `","upstreamCode":500}}` + "\n",
+		},
+		{
+			name: "Check Athenz unavailable returns service unavailable",
+			err: &service.UpstreamError{
+				Err:  service.ErrRoleTokenRequestFailed,
+				Code: http.StatusServiceUnavailable,
+			},
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantBody:       `{"error":{"code":503,"status":"Service Unavailable","message":"` + service.ErrRoleTokenRequestFailed.Error() + `","upstreamCode":503}}` + "\n",
+		},
+		{
+			name: "Check Athenz timeout returns gateway timeout",
+			err: &service.UpstreamError{
+				Err:  service.ErrRoleTokenRequestFailed,
+				Code: http.StatusRequestTimeout,
+			},
+			wantStatusCode: http.StatusGatewayTimeout,
+			wantBody:       `{"error":{"code":504,"status":"Gateway Timeout","message":"` + service.ErrRoleTokenRequestFailed.Error() + `","upstreamCode":408}}` + "\n",
+		},
+		{
+			name:           "Check context deadline returns gateway timeout",
+			err:            errors.Wrap(context.DeadlineExceeded, "dummy"),
+			wantStatusCode: http.StatusGatewayTimeout,
+			wantBody:       `{"error":{"code":504,"status":"Gateway Timeout","message":"dummy: context deadline exceeded"}}` + "\n",
+		},
+		{
+			name:           "Check transport timeout returns gateway timeout",
+			err:            &url.Error{Op: "Post", URL: "http://dummy", Err: timeoutError{}},
+			wantStatusCode: http.StatusGatewayTimeout,
+			wantBody:       `{"error":{"code":504,"status":"Gateway Timeout","message":"Post \"http://dummy\": i/o timeout"}}` + "\n",
+		},
+		{
+			name:           "Check transport error returns bad gateway",
+			err:            &url.Error{Op: "Post", URL: "http://dummy", Err: fmt.Errorf("connection refused")},
+			wantStatusCode: http.StatusBadGateway,
+			wantBody:       `{"error":{"code":502,"status":"Bad Gateway","message":"Post \"http://dummy\": connection refused"}}` + "\n",
+		},
+		{
+			name:           "Check unknown identity returns bad request",
+			err:            errors.Wrap(handler.ErrUnknownIdentity, `identity "dummy"`),
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       `{"error":{"code":400,"status":"Bad Request","message":"identity \"dummy\": unknown identity"}}` + "\n",
+		},
+		{
+			name:           "Check malformed request returns bad request",
+			err:            &handler.BadRequestError{Err: fmt.Errorf("unexpected EOF")},
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       `{"error":{"code":400,"status":"Bad Request","message":"invalid request: unexpected EOF"}}` + "\n",
+		},
+		{
+			name:           "Check forward proxy destination not allowed returns forbidden",
+			err:            errors.Wrap(handler.ErrForwardHostNotAllowed, `host "dummy"`),
+			wantStatusCode: http.StatusForbidden,
+			wantBody:       `{"error":{"code":403,"status":"Forbidden","message":"host \"dummy\": destination host is not allowed"}}` + "\n",
+		},
+		{
+			name:           "Check other error returns internal server error",
+			err:            fmt.Errorf("dummy"),
+			wantStatusCode: http.StatusInternalServerError,
+			wantBody:       `{"error":{"code":500,"status":"Internal Server Error","message":"dummy"}}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, tt.err)
+
+			res := rec.Result()
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+
+			if res.StatusCode != tt.wantStatusCode {
+				t.Errorf("writeError() status code = %v, want %v", res.StatusCode, tt.wantStatusCode)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("writeError() body = %v, want %v", string(body), tt.wantBody)
+			}
+			if got, want := res.Header.Get(service.ContentType), "application/json;charset=UTF-8"; got != want {
+				t.Errorf("writeError() content type = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -21,12 +21,16 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
 	"github.com/AthenZ/athenz-client-sidecar/v2/handler"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
 	"github.com/kpango/glg"
 )
 
@@ -37,16 +41,86 @@ func New(cfg config.Config, h handler.Handler) *http.ServeMux {
 
 	mux := http.NewServeMux()
 
-	dur, err := time.ParseDuration(cfg.Server.Timeout)
+	dur := handlerTimeout(cfg.Server)
+	for _, route := range NewRoutes(cfg, h) {
+		mux.Handle(route.Pattern, metrics.InstrumentRoute(route.Name, routing(route.Methods, dur, route.HandlerFunc)))
+	}
+	if cfg.Proxy.Enable {
+		for _, route := range cfg.Proxy.Routes {
+			mux.Handle(proxyRoutePattern(route), metrics.InstrumentRoute(route.Name, routing([]string{"*"}, proxyRouteTimeout(route, dur), h.RouteProxy(route.Name))))
+		}
+	}
+
+	return mux
+}
+
+// WithForwardProxy returns a http.Handler serving the forward proxy requests, i.e. the absolute-form requests and the CONNECT requests, by h,
+// and the other requests by next. The absolute-form requests to the client sidecar itself, e.g. "http://localhost:8080/roletoken", are served by next.
+func WithForwardProxy(cfg config.Config, h handler.Handler, next http.Handler) http.Handler {
+	fp := metrics.InstrumentRoute("Forward proxy Handler", routing([]string{"*"}, handlerTimeout(cfg.Server), h.ForwardProxy))
+	hostname, _ := os.Hostname()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect || (r.URL.IsAbs() && !selfRequest(cfg.Server, hostname, r)) {
+			fp.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// selfRequest returns whether the destination of the absolute-form request is the client sidecar itself,
+// i.e. the listening port on the loopback address, the listening address, the local address of the connection or the hostname.
+func selfRequest(cfg config.Server, hostname string, r *http.Request) bool {
+	host, port, err := net.SplitHostPort(r.URL.Host)
 	if err != nil {
-		dur = time.Second * 3
+		host, port = r.URL.Host, "80"
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
 	}
 
-	for _, route := range NewRoutes(cfg, h) {
-		mux.Handle(route.Pattern, routing(route.Methods, dur, route.HandlerFunc))
+	// the local address is set by http.Server, the listening port is used otherwise
+	localIP, localPort := net.IP(nil), strconv.Itoa(cfg.Port)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if h, p, err := net.SplitHostPort(addr.String()); err == nil {
+			localIP, localPort = net.ParseIP(h), p
+		}
+	}
+	if port != localPort {
+		return false
 	}
 
-	return mux
+	if strings.EqualFold(host, "localhost") || (cfg.Address != "" && strings.EqualFold(host, cfg.Address)) || (hostname != "" && strings.EqualFold(host, hostname)) {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && (ip.IsLoopback() || ip.Equal(localIP))
+}
+
+// handlerTimeout returns the timeout of the handlers. The default is 3 seconds.
+func handlerTimeout(cfg config.Server) time.Duration {
+	dur, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return time.Second * 3
+	}
+	return dur
+}
+
+// proxyRoutePattern returns the ServeMux pattern of the proxy route, i.e. the host followed by the path prefix.
+func proxyRoutePattern(route config.ProxyRoute) string {
+	if route.PathPrefix == "" {
+		return route.Host + "/"
+	}
+	return route.Host + route.PathPrefix
+}
+
+// proxyRouteTimeout returns the timeout of the proxy route, or def if the route does not set it.
+func proxyRouteTimeout(route config.ProxyRoute, def time.Duration) time.Duration {
+	dur, err := time.ParseDuration(route.Timeout)
+	if err != nil || dur <= 0 {
+		return def
+	}
+	return dur
 }
 
 func routing(m []string, t time.Duration, h handler.Func) http.Handler {
@@ -67,11 +141,7 @@ func routing(m []string, t time.Duration, h handler.Func) http.Handler {
 					select {
 					case err := <-ech:
 						if err != nil {
-							http.Error(w,
-								fmt.Sprintf("Error: %s\t%s",
-									err.Error(),
-									http.StatusText(http.StatusInternalServerError)),
-								http.StatusInternalServerError)
+							writeError(w, err)
 							glg.Error(err)
 						}
 						return
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -39,7 +40,7 @@ func TestNew(t *testing.T) {
 		RoleAuthHeader:      "X-test-role-header",
 		BufferSize:          1024,
 	}
-	h := handler.New(proxyConfig, nil, nil, nil, nil, nil)
+	h := handler.New(proxyConfig, nil)
 
 	type args struct {
 		cfg config.Config
@@ -95,6 +96,138 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNew_proxyRoutes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	cfg := config.Config{
+		Proxy: config.Proxy{
+			Enable: true,
+			Routes: []config.ProxyRoute{
+				{
+					Name:       "payments",
+					PathPrefix: "/payments/",
+					Upstream:   upstream.URL,
+				},
+				{
+					Name:     "orders",
+					Host:     "orders.local",
+					Upstream: upstream.URL + "/orders",
+					Timeout:  "5s",
+				},
+			},
+		},
+	}
+	mux := New(cfg, handler.New(cfg.Proxy, nil))
+
+	tests := []struct {
+		name     string
+		r        *http.Request
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Check path prefix route",
+			r:        httptest.NewRequest(http.MethodGet, "http://localhost/payments/1", nil),
+			wantCode: http.StatusOK,
+			wantBody: "upstream /payments/1",
+		},
+		{
+			name:     "Check host route",
+			r:        httptest.NewRequest(http.MethodPost, "http://orders.local/1", nil),
+			wantCode: http.StatusOK,
+			wantBody: "upstream /orders/1",
+		},
+		{
+			name:     "Check unmatched request",
+			r:        httptest.NewRequest(http.MethodGet, "http://localhost/orders/1", nil),
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, tt.r)
+			if w.Code != tt.wantCode {
+				t.Errorf("New() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("New() body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNew_badRequest(t *testing.T) {
+	cfg := config.Config{
+		AccessToken:    config.AccessToken{Enable: true},
+		RoleToken:      config.RoleToken{Enable: true},
+		IDToken:        config.IDToken{Enable: true},
+		RoleCert:       config.RoleCert{Enable: true},
+		AWSCredentials: config.AWSCredentials{Enable: true},
+		Verify:         config.Verify{Enable: true},
+	}
+	mux := New(cfg, handler.New(cfg.Proxy, nil))
+
+	tests := []struct {
+		name string
+		r    *http.Request
+	}{
+		{
+			name: "Check access token request with invalid JSON",
+			r:    httptest.NewRequest(http.MethodPost, "/accesstoken", strings.NewReader("{")),
+		},
+		{
+			name: "Check role token request with invalid JSON",
+			r:    httptest.NewRequest(http.MethodPost, "/roletoken", strings.NewReader("dummy")),
+		},
+		{
+			name: "Check ID token request with invalid JSON",
+			r:    httptest.NewRequest(http.MethodPost, "/idtoken", strings.NewReader(`{"domain":1}`)),
+		},
+		{
+			name: "Check role certificate request with invalid JSON",
+			r:    httptest.NewRequest(http.MethodPost, "/rolecert", strings.NewReader("")),
+		},
+		{
+			name: "Check verify request with invalid JSON",
+			r:    httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader("[")),
+		},
+		{
+			name: "Check AWS credentials request with invalid durationSeconds",
+			r:    httptest.NewRequest(http.MethodGet, "/awscreds?domain=dummy&role=dummy&durationSeconds=1h", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, tt.r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("New() code = %v, want %v, body: %v", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+			if want := `"message":"invalid request: `; !strings.Contains(w.Body.String(), want) {
+				t.Errorf("New() body = %v, want %v", w.Body.String(), want)
+			}
+		})
+	}
+}
+
+func Test_proxyRouteTimeout(t *testing.T) {
+	def := time.Second * 3
+	for timeout, want := range map[string]time.Duration{
+		"":        def,
+		"0s":      def,
+		"invalid": def,
+		"5s":      time.Second * 5,
+	} {
+		if got := proxyRouteTimeout(config.ProxyRoute{Timeout: timeout}, def); got != want {
+			t.Errorf("proxyRouteTimeout(%q) = %v, want %v", timeout, got, want)
+		}
+	}
+}
+
 func Test_routing(t *testing.T) {
 	type args struct {
 		m []string
@@ -189,7 +322,7 @@ func Test_routing(t *testing.T) {
 		}(),
 		func() test {
 			testStr := "test string"
-			want := "Error: " + testStr + "\t" + http.StatusText(http.StatusInternalServerError) + "\n"
+			want := `{"error":{"code":500,"status":"Internal Server Error","message":"` + testStr + `"}}` + "\n"
 			wantStatusCode := http.StatusInternalServerError
 
 			return test{
@@ -435,3 +568,85 @@ func Test_routing(t *testing.T) {
 		})
 	}
 }
+
+func TestWithForwardProxy(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "next")
+	})
+	h := handler.New(config.Proxy{}, nil)
+	fp := WithForwardProxy(config.Config{
+		Server: config.Server{
+			Address: "sidecar.local",
+			Port:    8080,
+		},
+	}, h, next)
+	withLocalAddr := func(r *http.Request, addr string) *http.Request {
+		la, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, la))
+	}
+
+	tests := []struct {
+		name     string
+		r        *http.Request
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Check origin-form request is served by next",
+			r:        httptest.NewRequest(http.MethodGet, "/roletoken", nil),
+			wantCode: http.StatusOK,
+			wantBody: "next",
+		},
+		{
+			name:     "Check absolute-form request is served by forward proxy",
+			r:        httptest.NewRequest(http.MethodGet, "http://api.foo/path", nil),
+			wantCode: http.StatusInternalServerError,
+			wantBody: handler.ErrForwardProxyDisabled.Error(),
+		},
+		{
+			name:     "Check absolute-form request to localhost is served by next",
+			r:        httptest.NewRequest(http.MethodGet, "http://localhost:8080/roletoken", nil),
+			wantCode: http.StatusOK,
+			wantBody: "next",
+		},
+		{
+			name:     "Check absolute-form request to the listening address is served by next",
+			r:        httptest.NewRequest(http.MethodGet, "http://sidecar.local:8080/roletoken", nil),
+			wantCode: http.StatusOK,
+			wantBody: "next",
+		},
+		{
+			name:     "Check absolute-form request to the local address is served by next",
+			r:        withLocalAddr(httptest.NewRequest(http.MethodGet, "http://10.0.0.1:8081/roletoken", nil), "10.0.0.1:8081"),
+			wantCode: http.StatusOK,
+			wantBody: "next",
+		},
+		{
+			name:     "Check absolute-form request to other port of localhost is served by forward proxy",
+			r:        httptest.NewRequest(http.MethodGet, "http://127.0.0.1:9090/path", nil),
+			wantCode: http.StatusInternalServerError,
+			wantBody: handler.ErrForwardProxyDisabled.Error(),
+		},
+		{
+			name:     "Check CONNECT request is served by forward proxy",
+			r:        httptest.NewRequest(http.MethodConnect, "api.foo:443", nil),
+			wantCode: http.StatusInternalServerError,
+			wantBody: handler.ErrForwardProxyDisabled.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			fp.ServeHTTP(w, tt.r)
+			if w.Code != tt.wantCode {
+				t.Errorf("WithForwardProxy() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("WithForwardProxy() body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
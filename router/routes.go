@@ -68,6 +68,17 @@ func NewRoutes(cfg config.Config, h handler.Handler) []Route {
 		})
 	}
 
+	if cfg.IDToken.Enable {
+		r = append(r, Route{
+			"ID Token Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/idtoken",
+			h.IDToken,
+		})
+	}
+
 	if cfg.ServiceCert.Enable {
 		r = append(r, Route{
 			"Service Cert Handler",
@@ -79,6 +90,39 @@ func NewRoutes(cfg config.Config, h handler.Handler) []Route {
 		})
 	}
 
+	if cfg.RoleCert.Enable {
+		r = append(r, Route{
+			"Role Cert Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/rolecert",
+			h.RoleCert,
+		})
+	}
+
+	if cfg.AWSCredentials.Enable {
+		r = append(r, Route{
+			"AWS Credentials Handler",
+			[]string{
+				http.MethodGet,
+			},
+			"/awscreds",
+			h.AWSCredentials,
+		})
+	}
+
+	if cfg.Verify.Enable {
+		r = append(r, Route{
+			"Verify Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/verify",
+			h.Verify,
+		})
+	}
+
 	if cfg.Proxy.Enable {
 		r = append(r, Route{
 			"RoleToken proxy Handler",
@@ -97,5 +141,27 @@ func NewRoutes(cfg config.Config, h handler.Handler) []Route {
 		})
 	}
 
+	if cfg.Proxy.Enable && cfg.AccessToken.Enable {
+		r = append(r, Route{
+			"AccessToken proxy Handler",
+			[]string{
+				"*",
+			},
+			"/proxy/accesstoken",
+			h.AccessTokenProxy,
+		})
+	}
+
+	if cfg.Proxy.Enable && cfg.Proxy.MTLS.Enable {
+		r = append(r, Route{
+			"mTLS proxy Handler",
+			[]string{
+				"*",
+			},
+			"/proxy/mtls",
+			h.MTLSProxy,
+		})
+	}
+
 	return r
 }
@@ -43,7 +43,7 @@ func TestNewRoutes(t *testing.T) {
 				RoleAuthHeader:      "X-test-role-header",
 				BufferSize:          1024,
 			}
-			h := handler.New(proxyConfig, nil, nil, nil, nil, nil)
+			h := handler.New(proxyConfig, nil)
 
 			return test{
 				name: "Run NewRoutes successfully",
@@ -58,9 +58,21 @@ func TestNewRoutes(t *testing.T) {
 						RoleToken: config.RoleToken{
 							Enable: true,
 						},
+						IDToken: config.IDToken{
+							Enable: true,
+						},
 						ServiceCert: config.ServiceCert{
 							Enable: true,
 						},
+						RoleCert: config.RoleCert{
+							Enable: true,
+						},
+						AWSCredentials: config.AWSCredentials{
+							Enable: true,
+						},
+						Verify: config.Verify{
+							Enable: true,
+						},
 						Proxy: config.Proxy{
 							Enable: true,
 						},
@@ -92,6 +104,14 @@ func TestNewRoutes(t *testing.T) {
 						"/roletoken",
 						h.RoleToken,
 					},
+					{
+						"ID Token Handler",
+						[]string{
+							http.MethodPost,
+						},
+						"/idtoken",
+						h.IDToken,
+					},
 					{
 						"Service Cert Handler",
 						[]string{
@@ -100,6 +120,30 @@ func TestNewRoutes(t *testing.T) {
 						"/svccert",
 						h.ServiceCert,
 					},
+					{
+						"Role Cert Handler",
+						[]string{
+							http.MethodPost,
+						},
+						"/rolecert",
+						h.RoleCert,
+					},
+					{
+						"AWS Credentials Handler",
+						[]string{
+							http.MethodGet,
+						},
+						"/awscreds",
+						h.AWSCredentials,
+					},
+					{
+						"Verify Handler",
+						[]string{
+							http.MethodPost,
+						},
+						"/verify",
+						h.Verify,
+					},
 					{
 						"RoleToken proxy Handler",
 						[]string{
@@ -116,6 +160,14 @@ func TestNewRoutes(t *testing.T) {
 						"/proxy/ntoken",
 						h.NTokenProxy,
 					},
+					{
+						"AccessToken proxy Handler",
+						[]string{
+							"*",
+						},
+						"/proxy/accesstoken",
+						h.AccessTokenProxy,
+					},
 				},
 			}
 		}(),
@@ -126,7 +178,7 @@ func TestNewRoutes(t *testing.T) {
 				RoleAuthHeader:      "X-test-role-header",
 				BufferSize:          1024,
 			}
-			h := handler.New(proxyConfig, nil, nil, nil, nil, nil)
+			h := handler.New(proxyConfig, nil)
 
 			return test{
 				name: "Run NewRoutes successfully with all routes disabled",
@@ -17,7 +17,6 @@ limitations under the License.
 package service
 
 import (
-	"bytes"
 	"context"
 	"crypto/x509"
 	"encoding/json"
@@ -31,6 +30,7 @@ import (
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
 	"github.com/kpango/fastime"
 	"github.com/kpango/gache"
 	"github.com/kpango/glg"
@@ -43,7 +43,9 @@ import (
 type AccessService interface {
 	StartAccessUpdater(context.Context) <-chan error
 	RefreshAccessTokenCache(ctx context.Context) <-chan error
+	PrefetchAccessTokens(ctx context.Context) <-chan error
 	GetAccessProvider() AccessProvider
+	Readiness() Readiness
 }
 
 // accessService represents the implementation of Athenz AccessService
@@ -63,6 +65,28 @@ type accessService struct {
 	refreshPeriod    time.Duration
 	errRetryMaxCount int
 	errRetryInterval time.Duration
+	errRetryBackoff  backoff
+
+	// grace represents the stale-while-revalidate mode. nil implies it is disabled.
+	grace *grace
+
+	// negativeCache caches the client errors returned by the Athenz server. nil implies it is disabled.
+	negativeCache *negativeCache
+
+	// cacheLimit tracks the cached tokens to evict. nil implies the cache is unlimited.
+	cacheLimit *cacheLimit
+
+	// scheduler schedules the refresh of each cached token. nil implies all the cached tokens are refreshed every refresh period.
+	scheduler *refreshScheduler
+
+	// refreshPool bounds the concurrency and the rate of the background refreshes. nil implies the tokens are refreshed one at a time without rate limit.
+	refreshPool *refreshPool
+
+	// prefetch represents the access tokens to fetch regardless of requests. The token field is always nil.
+	prefetch []*accessCacheData
+
+	// lastRefresh records the result of the last cache refresh and prefetch.
+	lastRefresh refreshRecorder
 }
 
 type accessCacheData struct {
@@ -71,6 +95,9 @@ type accessCacheData struct {
 	role              string
 	proxyForPrincipal string
 	expiresIn         int64
+
+	// expiry represents the expiry of the access token in unix time. 0 implies it is unknown.
+	expiry int64
 }
 
 // AccessTokenResponse represents the AccessTokenResponse from postAccessTokenRequest.
@@ -148,10 +175,59 @@ func NewAccessService(cfg config.AccessToken, token ntokend.TokenProvider) (Acce
 		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
 	}
 
+	errRetryBackoff, err := newBackoff(cfg.Retry, defaultErrRetryMaxInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	graceMode, err := newGrace(cfg.Grace)
+	if err != nil {
+		return nil, err
+	}
+
+	negCache, err := newNegativeCache(cfg.NegativeCache)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduler, err := newRefreshScheduler(cfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := newRefreshPool(cfg.RefreshPool)
+	if err != nil {
+		return nil, err
+	}
+
 	if token == nil && cfg.CertPath == "" {
 		return nil, errors.Wrap(ErrInvalidSetting, "Neither NToken nor client certificate is set.")
 	}
 
+	prefetch := make([]*accessCacheData, 0, len(cfg.Prefetch))
+	for i, p := range cfg.Prefetch {
+		if p.Domain == "" {
+			return nil, errors.Wrap(ErrInvalidSetting, fmt.Sprintf("Prefetch[%d]: domain is empty", i))
+		}
+		var pexp time.Duration
+		if p.Expiry != "" {
+			if pexp, err = time.ParseDuration(p.Expiry); err != nil {
+				return nil, errors.Wrap(ErrInvalidSetting, fmt.Sprintf("Prefetch[%d].Expiry: %s", i, err.Error()))
+			}
+		}
+		prefetch = append(prefetch, &accessCacheData{
+			domain:            p.Domain,
+			role:              strings.Join(p.Roles, roleSeparator),
+			proxyForPrincipal: p.ProxyForPrincipal,
+			expiresIn:         int64(pexp / time.Second),
+		})
+	}
+
+	prefetchKeys := make([]string, 0, len(prefetch))
+	for _, p := range prefetch {
+		prefetchKeys = append(prefetchKeys, encode(p.domain, p.role, p.proxyForPrincipal))
+	}
+
 	var cp *x509.CertPool
 	if cfg.AthenzCAPath != "" {
 		var err error
@@ -200,6 +276,13 @@ func NewAccessService(cfg config.AccessToken, token ntokend.TokenProvider) (Acce
 		refreshPeriod:         refreshPeriod,
 		errRetryMaxCount:      errRetryMaxCount,
 		errRetryInterval:      errRetryInterval,
+		errRetryBackoff:       errRetryBackoff,
+		grace:                 graceMode,
+		negativeCache:         negCache,
+		cacheLimit:            newCacheLimit(cfg.CacheLimit, refreshPeriod, prefetchKeys),
+		scheduler:             scheduler,
+		refreshPool:           pool,
+		prefetch:              prefetch,
 	}, nil
 }
 
@@ -212,12 +295,24 @@ func (a *accessService) StartAccessUpdater(ctx context.Context) <-chan error {
 	go func() {
 		defer close(ech)
 
+		// the scheduled refreshes send the errors until the scheduler is stopped
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			a.scheduler.run(ctx, func(ctx context.Context, key string) {
+				for err := range a.refreshScheduled(ctx, key) {
+					ech <- errors.Wrap(err, "error update access token")
+				}
+			})
+		}()
+
 		ticker := time.NewTicker(a.refreshPeriod)
 		for {
 			select {
 			case <-ctx.Done():
 				glg.Info("Stopping access token updater...")
 				ticker.Stop()
+				<-done
 				ech <- ctx.Err()
 				return
 			case <-ticker.C:
@@ -229,44 +324,183 @@ func (a *accessService) StartAccessUpdater(ctx context.Context) <-chan error {
 	}()
 
 	a.tokenCache.StartExpired(ctx, cachePurgePeriod)
+	a.negativeCache.startExpired(ctx)
 	a.tokenCache.EnableExpiredHook().SetExpiredHook(func(ctx context.Context, k string) {
+		a.cacheLimit.remove(k)
+		a.scheduler.remove(k)
 		glg.Warnf("the following cache is expired, key: %v", k)
 	})
 	return ech
 }
 
+// InheritAccessTokenCache copies the access tokens cached in src, which are not expired yet, to dst.
+// It returns the number of access tokens copied. Nothing is copied if either service is not created by NewAccessService.
+// The negative cache is not copied, so that the cached errors are cleared when the configuration is reloaded.
+func InheritAccessTokenCache(ctx context.Context, dst, src AccessService) int {
+	d, ok := dst.(*accessService)
+	if !ok {
+		return 0
+	}
+	s, ok := src.(*accessService)
+	if !ok {
+		return 0
+	}
+
+	var n int64
+	s.tokenCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		var dur time.Duration // 0 implies no expiry
+		if exp > 0 {
+			if dur = time.Duration(exp - fastime.UnixNanoNow()); dur <= 0 {
+				return true
+			}
+		}
+		d.tokenCache.SetWithExpire(key, val, dur)
+		d.limitCache(key)
+		atomic.AddInt64(&n, 1)
+		return true
+	})
+	return int(n)
+}
+
 // GetAccessProvider returns a function pointer to get the access token.
 func (a *accessService) GetAccessProvider() AccessProvider {
 	return a.getAccessToken
 }
 
+// Readiness returns the readiness of the access token service based on the result of the last cache refresh.
+func (a *accessService) Readiness() Readiness {
+	return a.lastRefresh.readiness()
+}
+
 // getAccessToken returns AccessTokenResponse struct or error.
 // This function will return the access token stored inside the cache, or fetch the access token from Athenz when corresponding access token cannot be found in the cache.
 func (a *accessService) getAccessToken(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) (*AccessTokenResponse, error) {
-	tok, ok := a.getCache(domain, role, proxyForPrincipal)
+	key := encode(domain, role, proxyForPrincipal)
+	val, ok := a.tokenCache.Get(key)
 	if !ok {
+		if err := a.negativeCache.get(key); err != nil {
+			metrics.CacheNegative(metrics.AccessToken)
+			return nil, err
+		}
+		metrics.CacheMiss(metrics.AccessToken)
 		return a.updateAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn)
 	}
-	return tok, nil
+	a.cacheLimit.touch(key)
+	cd := val.(*accessCacheData)
+	if cd.expiry > 0 && a.grace.stale(time.Unix(cd.expiry, 0)) {
+		metrics.CacheStale(metrics.AccessToken)
+		a.grace.revalidate(key, func(ctx context.Context) error {
+			_, err := a.updateAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn)
+			return err
+		})
+		return cd.token, nil
+	}
+	metrics.CacheHit(metrics.AccessToken)
+	return cd.token, nil
 }
 
 // RefreshAccessTokenCache returns the error channel when it is updated.
+// The prefetch access tokens are also fetched if they are not in the cache.
 func (a *accessService) RefreshAccessTokenCache(ctx context.Context) <-chan error {
 	glg.Info("RefreshAccessTokenCache started")
 
-	echan := make(chan error, a.tokenCache.Len()*(a.errRetryMaxCount+1))
+	// the tokens not requested recently are dropped instead of refreshed
+	for _, k := range a.cacheLimit.removeIdle() {
+		a.tokenCache.Delete(k)
+		a.scheduler.remove(k)
+		glg.Debugf("idle token is dropped from the cache, key: %s", k)
+	}
+
+	echan := make(chan error, (a.tokenCache.Len()+len(a.prefetch))*(a.errRetryMaxCount+1))
 	go func() {
 		defer close(echan)
 
-		a.tokenCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		// the scheduled tokens are refreshed by the scheduler
+		targets := refreshTargets(ctx, a.tokenCache, func(key string, val interface{}) (interface{}, bool) {
+			if a.scheduler.scheduled(key) {
+				return nil, false
+			}
 			domain, role, principal := decode(key)
 			cd := val.(*accessCacheData)
+			return &accessCacheData{
+				domain:            domain,
+				role:              role,
+				proxyForPrincipal: principal,
+				expiresIn:         cd.expiresIn,
+			}, true
+		})
+		for _, p := range a.prefetch {
+			if _, ok := a.getCache(p.domain, p.role, p.proxyForPrincipal); !ok {
+				targets = append(targets, p)
+			}
+		}
 
-			for err := range a.updateAccessTokenWithRetry(ctx, domain, role, principal, cd.expiresIn) {
-				echan <- err
+		// the tokens are refreshed concurrently, so that a slow domain does not delay the others
+		cnt := a.scheduler.results()
+		a.refreshPool.each(ctx, len(targets), func(i int) {
+			cd := targets[i].(*accessCacheData)
+			errs := make([]error, 0, a.errRetryMaxCount+1)
+			for err := range a.updateAccessTokenWithRetry(ctx, cd.domain, cd.role, cd.proxyForPrincipal, cd.expiresIn) {
+				echan <- errors.Wrapf(err, "domain: %s, role: %s, proxyForPrincipal: %s", cd.domain, cd.role, cd.proxyForPrincipal)
+				errs = append(errs, err)
 			}
-			return true
+			cnt.add(errs, a.errRetryMaxCount)
 		})
+		cnt.store(&a.lastRefresh)
+	}()
+
+	return echan
+}
+
+// refreshScheduled refreshes the cached access token of the key scheduled by the refresh scheduler.
+// The returned error channel is closed when the access token is refreshed, or failed after retry.
+func (a *accessService) refreshScheduled(ctx context.Context, key string) <-chan error {
+	echan := make(chan error, a.errRetryMaxCount+1)
+	go func() {
+		defer close(echan)
+
+		if !a.refreshPool.acquire(ctx) {
+			return
+		}
+		defer a.refreshPool.release()
+
+		val, ok := a.tokenCache.Get(key)
+		if !ok {
+			return
+		}
+		cd := val.(*accessCacheData)
+
+		errs := make([]error, 0, a.errRetryMaxCount+1)
+		for err := range a.updateAccessTokenWithRetry(ctx, cd.domain, cd.role, cd.proxyForPrincipal, cd.expiresIn) {
+			echan <- errors.Wrapf(err, "domain: %s, role: %s, proxyForPrincipal: %s", cd.domain, cd.role, cd.proxyForPrincipal)
+			errs = append(errs, err)
+		}
+		a.scheduler.record(errs, a.errRetryMaxCount)
+	}()
+
+	return echan
+}
+
+// PrefetchAccessTokens fetches the access tokens declared in the prefetch configuration concurrently.
+// The returned error channel is closed when all the access tokens are fetched, or failed after retry.
+func (a *accessService) PrefetchAccessTokens(ctx context.Context) <-chan error {
+	glg.Infof("PrefetchAccessTokens started, count: %d", len(a.prefetch))
+
+	echan := make(chan error, len(a.prefetch)*(a.errRetryMaxCount+1))
+	go func() {
+		defer close(echan)
+
+		cnt := new(refreshCounter)
+		a.refreshPool.each(ctx, len(a.prefetch), func(i int) {
+			p := a.prefetch[i]
+			errs := make([]error, 0, a.errRetryMaxCount+1)
+			for err := range a.updateAccessTokenWithRetry(ctx, p.domain, p.role, p.proxyForPrincipal, p.expiresIn) {
+				echan <- errors.Wrapf(err, "domain: %s, role: %s, proxyForPrincipal: %s", p.domain, p.role, p.proxyForPrincipal)
+				errs = append(errs, err)
+			}
+			cnt.add(errs, a.errRetryMaxCount)
+		})
+		cnt.store(&a.lastRefresh)
 	}()
 
 	return echan
@@ -281,14 +515,26 @@ func (a *accessService) updateAccessTokenWithRetry(ctx context.Context, domain,
 		defer close(echan)
 
 		for i := 0; i <= a.errRetryMaxCount; i++ {
-			if _, err := a.updateAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn); err != nil {
-				echan <- err
-				time.Sleep(a.errRetryInterval)
-			} else {
+			if !a.refreshPool.wait(ctx) {
+				return
+			}
+			_, err := a.updateAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn)
+			if err == nil {
 				glg.Debug("update success")
+				return
+			}
+			echan <- err
+			metrics.RetryFailure(metrics.AccessToken)
+
+			// the client errors will never succeed by retrying
+			if !retryable(err) || i == a.errRetryMaxCount {
 				break
 			}
+			if !sleep(ctx, a.errRetryBackoff.interval(i, a.errRetryInterval, err)) {
+				return
+			}
 		}
+		metrics.RefreshFailure(metrics.AccessToken)
 	}()
 
 	return echan
@@ -303,16 +549,31 @@ func (a *accessService) updateAccessToken(ctx context.Context, domain, role, pro
 	at, err, _ := a.group.Do(key, func() (interface{}, error) {
 		at, e := a.fetchAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn)
 		if e != nil {
+			a.negativeCache.set(key, e)
 			return nil, e
 		}
 
-		a.tokenCache.SetWithExpire(key, &accessCacheData{
+		cd := &accessCacheData{
 			token:             at,
 			domain:            domain,
 			role:              role,
 			proxyForPrincipal: proxyForPrincipal,
 			expiresIn:         expiresIn,
-		}, time.Unix(at.ExpiresIn, 0).Sub(expTimeDelta))
+		}
+		dur := time.Unix(at.ExpiresIn, 0).Sub(expTimeDelta)
+		if at.ExpiresIn > 0 {
+			cd.expiry = fastime.Now().Unix() + at.ExpiresIn
+			if a.grace != nil {
+				// keep the token until it expires, it is refreshed in background after the refresh point
+				dur = time.Duration(at.ExpiresIn) * time.Second
+			}
+		}
+		a.tokenCache.SetWithExpire(key, cd, dur)
+		a.negativeCache.delete(key)
+		a.limitCache(key)
+		if cd.expiry > 0 {
+			a.scheduler.schedule(key, time.Unix(cd.expiry, 0))
+		}
 
 		glg.Debugf("token is cached, domain: %s, role: %s, proxyForPrincipal: %s, expiry time: %v", domain, role, proxyForPrincipal, at.ExpiresIn)
 		return at, nil
@@ -363,20 +624,20 @@ func (a *accessService) fetchAccessToken(ctx context.Context, domain, role, prox
 	}
 
 	// send request
+	start := time.Now()
 	res, err := a.httpClient.Load().(*http.Client).Do(req.WithContext(ctx))
 	if err != nil {
+		metrics.ObserveAthenzRequest(metrics.AccessToken, metrics.StatusError, start)
 		return nil, err
 	}
+	metrics.ObserveAthenzRequest(metrics.AccessToken, metrics.StatusCode(res.StatusCode), start)
 
 	// process response
 	defer flushAndClose(res.Body)
 	if res.StatusCode != http.StatusOK {
-		buf := new(bytes.Buffer)
-		if _, err := buf.ReadFrom(res.Body); err != nil {
-			glg.Debugf("cannot read response body, err: %v", err)
-		}
-		glg.Debugf("error return from server, response:%+v, body: %v", res, buf.String())
-		return nil, ErrAccessTokenRequestFailed
+		uerr := newUpstreamError(ErrAccessTokenRequestFailed, res)
+		glg.Debugf("error return from server, response:%+v, message: %v", res, uerr.Message)
+		return nil, uerr
 	}
 
 	var atRes *AccessTokenResponse
@@ -402,6 +663,15 @@ func createScope(domain, role string) string {
 	return domain + ":domain"
 }
 
+// limitCache tracks the key of the token stored in the cache, and evicts the least recently requested tokens exceeding the limit.
+func (a *accessService) limitCache(key string) {
+	for _, k := range a.cacheLimit.add(key) {
+		a.tokenCache.Delete(k)
+		a.scheduler.remove(k)
+		glg.Debugf("token is evicted from the cache, key: %s", k)
+	}
+}
+
 func (a *accessService) getCache(domain, role, principal string) (*AccessTokenResponse, bool) {
 	val, ok := a.tokenCache.Get(encode(domain, role, principal))
 	if !ok {
@@ -22,7 +22,9 @@ import "context"
 type AccessServiceMock struct {
 	StartAccessUpdaterFunc      func(context.Context) <-chan error
 	RefreshAccessTokenCacheFunc func(ctx context.Context) <-chan error
+	PrefetchAccessTokensFunc    func(ctx context.Context) <-chan error
 	GetAccessProviderFunc       func() AccessProvider
+	ReadinessFunc               func() Readiness
 }
 
 // StartAccessUpdater is a mock implementation of AccessService.StartAccessUpdater
@@ -35,7 +37,17 @@ func (asm *AccessServiceMock) RefreshAccessTokenCache(ctx context.Context) <-cha
 	return asm.RefreshAccessTokenCacheFunc(ctx)
 }
 
+// PrefetchAccessTokens is a mock implementation of AccessService.PrefetchAccessTokens
+func (asm *AccessServiceMock) PrefetchAccessTokens(ctx context.Context) <-chan error {
+	return asm.PrefetchAccessTokensFunc(ctx)
+}
+
 // GetAccessProvider is a mock implementation of AccessService.GetAccessProvider
 func (asm *AccessServiceMock) GetAccessProvider() AccessProvider {
 	return asm.GetAccessProviderFunc()
 }
+
+// Readiness is a mock implementation of AccessService.Readiness
+func (asm *AccessServiceMock) Readiness() Readiness {
+	return asm.ReadinessFunc()
+}
@@ -647,8 +647,8 @@ func Test_accessService_StartAccessUpdater(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err.Error() != errors.Wrap(ErrAccessTokenRequestFailed, "error update access token").Error() {
-							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(ErrAccessTokenRequestFailed, "error update access token"))
+						if err.Error() != errors.Wrap(errors.Wrap(ErrAccessTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update access token").Error() {
+							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(errors.Wrap(ErrAccessTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update access token"))
 						}
 					}
 
@@ -725,8 +725,8 @@ func Test_accessService_StartAccessUpdater(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err.Error() != errors.Wrap(ErrAccessTokenRequestFailed, "error update access token").Error() {
-							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(ErrAccessTokenRequestFailed, "error update access token"))
+						if err.Error() != errors.Wrap(errors.Wrap(ErrAccessTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update access token").Error() {
+							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(errors.Wrap(ErrAccessTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update access token"))
 						}
 					}
 
@@ -775,6 +775,72 @@ func Test_accessService_StartAccessUpdater(t *testing.T) {
 	}
 }
 
+func TestInheritAccessTokenCache(t *testing.T) {
+	type args struct {
+		dst AccessService
+		src AccessService
+	}
+	type test struct {
+		name      string
+		args      args
+		want      int
+		checkFunc func(dst AccessService) error
+	}
+	tests := []test{
+		func() test {
+			src := gache.New()
+			src.SetWithExpire("dummyDomain;dummyRole", &accessCacheData{
+				token: &AccessTokenResponse{AccessToken: "valid"},
+			}, time.Hour)
+			src.SetWithExpire("dummyDomain;expiredRole", &accessCacheData{
+				token: &AccessTokenResponse{AccessToken: "expired"},
+			}, time.Millisecond)
+			time.Sleep(time.Millisecond * 200)
+
+			dst := &accessService{tokenCache: gache.New()}
+			return test{
+				name: "Inherit only unexpired cache",
+				args: args{
+					dst: dst,
+					src: &accessService{tokenCache: src},
+				},
+				want: 1,
+				checkFunc: func(got AccessService) error {
+					tok, ok := got.(*accessService).getCache("dummyDomain", "dummyRole", "")
+					if !ok || tok.AccessToken != "valid" {
+						return fmt.Errorf("valid token is not inherited, got: %v", tok)
+					}
+					if _, ok := got.(*accessService).getCache("dummyDomain", "expiredRole", ""); ok {
+						return fmt.Errorf("expired token is inherited")
+					}
+					return nil
+				},
+			}
+		}(),
+		{
+			name: "Inherit nothing from mock",
+			args: args{
+				dst: &accessService{tokenCache: gache.New()},
+				src: &AccessServiceMock{},
+			},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := InheritAccessTokenCache(context.Background(), tt.args.dst, tt.args.src)
+			if got != tt.want {
+				t.Errorf("InheritAccessTokenCache() = %v, want %v", got, tt.want)
+			}
+			if tt.checkFunc != nil {
+				if err := tt.checkFunc(tt.args.dst); err != nil {
+					t.Errorf("InheritAccessTokenCache() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
 func Test_accessService_GetAccessProvider(t *testing.T) {
 	tests := []struct {
 		name string
@@ -968,6 +1034,145 @@ func Test_accessService_getAccessToken(t *testing.T) {
 	}
 }
 
+func Test_accessService_getAccessToken_negativeCache(t *testing.T) {
+	var calls int32
+	dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer dummyServer.Close()
+
+	var httpClient atomic.Value
+	httpClient.Store(dummyServer.Client())
+	negCache, _ := newNegativeCache(config.NegativeCache{
+		Enable: true,
+		TTL:    "100ms",
+	})
+	a := &accessService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		athenzURL:             dummyServer.URL,
+		athenzPrincipleHeader: "Athenz-Principal",
+		tokenCache:            gache.New(),
+		httpClient:            httpClient,
+		negativeCache:         negCache,
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.getAccessToken(context.Background(), "dummyDomain", "dummyRole", "", 0); errors.Cause(err) != ErrAccessTokenRequestFailed {
+			t.Errorf("accessService.getAccessToken() error = %v, want %v", err, ErrAccessTokenRequestFailed)
+		}
+	}
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Errorf("accessService.getAccessToken() Athenz request count = %v, want %v", c, 1)
+	}
+
+	// the Athenz server is requested again after the cached error expired
+	time.Sleep(time.Millisecond * 150)
+	if _, err := a.getAccessToken(context.Background(), "dummyDomain", "dummyRole", "", 0); errors.Cause(err) != ErrAccessTokenRequestFailed {
+		t.Errorf("accessService.getAccessToken() error = %v, want %v", err, ErrAccessTokenRequestFailed)
+	}
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("accessService.getAccessToken() Athenz request count after expired = %v, want %v", c, 2)
+	}
+}
+
+func Test_accessService_getAccessToken_grace(t *testing.T) {
+	type test struct {
+		name       string
+		expiry     int64
+		statusCode int
+		wantCalls  int32
+		wantCached string
+	}
+	tests := []test{
+		{
+			name:       "getAccessToken returns the cached token before the refresh point",
+			expiry:     fastime.Now().Add(time.Hour).Unix(),
+			statusCode: http.StatusOK,
+			wantCalls:  0,
+			wantCached: "cachedToken",
+		},
+		{
+			name:       "getAccessToken returns the cached token when the expiry is unknown",
+			statusCode: http.StatusOK,
+			wantCalls:  0,
+			wantCached: "cachedToken",
+		},
+		{
+			name:       "getAccessToken returns the stale token and refreshes it in background",
+			expiry:     fastime.Now().Add(time.Second * 30).Unix(),
+			statusCode: http.StatusOK,
+			wantCalls:  1,
+			wantCached: "newToken",
+		},
+		{
+			name:       "getAccessToken returns the stale token when the background refresh failed",
+			expiry:     fastime.Now().Add(time.Second * 30).Unix(),
+			statusCode: http.StatusServiceUnavailable,
+			wantCalls:  1,
+			wantCached: "cachedToken",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprint(w, `{"access_token":"newToken","token_type":"Bearer","expires_in":3600}`)
+			}))
+			defer dummyServer.Close()
+
+			var httpClient atomic.Value
+			httpClient.Store(dummyServer.Client())
+			a := &accessService{
+				token: func() (string, error) {
+					return "dummyNToken", nil
+				},
+				athenzURL:             dummyServer.URL,
+				athenzPrincipleHeader: "Athenz-Principal",
+				tokenCache:            gache.New(),
+				httpClient:            httpClient,
+				grace: &grace{
+					refreshBefore: time.Minute,
+				},
+			}
+			cached := &AccessTokenResponse{
+				AccessToken: "cachedToken",
+			}
+			a.tokenCache.Set(encode("dummyDomain", "dummyRole", ""), &accessCacheData{
+				token:  cached,
+				domain: "dummyDomain",
+				role:   "dummyRole",
+				expiry: tt.expiry,
+			})
+
+			got, err := a.getAccessToken(context.Background(), "dummyDomain", "dummyRole", "", 0)
+			if err != nil {
+				t.Errorf("accessService.getAccessToken() error = %v", err)
+				return
+			}
+			if got != cached {
+				t.Errorf("accessService.getAccessToken() = %v, want %v", got, cached)
+			}
+
+			// wait for the background refresh
+			time.Sleep(time.Millisecond * 100)
+			if _, ok := a.grace.refreshing.Load(encode("dummyDomain", "dummyRole", "")); ok {
+				t.Errorf("accessService.getAccessToken() background refresh is not finished")
+			}
+			if c := atomic.LoadInt32(&calls); c != tt.wantCalls {
+				t.Errorf("accessService.getAccessToken() Athenz request count = %v, want %v", c, tt.wantCalls)
+			}
+			if tok, ok := a.getCache("dummyDomain", "dummyRole", ""); !ok || tok.AccessToken != tt.wantCached {
+				t.Errorf("accessService.getAccessToken() cached token = %v, want %v", tok, tt.wantCached)
+			}
+		})
+	}
+}
+
 func Test_accessService_RefreshAccessTokenCache(t *testing.T) {
 	type fields struct {
 		cfg                   config.AccessToken
@@ -1183,7 +1388,7 @@ func Test_accessService_RefreshAccessTokenCache(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrAccessTokenRequestFailed {
+						if errors.Cause(err) != ErrAccessTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
@@ -1257,7 +1462,7 @@ func Test_accessService_RefreshAccessTokenCache(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrAccessTokenRequestFailed {
+						if errors.Cause(err) != ErrAccessTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
@@ -1311,6 +1516,112 @@ func Test_accessService_RefreshAccessTokenCache(t *testing.T) {
 	}
 }
 
+func Test_accessService_PrefetchAccessTokens(t *testing.T) {
+	type test struct {
+		name      string
+		handler   http.HandlerFunc
+		prefetch  []*accessCacheData
+		checkFunc func(a *accessService, errs []error) error
+	}
+	tests := []test{
+		{
+			name: "PrefetchAccessTokens fetches all the declared tokens",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				fmt.Fprintf(w, `{"access_token":"%s", "token_type": "Bearer", "expires_in": %d}`, r.PostForm.Get("scope"), fastime.Now().Add(time.Hour).Unix())
+			},
+			prefetch: []*accessCacheData{
+				{domain: "dummyDomain", role: "role1"},
+				{domain: "dummyDomain", role: "role2,role3", proxyForPrincipal: "dummyPrincipal", expiresIn: 600},
+			},
+			checkFunc: func(a *accessService, errs []error) error {
+				if len(errs) != 0 {
+					return fmt.Errorf("unexpected errors: %v", errs)
+				}
+				if rd := a.Readiness(); !rd.Ready || rd.LastRefresh == "" {
+					return fmt.Errorf("not ready after prefetch: %+v", rd)
+				}
+				if tok, ok := a.getCache("dummyDomain", "role1", ""); !ok || tok.AccessToken != "dummyDomain:role.role1" {
+					return fmt.Errorf("role1 is not prefetched, got: %v", tok)
+				}
+				if tok, ok := a.getCache("dummyDomain", "role2,role3", "dummyPrincipal"); !ok || tok.AccessToken != "dummyDomain:role.role2 dummyDomain:role.role3" {
+					return fmt.Errorf("role2,role3 is not prefetched, got: %v", tok)
+				}
+				return nil
+			},
+		},
+		{
+			name: "PrefetchAccessTokens returns errors after retry",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			prefetch: []*accessCacheData{
+				{domain: "dummyDomain", role: "role1"},
+			},
+			checkFunc: func(a *accessService, errs []error) error {
+				if len(errs) != 2 {
+					return fmt.Errorf("unexpected errors: %v", errs)
+				}
+				if rd := a.Readiness(); rd.Ready {
+					return fmt.Errorf("ready after all the prefetch failed: %+v", rd)
+				}
+				if a.tokenCache.Len() != 0 {
+					return fmt.Errorf("token is cached")
+				}
+				return nil
+			},
+		},
+		{
+			name: "PrefetchAccessTokens does not retry client errors",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+			prefetch: []*accessCacheData{
+				{domain: "dummyDomain", role: "role1"},
+			},
+			checkFunc: func(a *accessService, errs []error) error {
+				if len(errs) != 1 {
+					return fmt.Errorf("unexpected errors: %v", errs)
+				}
+				if rd := a.Readiness(); rd.Ready {
+					return fmt.Errorf("ready after all the prefetch failed: %+v", rd)
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dummyServer := httptest.NewTLSServer(tt.handler)
+			defer dummyServer.Close()
+
+			a := &accessService{
+				token: func() (string, error) {
+					return "dummyToken", nil
+				},
+				athenzURL:             dummyServer.URL,
+				athenzPrincipleHeader: "Athenz-Principal",
+				tokenCache:            gache.New(),
+				errRetryMaxCount:      1,
+				errRetryInterval:      time.Millisecond,
+				prefetch:              tt.prefetch,
+			}
+			a.httpClient.Store(dummyServer.Client())
+
+			var errs []error
+			for err := range a.PrefetchAccessTokens(context.Background()) {
+				errs = append(errs, err)
+			}
+			if err := tt.checkFunc(a, errs); err != nil {
+				t.Errorf("PrefetchAccessTokens() error = %v", err)
+			}
+		})
+	}
+}
+
 func Test_accessService_updateAccessTokenWithRetry(t *testing.T) {
 	type fields struct {
 		cfg                   config.AccessToken
@@ -1444,7 +1755,7 @@ func Test_accessService_updateAccessTokenWithRetry(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrAccessTokenRequestFailed {
+						if errors.Cause(err) != ErrAccessTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
@@ -1509,7 +1820,7 @@ func Test_accessService_updateAccessTokenWithRetry(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrAccessTokenRequestFailed {
+						if errors.Cause(err) != ErrAccessTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
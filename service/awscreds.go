@@ -0,0 +1,333 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+)
+
+// AWSCredentialsService represents an interface to automatically refresh the AWS temporary credentials, and an AWS credentials provider function pointer.
+type AWSCredentialsService interface {
+	StartAWSCredentialsUpdater(context.Context) <-chan error
+	RefreshAWSCredentialsCache(ctx context.Context) <-chan error
+	GetAWSCredentialsProvider() AWSCredentialsProvider
+	Readiness() Readiness
+}
+
+// awsCredentialsService represents the implementation of Athenz AWSCredentialsService
+type awsCredentialsService struct {
+	cfg                   config.AWSCredentials
+	token                 ntokend.TokenProvider
+	athenzURL             string
+	athenzPrincipleHeader string
+	creds                 *credentialCache
+	expiry                time.Duration
+	httpClient            atomic.Value
+	rootCAs               *x509.CertPool
+	certPath              string
+	certKeyPath           string
+}
+
+// awsCredentialsCacheData represents the cached AWS temporary credentials, and the request fetching them.
+type awsCredentialsCacheData struct {
+	creds      *AWSCredentials
+	domain     string
+	role       string
+	externalID string
+	duration   int64
+}
+
+// AWSCredentials represents the AWS temporary credentials returned by the Athenz server.
+type AWSCredentials struct {
+	// AccessKeyID represents the access key ID of the AWS temporary credentials.
+	AccessKeyID string `json:"accessKeyId"`
+
+	// SecretAccessKey represents the secret access key of the AWS temporary credentials.
+	SecretAccessKey string `json:"secretAccessKey"`
+
+	// SessionToken represents the session token of the AWS temporary credentials.
+	SessionToken string `json:"sessionToken"`
+
+	// Expiration represents the expiration time of the AWS temporary credentials.
+	Expiration time.Time `json:"expiration"`
+}
+
+// AWSCredentialsProvider represents a function pointer to get the AWS temporary credentials of the AWS role, which is the role in the Athenz domain.
+// The duration is the duration of the credentials in second, and 0 implies the configured expiry.
+type AWSCredentialsProvider func(ctx context.Context, domain, role, externalID string, duration int64) (*AWSCredentials, error)
+
+// ErrAWSCredentialsRequestFailed represents an error when failed to fetch the AWS temporary credentials from AWSCredentialsProvider.
+var ErrAWSCredentialsRequestFailed = errors.New("Failed to fetch AWS temporary credentials")
+
+// NewAWSCredentialsService returns an AWSCredentialsService to update and get the AWS temporary credentials from Athenz.
+func NewAWSCredentialsService(cfg config.AWSCredentials, token ntokend.TokenProvider) (AWSCredentialsService, error) {
+	var (
+		err           error
+		exp           = defaultExpiry
+		refreshPeriod = defaultRefreshPeriod
+	)
+
+	if !cfg.Enable {
+		return nil, ErrDisabled
+	}
+
+	if cfg.Expiry != "" {
+		if exp, err = time.ParseDuration(cfg.Expiry); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Expiry: "+err.Error())
+		}
+	}
+	if cfg.RefreshPeriod != "" {
+		if refreshPeriod, err = time.ParseDuration(cfg.RefreshPeriod); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshPeriod: "+err.Error())
+		}
+	}
+
+	// if user set the expiry time and refresh period > expiry time then return error
+	if exp != 0 && refreshPeriod > exp {
+		return nil, errors.Wrap(ErrInvalidSetting, "refresh period > token expiry time")
+	}
+
+	a := &awsCredentialsService{
+		cfg:                   cfg,
+		token:                 token,
+		athenzURL:             cfg.AthenzURL,
+		athenzPrincipleHeader: cfg.PrincipalAuthHeader,
+		expiry:                exp,
+	}
+	if a.creds, err = newCredentialCache("AWS credentials", metrics.AWSCredentials, refreshPeriod, cfg.Retry, cfg.RefreshPool, a.fetchAWSCredentials); err != nil {
+		return nil, err
+	}
+
+	if token == nil && cfg.CertPath == "" {
+		return nil, errors.Wrap(ErrInvalidSetting, "Neither NToken nor client certificate is set.")
+	}
+
+	var cp *x509.CertPool
+	if cfg.AthenzCAPath != "" {
+		var err error
+		caPath := config.GetActualValue(cfg.AthenzCAPath)
+		_, err = os.Stat(caPath)
+		if os.IsNotExist(err) {
+			return nil, errors.Wrap(ErrInvalidSetting, "Athenz CA not exist")
+		}
+		cp, err = NewX509CertPool(caPath)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, err.Error())
+		}
+	}
+
+	certPath := cfg.CertPath
+	certKeyPath := cfg.CertKeyPath
+	// prevent using client certificate (ntoken has priority)
+	if token != nil {
+		certPath = ""
+		certKeyPath = ""
+	}
+
+	tlsConfig, err := NewTLSClientConfig(cp, certPath, certKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, err.Error())
+	}
+
+	a.httpClient.Store(&http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	})
+	a.rootCAs = cp
+	a.certPath = certPath
+	a.certKeyPath = certKeyPath
+	return a, nil
+}
+
+// StartAWSCredentialsUpdater returns AWSCredentialsService.
+// This function will periodically refresh the AWS temporary credentials.
+func (a *awsCredentialsService) StartAWSCredentialsUpdater(ctx context.Context) <-chan error {
+	return a.creds.start(ctx)
+}
+
+// InheritAWSCredentialsCache copies the AWS temporary credentials cached in src, which are not expired yet, to dst.
+// It returns the number of credentials copied. Nothing is copied if either service is not created by NewAWSCredentialsService.
+func InheritAWSCredentialsCache(ctx context.Context, dst, src AWSCredentialsService) int {
+	d, ok := dst.(*awsCredentialsService)
+	if !ok {
+		return 0
+	}
+	s, ok := src.(*awsCredentialsService)
+	if !ok {
+		return 0
+	}
+	return d.creds.inherit(ctx, s.creds)
+}
+
+// GetAWSCredentialsProvider returns a function pointer to get the AWS temporary credentials.
+func (a *awsCredentialsService) GetAWSCredentialsProvider() AWSCredentialsProvider {
+	return a.getAWSCredentials
+}
+
+// Readiness returns the readiness of the AWS credentials service based on the result of the last cache refresh.
+func (a *awsCredentialsService) Readiness() Readiness {
+	return a.creds.readiness()
+}
+
+// getAWSCredentials returns AWSCredentials struct or error.
+// This function will return the AWS temporary credentials stored inside the cache, or fetch them from Athenz when corresponding credentials cannot be found in the cache.
+func (a *awsCredentialsService) getAWSCredentials(ctx context.Context, domain, role, externalID string, duration int64) (*AWSCredentials, error) {
+	cd, err := a.creds.get(ctx, &awsCredentialsCacheData{
+		domain:     domain,
+		role:       role,
+		externalID: externalID,
+		duration:   duration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cd.(*awsCredentialsCacheData).creds, nil
+}
+
+// RefreshAWSCredentialsCache returns the error channel when it is updated.
+func (a *awsCredentialsService) RefreshAWSCredentialsCache(ctx context.Context) <-chan error {
+	return a.creds.refresh(ctx)
+}
+
+// fetchAWSCredentials fetch the AWS temporary credentials of the request from Athenz server, and return the cache entry of the decoded credentials, the duration to cache it, and any error if occurred.
+// P.S. Do not call fetchAWSCredentials() outside singleflight group, as behavior of concurrent request is not tested
+func (a *awsCredentialsService) fetchAWSCredentials(ctx context.Context, r credentialRequest) (credentialRequest, time.Duration, error) {
+	cd := r.(*awsCredentialsCacheData)
+	glg.Debugf("get AWS credentials, %s, duration: %d", cd, cd.duration)
+
+	// prepare request object
+	req, err := a.createGetAWSCredentialsRequest(cd.domain, cd.role, cd.externalID, cd.duration)
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, 0, err
+	}
+	glg.Debugf("request url: %v", req.URL)
+
+	// prepare Athenz credentials
+	if a.token != nil {
+		token, err := a.token()
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set(a.athenzPrincipleHeader, token)
+	} else if a.certPath != "" {
+		// prepare TLS config (certificate file may refresh)
+		tcc, err := NewTLSClientConfig(a.rootCAs, a.certPath, a.certKeyPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		a.httpClient.Store(&http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tcc,
+			},
+		})
+	} else {
+		return nil, 0, ErrNoCredentials
+	}
+
+	// send request
+	start := time.Now()
+	res, err := a.httpClient.Load().(*http.Client).Do(req.WithContext(ctx))
+	if err != nil {
+		metrics.ObserveAthenzRequest(metrics.AWSCredentials, metrics.StatusError, start)
+		return nil, 0, err
+	}
+	metrics.ObserveAthenzRequest(metrics.AWSCredentials, metrics.StatusCode(res.StatusCode), start)
+
+	defer flushAndClose(res.Body)
+	if res.StatusCode != http.StatusOK {
+		uerr := newUpstreamError(ErrAWSCredentialsRequestFailed, res)
+		glg.Debugf("error return from server, response:%+v, message: %v", res, uerr.Message)
+		return nil, 0, uerr
+	}
+
+	var data *AWSCredentials
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, 0, err
+	}
+
+	// the AWS temporary credentials are cached until a minute before they expire
+	return &awsCredentialsCacheData{
+		creds:      data,
+		domain:     cd.domain,
+		role:       cd.role,
+		externalID: cd.externalID,
+		duration:   cd.duration,
+	}, data.Expiration.Sub(fastime.Now().Add(time.Minute)), nil
+}
+
+// createGetAWSCredentialsRequest returns the request to the AWS credentials endpoint of the Athenz server.
+func (a *awsCredentialsService) createGetAWSCredentialsRequest(domain, role, externalID string, duration int64) (*http.Request, error) {
+	u := fmt.Sprintf("https://%s/domain/%s/role/%s/creds", strings.TrimPrefix(strings.TrimPrefix(a.athenzURL, "https://"), "http://"),
+		url.PathEscape(domain), url.PathEscape(role))
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+
+	// create URL query
+	q := req.URL.Query()
+	dur := int64(a.expiry / time.Second)
+	if duration > 0 {
+		dur = duration
+	}
+	if dur > 0 {
+		q.Add("durationSeconds", strconv.FormatInt(dur, 10))
+	}
+	if externalID != "" {
+		q.Add("externalId", externalID)
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	return req, nil
+}
+
+// cacheKey returns the cache key of the AWS temporary credentials.
+func (cd *awsCredentialsCacheData) cacheKey() string {
+	return encodeAWSCredentialsKey(cd.domain, cd.role, cd.externalID, cd.duration)
+}
+
+// String returns the request of the AWS temporary credentials in the error messages.
+func (cd *awsCredentialsCacheData) String() string {
+	return fmt.Sprintf("domain: %s, role: %s", cd.domain, cd.role)
+}
+
+// encodeAWSCredentialsKey returns the cache key of the AWS temporary credentials.
+func encodeAWSCredentialsKey(domain, role, externalID string, duration int64) string {
+	return strings.Join([]string{domain, role, externalID, strconv.FormatInt(duration, 10)}, cacheKeySeparator)
+}
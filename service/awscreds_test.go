@@ -0,0 +1,266 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/pkg/errors"
+)
+
+func TestNewAWSCredentialsService(t *testing.T) {
+	token := func() (string, error) {
+		return "dummyNToken", nil
+	}
+	tests := []struct {
+		name    string
+		cfg     config.AWSCredentials
+		wantErr error
+	}{
+		{
+			name:    "Check disabled",
+			cfg:     config.AWSCredentials{},
+			wantErr: ErrDisabled,
+		},
+		{
+			name: "Check invalid expiry",
+			cfg: config.AWSCredentials{
+				Enable: true,
+				Expiry: "invalid",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `Expiry: time: invalid duration "invalid"`),
+		},
+		{
+			name: "Check refresh period > expiry",
+			cfg: config.AWSCredentials{
+				Enable:        true,
+				Expiry:        "15m",
+				RefreshPeriod: "1h",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "refresh period > token expiry time"),
+		},
+		{
+			name: "Check invalid retry attempts",
+			cfg: config.AWSCredentials{
+				Enable: true,
+				Retry: config.Retry{
+					Attempts: -1,
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0"),
+		},
+		{
+			name: "Check success",
+			cfg: config.AWSCredentials{
+				Enable:        true,
+				AthenzURL:     "athenz.io/zts/v1",
+				Expiry:        "1h",
+				RefreshPeriod: "30m",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAWSCredentialsService(tt.cfg, token)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("NewAWSCredentialsService() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			a := got.(*awsCredentialsService)
+			if a.expiry != time.Hour || a.creds.refreshPeriod != time.Minute*30 {
+				t.Errorf("NewAWSCredentialsService() = %+v", a)
+			}
+		})
+	}
+}
+
+// newTestAWSCredentialsService returns the AWS credentials service requesting to the server, which responds the path and the query of the request as the session token.
+func newTestAWSCredentialsService(t *testing.T, h http.HandlerFunc) (*awsCredentialsService, *int64) {
+	var cnt int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&cnt, 1)
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	svc, err := NewAWSCredentialsService(config.AWSCredentials{
+		Enable:              true,
+		AthenzURL:           srv.URL,
+		PrincipalAuthHeader: "Athenz-Principal",
+		Expiry:              "1h",
+		Retry: config.Retry{
+			Attempts: 1,
+			Delay:    "1ms",
+		},
+	}, func() (string, error) {
+		return "dummyNToken", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := svc.(*awsCredentialsService)
+	a.httpClient.Store(srv.Client())
+	return a, &cnt
+}
+
+func Test_awsCredentialsService_getAWSCredentials(t *testing.T) {
+	exp := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+	a, cnt := newTestAWSCredentialsService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Athenz-Principal") != "dummyNToken" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/domain/error/role/admin/creds" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"code":403,"message":"not authorized"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"accessKeyId":"id","secretAccessKey":"secret","sessionToken":%q,"expiration":%q}`,
+			r.URL.Path+"?"+r.URL.RawQuery, exp.Format("2006-01-02T15:04:05.000Z"))
+	})
+
+	tests := []struct {
+		name       string
+		domain     string
+		role       string
+		externalID string
+		duration   int64
+		want       string
+		wantErr    bool
+		wantCount  int64
+	}{
+		{
+			name:      "Check AWS credentials with the configured expiry",
+			domain:    "domain",
+			role:      "admin",
+			want:      "/domain/domain/role/admin/creds?durationSeconds=3600",
+			wantCount: 1,
+		},
+		{
+			name:      "Check cached AWS credentials",
+			domain:    "domain",
+			role:      "admin",
+			want:      "/domain/domain/role/admin/creds?durationSeconds=3600",
+			wantCount: 1,
+		},
+		{
+			name:       "Check AWS credentials with duration and external ID",
+			domain:     "domain",
+			role:       "admin",
+			externalID: "external",
+			duration:   900,
+			want:       "/domain/domain/role/admin/creds?durationSeconds=900&externalId=external",
+			wantCount:  2,
+		},
+		{
+			name:      "Check error",
+			domain:    "error",
+			role:      "admin",
+			wantErr:   true,
+			wantCount: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.GetAWSCredentialsProvider()(context.Background(), tt.domain, tt.role, tt.externalID, tt.duration)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getAWSCredentials() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if c := atomic.LoadInt64(cnt); c != tt.wantCount {
+				t.Errorf("getAWSCredentials() request count = %v, want %v", c, tt.wantCount)
+			}
+			if err != nil {
+				var ue *UpstreamError
+				if !errors.Is(err, ErrAWSCredentialsRequestFailed) || !errors.As(err, &ue) || ue.Code != http.StatusForbidden {
+					t.Errorf("getAWSCredentials() error = %v, want %v", err, ErrAWSCredentialsRequestFailed)
+				}
+				return
+			}
+			if got.AccessKeyID != "id" || got.SecretAccessKey != "secret" || got.SessionToken != tt.want || !got.Expiration.Equal(exp) {
+				t.Errorf("getAWSCredentials() = %+v, want session token %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_awsCredentialsService_RefreshAWSCredentialsCache(t *testing.T) {
+	var fail int32
+	a, cnt := newTestAWSCredentialsService(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"accessKeyId":"id","secretAccessKey":"secret","sessionToken":"token","expiration":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	for _, role := range []string{"reader", "writer"} {
+		if _, err := a.getAWSCredentials(context.Background(), "domain", role, "", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for err := range a.RefreshAWSCredentialsCache(context.Background()) {
+		t.Errorf("RefreshAWSCredentialsCache() error = %v", err)
+	}
+	if c := atomic.LoadInt64(cnt); c != 4 {
+		t.Errorf("RefreshAWSCredentialsCache() request count = %v, want 4", c)
+	}
+	if rd := a.Readiness(); !rd.Ready {
+		t.Errorf("Readiness() = %+v, want ready", rd)
+	}
+
+	// all the credentials failed after retry
+	atomic.StoreInt32(&fail, 1)
+	var errs int
+	for range a.RefreshAWSCredentialsCache(context.Background()) {
+		errs++
+	}
+	if errs != 4 {
+		t.Errorf("RefreshAWSCredentialsCache() errors = %v, want 4", errs)
+	}
+	if rd := a.Readiness(); rd.Ready {
+		t.Errorf("Readiness() = %+v, want not ready", rd)
+	}
+}
+
+func TestInheritAWSCredentialsCache(t *testing.T) {
+	src, _ := newTestAWSCredentialsService(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"accessKeyId":"id","secretAccessKey":"secret","sessionToken":"token","expiration":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	if _, err := src.getAWSCredentials(context.Background(), "domain", "admin", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	dst, cnt := newTestAWSCredentialsService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	if n := InheritAWSCredentialsCache(context.Background(), dst, src); n != 1 {
+		t.Errorf("InheritAWSCredentialsCache() = %v, want 1", n)
+	}
+	if _, err := dst.getAWSCredentials(context.Background(), "domain", "admin", "", 0); err != nil || atomic.LoadInt64(cnt) != 0 {
+		t.Errorf("getAWSCredentials() error = %v, request count = %v, want the inherited credentials", err, atomic.LoadInt64(cnt))
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+)
+
+// cacheLimit tracks the keys of the token cache in the least recently requested order,
+// to evict the tokens exceeding the maximum number of entries, and to drop the tokens not requested within the idle timeout.
+// It is safe for concurrent use.
+type cacheLimit struct {
+	maxEntries  int
+	idleTimeout time.Duration
+
+	// pinned represents the keys never evicted, i.e. the prefetch tokens. They are not tracked, so that they do not count toward maxEntries.
+	pinned map[string]struct{}
+
+	mu sync.Mutex
+	// ll holds *cacheLimitEntry, from the most recently requested to the least.
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// cacheLimitEntry represents a key tracked by cacheLimit.
+type cacheLimitEntry struct {
+	key      string
+	lastUsed time.Time
+}
+
+// newCacheLimit returns the cache limit of the configuration, or nil if there is no limit.
+// The idle timeout is the refresh period multiplied by cfg.IdlePeriods.
+func newCacheLimit(cfg config.CacheLimit, refreshPeriod time.Duration, pinned []string) *cacheLimit {
+	if cfg.MaxEntries <= 0 && cfg.IdlePeriods <= 0 {
+		return nil
+	}
+
+	l := &cacheLimit{
+		maxEntries: cfg.MaxEntries,
+		pinned:     make(map[string]struct{}, len(pinned)),
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+	if cfg.IdlePeriods > 0 {
+		l.idleTimeout = refreshPeriod * time.Duration(cfg.IdlePeriods)
+	}
+	for _, k := range pinned {
+		l.pinned[k] = struct{}{}
+	}
+	return l
+}
+
+// add starts tracking the key of the token stored in the cache, as if it is requested now.
+// It does nothing if the key is already tracked, since storing the refreshed token is not a request, or if the key is pinned.
+// It returns the keys to evict from the cache if the maximum number of entries is exceeded.
+func (l *cacheLimit) add(key string) []string {
+	if l == nil {
+		return nil
+	}
+	if _, ok := l.pinned[key]; ok {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.items[key]; ok {
+		return nil
+	}
+	l.items[key] = l.ll.PushFront(&cacheLimitEntry{
+		key:      key,
+		lastUsed: fastime.Now(),
+	})
+
+	if l.maxEntries <= 0 {
+		return nil
+	}
+	var evicted []string
+	for e := l.ll.Back(); e != nil && len(l.items) > l.maxEntries; {
+		prev := e.Prev()
+		k := e.Value.(*cacheLimitEntry).key
+		if k != key {
+			l.ll.Remove(e)
+			delete(l.items, k)
+			evicted = append(evicted, k)
+		}
+		e = prev
+	}
+	return evicted
+}
+
+// touch records the request of the key.
+func (l *cacheLimit) touch(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.items[key]; ok {
+		e.Value.(*cacheLimitEntry).lastUsed = fastime.Now()
+		l.ll.MoveToFront(e)
+	}
+}
+
+// remove stops tracking the key, e.g. when the token is expired.
+func (l *cacheLimit) remove(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.items[key]; ok {
+		l.ll.Remove(e)
+		delete(l.items, key)
+	}
+}
+
+// removeIdle stops tracking the keys not requested within the idle timeout, and returns them to drop from the cache.
+func (l *cacheLimit) removeIdle() []string {
+	if l == nil || l.idleTimeout <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var idle []string
+	deadline := fastime.Now().Add(-l.idleTimeout)
+	for e := l.ll.Back(); e != nil; {
+		prev := e.Prev()
+		ent := e.Value.(*cacheLimitEntry)
+		if !ent.lastUsed.Before(deadline) {
+			// the rest of the keys are requested more recently
+			break
+		}
+		l.ll.Remove(e)
+		delete(l.items, ent.key)
+		idle = append(idle, ent.key)
+		e = prev
+	}
+	return idle
+}
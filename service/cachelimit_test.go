@@ -0,0 +1,189 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+)
+
+func Test_newCacheLimit(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             config.CacheLimit
+		refreshPeriod   time.Duration
+		wantNil         bool
+		wantMaxEntries  int
+		wantIdleTimeout time.Duration
+	}{
+		{
+			name:          "Check unlimited",
+			refreshPeriod: time.Minute,
+			wantNil:       true,
+		},
+		{
+			name: "Check max entries",
+			cfg: config.CacheLimit{
+				MaxEntries: 10,
+			},
+			refreshPeriod:  time.Minute,
+			wantMaxEntries: 10,
+		},
+		{
+			name: "Check idle timeout",
+			cfg: config.CacheLimit{
+				IdlePeriods: 3,
+			},
+			refreshPeriod:   time.Minute * 30,
+			wantIdleTimeout: time.Minute * 90,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newCacheLimit(tt.cfg, tt.refreshPeriod, nil)
+			if (got == nil) != tt.wantNil {
+				t.Errorf("newCacheLimit() = %v, want nil: %v", got, tt.wantNil)
+				return
+			}
+			if got == nil {
+				return
+			}
+			if got.maxEntries != tt.wantMaxEntries {
+				t.Errorf("newCacheLimit() maxEntries = %v, want %v", got.maxEntries, tt.wantMaxEntries)
+			}
+			if got.idleTimeout != tt.wantIdleTimeout {
+				t.Errorf("newCacheLimit() idleTimeout = %v, want %v", got.idleTimeout, tt.wantIdleTimeout)
+			}
+		})
+	}
+}
+
+func Test_cacheLimit_add(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxEntries  int
+		pinned      []string
+		beforeFunc  func(l *cacheLimit)
+		key         string
+		wantEvicted []string
+	}{
+		{
+			name:       "Check nothing is evicted within the limit",
+			maxEntries: 3,
+			beforeFunc: func(l *cacheLimit) {
+				l.add("key1")
+			},
+			key: "key2",
+		},
+		{
+			name:       "Check the least recently requested key is evicted",
+			maxEntries: 2,
+			beforeFunc: func(l *cacheLimit) {
+				l.add("key1")
+				l.add("key2")
+				l.touch("key1")
+			},
+			key:         "key3",
+			wantEvicted: []string{"key2"},
+		},
+		{
+			name:       "Check the pinned key is not evicted",
+			maxEntries: 1,
+			pinned:     []string{"key1"},
+			beforeFunc: func(l *cacheLimit) {
+				l.add("key1")
+				l.add("key2")
+			},
+			key:         "key3",
+			wantEvicted: []string{"key2"},
+		},
+		{
+			name:       "Check the pinned keys do not count toward the limit",
+			maxEntries: 1,
+			pinned:     []string{"key1", "key2"},
+			beforeFunc: func(l *cacheLimit) {
+				l.add("key1")
+				l.add("key2")
+			},
+			key: "key3",
+		},
+		{
+			name:       "Check the tracked key is not added again",
+			maxEntries: 2,
+			beforeFunc: func(l *cacheLimit) {
+				l.add("key1")
+				l.add("key2")
+			},
+			key: "key1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newCacheLimit(config.CacheLimit{
+				MaxEntries: tt.maxEntries,
+			}, time.Minute, tt.pinned)
+			tt.beforeFunc(l)
+			if got := l.add(tt.key); !reflect.DeepEqual(got, tt.wantEvicted) {
+				t.Errorf("cacheLimit.add() = %v, want %v", got, tt.wantEvicted)
+			}
+			if len(l.items) != l.ll.Len() || len(l.items) > tt.maxEntries {
+				t.Errorf("cacheLimit.add() tracked %d keys, list %d keys, want at most %d", len(l.items), l.ll.Len(), tt.maxEntries)
+			}
+		})
+	}
+}
+
+func Test_cacheLimit_removeIdle(t *testing.T) {
+	l := newCacheLimit(config.CacheLimit{
+		IdlePeriods: 1,
+	}, time.Millisecond*100, []string{"pinned"})
+	l.add("pinned")
+	l.add("idle")
+	l.add("used")
+	l.add("removed")
+	l.remove("removed")
+
+	time.Sleep(time.Millisecond * 150)
+	l.touch("used")
+	l.add("new")
+
+	if got, want := l.removeIdle(), []string{"idle"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cacheLimit.removeIdle() = %v, want %v", got, want)
+	}
+	for _, k := range []string{"used", "new"} {
+		if _, ok := l.items[k]; !ok {
+			t.Errorf("cacheLimit.removeIdle() removed %v", k)
+		}
+	}
+	if got := l.removeIdle(); got != nil {
+		t.Errorf("cacheLimit.removeIdle() second call = %v, want nil", got)
+	}
+}
+
+func Test_cacheLimit_disabled(t *testing.T) {
+	var l *cacheLimit
+	l.touch("key")
+	l.remove("key")
+	if got := l.add("key"); got != nil {
+		t.Errorf("cacheLimit.add() = %v, want nil", got)
+	}
+	if got := l.removeIdle(); got != nil {
+		t.Errorf("cacheLimit.removeIdle() = %v, want nil", got)
+	}
+}
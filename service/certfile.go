@@ -0,0 +1,187 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultCertFileMode represents the default file permission of the certificate and the CA certificate bundle.
+	defaultCertFileMode os.FileMode = 0644
+
+	// defaultKeyFileMode represents the default file permission of the private key.
+	defaultKeyFileMode os.FileMode = 0600
+
+	// reloadCommandTimeout represents the time limit of the reload command.
+	reloadCommandTimeout = time.Second * 30
+)
+
+// signals represents the signals allowed to send after the files are written.
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// certWriter writes the certificate, the CA certificate bundle and the private key to files, and notifies the consumers.
+type certWriter struct {
+	cfg      config.CertOutput
+	certMode os.FileMode
+	keyMode  os.FileMode
+	signal   syscall.Signal
+}
+
+// newCertWriter returns a certWriter with the parsed configuration.
+func newCertWriter(cfg config.CertOutput) (*certWriter, error) {
+	if cfg.CertPath == "" || cfg.KeyPath == "" {
+		return nil, errors.Wrap(ErrInvalidParameter, "Output: certPath and keyPath are required")
+	}
+
+	w := &certWriter{
+		cfg:      cfg,
+		certMode: defaultCertFileMode,
+		keyMode:  defaultKeyFileMode,
+	}
+
+	var err error
+	if cfg.CertMode != "" {
+		if w.certMode, err = parseFileMode(cfg.CertMode); err != nil {
+			return nil, errors.Wrap(ErrInvalidParameter, "Output.CertMode: "+err.Error())
+		}
+	}
+	if cfg.KeyMode != "" {
+		if w.keyMode, err = parseFileMode(cfg.KeyMode); err != nil {
+			return nil, errors.Wrap(ErrInvalidParameter, "Output.KeyMode: "+err.Error())
+		}
+	}
+	if cfg.Signal != "" {
+		sig, ok := signals[cfg.Signal]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidParameter, "Output.Signal: unsupported signal %s", cfg.Signal)
+		}
+		if cfg.PIDFile == "" {
+			return nil, errors.Wrap(ErrInvalidParameter, "Output.PIDFile: required to send the signal")
+		}
+		w.signal = sig
+	}
+	return w, nil
+}
+
+// write writes the files atomically, then runs the reload command and sends the signal.
+// The private key is written first and the certificate last, since the key is regenerated on each refresh,
+// so that the consumers watching the certificate file are triggered after its private key is in place.
+// The CA certificate bundle is not written if either the path or the bundle is empty.
+func (w *certWriter) write(cert, caBundle, key []byte) error {
+	if err := writeFileAtomic(w.cfg.KeyPath, key, w.keyMode); err != nil {
+		return errors.Wrap(err, "failed to write private key")
+	}
+	if w.cfg.CABundlePath != "" && len(caBundle) != 0 {
+		if err := writeFileAtomic(w.cfg.CABundlePath, caBundle, w.certMode); err != nil {
+			return errors.Wrap(err, "failed to write CA certificate bundle")
+		}
+	}
+	if err := writeFileAtomic(w.cfg.CertPath, cert, w.certMode); err != nil {
+		return errors.Wrap(err, "failed to write certificate")
+	}
+	glg.Infof("service certificate is written to %s", w.cfg.CertPath)
+
+	return w.notify()
+}
+
+// notify runs the reload command and sends the signal to the process in the PID file, if they are configured.
+func (w *certWriter) notify() error {
+	if w.cfg.ReloadCommand != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), reloadCommandTimeout)
+		defer cancel()
+
+		out, err := exec.CommandContext(ctx, "/bin/sh", "-c", w.cfg.ReloadCommand).CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "reload command failed, output: %s", out)
+		}
+	}
+
+	if w.signal != 0 {
+		b, err := ioutil.ReadFile(w.cfg.PIDFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read PID file")
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return errors.Wrap(err, "invalid PID file")
+		}
+		if err := syscall.Kill(pid, w.signal); err != nil {
+			return errors.Wrapf(err, "failed to send %s to %d", w.cfg.Signal, pid)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it to path,
+// so that the consumers never read a partially written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// parseFileMode parses the file permission in octal, e.g. "0644".
+func parseFileMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(m).Perm(), nil
+}
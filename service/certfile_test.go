@@ -0,0 +1,353 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/pkg/errors"
+)
+
+func TestNewCertWriter(t *testing.T) {
+	type test struct {
+		name    string
+		cfg     config.CertOutput
+		want    *certWriter
+		wantErr error
+	}
+	tests := []test{
+		{
+			name: "NewCertWriter with default file modes",
+			cfg: config.CertOutput{
+				Enable:   true,
+				CertPath: "/tmp/cert.pem",
+				KeyPath:  "/tmp/key.pem",
+			},
+			want: &certWriter{
+				certMode: 0644,
+				keyMode:  0600,
+			},
+		},
+		{
+			name: "NewCertWriter with custom file modes and signal",
+			cfg: config.CertOutput{
+				Enable:   true,
+				CertPath: "/tmp/cert.pem",
+				KeyPath:  "/tmp/key.pem",
+				CertMode: "0640",
+				KeyMode:  "0400",
+				Signal:   "SIGHUP",
+				PIDFile:  "/var/run/nginx.pid",
+			},
+			want: &certWriter{
+				certMode: 0640,
+				keyMode:  0400,
+				signal:   syscall.SIGHUP,
+			},
+		},
+		{
+			name: "NewCertWriter without key path",
+			cfg: config.CertOutput{
+				Enable:   true,
+				CertPath: "/tmp/cert.pem",
+			},
+			wantErr: errors.Wrap(ErrInvalidParameter, "Output: certPath and keyPath are required"),
+		},
+		{
+			name: "NewCertWriter with invalid file mode",
+			cfg: config.CertOutput{
+				Enable:   true,
+				CertPath: "/tmp/cert.pem",
+				KeyPath:  "/tmp/key.pem",
+				KeyMode:  "0800",
+			},
+			wantErr: errors.Wrap(ErrInvalidParameter, `Output.KeyMode: strconv.ParseUint: parsing "0800": invalid syntax`),
+		},
+		{
+			name: "NewCertWriter with unsupported signal",
+			cfg: config.CertOutput{
+				Enable:   true,
+				CertPath: "/tmp/cert.pem",
+				KeyPath:  "/tmp/key.pem",
+				Signal:   "SIGKILL",
+				PIDFile:  "/var/run/nginx.pid",
+			},
+			wantErr: errors.Wrap(ErrInvalidParameter, "Output.Signal: unsupported signal SIGKILL"),
+		},
+		{
+			name: "NewCertWriter with signal without PID file",
+			cfg: config.CertOutput{
+				Enable:   true,
+				CertPath: "/tmp/cert.pem",
+				KeyPath:  "/tmp/key.pem",
+				Signal:   "SIGHUP",
+			},
+			wantErr: errors.Wrap(ErrInvalidParameter, "Output.PIDFile: required to send the signal"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newCertWriter(tt.cfg)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newCertWriter() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newCertWriter() unexpected error = %v", err)
+				return
+			}
+			if got.certMode != tt.want.certMode || got.keyMode != tt.want.keyMode || got.signal != tt.want.signal {
+				t.Errorf("newCertWriter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_certWriter_write(t *testing.T) {
+	type test struct {
+		name      string
+		cfg       func(dir string) config.CertOutput
+		wantErr   bool
+		checkFunc func(dir string) error
+	}
+	checkFile := func(path, content string, mode os.FileMode) error {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if string(b) != content {
+			return fmt.Errorf("%s content got: %s, want: %s", path, b, content)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.Mode().Perm() != mode {
+			return fmt.Errorf("%s mode got: %v, want: %v", path, fi.Mode().Perm(), mode)
+		}
+		return nil
+	}
+	tests := []test{
+		{
+			name: "write certificate, CA bundle and key, then run reload command",
+			cfg: func(dir string) config.CertOutput {
+				return config.CertOutput{
+					Enable:        true,
+					CertPath:      filepath.Join(dir, "cert.pem"),
+					CABundlePath:  filepath.Join(dir, "ca.pem"),
+					KeyPath:       filepath.Join(dir, "key.pem"),
+					CertMode:      "0640",
+					ReloadCommand: "cat " + filepath.Join(dir, "cert.pem") + " > " + filepath.Join(dir, "reloaded"),
+				}
+			},
+			checkFunc: func(dir string) error {
+				if err := checkFile(filepath.Join(dir, "cert.pem"), "cert", 0640); err != nil {
+					return err
+				}
+				if err := checkFile(filepath.Join(dir, "ca.pem"), "ca", 0640); err != nil {
+					return err
+				}
+				if err := checkFile(filepath.Join(dir, "key.pem"), "key", 0600); err != nil {
+					return err
+				}
+				b, err := ioutil.ReadFile(filepath.Join(dir, "reloaded"))
+				if err != nil || string(b) != "cert" {
+					return fmt.Errorf("reload command is not run after writing the files, got: %s, err: %v", b, err)
+				}
+				files, _ := filepath.Glob(filepath.Join(dir, ".*.tmp*"))
+				if len(files) != 0 {
+					return fmt.Errorf("temporary files remain: %v", files)
+				}
+				return nil
+			},
+		},
+		{
+			name: "write files and send signal",
+			cfg: func(dir string) config.CertOutput {
+				pidFile := filepath.Join(dir, "pid")
+				ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+				return config.CertOutput{
+					Enable:   true,
+					CertPath: filepath.Join(dir, "cert.pem"),
+					KeyPath:  filepath.Join(dir, "key.pem"),
+					Signal:   "SIGUSR2",
+					PIDFile:  pidFile,
+				}
+			},
+			checkFunc: func(dir string) error {
+				if _, err := os.Stat(filepath.Join(dir, "ca.pem")); !os.IsNotExist(err) {
+					return fmt.Errorf("CA bundle is written without the path")
+				}
+				return checkFile(filepath.Join(dir, "key.pem"), "key", 0600)
+			},
+		},
+		{
+			name: "reload command failure",
+			cfg: func(dir string) config.CertOutput {
+				return config.CertOutput{
+					Enable:        true,
+					CertPath:      filepath.Join(dir, "cert.pem"),
+					KeyPath:       filepath.Join(dir, "key.pem"),
+					ReloadCommand: "exit 1",
+				}
+			},
+			wantErr: true,
+			checkFunc: func(dir string) error {
+				return checkFile(filepath.Join(dir, "cert.pem"), "cert", 0644)
+			},
+		},
+		{
+			name: "write to non-existing directory",
+			cfg: func(dir string) config.CertOutput {
+				return config.CertOutput{
+					Enable:   true,
+					CertPath: filepath.Join(dir, "cert.pem"),
+					KeyPath:  filepath.Join(dir, "non_exist", "key.pem"),
+				}
+			},
+			wantErr: true,
+			checkFunc: func(dir string) error {
+				if _, err := os.Stat(filepath.Join(dir, "cert.pem")); !os.IsNotExist(err) {
+					return fmt.Errorf("certificate is written after the key failed")
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "certfile")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			cfg := tt.cfg(dir)
+			sigCh := make(chan os.Signal, 1)
+			if cfg.Signal != "" {
+				signal.Notify(sigCh, signals[cfg.Signal])
+				defer signal.Stop(sigCh)
+			}
+
+			w, err := newCertWriter(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = w.write([]byte("cert"), []byte("ca"), []byte("key"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("certWriter.write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := tt.checkFunc(dir); err != nil {
+				t.Errorf("certWriter.write() error = %v", err)
+			}
+			if cfg.Signal != "" {
+				select {
+				case <-sigCh:
+				case <-time.After(time.Second):
+					t.Errorf("signal %s is not received", cfg.Signal)
+				}
+			}
+		})
+	}
+}
+
+func TestSvcCertService_RefreshSvcCert_output(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dummyCertBytes, _ := ioutil.ReadFile("../test/data/dummyServer.crt")
+	dummyCaCertBytes, _ := ioutil.ReadFile("../test/data/dummyCa.pem")
+	dummyKeyBytes, _ := ioutil.ReadFile("../test/data/dummyServer.key")
+	dummyResponse := fmt.Sprintf(
+		`{"name": "dummy", "certificate":"%s", "caCertBundle": "%s"}`,
+		strings.ReplaceAll(string(dummyCertBytes), "\n", "\\n"),
+		strings.ReplaceAll(string(dummyCaCertBytes), "\n", "\\n"),
+	)
+
+	cfg := config.Config{
+		NToken: config.NToken{
+			PrivateKeyPath: "../test/data/dummyServer.key",
+			AthenzDomain:   "dummyDomain",
+			ServiceName:    "dummyService",
+		},
+		ServiceCert: config.ServiceCert{
+			Enable:              true,
+			AthenzCAPath:        "../test/data/dummyCa.pem",
+			AthenzURL:           "http://dummy",
+			RefreshPeriod:       "30m",
+			PrincipalAuthHeader: "Athenz-Principal",
+			Output: config.CertOutput{
+				Enable:       true,
+				CertPath:     filepath.Join(dir, "cert.pem"),
+				CABundlePath: filepath.Join(dir, "ca.pem"),
+				KeyPath:      filepath.Join(dir, "key.pem"),
+			},
+		},
+	}
+	s, err := NewSvcCertService(cfg, func() (string, error) { return "dummyToken", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*svcCertService).client.Transport = &mockTransporter{
+		StatusCode: 200,
+		Body:       [][]byte{[]byte(dummyResponse)},
+		Method:     "GET",
+	}
+
+	if _, err := s.RefreshSvcCert(); err != nil {
+		t.Fatalf("RefreshSvcCert() error = %v", err)
+	}
+	for path, want := range map[string][]byte{
+		"cert.pem": dummyCertBytes,
+		"ca.pem":   dummyCaCertBytes,
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(got) != string(want) {
+			t.Errorf("%s got: %s, err: %v", path, got, err)
+		}
+	}
+
+	// the N-token signing key must not be written, a new ECDSA key is generated instead
+	key, err := ioutil.ReadFile(filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) == string(dummyKeyBytes) {
+		t.Errorf("key.pem is the N-token signing key")
+	}
+	if block, _ := pem.Decode(key); block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Errorf("key.pem is not an ECDSA private key: %s", key)
+	}
+	_, cachedKey, err := s.GetSvcCertKeyProvider()()
+	if err != nil || string(cachedKey) != string(key) {
+		t.Errorf("GetSvcCertKeyProvider() key = %s, err = %v, want %s", cachedKey, err, key)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TokenClaims represents the claims of the role token or the access token.
+type TokenClaims struct {
+	// Domain represents the domain of the granted roles.
+	Domain string `json:"domain"`
+
+	// Roles represents the names of the granted roles.
+	Roles []string `json:"roles"`
+
+	// Principal represents the principal the token is issued to, e.g. "domain.service".
+	Principal string `json:"principal"`
+
+	// IssueTime represents the issue time of the token in Unix time.
+	IssueTime int64 `json:"issueTime"`
+
+	// ExpiryTime represents the expiry time of the token in Unix time.
+	ExpiryTime int64 `json:"expiryTime"`
+
+	// ProxyPrincipal represents the principal the token is requested for by the proxy principal. Empty implies no proxy.
+	ProxyPrincipal string `json:"proxyPrincipal,omitempty"`
+
+	// KeyID represents the ID of the ZTS private key signing the token.
+	KeyID string `json:"keyId"`
+
+	// Issuer represents the issuer of the access token, e.g. "https://athenz.io:4443/zts/v1". It is empty for the role tokens.
+	Issuer string `json:"issuer,omitempty"`
+}
+
+// ErrInvalidToken represents an error when the token cannot be parsed, or fails the verification.
+var ErrInvalidToken = errors.New("Invalid token")
+
+// ParseRoleTokenClaims returns the claims of the role token without verifying the signature.
+// The role token consists of the fields "<key>=<value>" separated by ";", e.g. "v=Z1;d=domain;r=reader,writer;...;s=signature".
+func ParseRoleTokenClaims(token string) (*TokenClaims, error) {
+	c := new(TokenClaims)
+	for _, field := range strings.Split(token, ";") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Wrap(ErrInvalidToken, "malformed role token field")
+		}
+
+		var err error
+		switch kv[0] {
+		case "d":
+			c.Domain = kv[1]
+		case "r":
+			c.Roles = strings.Split(kv[1], roleSeparator)
+		case "p":
+			c.Principal = kv[1]
+		case "t":
+			c.IssueTime, err = strconv.ParseInt(kv[1], 10, 64)
+		case "e":
+			c.ExpiryTime, err = strconv.ParseInt(kv[1], 10, 64)
+		case "proxy":
+			c.ProxyPrincipal = kv[1]
+		case "k":
+			c.KeyID = kv[1]
+		}
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidToken, "role token field %s: %s", kv[0], err.Error())
+		}
+	}
+
+	if c.Domain == "" || len(c.Roles) == 0 {
+		return nil, errors.Wrap(ErrInvalidToken, "role token without domain or roles")
+	}
+	return c, nil
+}
+
+// accessTokenHeader represents the JOSE header of the access token.
+type accessTokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// accessTokenPayload represents the claims of the access token issued by ZTS.
+type accessTokenPayload struct {
+	Audience string   `json:"aud"`
+	Scopes   []string `json:"scp"`
+	Scope    string   `json:"scope"`
+	Subject  string   `json:"sub"`
+	IssuedAt int64    `json:"iat"`
+	Expiry   int64    `json:"exp"`
+	Proxy    string   `json:"proxy"`
+	Issuer   string   `json:"iss"`
+}
+
+// ParseAccessTokenClaims returns the claims of the access token without verifying the signature.
+// The audience is the domain, and the scope is the granted roles of the access token issued by ZTS.
+func ParseAccessTokenClaims(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.Wrap(ErrInvalidToken, "access token is not a JWT")
+	}
+
+	var h accessTokenHeader
+	if err := decodeJWTSegment(parts[0], &h); err != nil {
+		return nil, errors.Wrapf(ErrInvalidToken, "access token header: %s", err.Error())
+	}
+	var p accessTokenPayload
+	if err := decodeJWTSegment(parts[1], &p); err != nil {
+		return nil, errors.Wrapf(ErrInvalidToken, "access token payload: %s", err.Error())
+	}
+
+	roles := p.Scopes
+	if len(roles) == 0 && p.Scope != "" {
+		roles = strings.Fields(p.Scope)
+	}
+	return &TokenClaims{
+		Domain:         p.Audience,
+		Roles:          roles,
+		Principal:      p.Subject,
+		IssueTime:      p.IssuedAt,
+		ExpiryTime:     p.Expiry,
+		ProxyPrincipal: p.Proxy,
+		KeyID:          h.KeyID,
+		Issuer:         p.Issuer,
+	}, nil
+}
+
+// decodeJWTSegment decodes the base64url encoded JSON segment of the JWT into v.
+func decodeJWTSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseRoleTokenClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		want    *TokenClaims
+		wantErr bool
+	}{
+		{
+			name:  "Check role token",
+			token: "v=Z1;d=domain;r=reader,writer;p=client.service;h=host;a=salt;t=1600000000;e=1600003600;k=0;i=127.0.0.1;s=signature",
+			want: &TokenClaims{
+				Domain:     "domain",
+				Roles:      []string{"reader", "writer"},
+				Principal:  "client.service",
+				IssueTime:  1600000000,
+				ExpiryTime: 1600003600,
+				KeyID:      "0",
+			},
+		},
+		{
+			name:  "Check role token with proxy principal",
+			token: "v=Z1;d=domain;r=reader;p=client.service;t=1600000000;e=1600003600;k=1;proxy=proxy.service;s=sig=nature",
+			want: &TokenClaims{
+				Domain:         "domain",
+				Roles:          []string{"reader"},
+				Principal:      "client.service",
+				IssueTime:      1600000000,
+				ExpiryTime:     1600003600,
+				ProxyPrincipal: "proxy.service",
+				KeyID:          "1",
+			},
+		},
+		{
+			name:    "Check malformed field",
+			token:   "v=Z1;d=domain;r=reader;invalid",
+			wantErr: true,
+		},
+		{
+			name:    "Check invalid issue time",
+			token:   "v=Z1;d=domain;r=reader;t=now",
+			wantErr: true,
+		},
+		{
+			name:    "Check role token without roles",
+			token:   "v=Z1;d=domain;p=client.service",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRoleTokenClaims(tt.token)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidToken)) {
+				t.Errorf("ParseRoleTokenClaims() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRoleTokenClaims() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAccessTokenClaims(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"kid":"0","typ":"at+jwt","alg":"RS256"}`))
+	jwt := func(payload string) string {
+		return header + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	}
+	tests := []struct {
+		name    string
+		token   string
+		want    *TokenClaims
+		wantErr bool
+	}{
+		{
+			name:  "Check access token",
+			token: jwt(`{"aud":"domain","scp":["reader","writer"],"sub":"client.service","iat":1600000000,"exp":1600003600,"proxy":"proxy.service","iss":"https://athenz.io:4443/zts/v1"}`),
+			want: &TokenClaims{
+				Domain:         "domain",
+				Roles:          []string{"reader", "writer"},
+				Principal:      "client.service",
+				IssueTime:      1600000000,
+				ExpiryTime:     1600003600,
+				ProxyPrincipal: "proxy.service",
+				KeyID:          "0",
+				Issuer:         "https://athenz.io:4443/zts/v1",
+			},
+		},
+		{
+			name:  "Check access token with scope string",
+			token: jwt(`{"aud":"domain","scope":"reader writer","sub":"client.service","iat":1600000000,"exp":1600003600}`),
+			want: &TokenClaims{
+				Domain:     "domain",
+				Roles:      []string{"reader", "writer"},
+				Principal:  "client.service",
+				IssueTime:  1600000000,
+				ExpiryTime: 1600003600,
+				KeyID:      "0",
+			},
+		},
+		{
+			name:    "Check not JWT",
+			token:   "access-token",
+			wantErr: true,
+		},
+		{
+			name:    "Check invalid payload",
+			token:   header + ".invalid.signature",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAccessTokenClaims(tt.token)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidToken)) {
+				t.Errorf("ParseAccessTokenClaims() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAccessTokenClaims() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
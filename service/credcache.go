@@ -0,0 +1,256 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
+	"github.com/kpango/fastime"
+	"github.com/kpango/gache"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// credentialCache caches the credentials fetched from the Athenz server on demand, and refreshes them periodically.
+// It is shared by the services of the credentials requested by the clients, which provide only how to fetch the credentials.
+type credentialCache struct {
+	// name represents the credentials in the log messages, e.g. "ID token".
+	name string
+	// metric represents the credentials in the metrics labels.
+	metric string
+
+	cache gache.Gache
+	group singleflight.Group
+
+	// fetch requests the credentials of req to the Athenz server, and returns the cache entry of them and the duration to cache it.
+	// It is called in the singleflight group of the cache key of req.
+	fetch func(ctx context.Context, req credentialRequest) (credentialRequest, time.Duration, error)
+
+	// refreshable reports whether the cache entry should be refreshed by the periodic refresh. nil implies all the entries are refreshed.
+	refreshable func(entry credentialRequest) bool
+
+	refreshPeriod time.Duration
+	retryMaxCount int
+	retryInterval time.Duration
+	retryBackoff  backoff
+
+	// refreshPool bounds the concurrency and the rate of the background refreshes. nil implies the credentials are refreshed one at a time without rate limit.
+	refreshPool *refreshPool
+
+	// lastRefresh records the result of the last cache refresh. It is lazy, since the credentials are fetched only by the requests.
+	lastRefresh refreshRecorder
+}
+
+// credentialRequest represents the request of the credentials. The cache entry of the credentials is the request fetching them, so that it is refreshed by the same request.
+type credentialRequest interface {
+	// cacheKey returns the cache key of the credentials of the request.
+	cacheKey() string
+
+	// String returns the request in the error messages.
+	String() string
+}
+
+// newCredentialCache returns the credential cache refreshed every refreshPeriod with the retry and the refresh pool configurations.
+func newCredentialCache(name, metric string, refreshPeriod time.Duration, retry config.Retry, pool config.RefreshPool,
+	fetch func(ctx context.Context, req credentialRequest) (credentialRequest, time.Duration, error)) (*credentialCache, error) {
+	var err error
+	retryInterval := defaultErrRetryInterval
+	if retry.Delay != "" {
+		if retryInterval, err = time.ParseDuration(retry.Delay); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryInterval: "+err.Error())
+		}
+	}
+
+	retryMaxCount := defaultErrRetryMaxCount
+	if retry.Attempts > 0 {
+		retryMaxCount = retry.Attempts
+	} else if retry.Attempts != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
+	}
+
+	retryBackoff, err := newBackoff(retry, defaultErrRetryMaxInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshPool, err := newRefreshPool(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentialCache{
+		name:          name,
+		metric:        metric,
+		cache:         gache.New(),
+		fetch:         fetch,
+		refreshPeriod: refreshPeriod,
+		retryMaxCount: retryMaxCount,
+		retryInterval: retryInterval,
+		retryBackoff:  retryBackoff,
+		refreshPool:   refreshPool,
+		lastRefresh:   refreshRecorder{lazy: true},
+	}, nil
+}
+
+// start refreshes the cached credentials every refresh period until ctx is done, and returns the error channel of the refreshes.
+func (c *credentialCache) start(ctx context.Context) <-chan error {
+	glg.Infof("Starting %s updater", c.name)
+
+	ech := make(chan error, 100)
+	go func() {
+		defer close(ech)
+
+		ticker := time.NewTicker(c.refreshPeriod)
+		for {
+			select {
+			case <-ctx.Done():
+				glg.Infof("Stopping %s updater...", c.name)
+				ticker.Stop()
+				ech <- ctx.Err()
+				return
+			case <-ticker.C:
+				for err := range c.refresh(ctx) {
+					ech <- errors.Wrap(err, "error update "+c.name)
+				}
+			}
+		}
+	}()
+
+	c.cache.StartExpired(ctx, cachePurgePeriod)
+	c.cache.EnableExpiredHook().SetExpiredHook(func(ctx context.Context, k string) {
+		glg.Warnf("the following cache is expired, key: %v", k)
+	})
+	return ech
+}
+
+// inherit copies the credentials cached in src, which are not expired yet, and returns the number of the credentials copied.
+func (c *credentialCache) inherit(ctx context.Context, src *credentialCache) int {
+	var n int64
+	src.cache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		var dur time.Duration // 0 implies no expiry
+		if exp > 0 {
+			if dur = time.Duration(exp - fastime.UnixNanoNow()); dur <= 0 {
+				return true
+			}
+		}
+		c.cache.SetWithExpire(key, val, dur)
+		atomic.AddInt64(&n, 1)
+		return true
+	})
+	return int(n)
+}
+
+// get returns the cache entry of the credentials of req, or fetches them from the Athenz server if they are not cached.
+func (c *credentialCache) get(ctx context.Context, req credentialRequest) (credentialRequest, error) {
+	if val, ok := c.cache.Get(req.cacheKey()); ok {
+		metrics.CacheHit(c.metric)
+		return val.(credentialRequest), nil
+	}
+	metrics.CacheMiss(c.metric)
+	return c.update(ctx, req)
+}
+
+// refresh refetches the cached credentials, and returns the error channel when they are updated.
+// The credentials are refreshed concurrently by the refresh pool, so that a slow request does not delay the others.
+func (c *credentialCache) refresh(ctx context.Context) <-chan error {
+	glg.Infof("Refresh %s cache started", c.name)
+
+	echan := make(chan error, c.cache.Len()*(c.retryMaxCount+1))
+	go func() {
+		defer close(echan)
+
+		targets := refreshTargets(ctx, c.cache, func(key string, val interface{}) (interface{}, bool) {
+			return val, c.refreshable == nil || c.refreshable(val.(credentialRequest))
+		})
+
+		cnt := new(refreshCounter)
+		c.refreshPool.each(ctx, len(targets), func(n int) {
+			req := targets[n].(credentialRequest)
+			errs := make([]error, 0, c.retryMaxCount+1)
+			for err := range c.updateWithRetry(ctx, req) {
+				echan <- errors.Wrap(err, req.String())
+				errs = append(errs, err)
+			}
+			cnt.add(errs, c.retryMaxCount)
+		})
+		cnt.store(&c.lastRefresh)
+	}()
+
+	return echan
+}
+
+// updateWithRetry wraps update with retry logic.
+func (c *credentialCache) updateWithRetry(ctx context.Context, req credentialRequest) <-chan error {
+	glg.Debugf("update %s with retry started, %s", c.name, req)
+
+	echan := make(chan error, c.retryMaxCount+1)
+	go func() {
+		defer close(echan)
+
+		for n := 0; n <= c.retryMaxCount; n++ {
+			if !c.refreshPool.wait(ctx) {
+				return
+			}
+			_, err := c.update(ctx, req)
+			if err == nil {
+				glg.Debug("update success")
+				return
+			}
+			echan <- err
+			metrics.RetryFailure(c.metric)
+
+			// the client errors will never succeed by retrying
+			if !retryable(err) || n == c.retryMaxCount {
+				break
+			}
+			if !sleep(ctx, c.retryBackoff.interval(n, c.retryInterval, err)) {
+				return
+			}
+		}
+		metrics.RefreshFailure(c.metric)
+	}()
+
+	return echan
+}
+
+// update fetches the credentials of req from the Athenz server, caches them, and returns the cache entry of them.
+func (c *credentialCache) update(ctx context.Context, req credentialRequest) (credentialRequest, error) {
+	key := req.cacheKey()
+	entry, err, _ := c.group.Do(key, func() (interface{}, error) {
+		entry, ttl, err := c.fetch(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		c.cache.SetWithExpire(key, entry, ttl)
+		glg.Debugf("%s is cached, %s", c.name, entry)
+		return entry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry.(credentialRequest), nil
+}
+
+// readiness returns the readiness based on the result of the last cache refresh.
+func (c *credentialCache) readiness() Readiness {
+	return c.lastRefresh.readiness()
+}
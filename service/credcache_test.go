@@ -0,0 +1,208 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/pkg/errors"
+)
+
+// testCredential is the credential request of the tests, which is also the cache entry of the fetched credentials.
+type testCredential struct {
+	name    string
+	fetched int64
+}
+
+func (c *testCredential) cacheKey() string {
+	return c.name
+}
+
+func (c *testCredential) String() string {
+	return "name: " + c.name
+}
+
+// newTestCredentialCache returns the credential cache fetching the credentials by fn, and the number of the fetches.
+func newTestCredentialCache(t *testing.T, fn func(name string) error) (*credentialCache, *int64) {
+	var cnt int64
+	c, err := newCredentialCache("test credentials", "test", time.Hour, config.Retry{
+		Attempts: 1,
+		Delay:    "1ms",
+	}, config.RefreshPool{}, func(ctx context.Context, req credentialRequest) (credentialRequest, time.Duration, error) {
+		n := atomic.AddInt64(&cnt, 1)
+		name := req.(*testCredential).name
+		if err := fn(name); err != nil {
+			return nil, 0, err
+		}
+		return &testCredential{name: name, fetched: n}, time.Hour, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &cnt
+}
+
+func Test_newCredentialCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   config.Retry
+		pool    config.RefreshPool
+		wantErr error
+	}{
+		{
+			name: "Check invalid retry delay",
+			retry: config.Retry{
+				Delay: "invalid",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `ErrRetryInterval: time: invalid duration "invalid"`),
+		},
+		{
+			name: "Check invalid retry attempts",
+			retry: config.Retry{
+				Attempts: -1,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0"),
+		},
+		{
+			name: "Check invalid refresh pool",
+			pool: config.RefreshPool{
+				Concurrency: -1,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "RefreshPool.Concurrency < 0"),
+		},
+		{
+			name: "Check default retry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newCredentialCache("test credentials", "test", time.Hour, tt.retry, tt.pool, nil)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("newCredentialCache() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.retryMaxCount != defaultErrRetryMaxCount || got.retryInterval != defaultErrRetryInterval || got.refreshPool == nil {
+				t.Errorf("newCredentialCache() = %+v", got)
+			}
+		})
+	}
+}
+
+func Test_credentialCache_get(t *testing.T) {
+	c, cnt := newTestCredentialCache(t, func(name string) error {
+		if name == "error" {
+			return &UpstreamError{Err: ErrIDTokenRequestFailed, Code: http.StatusForbidden}
+		}
+		return nil
+	})
+
+	for _, name := range []string{"a", "a", "b"} {
+		got, err := c.get(context.Background(), &testCredential{name: name})
+		if err != nil {
+			t.Fatalf("get(%q) error = %v", name, err)
+		}
+		if got.(*testCredential).name != name {
+			t.Errorf("get(%q) = %+v", name, got)
+		}
+	}
+	if n := atomic.LoadInt64(cnt); n != 2 {
+		t.Errorf("get() fetch count = %v, want 2", n)
+	}
+
+	if _, err := c.get(context.Background(), &testCredential{name: "error"}); !errors.Is(err, ErrIDTokenRequestFailed) {
+		t.Errorf("get() error = %v, want %v", err, ErrIDTokenRequestFailed)
+	}
+	if _, ok := c.cache.Get("error"); ok {
+		t.Error("get() cached the failed credentials")
+	}
+}
+
+func Test_credentialCache_refresh(t *testing.T) {
+	var fail int32
+	c, cnt := newTestCredentialCache(t, func(name string) error {
+		if atomic.LoadInt32(&fail) == 0 {
+			return nil
+		}
+		if name == "forbidden" {
+			return &UpstreamError{Err: ErrIDTokenRequestFailed, Code: http.StatusForbidden}
+		}
+		return &UpstreamError{Err: ErrIDTokenRequestFailed, Code: http.StatusInternalServerError}
+	})
+	c.refreshable = func(entry credentialRequest) bool {
+		return entry.(*testCredential).name != "skipped"
+	}
+	for _, name := range []string{"a", "forbidden", "skipped"} {
+		if _, err := c.get(context.Background(), &testCredential{name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for err := range c.refresh(context.Background()) {
+		t.Errorf("refresh() error = %v", err)
+	}
+	if n := atomic.LoadInt64(cnt); n != 5 {
+		t.Errorf("refresh() fetch count = %v, want 5", n)
+	}
+	if val, _ := c.cache.Get("skipped"); val.(*testCredential).fetched != 3 {
+		t.Errorf("refresh() refreshed the skipped credentials, %+v", val)
+	}
+	if rd := c.readiness(); !rd.Ready {
+		t.Errorf("readiness() = %+v, want ready", rd)
+	}
+
+	// the server error is retried, but the client error is not
+	atomic.StoreInt32(&fail, 1)
+	var errs int
+	for range c.refresh(context.Background()) {
+		errs++
+	}
+	if errs != 3 {
+		t.Errorf("refresh() errors = %v, want 3", errs)
+	}
+	if rd := c.readiness(); rd.Ready {
+		t.Errorf("readiness() = %+v, want not ready", rd)
+	}
+}
+
+func Test_credentialCache_inherit(t *testing.T) {
+	src, _ := newTestCredentialCache(t, func(string) error {
+		return nil
+	})
+	if _, err := src.get(context.Background(), &testCredential{name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	src.cache.SetWithExpire("expired", &testCredential{name: "expired"}, time.Millisecond)
+	time.Sleep(time.Millisecond * 50)
+
+	dst, cnt := newTestCredentialCache(t, func(string) error {
+		return nil
+	})
+	if n := dst.inherit(context.Background(), src); n != 1 {
+		t.Errorf("inherit() = %v, want 1", n)
+	}
+	if _, err := dst.get(context.Background(), &testCredential{name: "a"}); err != nil || atomic.LoadInt64(cnt) != 0 {
+		t.Errorf("get() error = %v, fetch count = %v, want the inherited credentials", err, atomic.LoadInt64(cnt))
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxErrorMessageLength represents the maximum length of the error message read from the Athenz server response.
+	maxErrorMessageLength = 1024
+)
+
+// UpstreamError represents the error response from the Athenz server.
+// It keeps the status code and the error message of the response, so that the callers can tell the client errors from the server errors.
+type UpstreamError struct {
+	// Err represents the error of the request type, e.g. ErrRoleTokenRequestFailed. errors.Cause returns it.
+	Err error
+
+	// Code represents the HTTP status code of the response.
+	Code int
+
+	// Message represents the error message of the response.
+	Message string
+
+	// retryAfter represents the duration requested by the Retry-After header.
+	retryAfter time.Duration
+}
+
+// newUpstreamError returns the UpstreamError of the error response. The response body is read to get the error message.
+func newUpstreamError(err error, res *http.Response) *UpstreamError {
+	return &UpstreamError{
+		Err:        err,
+		Code:       res.StatusCode,
+		Message:    readErrorMessage(res.Body),
+		retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+}
+
+// Error returns the message of Err, followed by the error message of the response if any.
+func (e *UpstreamError) Error() string {
+	if e.Message == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Message
+}
+
+// Cause returns Err.
+func (e *UpstreamError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns Err.
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the status code of the response.
+func (e *UpstreamError) StatusCode() int {
+	return e.Code
+}
+
+// RetryAfter returns the duration requested by the Retry-After header.
+func (e *UpstreamError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// readErrorMessage returns the message of the Athenz error response body, e.g. {"code":403,"message":"..."},
+// or the body itself if it is not in the Athenz error format.
+func readErrorMessage(body io.Reader) string {
+	b, err := ioutil.ReadAll(io.LimitReader(body, maxErrorMessageLength))
+	if err != nil {
+		return ""
+	}
+
+	var res struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &res) == nil && res.Message != "" {
+		return res.Message
+	}
+	return strings.TrimSpace(string(b))
+}
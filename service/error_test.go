@@ -0,0 +1,113 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func Test_newUpstreamError(t *testing.T) {
+	type args struct {
+		err        error
+		code       int
+		retryAfter string
+		body       string
+	}
+	tests := []struct {
+		name           string
+		args           args
+		wantError      string
+		wantMessage    string
+		wantRetryAfter time.Duration
+	}{
+		{
+			name: "Check Athenz error message is parsed",
+			args: args{
+				err:  ErrRoleTokenRequestFailed,
+				code: http.StatusNotFound,
+				body: `{"code":404,"message":"getRoleToken: No access to any roles in domain: dummy"}`,
+			},
+			wantError:   ErrRoleTokenRequestFailed.Error() + ": getRoleToken: No access to any roles in domain: dummy",
+			wantMessage: "getRoleToken: No access to any roles in domain: dummy",
+		},
+		{
+			name: "Check plain text body is used as the message",
+			args: args{
+				err:  ErrAccessTokenRequestFailed,
+				code: http.StatusBadGateway,
+				body: "bad gateway\n",
+			},
+			wantError:   ErrAccessTokenRequestFailed.Error() + ": bad gateway",
+			wantMessage: "bad gateway",
+		},
+		{
+			name: "Check empty body",
+			args: args{
+				err:        ErrRoleTokenRequestFailed,
+				code:       http.StatusTooManyRequests,
+				retryAfter: "120",
+			},
+			wantError:      ErrRoleTokenRequestFailed.Error(),
+			wantRetryAfter: time.Minute * 2,
+		},
+		{
+			name: "Check long body is truncated",
+			args: args{
+				err:  ErrRoleTokenRequestFailed,
+				code: http.StatusInternalServerError,
+				body: strings.Repeat("a", maxErrorMessageLength+10),
+			},
+			wantError:   ErrRoleTokenRequestFailed.Error() + ": " + strings.Repeat("a", maxErrorMessageLength),
+			wantMessage: strings.Repeat("a", maxErrorMessageLength),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if tt.args.retryAfter != "" {
+				rec.Header().Set("Retry-After", tt.args.retryAfter)
+			}
+			rec.WriteHeader(tt.args.code)
+			rec.WriteString(tt.args.body)
+
+			err := newUpstreamError(tt.args.err, rec.Result())
+			if err.Error() != tt.wantError {
+				t.Errorf("newUpstreamError() error = %v, want %v", err.Error(), tt.wantError)
+			}
+			if errors.Cause(err) != tt.args.err {
+				t.Errorf("newUpstreamError() cause = %v, want %v", errors.Cause(err), tt.args.err)
+			}
+			if !errors.Is(err, tt.args.err) {
+				t.Errorf("newUpstreamError() does not wrap %v", tt.args.err)
+			}
+			if err.StatusCode() != tt.args.code {
+				t.Errorf("newUpstreamError() status code = %v, want %v", err.StatusCode(), tt.args.code)
+			}
+			if err.Message != tt.wantMessage {
+				t.Errorf("newUpstreamError() message = %v, want %v", err.Message, tt.wantMessage)
+			}
+			if err.RetryAfter() != tt.wantRetryAfter {
+				t.Errorf("newUpstreamError() Retry-After = %v, want %v", err.RetryAfter(), tt.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultGraceRefreshBefore represents the default duration before the token expiry to refresh the token in background.
+	defaultGraceRefreshBefore = time.Minute
+
+	// graceRefreshTimeout represents the timeout of the background refresh, since it is not bound to the request context.
+	graceRefreshTimeout = time.Minute
+)
+
+// grace represents the stale-while-revalidate mode of the token cache.
+// The cached token past its refresh point is served while it is refreshed in background, and the cache entry expires with the token.
+type grace struct {
+	refreshBefore time.Duration
+
+	// refreshing holds the cache keys being refreshed in background.
+	refreshing sync.Map
+}
+
+// newGrace returns the grace mode of the configuration, or nil if it is disabled.
+func newGrace(cfg config.Grace) (*grace, error) {
+	if !cfg.Enable {
+		return nil, nil
+	}
+
+	refreshBefore := defaultGraceRefreshBefore
+	if cfg.RefreshBefore != "" {
+		var err error
+		if refreshBefore, err = time.ParseDuration(cfg.RefreshBefore); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Grace.RefreshBefore: "+err.Error())
+		}
+		if refreshBefore <= 0 {
+			return nil, errors.Wrap(ErrInvalidSetting, "Grace.RefreshBefore must be positive")
+		}
+	}
+	return &grace{
+		refreshBefore: refreshBefore,
+	}, nil
+}
+
+// stale returns whether the token expiring at exp is past its refresh point. It always returns false if the grace mode is disabled.
+func (g *grace) stale(exp time.Time) bool {
+	return g != nil && !fastime.Now().Before(exp.Add(-g.refreshBefore))
+}
+
+// revalidate runs refresh in background, unless the token of the key is already being refreshed.
+func (g *grace) revalidate(key string, refresh func(ctx context.Context) error) {
+	if _, loaded := g.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer g.refreshing.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), graceRefreshTimeout)
+		defer cancel()
+		if err := refresh(ctx); err != nil {
+			glg.Warnf("failed to refresh the stale token in background, key: %s, error: %v", key, err)
+		}
+	}()
+}
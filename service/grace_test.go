@@ -0,0 +1,165 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+	"github.com/pkg/errors"
+)
+
+func Test_newGrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Grace
+		want    *grace
+		wantErr string
+	}{
+		{
+			name: "Check disabled",
+			cfg: config.Grace{
+				RefreshBefore: "5m",
+			},
+			want: nil,
+		},
+		{
+			name: "Check default refresh point",
+			cfg: config.Grace{
+				Enable: true,
+			},
+			want: &grace{
+				refreshBefore: defaultGraceRefreshBefore,
+			},
+		},
+		{
+			name: "Check refresh point",
+			cfg: config.Grace{
+				Enable:        true,
+				RefreshBefore: "5m",
+			},
+			want: &grace{
+				refreshBefore: time.Minute * 5,
+			},
+		},
+		{
+			name: "Check invalid refresh point",
+			cfg: config.Grace{
+				Enable:        true,
+				RefreshBefore: "dummy",
+			},
+			wantErr: `Grace.RefreshBefore: time: invalid duration "dummy": Invalid config`,
+		},
+		{
+			name: "Check non-positive refresh point",
+			cfg: config.Grace{
+				Enable:        true,
+				RefreshBefore: "0s",
+			},
+			wantErr: "Grace.RefreshBefore must be positive: Invalid config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newGrace(tt.cfg)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("newGrace() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newGrace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_grace_stale(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace *grace
+		exp   time.Time
+		want  bool
+	}{
+		{
+			name: "Check before the refresh point",
+			grace: &grace{
+				refreshBefore: time.Minute,
+			},
+			exp:  fastime.Now().Add(time.Minute * 2),
+			want: false,
+		},
+		{
+			name: "Check after the refresh point",
+			grace: &grace{
+				refreshBefore: time.Minute,
+			},
+			exp:  fastime.Now().Add(time.Second * 30),
+			want: true,
+		},
+		{
+			name: "Check disabled",
+			exp:  fastime.Now().Add(time.Second * 30),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.grace.stale(tt.exp); got != tt.want {
+				t.Errorf("grace.stale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_grace_revalidate(t *testing.T) {
+	g := &grace{
+		refreshBefore: time.Minute,
+	}
+
+	var calls int32
+	release := make(chan struct{})
+	refresh := func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("grace.revalidate() context has no deadline")
+		}
+		<-release
+		return errors.New("dummy error")
+	}
+
+	// the refresh of the same key is run only once at a time
+	for i := 0; i < 10; i++ {
+		g.revalidate("dummyKey", refresh)
+	}
+	g.revalidate("otherKey", refresh)
+	time.Sleep(time.Millisecond * 50)
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("grace.revalidate() refresh count = %v, want %v", got, 2)
+	}
+
+	close(release)
+	time.Sleep(time.Millisecond * 50)
+	g.revalidate("dummyKey", refresh)
+	time.Sleep(time.Millisecond * 50)
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("grace.revalidate() refresh count after finished = %v, want %v", got, 3)
+	}
+}
@@ -0,0 +1,373 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+)
+
+// IDTokenService represents an interface to automatically refresh the OIDC ID token, and an ID token provider function pointer.
+type IDTokenService interface {
+	StartIDTokenUpdater(context.Context) <-chan error
+	RefreshIDTokenCache(ctx context.Context) <-chan error
+	GetIDTokenProvider() IDTokenProvider
+	Readiness() Readiness
+}
+
+// idTokenService represents the implementation of Athenz IDTokenService
+type idTokenService struct {
+	cfg                   config.IDToken
+	token                 ntokend.TokenProvider
+	athenzURL             string
+	athenzPrincipleHeader string
+	tokens                *credentialCache
+	expiry                time.Duration
+	redirectURI           string
+	httpClient            atomic.Value
+	rootCAs               *x509.CertPool
+	certPath              string
+	certKeyPath           string
+}
+
+// idTokenCacheData represents the cached ID token, and the request fetching it.
+type idTokenCacheData struct {
+	token       *IDTokenResponse
+	audience    string
+	domain      string
+	role        string
+	redirectURI string
+	expiry      int64
+}
+
+// IDTokenResponse represents the OIDC ID token returned by the Athenz server.
+type IDTokenResponse struct {
+	// IDToken represents the signed JWT of the ID token.
+	IDToken string `json:"id_token"`
+
+	// TokenType represents the type of the token.
+	TokenType string `json:"token_type"`
+
+	// ExpirationTime represents the expiration time of the ID token in Unix time.
+	ExpirationTime int64 `json:"expiration_time"`
+}
+
+// IDTokenProvider represents a function pointer to get the ID token.
+// The audience is the Athenz service name of the relying party, and the roles of the domain are included in the ID token as the groups claim.
+type IDTokenProvider func(ctx context.Context, audience, domain, role, redirectURI string, expiry int64) (*IDTokenResponse, error)
+
+// ErrIDTokenRequestFailed represents an error when failed to fetch the ID token from IDTokenProvider.
+var ErrIDTokenRequestFailed = errors.New("Failed to fetch ID token")
+
+const (
+	// idTokenResponseType represents the OIDC response type of the ID token requests.
+	idTokenResponseType = "id_token"
+
+	// idTokenNonceSize represents the number of the random bytes of the nonce of the ID token requests.
+	idTokenNonceSize = 16
+)
+
+// NewIDTokenService returns an IDTokenService to update and get the ID token from Athenz.
+func NewIDTokenService(cfg config.IDToken, token ntokend.TokenProvider) (IDTokenService, error) {
+	var (
+		err           error
+		exp           = defaultExpiry
+		refreshPeriod = defaultRefreshPeriod
+	)
+
+	if !cfg.Enable {
+		return nil, ErrDisabled
+	}
+
+	if cfg.Expiry != "" {
+		if exp, err = time.ParseDuration(cfg.Expiry); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Expiry: "+err.Error())
+		}
+	}
+	if cfg.RefreshPeriod != "" {
+		if refreshPeriod, err = time.ParseDuration(cfg.RefreshPeriod); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshPeriod: "+err.Error())
+		}
+	}
+
+	// if user set the expiry time and refresh period > expiry time then return error
+	if exp != 0 && refreshPeriod > exp {
+		return nil, errors.Wrap(ErrInvalidSetting, "refresh period > token expiry time")
+	}
+
+	i := &idTokenService{
+		cfg:                   cfg,
+		token:                 token,
+		athenzURL:             cfg.AthenzURL,
+		athenzPrincipleHeader: cfg.PrincipalAuthHeader,
+		expiry:                exp,
+		redirectURI:           cfg.RedirectURI,
+	}
+	if i.tokens, err = newCredentialCache("ID token", metrics.IDToken, refreshPeriod, cfg.Retry, cfg.RefreshPool, i.fetchIDToken); err != nil {
+		return nil, err
+	}
+
+	if token == nil && cfg.CertPath == "" {
+		return nil, errors.Wrap(ErrInvalidSetting, "Neither NToken nor client certificate is set.")
+	}
+
+	var cp *x509.CertPool
+	if cfg.AthenzCAPath != "" {
+		var err error
+		caPath := config.GetActualValue(cfg.AthenzCAPath)
+		_, err = os.Stat(caPath)
+		if os.IsNotExist(err) {
+			return nil, errors.Wrap(ErrInvalidSetting, "Athenz CA not exist")
+		}
+		cp, err = NewX509CertPool(caPath)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, err.Error())
+		}
+	}
+
+	certPath := cfg.CertPath
+	certKeyPath := cfg.CertKeyPath
+	// prevent using client certificate (ntoken has priority)
+	if token != nil {
+		certPath = ""
+		certKeyPath = ""
+	}
+
+	tlsConfig, err := NewTLSClientConfig(cp, certPath, certKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, err.Error())
+	}
+
+	i.httpClient.Store(&http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	})
+	i.rootCAs = cp
+	i.certPath = certPath
+	i.certKeyPath = certKeyPath
+	return i, nil
+}
+
+// StartIDTokenUpdater returns IDTokenService.
+// This function will periodically refresh the ID token.
+func (i *idTokenService) StartIDTokenUpdater(ctx context.Context) <-chan error {
+	return i.tokens.start(ctx)
+}
+
+// InheritIDTokenCache copies the ID tokens cached in src, which are not expired yet, to dst.
+// It returns the number of ID tokens copied. Nothing is copied if either service is not created by NewIDTokenService.
+func InheritIDTokenCache(ctx context.Context, dst, src IDTokenService) int {
+	d, ok := dst.(*idTokenService)
+	if !ok {
+		return 0
+	}
+	s, ok := src.(*idTokenService)
+	if !ok {
+		return 0
+	}
+	return d.tokens.inherit(ctx, s.tokens)
+}
+
+// GetIDTokenProvider returns a function pointer to get the ID token.
+func (i *idTokenService) GetIDTokenProvider() IDTokenProvider {
+	return i.getIDToken
+}
+
+// Readiness returns the readiness of the ID token service based on the result of the last cache refresh.
+func (i *idTokenService) Readiness() Readiness {
+	return i.tokens.readiness()
+}
+
+// getIDToken returns IDTokenResponse struct or error.
+// This function will return the ID token stored inside the cache, or fetch the ID token from Athenz when corresponding ID token cannot be found in the cache.
+func (i *idTokenService) getIDToken(ctx context.Context, audience, domain, role, redirectURI string, expiry int64) (*IDTokenResponse, error) {
+	if redirectURI == "" {
+		redirectURI = i.redirectURI
+	}
+	cd, err := i.tokens.get(ctx, &idTokenCacheData{
+		audience:    audience,
+		domain:      domain,
+		role:        role,
+		redirectURI: redirectURI,
+		expiry:      expiry,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cd.(*idTokenCacheData).token, nil
+}
+
+// RefreshIDTokenCache returns the error channel when it is updated.
+func (i *idTokenService) RefreshIDTokenCache(ctx context.Context) <-chan error {
+	return i.tokens.refresh(ctx)
+}
+
+// fetchIDToken fetch the ID token of the request from Athenz server, and return the cache entry of the decoded ID token, the duration to cache it, and any error if occurred.
+// P.S. Do not call fetchIDToken() outside singleflight group, as behavior of concurrent request is not tested
+func (i *idTokenService) fetchIDToken(ctx context.Context, r credentialRequest) (credentialRequest, time.Duration, error) {
+	cd := r.(*idTokenCacheData)
+	glg.Debugf("get ID token, %s, expiry: %d", cd, cd.expiry)
+
+	// prepare request object
+	req, err := i.createGetIDTokenRequest(cd.audience, cd.domain, cd.role, cd.redirectURI, cd.expiry)
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, 0, err
+	}
+	glg.Debugf("request url: %v", req.URL)
+
+	// prepare Athenz credentials
+	if i.token != nil {
+		token, err := i.token()
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set(i.athenzPrincipleHeader, token)
+	} else if i.certPath != "" {
+		// prepare TLS config (certificate file may refresh)
+		tcc, err := NewTLSClientConfig(i.rootCAs, i.certPath, i.certKeyPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		i.httpClient.Store(&http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tcc,
+			},
+		})
+	} else {
+		return nil, 0, ErrNoCredentials
+	}
+
+	// send request
+	start := time.Now()
+	res, err := i.httpClient.Load().(*http.Client).Do(req.WithContext(ctx))
+	if err != nil {
+		metrics.ObserveAthenzRequest(metrics.IDToken, metrics.StatusError, start)
+		return nil, 0, err
+	}
+	metrics.ObserveAthenzRequest(metrics.IDToken, metrics.StatusCode(res.StatusCode), start)
+
+	defer flushAndClose(res.Body)
+	if res.StatusCode != http.StatusOK {
+		uerr := newUpstreamError(ErrIDTokenRequestFailed, res)
+		glg.Debugf("error return from server, response:%+v, message: %v", res, uerr.Message)
+		return nil, 0, uerr
+	}
+
+	var data *IDTokenResponse
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, 0, err
+	}
+
+	// the ID token is cached until a minute before it expires
+	return &idTokenCacheData{
+		token:       data,
+		audience:    cd.audience,
+		domain:      cd.domain,
+		role:        cd.role,
+		redirectURI: cd.redirectURI,
+		expiry:      cd.expiry,
+	}, time.Unix(data.ExpirationTime, 0).Sub(fastime.Now().Add(time.Minute)), nil
+}
+
+// createGetIDTokenRequest returns the request to the OIDC authorization endpoint of the Athenz server, which responds the ID token in JSON.
+func (i *idTokenService) createGetIDTokenRequest(audience, domain, role, redirectURI string, expiry int64) (*http.Request, error) {
+	u := fmt.Sprintf("https://%s/oauth2/auth", strings.TrimPrefix(strings.TrimPrefix(i.athenzURL, "https://"), "http://"))
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+
+	nonce := make([]byte, idTokenNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// create URL query
+	q := req.URL.Query()
+	q.Add("response_type", idTokenResponseType)
+	q.Add("client_id", audience)
+	q.Add("redirect_uri", redirectURI)
+	q.Add("scope", idTokenScope(domain, role))
+	q.Add("nonce", hex.EncodeToString(nonce))
+	q.Add("output", "json")
+
+	exp := int64(i.expiry / time.Second)
+	if expiry > 0 {
+		exp = expiry
+	}
+	if exp > 0 {
+		q.Add("expiryTime", strconv.FormatInt(exp, 10))
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	return req, nil
+}
+
+// idTokenScope returns the OIDC scope requesting the roles of the domain. Empty role implies all the roles in the domain.
+func idTokenScope(domain, role string) string {
+	scope := []string{"openid"}
+	if domain == "" {
+		return scope[0]
+	}
+	if role == "" {
+		return strings.Join(append(scope, "roles", domain+":domain"), " ")
+	}
+	for _, r := range strings.Split(role, roleSeparator) {
+		scope = append(scope, domain+":role."+r)
+	}
+	return strings.Join(scope, " ")
+}
+
+// cacheKey returns the cache key of the ID token.
+func (cd *idTokenCacheData) cacheKey() string {
+	return encodeIDTokenKey(cd.audience, cd.domain, cd.role, cd.redirectURI, cd.expiry)
+}
+
+// String returns the request of the ID token in the error messages.
+func (cd *idTokenCacheData) String() string {
+	return fmt.Sprintf("audience: %s, domain: %s, role: %s", cd.audience, cd.domain, cd.role)
+}
+
+// encodeIDTokenKey returns the cache key of the ID token. The role names are sorted.
+func encodeIDTokenKey(audience, domain, role, redirectURI string, expiry int64) string {
+	roles := strings.Split(role, roleSeparator)
+	sort.Strings(roles)
+	return strings.Join([]string{audience, domain, strings.Join(roles, roleSeparator), redirectURI, strconv.FormatInt(expiry, 10)}, cacheKeySeparator)
+}
@@ -0,0 +1,313 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/pkg/errors"
+)
+
+func TestNewIDTokenService(t *testing.T) {
+	token := func() (string, error) {
+		return "dummyNToken", nil
+	}
+	tests := []struct {
+		name    string
+		cfg     config.IDToken
+		wantErr error
+	}{
+		{
+			name:    "Check disabled",
+			cfg:     config.IDToken{},
+			wantErr: ErrDisabled,
+		},
+		{
+			name: "Check invalid expiry",
+			cfg: config.IDToken{
+				Enable: true,
+				Expiry: "invalid",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `Expiry: time: invalid duration "invalid"`),
+		},
+		{
+			name: "Check refresh period > expiry",
+			cfg: config.IDToken{
+				Enable:        true,
+				Expiry:        "10m",
+				RefreshPeriod: "1h",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "refresh period > token expiry time"),
+		},
+		{
+			name: "Check invalid retry attempts",
+			cfg: config.IDToken{
+				Enable: true,
+				Retry: config.Retry{
+					Attempts: -1,
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0"),
+		},
+		{
+			name: "Check success",
+			cfg: config.IDToken{
+				Enable:        true,
+				AthenzURL:     "athenz.io/zts/v1",
+				Expiry:        "1h",
+				RefreshPeriod: "30m",
+				RedirectURI:   "https://redirect.athenz.io",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewIDTokenService(tt.cfg, token)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("NewIDTokenService() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			i := got.(*idTokenService)
+			if i.expiry != time.Hour || i.tokens.refreshPeriod != time.Minute*30 || i.redirectURI != tt.cfg.RedirectURI {
+				t.Errorf("NewIDTokenService() = %+v", i)
+			}
+		})
+	}
+}
+
+// newTestIDTokenService returns the ID token service requesting to the server, which responds the query of the request as the ID token.
+func newTestIDTokenService(t *testing.T, h http.HandlerFunc) (*idTokenService, *int64) {
+	var cnt int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&cnt, 1)
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	svc, err := NewIDTokenService(config.IDToken{
+		Enable:              true,
+		AthenzURL:           srv.URL,
+		PrincipalAuthHeader: "Athenz-Principal",
+		RedirectURI:         "https://default.redirect",
+		Retry: config.Retry{
+			Attempts: 1,
+			Delay:    "1ms",
+		},
+	}, func() (string, error) {
+		return "dummyNToken", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := svc.(*idTokenService)
+	i.httpClient.Store(srv.Client())
+	return i, &cnt
+}
+
+func Test_idTokenService_getIDToken(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	i, cnt := newTestIDTokenService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/auth" || r.Header.Get("Athenz-Principal") != "dummyNToken" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("client_id") == "error" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":400,"message":"invalid client"}`)
+			return
+		}
+		if q.Get("nonce") == "" || q.Get("output") != "json" || q.Get("response_type") != "id_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		q.Del("nonce")
+		fmt.Fprintf(w, `{"id_token":%q,"token_type":"urn:ietf:params:oauth:token-type:id_token","expiration_time":%d}`, q.Encode(), exp)
+	})
+
+	tests := []struct {
+		name        string
+		audience    string
+		domain      string
+		role        string
+		redirectURI string
+		expiry      int64
+		want        url.Values
+		wantErr     bool
+		wantCount   int64
+	}{
+		{
+			name:     "Check ID token with the roles",
+			audience: "client.service",
+			domain:   "domain",
+			role:     "reader,writer",
+			expiry:   600,
+			want: url.Values{
+				"client_id":     {"client.service"},
+				"redirect_uri":  {"https://default.redirect"},
+				"scope":         {"openid domain:role.reader domain:role.writer"},
+				"output":        {"json"},
+				"response_type": {"id_token"},
+				"expiryTime":    {"600"},
+			},
+			wantCount: 1,
+		},
+		{
+			name:     "Check cached ID token, role order ignored",
+			audience: "client.service",
+			domain:   "domain",
+			role:     "writer,reader",
+			expiry:   600,
+			want: url.Values{
+				"client_id":     {"client.service"},
+				"redirect_uri":  {"https://default.redirect"},
+				"scope":         {"openid domain:role.reader domain:role.writer"},
+				"output":        {"json"},
+				"response_type": {"id_token"},
+				"expiryTime":    {"600"},
+			},
+			wantCount: 1,
+		},
+		{
+			name:        "Check ID token with redirect URI",
+			audience:    "client.service",
+			redirectURI: "https://client.redirect",
+			want: url.Values{
+				"client_id":     {"client.service"},
+				"redirect_uri":  {"https://client.redirect"},
+				"scope":         {"openid"},
+				"output":        {"json"},
+				"response_type": {"id_token"},
+			},
+			wantCount: 2,
+		},
+		{
+			name:      "Check error",
+			audience:  "error",
+			wantErr:   true,
+			wantCount: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.GetIDTokenProvider()(context.Background(), tt.audience, tt.domain, tt.role, tt.redirectURI, tt.expiry)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getIDToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if c := atomic.LoadInt64(cnt); c != tt.wantCount {
+				t.Errorf("getIDToken() request count = %v, want %v", c, tt.wantCount)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrIDTokenRequestFailed) {
+					t.Errorf("getIDToken() error = %v, want %v", err, ErrIDTokenRequestFailed)
+				}
+				return
+			}
+			if got.IDToken != tt.want.Encode() || got.ExpirationTime != exp {
+				t.Errorf("getIDToken() = %+v, want query %v", got, tt.want.Encode())
+			}
+		})
+	}
+}
+
+func Test_idTokenService_RefreshIDTokenCache(t *testing.T) {
+	var fail int32
+	i, cnt := newTestIDTokenService(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"id_token":"token","expiration_time":%d}`, time.Now().Add(time.Hour).Unix())
+	})
+	for _, aud := range []string{"a.service", "b.service"} {
+		if _, err := i.getIDToken(context.Background(), aud, "", "", "", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for err := range i.RefreshIDTokenCache(context.Background()) {
+		t.Errorf("RefreshIDTokenCache() error = %v", err)
+	}
+	if c := atomic.LoadInt64(cnt); c != 4 {
+		t.Errorf("RefreshIDTokenCache() request count = %v, want 4", c)
+	}
+	if rd := i.Readiness(); !rd.Ready {
+		t.Errorf("Readiness() = %+v, want ready", rd)
+	}
+
+	// all the tokens failed after retry
+	atomic.StoreInt32(&fail, 1)
+	var errs int
+	for range i.RefreshIDTokenCache(context.Background()) {
+		errs++
+	}
+	if errs != 4 {
+		t.Errorf("RefreshIDTokenCache() errors = %v, want 4", errs)
+	}
+	if rd := i.Readiness(); rd.Ready {
+		t.Errorf("Readiness() = %+v, want not ready", rd)
+	}
+}
+
+func TestInheritIDTokenCache(t *testing.T) {
+	src, _ := newTestIDTokenService(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id_token":"token","expiration_time":%d}`, time.Now().Add(time.Hour).Unix())
+	})
+	if _, err := src.getIDToken(context.Background(), "a.service", "", "", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	dst, cnt := newTestIDTokenService(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	if n := InheritIDTokenCache(context.Background(), dst, src); n != 1 {
+		t.Errorf("InheritIDTokenCache() = %v, want 1", n)
+	}
+	if _, err := dst.getIDToken(context.Background(), "a.service", "", "", "", 0); err != nil || atomic.LoadInt64(cnt) != 0 {
+		t.Errorf("getIDToken() error = %v, request count = %v, want the inherited token", err, atomic.LoadInt64(cnt))
+	}
+}
+
+func Test_idTokenScope(t *testing.T) {
+	tests := []struct {
+		domain string
+		role   string
+		want   string
+	}{
+		{"", "", "openid"},
+		{"", "reader", "openid"},
+		{"domain", "", "openid roles domain:domain"},
+		{"domain", "reader", "openid domain:role.reader"},
+		{"domain", "reader,writer", "openid domain:role.reader domain:role.writer"},
+	}
+	for _, tt := range tests {
+		if got := idTokenScope(tt.domain, tt.role); got != tt.want {
+			t.Errorf("idTokenScope(%q, %q) = %q, want %q", tt.domain, tt.role, got, tt.want)
+		}
+	}
+}
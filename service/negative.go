@@ -0,0 +1,122 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/gache"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultNegativeCacheTTL represents the default duration to cache the client errors.
+	defaultNegativeCacheTTL = time.Second * 10
+)
+
+// negativeCache caches the client errors returned by the Athenz server for a short time,
+// so that the requests which will never succeed, e.g. for the roles the principal is not a member of, do not reach the Athenz server every time.
+type negativeCache struct {
+	ttl   time.Duration
+	cache gache.Gache
+}
+
+// newNegativeCache returns the negative cache of the configuration, or nil if it is disabled.
+func newNegativeCache(cfg config.NegativeCache) (*negativeCache, error) {
+	if !cfg.Enable {
+		return nil, nil
+	}
+
+	ttl := defaultNegativeCacheTTL
+	if cfg.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(cfg.TTL); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "NegativeCache.TTL: "+err.Error())
+		}
+		if ttl <= 0 {
+			return nil, errors.Wrap(ErrInvalidSetting, "NegativeCache.TTL must be positive")
+		}
+	}
+	return &negativeCache{
+		ttl:   ttl,
+		cache: gache.New(),
+	}, nil
+}
+
+// get returns the cached error of the key, or nil if it is not cached.
+func (n *negativeCache) get(key string) error {
+	if n == nil {
+		return nil
+	}
+	val, ok := n.cache.Get(key)
+	if !ok {
+		return nil
+	}
+	return val.(error)
+}
+
+// set caches the error of the key if it is a client error, for the duration returned by errorTTL.
+func (n *negativeCache) set(key string, err error) {
+	if n == nil {
+		return
+	}
+	if ttl := n.errorTTL(err); ttl > 0 {
+		n.cache.SetWithExpire(key, err, ttl)
+	}
+}
+
+// errorTTL returns the duration to cache the error, or 0 if the error is not cached.
+// 429 is cached only for the duration requested by the Retry-After header, so that the requests reach the Athenz server again as soon as it allows.
+func (n *negativeCache) errorTTL(err error) time.Duration {
+	var se interface{ StatusCode() int }
+	if errors.As(err, &se) && se.StatusCode() == http.StatusTooManyRequests {
+		return retryAfter(err)
+	}
+	if negativeCacheable(err) {
+		return n.ttl
+	}
+	return 0
+}
+
+// delete removes the cached error of the key.
+func (n *negativeCache) delete(key string) {
+	if n == nil {
+		return
+	}
+	n.cache.Delete(key)
+}
+
+// startExpired starts removing the expired errors periodically until ctx is done.
+func (n *negativeCache) startExpired(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	n.cache.StartExpired(ctx, cachePurgePeriod)
+}
+
+// negativeCacheable returns whether the error is a client error returned by the Athenz server except 408 and 429, which may succeed by requesting again.
+func negativeCacheable(err error) bool {
+	var se interface{ StatusCode() int }
+	if !errors.As(err, &se) {
+		return false
+	}
+	code := se.StatusCode()
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
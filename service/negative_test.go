@@ -0,0 +1,217 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/pkg/errors"
+)
+
+func Test_newNegativeCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.NegativeCache
+		wantTTL time.Duration
+		wantNil bool
+		wantErr string
+	}{
+		{
+			name: "Check disabled",
+			cfg: config.NegativeCache{
+				TTL: "1m",
+			},
+			wantNil: true,
+		},
+		{
+			name: "Check default TTL",
+			cfg: config.NegativeCache{
+				Enable: true,
+			},
+			wantTTL: defaultNegativeCacheTTL,
+		},
+		{
+			name: "Check TTL",
+			cfg: config.NegativeCache{
+				Enable: true,
+				TTL:    "1m",
+			},
+			wantTTL: time.Minute,
+		},
+		{
+			name: "Check invalid TTL",
+			cfg: config.NegativeCache{
+				Enable: true,
+				TTL:    "dummy",
+			},
+			wantErr: `NegativeCache.TTL: time: invalid duration "dummy": Invalid config`,
+		},
+		{
+			name: "Check non-positive TTL",
+			cfg: config.NegativeCache{
+				Enable: true,
+				TTL:    "0s",
+			},
+			wantErr: "NegativeCache.TTL must be positive: Invalid config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newNegativeCache(tt.cfg)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("newNegativeCache() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("newNegativeCache() = %v, want nil: %v", got, tt.wantNil)
+				return
+			}
+			if got != nil && got.ttl != tt.wantTTL {
+				t.Errorf("newNegativeCache() ttl = %v, want %v", got.ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func Test_negativeCache(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "Check client error is cached",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusForbidden},
+			want: true,
+		},
+		{
+			name: "Check wrapped client error is cached",
+			err:  errors.Wrap(&UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusNotFound}, "dummy"),
+			want: true,
+		},
+		{
+			name: "Check ZTS client error is cached",
+			err:  rdl.ResourceError{Code: http.StatusNotFound, Message: "not found"},
+			want: true,
+		},
+		{
+			name: "Check too many requests without Retry-After is not cached",
+			err:  rdl.ResourceError{Code: http.StatusTooManyRequests, Message: "too many requests"},
+			want: false,
+		},
+		{
+			name: "Check too many requests with Retry-After is cached",
+			err:  errors.Wrap(&UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusTooManyRequests, retryAfter: time.Minute}, "dummy"),
+			want: true,
+		},
+		{
+			name: "Check request timeout is not cached",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusRequestTimeout},
+			want: false,
+		},
+		{
+			name: "Check server error is not cached",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusServiceUnavailable},
+			want: false,
+		},
+		{
+			name: "Check transport error is not cached",
+			err:  errors.New("connection refused"),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, _ := newNegativeCache(config.NegativeCache{
+				Enable: true,
+			})
+			n.set("dummyKey", tt.err)
+
+			got := n.get("dummyKey")
+			if (got != nil) != tt.want {
+				t.Errorf("negativeCache.get() = %v, want cached: %v", got, tt.want)
+			}
+			if got != nil && !reflect.DeepEqual(got, tt.err) {
+				t.Errorf("negativeCache.get() = %v, want %v", got, tt.err)
+			}
+
+			n.delete("dummyKey")
+			if got := n.get("dummyKey"); got != nil {
+				t.Errorf("negativeCache.get() after delete = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func Test_negativeCache_errorTTL(t *testing.T) {
+	n := &negativeCache{
+		ttl: time.Second * 10,
+	}
+	tests := []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{
+			name: "Check client error is cached for TTL",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusForbidden},
+			want: time.Second * 10,
+		},
+		{
+			name: "Check too many requests is cached for Retry-After",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusTooManyRequests, retryAfter: time.Second * 3},
+			want: time.Second * 3,
+		},
+		{
+			name: "Check wrapped too many requests is cached for Retry-After",
+			err:  errors.Wrap(&UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusTooManyRequests, retryAfter: time.Minute}, "dummy"),
+			want: time.Minute,
+		},
+		{
+			name: "Check too many requests without Retry-After is not cached",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusTooManyRequests},
+			want: 0,
+		},
+		{
+			name: "Check server error is not cached",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusBadGateway},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := n.errorTTL(tt.err); got != tt.want {
+				t.Errorf("negativeCache.errorTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_negativeCache_disabled(t *testing.T) {
+	var n *negativeCache
+	n.set("dummyKey", &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusForbidden})
+	if got := n.get("dummyKey"); got != nil {
+		t.Errorf("negativeCache.get() = %v, want nil", got)
+	}
+}
@@ -38,3 +38,10 @@ func WithServerHandler(h http.Handler) Option {
 		s.srvHandler = h
 	}
 }
+
+// WithReadinessChecker set the readiness checker of the readiness endpoint to server.
+func WithReadinessChecker(c ReadinessChecker) Option {
+	return func(s *server) {
+		s.readiness = c
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/kpango/ntokend"
+)
+
+const (
+	// ApplicationJSON represents a HTTP content type "application/json"
+	ApplicationJSON = "application/json"
+
+	// defaultReadinessEndpoint represents the default readiness endpoint of the health check server
+	defaultReadinessEndpoint = "/readyz"
+)
+
+// Readiness represents whether a subsystem can provide its credential.
+type Readiness struct {
+	// Ready represents whether the credential is available.
+	Ready bool `json:"ready"`
+
+	// Message represents the reason when the subsystem is not ready, or the detail of the last failure.
+	Message string `json:"message,omitempty"`
+
+	// Expiry represents the expiry of the cached credential in RFC3339 format.
+	Expiry string `json:"expiry,omitempty"`
+
+	// LastRefresh represents the time when the last cache refresh finished in RFC3339 format.
+	LastRefresh string `json:"lastRefresh,omitempty"`
+}
+
+// ReadinessChecker represents a function to get the readiness of each enabled subsystem, keyed by the subsystem name.
+type ReadinessChecker func() map[string]Readiness
+
+// readinessResponse represents the response body of the readiness endpoint.
+type readinessResponse struct {
+	Ready      bool                 `json:"ready"`
+	Subsystems map[string]Readiness `json:"subsystems"`
+}
+
+// refreshResult represents the result of a cache refresh of the token updater.
+type refreshResult struct {
+	// time is the time when the refresh finished.
+	time time.Time
+	// total is the number of the tokens refreshed.
+	total int
+	// failed is the number of the tokens failed to refresh after retry.
+	failed int
+	// err is the last error of the failed tokens.
+	err error
+}
+
+// refreshRecorder records the result of the last cache refresh. The zero value is ready to use.
+type refreshRecorder struct {
+	result atomic.Value
+
+	// lazy represents that the cache is filled only by the requests, so that there is nothing to wait for before the first refresh.
+	lazy bool
+}
+
+// refreshCounter counts the tokens failed to refresh in a cache refresh. It is safe for concurrent use.
+type refreshCounter struct {
+	mu     sync.Mutex
+	total  int
+	failed int
+	err    error
+}
+
+// NTokenReadiness returns the readiness of the N-token provided by the token service.
+func NTokenReadiness(token ntokend.TokenService) Readiness {
+	if !token.TokenExists() {
+		return Readiness{
+			Message: "N-token is not generated",
+		}
+	}
+	return Readiness{
+		Ready: true,
+	}
+}
+
+// add counts a token refresh. The refresh is regarded as failed if all the maxRetry+1 attempts returned errors,
+// or the retry is stopped by the error not retryable.
+func (c *refreshCounter) add(errs []error, maxRetry int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total++
+	if len(errs) > maxRetry || (len(errs) > 0 && !retryable(errs[len(errs)-1])) {
+		c.failed++
+		c.err = errs[len(errs)-1]
+	}
+}
+
+// store records the counted result as the last refresh result.
+func (c *refreshCounter) store(r *refreshRecorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.result.Store(refreshResult{
+		time:   fastime.Now(),
+		total:  c.total,
+		failed: c.failed,
+		err:    c.err,
+	})
+}
+
+// readiness returns the readiness based on the last refresh result.
+// It is not ready until the first refresh or prefetch finishes, unless the recorder is lazy, and while any token failed to refresh in the last refresh.
+func (r *refreshRecorder) readiness() Readiness {
+	res, ok := r.result.Load().(refreshResult)
+	if !ok {
+		if r.lazy {
+			return Readiness{
+				Ready: true,
+			}
+		}
+		return Readiness{
+			Message: "cache is not refreshed yet",
+		}
+	}
+
+	rd := Readiness{
+		Ready:       res.failed == 0,
+		LastRefresh: res.time.Format(time.RFC3339),
+	}
+	if res.failed > 0 {
+		rd.Message = fmt.Sprintf("%d of %d tokens failed to refresh: %s", res.failed, res.total, res.err.Error())
+	}
+	return rd
+}
+
+func handleReadinessRequest(check ReadinessChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			return
+		}
+
+		res := readinessResponse{
+			Ready:      true,
+			Subsystems: map[string]Readiness{},
+		}
+		if check != nil {
+			res.Subsystems = check()
+		}
+		for _, s := range res.Subsystems {
+			if !s.Ready {
+				res.Ready = false
+			}
+		}
+
+		w.Header().Set(ContentType, fmt.Sprintf("%s;%s", ApplicationJSON, CharsetUTF8))
+		if res.Ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			glg.Error(err)
+		}
+	}
+}
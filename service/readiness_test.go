@@ -0,0 +1,266 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+)
+
+type tokenServiceMock struct {
+	exists bool
+}
+
+func (t *tokenServiceMock) StartTokenUpdater(context.Context) ntokend.TokenService {
+	return t
+}
+
+func (t *tokenServiceMock) Update() error {
+	return nil
+}
+
+func (t *tokenServiceMock) TokenExists() bool {
+	return t.exists
+}
+
+func (t *tokenServiceMock) GetTokenProvider() ntokend.TokenProvider {
+	return nil
+}
+
+func TestNTokenReadiness(t *testing.T) {
+	type test struct {
+		name  string
+		token ntokend.TokenService
+		want  Readiness
+	}
+	tests := []test{
+		{
+			name: "N-token exists",
+			token: &tokenServiceMock{
+				exists: true,
+			},
+			want: Readiness{
+				Ready: true,
+			},
+		},
+		{
+			name: "N-token does not exist",
+			token: &tokenServiceMock{
+				exists: false,
+			},
+			want: Readiness{
+				Message: "N-token is not generated",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NTokenReadiness(tt.token); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NTokenReadiness() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_refreshRecorder_readiness(t *testing.T) {
+	type test struct {
+		name      string
+		lazy      bool
+		counts    [][]error
+		checkFunc func(Readiness) error
+	}
+	dummyErr := errors.New("dummy")
+	tests := []test{
+		{
+			name: "not ready before the first refresh",
+			checkFunc: func(got Readiness) error {
+				if got.Ready || got.LastRefresh != "" || got.Message != "cache is not refreshed yet" {
+					return fmt.Errorf("got: %+v", got)
+				}
+				return nil
+			},
+		},
+		{
+			name: "lazy recorder is ready before the first refresh",
+			lazy: true,
+			checkFunc: func(got Readiness) error {
+				if !got.Ready || got.LastRefresh != "" {
+					return fmt.Errorf("got: %+v", got)
+				}
+				return nil
+			},
+		},
+		{
+			name: "ready when all the tokens are refreshed",
+			counts: [][]error{
+				nil,
+				{dummyErr},
+			},
+			checkFunc: func(got Readiness) error {
+				if !got.Ready || got.LastRefresh == "" || got.Message != "" {
+					return fmt.Errorf("got: %+v", got)
+				}
+				return nil
+			},
+		},
+		{
+			name: "not ready when some tokens are failed to refresh",
+			counts: [][]error{
+				nil,
+				{dummyErr, dummyErr},
+			},
+			checkFunc: func(got Readiness) error {
+				if got.Ready || got.Message != "1 of 2 tokens failed to refresh: dummy" {
+					return fmt.Errorf("got: %+v", got)
+				}
+				return nil
+			},
+		},
+		{
+			name: "not ready when all the tokens are failed to refresh",
+			counts: [][]error{
+				{dummyErr, dummyErr},
+			},
+			checkFunc: func(got Readiness) error {
+				if got.Ready || got.Message != "1 of 1 tokens failed to refresh: dummy" {
+					return fmt.Errorf("got: %+v", got)
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &refreshRecorder{
+				lazy: tt.lazy,
+			}
+			if tt.counts != nil {
+				cnt := new(refreshCounter)
+				for _, errs := range tt.counts {
+					cnt.add(errs, 1)
+				}
+				cnt.store(r)
+			}
+			if err := tt.checkFunc(r.readiness()); err != nil {
+				t.Errorf("refreshRecorder.readiness() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_handleReadinessRequest(t *testing.T) {
+	type test struct {
+		name     string
+		check    ReadinessChecker
+		method   string
+		wantCode int
+		wantBody readinessResponse
+	}
+	tests := []test{
+		{
+			name: "ready when all the subsystems are ready",
+			check: func() map[string]Readiness {
+				return map[string]Readiness{
+					"ntoken": {
+						Ready: true,
+					},
+					"svccert": {
+						Ready:  true,
+						Expiry: "2099-01-01T00:00:00Z",
+					},
+				}
+			},
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			wantBody: readinessResponse{
+				Ready: true,
+				Subsystems: map[string]Readiness{
+					"ntoken": {
+						Ready: true,
+					},
+					"svccert": {
+						Ready:  true,
+						Expiry: "2099-01-01T00:00:00Z",
+					},
+				},
+			},
+		},
+		{
+			name: "not ready when any subsystem is not ready",
+			check: func() map[string]Readiness {
+				return map[string]Readiness{
+					"ntoken": {
+						Ready: true,
+					},
+					"svccert": {
+						Message: "service certificate is not fetched",
+					},
+				}
+			},
+			method:   http.MethodGet,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: readinessResponse{
+				Ready: false,
+				Subsystems: map[string]Readiness{
+					"ntoken": {
+						Ready: true,
+					},
+					"svccert": {
+						Message: "service certificate is not fetched",
+					},
+				},
+			},
+		},
+		{
+			name:     "ready when no checker is set",
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			wantBody: readinessResponse{
+				Ready:      true,
+				Subsystems: map[string]Readiness{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			handleReadinessRequest(tt.check)(rw, httptest.NewRequest(tt.method, "/readyz", nil))
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("status code = %v, want %v", rw.Code, tt.wantCode)
+			}
+			if contentType := rw.Header().Get("Content-Type"); contentType != "application/json;charset=UTF-8" {
+				t.Errorf("content type is not correct, got: %v", contentType)
+			}
+			var got readinessResponse
+			if err := json.NewDecoder(rw.Body).Decode(&got); err != nil {
+				t.Errorf("failed to decode body: %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.wantBody) {
+				t.Errorf("body = %+v, want %+v", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+	"github.com/kpango/gache"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRefreshConcurrency represents the default maximum number of the tokens refreshed at the same time.
+	defaultRefreshConcurrency = 4
+
+	// defaultRefreshBurst represents the default maximum number of the requests sent at once within the rate limit.
+	defaultRefreshBurst = 1
+)
+
+// refreshPool bounds the number of the tokens refreshed at the same time, and the rate of the requests to the Athenz server by the refreshes,
+// so that a slow domain does not delay the refreshes of the other tokens, and the refreshes do not overload the Athenz server.
+// The nil pool refreshes the tokens one at a time without rate limit. It is safe for concurrent use.
+type refreshPool struct {
+	sem chan struct{}
+
+	// limiter limits the rate of the requests. nil implies unlimited.
+	limiter *tokenBucket
+}
+
+// tokenBucket limits the rate of the events by the token bucket algorithm. It is safe for concurrent use.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRefreshPool returns the refresh pool of the configuration.
+func newRefreshPool(cfg config.RefreshPool) (*refreshPool, error) {
+	concurrency := defaultRefreshConcurrency
+	if cfg.Concurrency > 0 {
+		concurrency = cfg.Concurrency
+	} else if cfg.Concurrency < 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "RefreshPool.Concurrency < 0")
+	}
+	if cfg.RateLimit < 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "RefreshPool.RateLimit < 0")
+	}
+	burst := defaultRefreshBurst
+	if cfg.Burst > 0 {
+		burst = cfg.Burst
+	} else if cfg.Burst < 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "RefreshPool.Burst < 0")
+	}
+
+	p := &refreshPool{
+		sem: make(chan struct{}, concurrency),
+	}
+	if cfg.RateLimit > 0 {
+		p.limiter = newTokenBucket(cfg.RateLimit, burst)
+	}
+	return p, nil
+}
+
+// each calls fn with each index from 0 to n-1 on the workers of the pool, and returns after all of them return.
+// The indexes not started yet are skipped when ctx is done.
+func (p *refreshPool) each(ctx context.Context, n int, fn func(i int)) {
+	if p == nil {
+		for i := 0; i < n && ctx.Err() == nil; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+	for i := 0; i < n; i++ {
+		if !p.acquire(ctx) {
+			return
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer p.release()
+			fn(i)
+		}(i)
+	}
+}
+
+// refreshTargets returns the values selected by fn from the cache entries, to be refreshed by each.
+// fn is called one entry at a time, because gache calls the Foreach callback concurrently on each shard; it returns false to skip the entry.
+func refreshTargets(ctx context.Context, c gache.Gache, fn func(key string, val interface{}) (interface{}, bool)) []interface{} {
+	var mu sync.Mutex
+	targets := make([]interface{}, 0, c.Len())
+	c.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if t, ok := fn(key, val); ok {
+			targets = append(targets, t)
+		}
+		return true
+	})
+	return targets
+}
+
+// acquire blocks until a worker of the pool is available, and returns false if ctx is done before that.
+func (p *refreshPool) acquire(ctx context.Context) bool {
+	if p == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case p.sem <- struct{}{}:
+		return true
+	}
+}
+
+// release returns the worker acquired by acquire to the pool.
+func (p *refreshPool) release() {
+	if p == nil {
+		return
+	}
+	<-p.sem
+}
+
+// wait blocks until a request to the Athenz server is allowed by the rate limit, and returns false if ctx is done before that.
+func (p *refreshPool) wait(ctx context.Context) bool {
+	if p == nil || p.limiter == nil {
+		return ctx.Err() == nil
+	}
+	return sleep(ctx, p.limiter.reserve())
+}
+
+// newTokenBucket returns the token bucket allowing rate events per second, and at most burst events at once. The bucket is full initially.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   fastime.Now(),
+	}
+}
+
+// reserve takes a token from the bucket, and returns the duration to wait until the token is available.
+// The following callers wait longer, so that the events are spread out at the rate.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := fastime.Now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,253 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/gache"
+)
+
+func Test_newRefreshPool(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             config.RefreshPool
+		wantErr         bool
+		wantConcurrency int
+		wantLimiter     bool
+		wantBurst       float64
+	}{
+		{
+			name:            "Check default",
+			wantConcurrency: defaultRefreshConcurrency,
+		},
+		{
+			name: "Check custom",
+			cfg: config.RefreshPool{
+				Concurrency: 8,
+				RateLimit:   10,
+				Burst:       5,
+			},
+			wantConcurrency: 8,
+			wantLimiter:     true,
+			wantBurst:       5,
+		},
+		{
+			name: "Check default burst",
+			cfg: config.RefreshPool{
+				RateLimit: 10,
+			},
+			wantConcurrency: defaultRefreshConcurrency,
+			wantLimiter:     true,
+			wantBurst:       defaultRefreshBurst,
+		},
+		{
+			name: "Check invalid concurrency",
+			cfg: config.RefreshPool{
+				Concurrency: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Check invalid rate limit",
+			cfg: config.RefreshPool{
+				RateLimit: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Check invalid burst",
+			cfg: config.RefreshPool{
+				Burst: -1,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newRefreshPool(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newRefreshPool() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if cap(got.sem) != tt.wantConcurrency {
+				t.Errorf("newRefreshPool() concurrency = %v, want %v", cap(got.sem), tt.wantConcurrency)
+			}
+			if (got.limiter != nil) != tt.wantLimiter {
+				t.Errorf("newRefreshPool() limiter = %v, want limiter: %v", got.limiter, tt.wantLimiter)
+				return
+			}
+			if got.limiter != nil && got.limiter.burst != tt.wantBurst {
+				t.Errorf("newRefreshPool() burst = %v, want %v", got.limiter.burst, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func Test_refreshPool_each(t *testing.T) {
+	p, err := newRefreshPool(config.RefreshPool{
+		Concurrency: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var running, maxRunning int64
+	done := make([]bool, 10)
+	mu := new(sync.Mutex)
+	p.each(context.Background(), len(done), func(i int) {
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+
+		mu.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		done[i] = true
+		mu.Unlock()
+		time.Sleep(time.Millisecond * 20)
+	})
+
+	for i, d := range done {
+		if !d {
+			t.Errorf("each() did not call %d", i)
+		}
+	}
+	if maxRunning != 3 {
+		t.Errorf("each() max concurrency = %v, want 3", maxRunning)
+	}
+}
+
+func Test_refreshPool_each_slow(t *testing.T) {
+	p, err := newRefreshPool(config.RefreshPool{
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a slow item does not block the others
+	release := make(chan struct{})
+	finished := make(chan int, 5)
+	go func() {
+		p.each(context.Background(), 5, func(i int) {
+			if i == 0 {
+				<-release
+			}
+			finished <- i
+		})
+		close(finished)
+	}()
+	for i := 0; i < 4; i++ {
+		select {
+		case got := <-finished:
+			if got == 0 {
+				t.Error("each() finished the slow item first")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("each() blocked by the slow item")
+		}
+	}
+	close(release)
+	for range finished {
+	}
+}
+
+func Test_refreshTargets(t *testing.T) {
+	c := gache.New()
+	for i := 0; i < 1000; i++ {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	// the odd values are skipped
+	got := refreshTargets(context.Background(), c, func(key string, val interface{}) (interface{}, bool) {
+		return val, val.(int)%2 == 0
+	})
+	if len(got) != 500 {
+		t.Fatalf("refreshTargets() returned %d targets, want 500", len(got))
+	}
+	seen := make(map[int]bool, len(got))
+	for _, v := range got {
+		if n := v.(int); n%2 != 0 || seen[n] {
+			t.Errorf("refreshTargets() returned unexpected target %d", n)
+		}
+		seen[v.(int)] = true
+	}
+}
+
+func Test_refreshPool_nil(t *testing.T) {
+	var p *refreshPool
+	var got []int
+	p.each(context.Background(), 3, func(i int) {
+		got = append(got, i)
+	})
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("each() called %v, want [0 1 2]", got)
+	}
+	if !p.acquire(context.Background()) {
+		t.Error("acquire() = false, want true")
+	}
+	p.release()
+	if !p.wait(context.Background()) {
+		t.Error("wait() = false, want true")
+	}
+}
+
+func Test_refreshPool_wait(t *testing.T) {
+	p, err := newRefreshPool(config.RefreshPool{
+		RateLimit: 20,
+		Burst:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if !p.wait(context.Background()) {
+			t.Fatal("wait() = false, want true")
+		}
+	}
+	// the burst is allowed at once, and the rest are spread out at 20 requests per second
+	if d := time.Since(start); d < time.Millisecond*80 || d > time.Millisecond*500 {
+		t.Errorf("wait() took %v, want about 100ms", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if p.wait(ctx) {
+		t.Error("wait() with canceled context = true, want false")
+	}
+}
+
+func Test_tokenBucket_reserve(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	for i, want := range []time.Duration{0, 0, time.Millisecond * 100, time.Millisecond * 200} {
+		got := b.reserve()
+		if diff := got - want; diff < -time.Millisecond*20 || diff > time.Millisecond*20 {
+			t.Errorf("reserve() #%d = %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultErrRetryMaxInterval represents the default maximum error retry interval.
+	defaultErrRetryMaxInterval = time.Minute
+
+	// defaultErrRetryMultiplier represents the default factor to increase the error retry interval after each retry.
+	defaultErrRetryMultiplier = 2.0
+
+	// defaultErrRetryJitter represents the default ratio of the random variation of the error retry interval.
+	defaultErrRetryJitter = 0.2
+)
+
+// backoff calculates the interval before each retry. The zero value retries with the constant interval.
+type backoff struct {
+	maxInterval time.Duration
+	multiplier  float64
+	jitter      float64
+}
+
+// newBackoff returns the backoff from the retry configuration. defaultMax is used if the maximum delay is not set.
+func newBackoff(cfg config.Retry, defaultMax time.Duration) (backoff, error) {
+	b := backoff{
+		maxInterval: defaultMax,
+		multiplier:  defaultErrRetryMultiplier,
+		jitter:      defaultErrRetryJitter,
+	}
+	if cfg.MaxDelay != "" {
+		var err error
+		if b.maxInterval, err = time.ParseDuration(cfg.MaxDelay); err != nil {
+			return backoff{}, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxInterval: "+err.Error())
+		}
+	}
+	if cfg.Multiplier != 0 {
+		if cfg.Multiplier < 1 {
+			return backoff{}, errors.Wrap(ErrInvalidSetting, "ErrRetryMultiplier < 1")
+		}
+		b.multiplier = cfg.Multiplier
+	}
+	if cfg.Jitter != 0 {
+		if cfg.Jitter < 0 || cfg.Jitter > 1 {
+			return backoff{}, errors.Wrap(ErrInvalidSetting, "ErrRetryJitter is not between 0 and 1")
+		}
+		b.jitter = cfg.Jitter
+	}
+	return b, nil
+}
+
+// interval returns the duration to wait before the retry after the given number of failed retries, starting from base.
+// The Retry-After of the error is honoured if it is longer, up to the maximum interval, or defaultErrRetryMaxInterval if it is not set,
+// so that a long Retry-After does not stall the refreshes past the token expiry.
+func (b backoff) interval(retries int, base time.Duration, err error) time.Duration {
+	d := float64(base)
+	if b.multiplier > 1 {
+		d *= math.Pow(b.multiplier, float64(retries))
+	}
+	if b.jitter > 0 {
+		d *= 1 + b.jitter*(2*rand.Float64()-1)
+	}
+	if b.maxInterval > 0 && d > float64(b.maxInterval) {
+		d = float64(b.maxInterval)
+	}
+
+	dur := time.Duration(d)
+	if ra := retryAfter(err); ra > dur {
+		limit := b.maxInterval
+		if limit <= 0 {
+			limit = defaultErrRetryMaxInterval
+		}
+		if ra > limit {
+			ra = limit
+		}
+		if ra > dur {
+			dur = ra
+		}
+	}
+	return dur
+}
+
+// retryable returns whether the request may succeed by retrying.
+// The client errors are not retryable, except request timeout and too many requests.
+func retryable(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	var se interface{ StatusCode() int }
+	if !errors.As(err, &se) {
+		return true
+	}
+	switch code := se.StatusCode(); {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	case code >= 400 && code < 500:
+		return false
+	}
+	return true
+}
+
+// retryAfter returns the duration requested by the Athenz server to wait before the retry, or 0 if it is not requested.
+func retryAfter(err error) time.Duration {
+	var ra interface{ RetryAfter() time.Duration }
+	if errors.As(err, &ra) {
+		return ra.RetryAfter()
+	}
+	return 0
+}
+
+// parseRetryAfter parses the Retry-After header value in either delay seconds or HTTP date.
+func parseRetryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		if sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		if d := t.Sub(fastime.Now()); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// sleep waits for the duration, and returns false if the context is done before that.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/kpango/fastime"
+	"github.com/pkg/errors"
+)
+
+func Test_newBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Retry
+		want    backoff
+		wantErr error
+	}{
+		{
+			name: "Check default",
+			want: backoff{
+				maxInterval: time.Minute,
+				multiplier:  defaultErrRetryMultiplier,
+				jitter:      defaultErrRetryJitter,
+			},
+		},
+		{
+			name: "Check configuration",
+			cfg: config.Retry{
+				MaxDelay:   "10s",
+				Multiplier: 1,
+				Jitter:     0.5,
+			},
+			want: backoff{
+				maxInterval: time.Second * 10,
+				multiplier:  1,
+				jitter:      0.5,
+			},
+		},
+		{
+			name: "Check invalid max delay",
+			cfg: config.Retry{
+				MaxDelay: "10",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryMaxInterval: time: missing unit in duration \"10\""),
+		},
+		{
+			name: "Check invalid multiplier",
+			cfg: config.Retry{
+				Multiplier: 0.5,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryMultiplier < 1"),
+		},
+		{
+			name: "Check invalid jitter",
+			cfg: config.Retry{
+				Jitter: 1.5,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryJitter is not between 0 and 1"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newBackoff(tt.cfg, time.Minute)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newBackoff() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newBackoff() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("newBackoff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_backoff_interval(t *testing.T) {
+	type args struct {
+		retries int
+		base    time.Duration
+		err     error
+	}
+	tests := []struct {
+		name    string
+		backoff backoff
+		args    args
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name:    "Check zero value keeps constant interval",
+			backoff: backoff{},
+			args: args{
+				retries: 3,
+				base:    time.Second,
+			},
+			wantMin: time.Second,
+			wantMax: time.Second,
+		},
+		{
+			name: "Check exponential interval",
+			backoff: backoff{
+				multiplier: 2,
+			},
+			args: args{
+				retries: 3,
+				base:    time.Second,
+			},
+			wantMin: time.Second * 8,
+			wantMax: time.Second * 8,
+		},
+		{
+			name: "Check interval with jitter",
+			backoff: backoff{
+				multiplier: 2,
+				jitter:     0.5,
+			},
+			args: args{
+				retries: 1,
+				base:    time.Second,
+			},
+			wantMin: time.Second,
+			wantMax: time.Second * 3,
+		},
+		{
+			name: "Check interval is capped by the max interval",
+			backoff: backoff{
+				maxInterval: time.Second * 5,
+				multiplier:  2,
+				jitter:      0.5,
+			},
+			args: args{
+				retries: 10,
+				base:    time.Second,
+			},
+			wantMin: time.Second * 5,
+			wantMax: time.Second * 5,
+		},
+		{
+			name: "Check Retry-After longer than the interval is honoured",
+			backoff: backoff{
+				maxInterval: time.Second * 30,
+				multiplier:  2,
+			},
+			args: args{
+				retries: 0,
+				base:    time.Second,
+				err:     &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusServiceUnavailable, retryAfter: time.Second * 10},
+			},
+			wantMin: time.Second * 10,
+			wantMax: time.Second * 10,
+		},
+		{
+			name: "Check Retry-After is capped by the max interval",
+			backoff: backoff{
+				maxInterval: time.Second * 5,
+				multiplier:  2,
+			},
+			args: args{
+				retries: 0,
+				base:    time.Second,
+				err:     &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusServiceUnavailable, retryAfter: time.Hour},
+			},
+			wantMin: time.Second * 5,
+			wantMax: time.Second * 5,
+		},
+		{
+			name:    "Check Retry-After is capped by the default max interval without max interval",
+			backoff: backoff{},
+			args: args{
+				retries: 0,
+				base:    time.Second,
+				err:     &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusServiceUnavailable, retryAfter: time.Hour},
+			},
+			wantMin: defaultErrRetryMaxInterval,
+			wantMax: defaultErrRetryMaxInterval,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := tt.backoff.interval(tt.args.retries, tt.args.base, tt.args.err); got < tt.wantMin || got > tt.wantMax {
+					t.Errorf("backoff.interval() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+					return
+				}
+			}
+		})
+	}
+}
+
+func Test_retryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "Check network error",
+			err:  errors.New("connection refused"),
+			want: true,
+		},
+		{
+			name: "Check server error",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusInternalServerError},
+			want: true,
+		},
+		{
+			name: "Check too many requests",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusTooManyRequests},
+			want: true,
+		},
+		{
+			name: "Check client error",
+			err:  &UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusForbidden},
+			want: false,
+		},
+		{
+			name: "Check wrapped client error",
+			err:  errors.Wrap(&UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusNotFound}, "dummy"),
+			want: false,
+		},
+		{
+			name: "Check wrapped too many requests",
+			err:  errors.Wrap(&UpstreamError{Err: ErrRoleTokenRequestFailed, Code: http.StatusTooManyRequests}, "dummy"),
+			want: true,
+		},
+		{
+			name: "Check ZTS client error",
+			err:  rdl.ResourceError{Code: http.StatusBadRequest, Message: "bad request"},
+			want: false,
+		},
+		{
+			name: "Check canceled",
+			err:  context.Canceled,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name    string
+		val     string
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{
+			name:    "Check delay seconds",
+			val:     "30",
+			wantMin: time.Second * 30,
+			wantMax: time.Second * 30,
+		},
+		{
+			name:    "Check HTTP date",
+			val:     fastime.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			wantMin: time.Second * 50,
+			wantMax: time.Minute,
+		},
+		{
+			name: "Check past HTTP date",
+			val:  fastime.Now().Add(-time.Minute).UTC().Format(http.TimeFormat),
+		},
+		{
+			name: "Check invalid value",
+			val:  "invalid",
+		},
+		{
+			name: "Check negative value",
+			val:  "-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.val); got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("parseRetryAfter() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func Test_sleep(t *testing.T) {
+	if !sleep(context.Background(), time.Millisecond) {
+		t.Errorf("sleep() = false, want true")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if sleep(ctx, time.Hour) {
+		t.Errorf("sleep() = true after the context is canceled, want false")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("sleep() does not return promptly after the context is canceled")
+	}
+}
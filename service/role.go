@@ -17,7 +17,6 @@ limitations under the License.
 package service
 
 import (
-	"bytes"
 	"context"
 	"crypto/x509"
 	"encoding/json"
@@ -33,6 +32,7 @@ import (
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
 	"github.com/kpango/fastime"
 	"github.com/kpango/gache"
 	"github.com/kpango/glg"
@@ -45,7 +45,9 @@ import (
 type RoleService interface {
 	StartRoleUpdater(context.Context) <-chan error
 	RefreshRoleTokenCache(ctx context.Context) <-chan error
+	PrefetchRoleTokens(ctx context.Context) <-chan error
 	GetRoleProvider() RoleProvider
+	Readiness() Readiness
 }
 
 // roleService represents the implementation of Athenz RoleService
@@ -65,6 +67,28 @@ type roleService struct {
 	refreshPeriod    time.Duration
 	errRetryMaxCount int
 	errRetryInterval time.Duration
+	errRetryBackoff  backoff
+
+	// grace represents the stale-while-revalidate mode. nil implies it is disabled.
+	grace *grace
+
+	// negativeCache caches the client errors returned by the Athenz server. nil implies it is disabled.
+	negativeCache *negativeCache
+
+	// cacheLimit tracks the cached tokens to evict. nil implies the cache is unlimited.
+	cacheLimit *cacheLimit
+
+	// scheduler schedules the refresh of each cached token. nil implies all the cached tokens are refreshed every refresh period.
+	scheduler *refreshScheduler
+
+	// refreshPool bounds the concurrency and the rate of the background refreshes. nil implies the tokens are refreshed one at a time without rate limit.
+	refreshPool *refreshPool
+
+	// prefetch represents the role tokens to fetch regardless of requests. The token field is always nil.
+	prefetch []*cacheData
+
+	// lastRefresh records the result of the last cache refresh and prefetch.
+	lastRefresh refreshRecorder
 }
 
 type cacheData struct {
@@ -163,10 +187,65 @@ func NewRoleService(cfg config.RoleToken, token ntokend.TokenProvider) (RoleServ
 		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
 	}
 
+	errRetryBackoff, err := newBackoff(cfg.Retry, defaultErrRetryMaxInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	graceMode, err := newGrace(cfg.Grace)
+	if err != nil {
+		return nil, err
+	}
+
+	negCache, err := newNegativeCache(cfg.NegativeCache)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduler, err := newRefreshScheduler(cfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := newRefreshPool(cfg.RefreshPool)
+	if err != nil {
+		return nil, err
+	}
+
 	if token == nil && cfg.CertPath == "" {
 		return nil, errors.Wrap(ErrInvalidSetting, "Neither NToken nor client certificate is set.")
 	}
 
+	prefetch := make([]*cacheData, 0, len(cfg.Prefetch))
+	for i, p := range cfg.Prefetch {
+		if p.Domain == "" {
+			return nil, errors.Wrap(ErrInvalidSetting, fmt.Sprintf("Prefetch[%d]: domain is empty", i))
+		}
+		var minExp, maxExp time.Duration
+		if p.MinExpiry != "" {
+			if minExp, err = time.ParseDuration(p.MinExpiry); err != nil {
+				return nil, errors.Wrap(ErrInvalidSetting, fmt.Sprintf("Prefetch[%d].MinExpiry: %s", i, err.Error()))
+			}
+		}
+		if p.MaxExpiry != "" {
+			if maxExp, err = time.ParseDuration(p.MaxExpiry); err != nil {
+				return nil, errors.Wrap(ErrInvalidSetting, fmt.Sprintf("Prefetch[%d].MaxExpiry: %s", i, err.Error()))
+			}
+		}
+		prefetch = append(prefetch, &cacheData{
+			domain:            p.Domain,
+			role:              strings.Join(p.Roles, roleSeparator),
+			proxyForPrincipal: p.ProxyForPrincipal,
+			minExpiry:         int64(minExp / time.Second),
+			maxExpiry:         int64(maxExp / time.Second),
+		})
+	}
+
+	prefetchKeys := make([]string, 0, len(prefetch))
+	for _, p := range prefetch {
+		prefetchKeys = append(prefetchKeys, encode(p.domain, p.role, p.proxyForPrincipal))
+	}
+
 	var cp *x509.CertPool
 	if cfg.AthenzCAPath != "" {
 		var err error
@@ -215,6 +294,13 @@ func NewRoleService(cfg config.RoleToken, token ntokend.TokenProvider) (RoleServ
 		refreshPeriod:         refreshPeriod,
 		errRetryMaxCount:      errRetryMaxCount,
 		errRetryInterval:      errRetryInterval,
+		errRetryBackoff:       errRetryBackoff,
+		grace:                 graceMode,
+		negativeCache:         negCache,
+		cacheLimit:            newCacheLimit(cfg.CacheLimit, refreshPeriod, prefetchKeys),
+		scheduler:             scheduler,
+		refreshPool:           pool,
+		prefetch:              prefetch,
 	}, nil
 }
 
@@ -227,12 +313,24 @@ func (r *roleService) StartRoleUpdater(ctx context.Context) <-chan error {
 	go func() {
 		defer close(ech)
 
+		// the scheduled refreshes send the errors until the scheduler is stopped
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			r.scheduler.run(ctx, func(ctx context.Context, key string) {
+				for err := range r.refreshScheduled(ctx, key) {
+					ech <- errors.Wrap(err, "error update role token")
+				}
+			})
+		}()
+
 		ticker := time.NewTicker(r.refreshPeriod)
 		for {
 			select {
 			case <-ctx.Done():
 				glg.Info("Stopping role token updater...")
 				ticker.Stop()
+				<-done
 				ech <- ctx.Err()
 				return
 			case <-ticker.C:
@@ -244,44 +342,183 @@ func (r *roleService) StartRoleUpdater(ctx context.Context) <-chan error {
 	}()
 
 	r.domainRoleCache.StartExpired(ctx, cachePurgePeriod)
+	r.negativeCache.startExpired(ctx)
 	r.domainRoleCache.EnableExpiredHook().SetExpiredHook(func(ctx context.Context, k string) {
+		r.cacheLimit.remove(k)
+		r.scheduler.remove(k)
 		glg.Warnf("the following cache is expired, key: %v", k)
 	})
 	return ech
 }
 
+// InheritRoleTokenCache copies the role tokens cached in src, which are not expired yet, to dst.
+// It returns the number of role tokens copied. Nothing is copied if either service is not created by NewRoleService.
+// The negative cache is not copied, so that the cached errors are cleared when the configuration is reloaded.
+func InheritRoleTokenCache(ctx context.Context, dst, src RoleService) int {
+	d, ok := dst.(*roleService)
+	if !ok {
+		return 0
+	}
+	s, ok := src.(*roleService)
+	if !ok {
+		return 0
+	}
+
+	var n int64
+	s.domainRoleCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		var dur time.Duration // 0 implies no expiry
+		if exp > 0 {
+			if dur = time.Duration(exp - fastime.UnixNanoNow()); dur <= 0 {
+				return true
+			}
+		}
+		d.domainRoleCache.SetWithExpire(key, val, dur)
+		d.limitCache(key)
+		atomic.AddInt64(&n, 1)
+		return true
+	})
+	return int(n)
+}
+
 // GetRoleProvider returns a function pointer to get the role token.
 func (r *roleService) GetRoleProvider() RoleProvider {
 	return r.getRoleToken
 }
 
+// Readiness returns the readiness of the role token service based on the result of the last cache refresh.
+func (r *roleService) Readiness() Readiness {
+	return r.lastRefresh.readiness()
+}
+
 // getRoleToken returns RoleToken struct or error.
 // This function will return the role token stored inside the cache, or fetch the role token from Athenz when corresponding role token cannot be found in the cache.
 func (r *roleService) getRoleToken(ctx context.Context, domain, role, proxyForPrincipal string, minExpiry, maxExpiry int64) (*RoleToken, error) {
+	key := encode(domain, role, proxyForPrincipal)
 	tok, ok := r.getCache(domain, role, proxyForPrincipal)
 	if !ok {
+		if err := r.negativeCache.get(key); err != nil {
+			metrics.CacheNegative(metrics.RoleToken)
+			return nil, err
+		}
+		metrics.CacheMiss(metrics.RoleToken)
 		return r.updateRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry)
 	}
+	r.cacheLimit.touch(key)
+	if r.grace.stale(time.Unix(tok.ExpiryTime, 0)) {
+		metrics.CacheStale(metrics.RoleToken)
+		r.grace.revalidate(key, func(ctx context.Context) error {
+			_, err := r.updateRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry)
+			return err
+		})
+		return tok, nil
+	}
+	metrics.CacheHit(metrics.RoleToken)
 	return tok, nil
 }
 
 // RefreshRoleTokenCache returns the error channel when it is updated.
+// The prefetch role tokens are also fetched if they are not in the cache.
 func (r *roleService) RefreshRoleTokenCache(ctx context.Context) <-chan error {
 	glg.Info("RefreshRoleTokenCache started")
 
-	echan := make(chan error, r.domainRoleCache.Len()*(r.errRetryMaxCount+1))
+	// the tokens not requested recently are dropped instead of refreshed
+	for _, k := range r.cacheLimit.removeIdle() {
+		r.domainRoleCache.Delete(k)
+		r.scheduler.remove(k)
+		glg.Debugf("idle token is dropped from the cache, key: %s", k)
+	}
+
+	echan := make(chan error, (r.domainRoleCache.Len()+len(r.prefetch))*(r.errRetryMaxCount+1))
 	go func() {
 		defer close(echan)
 
-		r.domainRoleCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		// the scheduled tokens are refreshed by the scheduler
+		targets := refreshTargets(ctx, r.domainRoleCache, func(key string, val interface{}) (interface{}, bool) {
+			if r.scheduler.scheduled(key) {
+				return nil, false
+			}
 			domain, role, principal := decode(key)
 			cd := val.(*cacheData)
+			return &cacheData{
+				domain:            domain,
+				role:              role,
+				proxyForPrincipal: principal,
+				minExpiry:         cd.minExpiry,
+				maxExpiry:         cd.maxExpiry,
+			}, true
+		})
+		for _, p := range r.prefetch {
+			if _, ok := r.getCache(p.domain, p.role, p.proxyForPrincipal); !ok {
+				targets = append(targets, p)
+			}
+		}
+
+		// the tokens are refreshed concurrently, so that a slow domain does not delay the others
+		cnt := r.scheduler.results()
+		r.refreshPool.each(ctx, len(targets), func(i int) {
+			cd := targets[i].(*cacheData)
+			errs := make([]error, 0, r.errRetryMaxCount+1)
+			for err := range r.updateRoleTokenWithRetry(ctx, cd.domain, cd.role, cd.proxyForPrincipal, cd.minExpiry, cd.maxExpiry) {
+				echan <- errors.Wrapf(err, "domain: %s, role: %s, proxyForPrincipal: %s", cd.domain, cd.role, cd.proxyForPrincipal)
+				errs = append(errs, err)
+			}
+			cnt.add(errs, r.errRetryMaxCount)
+		})
+		cnt.store(&r.lastRefresh)
+	}()
+
+	return echan
+}
+
+// refreshScheduled refreshes the cached role token of the key scheduled by the refresh scheduler.
+// The returned error channel is closed when the role token is refreshed, or failed after retry.
+func (r *roleService) refreshScheduled(ctx context.Context, key string) <-chan error {
+	echan := make(chan error, r.errRetryMaxCount+1)
+	go func() {
+		defer close(echan)
+
+		if !r.refreshPool.acquire(ctx) {
+			return
+		}
+		defer r.refreshPool.release()
+
+		val, ok := r.domainRoleCache.Get(key)
+		if !ok {
+			return
+		}
+		cd := val.(*cacheData)
+
+		errs := make([]error, 0, r.errRetryMaxCount+1)
+		for err := range r.updateRoleTokenWithRetry(ctx, cd.domain, cd.role, cd.proxyForPrincipal, cd.minExpiry, cd.maxExpiry) {
+			echan <- errors.Wrapf(err, "domain: %s, role: %s, proxyForPrincipal: %s", cd.domain, cd.role, cd.proxyForPrincipal)
+			errs = append(errs, err)
+		}
+		r.scheduler.record(errs, r.errRetryMaxCount)
+	}()
+
+	return echan
+}
+
+// PrefetchRoleTokens fetches the role tokens declared in the prefetch configuration concurrently.
+// The returned error channel is closed when all the role tokens are fetched, or failed after retry.
+func (r *roleService) PrefetchRoleTokens(ctx context.Context) <-chan error {
+	glg.Infof("PrefetchRoleTokens started, count: %d", len(r.prefetch))
+
+	echan := make(chan error, len(r.prefetch)*(r.errRetryMaxCount+1))
+	go func() {
+		defer close(echan)
 
-			for err := range r.updateRoleTokenWithRetry(ctx, domain, role, principal, cd.minExpiry, cd.maxExpiry) {
-				echan <- err
+		cnt := new(refreshCounter)
+		r.refreshPool.each(ctx, len(r.prefetch), func(i int) {
+			p := r.prefetch[i]
+			errs := make([]error, 0, r.errRetryMaxCount+1)
+			for err := range r.updateRoleTokenWithRetry(ctx, p.domain, p.role, p.proxyForPrincipal, p.minExpiry, p.maxExpiry) {
+				echan <- errors.Wrapf(err, "domain: %s, role: %s, proxyForPrincipal: %s", p.domain, p.role, p.proxyForPrincipal)
+				errs = append(errs, err)
 			}
-			return true
+			cnt.add(errs, r.errRetryMaxCount)
 		})
+		cnt.store(&r.lastRefresh)
 	}()
 
 	return echan
@@ -296,14 +533,26 @@ func (r *roleService) updateRoleTokenWithRetry(ctx context.Context, domain, role
 		defer close(echan)
 
 		for i := 0; i <= r.errRetryMaxCount; i++ {
-			if _, err := r.updateRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry); err != nil {
-				echan <- err
-				time.Sleep(r.errRetryInterval)
-			} else {
+			if !r.refreshPool.wait(ctx) {
+				return
+			}
+			_, err := r.updateRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry)
+			if err == nil {
 				glg.Debug("update success")
+				return
+			}
+			echan <- err
+			metrics.RetryFailure(metrics.RoleToken)
+
+			// the client errors will never succeed by retrying
+			if !retryable(err) || i == r.errRetryMaxCount {
 				break
 			}
+			if !sleep(ctx, r.errRetryBackoff.interval(i, r.errRetryInterval, err)) {
+				return
+			}
 		}
+		metrics.RefreshFailure(metrics.RoleToken)
 	}()
 
 	return echan
@@ -318,9 +567,15 @@ func (r *roleService) updateRoleToken(ctx context.Context, domain, role, proxyFo
 	rt, err, _ := r.group.Do(key, func() (interface{}, error) {
 		rt, e := r.fetchRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry)
 		if e != nil {
+			r.negativeCache.set(key, e)
 			return nil, e
 		}
 
+		dur := time.Unix(rt.ExpiryTime, 0).Sub(expTimeDelta)
+		if r.grace != nil {
+			// keep the token until it expires, it is refreshed in background after the refresh point
+			dur = time.Unix(rt.ExpiryTime, 0).Sub(fastime.Now())
+		}
 		r.domainRoleCache.SetWithExpire(key, &cacheData{
 			token:             rt,
 			domain:            domain,
@@ -328,7 +583,11 @@ func (r *roleService) updateRoleToken(ctx context.Context, domain, role, proxyFo
 			proxyForPrincipal: proxyForPrincipal,
 			minExpiry:         minExpiry,
 			maxExpiry:         maxExpiry,
-		}, time.Unix(rt.ExpiryTime, 0).Sub(expTimeDelta))
+		}, dur)
+		r.negativeCache.delete(key)
+		r.limitCache(key)
+		// refresh the token before the cache entry expires
+		r.scheduler.schedule(key, fastime.Now().Add(dur))
 
 		glg.Debugf("token is cached, domain: %s, role: %s, proxyForPrincipal: %s, expiry time: %v", domain, role, proxyForPrincipal, rt.ExpiryTime)
 		return rt, nil
@@ -376,19 +635,19 @@ func (r *roleService) fetchRoleToken(ctx context.Context, domain, role, proxyFor
 	}
 
 	// send request
+	start := time.Now()
 	res, err := r.httpClient.Load().(*http.Client).Do(req.WithContext(ctx))
 	if err != nil {
+		metrics.ObserveAthenzRequest(metrics.RoleToken, metrics.StatusError, start)
 		return nil, err
 	}
+	metrics.ObserveAthenzRequest(metrics.RoleToken, metrics.StatusCode(res.StatusCode), start)
 
 	defer flushAndClose(res.Body)
 	if res.StatusCode != http.StatusOK {
-		buf := new(bytes.Buffer)
-		if _, err := buf.ReadFrom(res.Body); err != nil {
-			glg.Debugf("cannot read response body, err: %v", err)
-		}
-		glg.Debugf("error return from server, response:%+v, body: %v", res, buf.String())
-		return nil, ErrRoleTokenRequestFailed
+		uerr := newUpstreamError(ErrRoleTokenRequestFailed, res)
+		glg.Debugf("error return from server, response:%+v, message: %v", res, uerr.Message)
+		return nil, uerr
 	}
 
 	var data *RoleToken
@@ -399,6 +658,15 @@ func (r *roleService) fetchRoleToken(ctx context.Context, domain, role, proxyFor
 	return data, nil
 }
 
+// limitCache tracks the key of the token stored in the cache, and evicts the least recently requested tokens exceeding the limit.
+func (r *roleService) limitCache(key string) {
+	for _, k := range r.cacheLimit.add(key) {
+		r.domainRoleCache.Delete(k)
+		r.scheduler.remove(k)
+		glg.Debugf("token is evicted from the cache, key: %s", k)
+	}
+}
+
 func (r *roleService) getCache(domain, role, principal string) (*RoleToken, bool) {
 	val, ok := r.domainRoleCache.Get(encode(domain, role, principal))
 	if !ok {
@@ -22,7 +22,9 @@ import "context"
 type RoleServiceMock struct {
 	StartRoleUpdaterFunc      func(context.Context) <-chan error
 	RefreshRoleTokenCacheFunc func(ctx context.Context) <-chan error
+	PrefetchRoleTokensFunc    func(ctx context.Context) <-chan error
 	GetRoleProviderFunc       func() RoleProvider
+	ReadinessFunc             func() Readiness
 }
 
 // StartRoleUpdater is a mock implementation of RoleService.StartRoleUpdater
@@ -35,7 +37,17 @@ func (asm *RoleServiceMock) RefreshRoleTokenCache(ctx context.Context) <-chan er
 	return asm.RefreshRoleTokenCacheFunc(ctx)
 }
 
+// PrefetchRoleTokens is a mock implementation of RoleService.PrefetchRoleTokens
+func (asm *RoleServiceMock) PrefetchRoleTokens(ctx context.Context) <-chan error {
+	return asm.PrefetchRoleTokensFunc(ctx)
+}
+
 // GetRoleProvider is a mock implementation of RoleService.GetRoleProvider
 func (asm *RoleServiceMock) GetRoleProvider() RoleProvider {
 	return asm.GetRoleProviderFunc()
 }
+
+// Readiness is a mock implementation of RoleService.Readiness
+func (asm *RoleServiceMock) Readiness() Readiness {
+	return asm.ReadinessFunc()
+}
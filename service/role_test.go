@@ -455,6 +455,77 @@ func TestNewRoleService(t *testing.T) {
 			},
 			wantErr: errors.Wrap(ErrInvalidSetting, "client certificate not found"),
 		},
+		func() test {
+			args := args{
+				cfg: config.RoleToken{
+					Enable: true,
+					Prefetch: []config.RoleTokenPrefetch{
+						{
+							Domain:            "dummyDomain",
+							Roles:             []string{"role1", "role2"},
+							ProxyForPrincipal: "dummyPrincipal",
+							MinExpiry:         "10m",
+							MaxExpiry:         "1h",
+						},
+					},
+				},
+				token: dummyTokenProvider,
+			}
+			return test{
+				name: "NewRoleService with prefetch",
+				args: args,
+				checkFunc: func(got, want RoleService) error {
+					gotS := got.(*roleService)
+					wantS := want.(*roleService)
+					if !reflect.DeepEqual(gotS.prefetch, wantS.prefetch) {
+						return fmt.Errorf("got: %+v, want: %+v", gotS.prefetch, wantS.prefetch)
+					}
+					return nil
+				},
+				want: &roleService{
+					prefetch: []*cacheData{
+						{
+							domain:            "dummyDomain",
+							role:              "role1,role2",
+							proxyForPrincipal: "dummyPrincipal",
+							minExpiry:         600,
+							maxExpiry:         3600,
+						},
+					},
+				},
+			}
+		}(),
+		{
+			name: "NewRoleService with invalid prefetch expiry",
+			args: args{
+				cfg: config.RoleToken{
+					Enable: true,
+					Prefetch: []config.RoleTokenPrefetch{
+						{
+							Domain:    "dummyDomain",
+							MinExpiry: "invalid",
+						},
+					},
+				},
+				token: dummyTokenProvider,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `Prefetch[0].MinExpiry: time: invalid duration "invalid"`),
+		},
+		{
+			name: "NewRoleService with empty prefetch domain",
+			args: args{
+				cfg: config.RoleToken{
+					Enable: true,
+					Prefetch: []config.RoleTokenPrefetch{
+						{
+							Roles: []string{"role"},
+						},
+					},
+				},
+				token: dummyTokenProvider,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "Prefetch[0]: domain is empty"),
+		},
 		{
 			name: "NewRoleService with non-existing client certificate key",
 			args: args{
@@ -648,8 +719,8 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err.Error() != errors.Wrap(ErrRoleTokenRequestFailed, "error update role token").Error() {
-							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(ErrRoleTokenRequestFailed, "error update role token"))
+						if err.Error() != errors.Wrap(errors.Wrap(ErrRoleTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update role token").Error() {
+							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(errors.Wrap(ErrRoleTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update role token"))
 						}
 					}
 
@@ -727,8 +798,8 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err.Error() != errors.Wrap(ErrRoleTokenRequestFailed, "error update role token").Error() {
-							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(ErrRoleTokenRequestFailed, "error update role token"))
+						if err.Error() != errors.Wrap(errors.Wrap(ErrRoleTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update role token").Error() {
+							return errors.Errorf("Unexpected error: %v, want: %v", err, errors.Wrap(errors.Wrap(ErrRoleTokenRequestFailed, "domain: dummyDomain, role: dummyRole, proxyForPrincipal: dummyProxy"), "error update role token"))
 						}
 					}
 
@@ -772,6 +843,72 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 	}
 }
 
+func TestInheritRoleTokenCache(t *testing.T) {
+	type args struct {
+		dst RoleService
+		src RoleService
+	}
+	type test struct {
+		name      string
+		args      args
+		want      int
+		checkFunc func(dst RoleService) error
+	}
+	tests := []test{
+		func() test {
+			src := gache.New()
+			src.SetWithExpire("dummyDomain;dummyRole", &cacheData{
+				token: &RoleToken{Token: "valid"},
+			}, time.Hour)
+			src.SetWithExpire("dummyDomain;expiredRole", &cacheData{
+				token: &RoleToken{Token: "expired"},
+			}, time.Millisecond)
+			time.Sleep(time.Millisecond * 200)
+
+			dst := &roleService{domainRoleCache: gache.New()}
+			return test{
+				name: "Inherit only unexpired cache",
+				args: args{
+					dst: dst,
+					src: &roleService{domainRoleCache: src},
+				},
+				want: 1,
+				checkFunc: func(got RoleService) error {
+					tok, ok := got.(*roleService).getCache("dummyDomain", "dummyRole", "")
+					if !ok || tok.Token != "valid" {
+						return fmt.Errorf("valid token is not inherited, got: %v", tok)
+					}
+					if _, ok := got.(*roleService).getCache("dummyDomain", "expiredRole", ""); ok {
+						return fmt.Errorf("expired token is inherited")
+					}
+					return nil
+				},
+			}
+		}(),
+		{
+			name: "Inherit nothing from mock",
+			args: args{
+				dst: &roleService{domainRoleCache: gache.New()},
+				src: &RoleServiceMock{},
+			},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := InheritRoleTokenCache(context.Background(), tt.args.dst, tt.args.src)
+			if got != tt.want {
+				t.Errorf("InheritRoleTokenCache() = %v, want %v", got, tt.want)
+			}
+			if tt.checkFunc != nil {
+				if err := tt.checkFunc(tt.args.dst); err != nil {
+					t.Errorf("InheritRoleTokenCache() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
 func Test_roleService_GetRoleProvider(t *testing.T) {
 	tests := []struct {
 		name string
@@ -964,6 +1101,229 @@ func Test_roleService_getRoleToken(t *testing.T) {
 	}
 }
 
+func Test_roleService_getRoleToken_grace(t *testing.T) {
+	type test struct {
+		name       string
+		cached     *RoleToken
+		statusCode int
+		wantCalls  int32
+		wantCached string
+	}
+	tests := []test{
+		{
+			name: "getRoleToken returns the cached token before the refresh point",
+			cached: &RoleToken{
+				Token:      "cachedToken",
+				ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+			},
+			statusCode: http.StatusOK,
+			wantCalls:  0,
+			wantCached: "cachedToken",
+		},
+		{
+			name: "getRoleToken returns the stale token and refreshes it in background",
+			cached: &RoleToken{
+				Token:      "cachedToken",
+				ExpiryTime: fastime.Now().Add(time.Second * 30).Unix(),
+			},
+			statusCode: http.StatusOK,
+			wantCalls:  1,
+			wantCached: "newToken",
+		},
+		{
+			name: "getRoleToken returns the stale token when the background refresh failed",
+			cached: &RoleToken{
+				Token:      "cachedToken",
+				ExpiryTime: fastime.Now().Add(time.Second * 30).Unix(),
+			},
+			statusCode: http.StatusServiceUnavailable,
+			wantCalls:  1,
+			wantCached: "cachedToken",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprintf(w, `{"token":"newToken", "expiryTime": %d}`, fastime.Now().Add(time.Hour).Unix())
+			}))
+			defer dummyServer.Close()
+
+			var httpClient atomic.Value
+			httpClient.Store(dummyServer.Client())
+			r := &roleService{
+				token: func() (string, error) {
+					return "dummyNToken", nil
+				},
+				athenzURL:             dummyServer.URL,
+				athenzPrincipleHeader: "Athenz-Principal",
+				domainRoleCache:       gache.New(),
+				httpClient:            httpClient,
+				grace: &grace{
+					refreshBefore: time.Minute,
+				},
+			}
+			r.domainRoleCache.Set(encode("dummyDomain", "dummyRole", ""), &cacheData{
+				token:  tt.cached,
+				domain: "dummyDomain",
+				role:   "dummyRole",
+			})
+
+			got, err := r.getRoleToken(context.Background(), "dummyDomain", "dummyRole", "", 0, 0)
+			if err != nil {
+				t.Errorf("roleService.getRoleToken() error = %v", err)
+				return
+			}
+			if got != tt.cached {
+				t.Errorf("roleService.getRoleToken() = %v, want %v", got, tt.cached)
+			}
+
+			// wait for the background refresh
+			time.Sleep(time.Millisecond * 100)
+			if _, ok := r.grace.refreshing.Load(encode("dummyDomain", "dummyRole", "")); ok {
+				t.Errorf("roleService.getRoleToken() background refresh is not finished")
+			}
+			if c := atomic.LoadInt32(&calls); c != tt.wantCalls {
+				t.Errorf("roleService.getRoleToken() Athenz request count = %v, want %v", c, tt.wantCalls)
+			}
+			if tok, ok := r.getCache("dummyDomain", "dummyRole", ""); !ok || tok.Token != tt.wantCached {
+				t.Errorf("roleService.getRoleToken() cached token = %v, want %v", tok, tt.wantCached)
+			}
+		})
+	}
+}
+
+func Test_roleService_getRoleToken_negativeCache(t *testing.T) {
+	type test struct {
+		name       string
+		statusCode int
+		wantCalls  int32
+		wantCode   int
+	}
+	tests := []test{
+		{
+			name:       "getRoleToken returns the cached client error",
+			statusCode: http.StatusForbidden,
+			wantCalls:  1,
+			wantCode:   http.StatusForbidden,
+		},
+		{
+			name:       "getRoleToken does not cache the server error",
+			statusCode: http.StatusServiceUnavailable,
+			wantCalls:  3,
+			wantCode:   http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprint(w, `{"code":403,"message":"forbidden"}`)
+			}))
+			defer dummyServer.Close()
+
+			var httpClient atomic.Value
+			httpClient.Store(dummyServer.Client())
+			negCache, _ := newNegativeCache(config.NegativeCache{
+				Enable: true,
+			})
+			r := &roleService{
+				token: func() (string, error) {
+					return "dummyNToken", nil
+				},
+				athenzURL:             dummyServer.URL,
+				athenzPrincipleHeader: "Athenz-Principal",
+				domainRoleCache:       gache.New(),
+				httpClient:            httpClient,
+				negativeCache:         negCache,
+			}
+
+			for i := 0; i < 3; i++ {
+				_, err := r.getRoleToken(context.Background(), "dummyDomain", "dummyRole", "", 0, 0)
+				var uerr *UpstreamError
+				if !errors.As(err, &uerr) || uerr.Code != tt.wantCode {
+					t.Errorf("roleService.getRoleToken() error = %v, want status code %v", err, tt.wantCode)
+				}
+			}
+			if c := atomic.LoadInt32(&calls); c != tt.wantCalls {
+				t.Errorf("roleService.getRoleToken() Athenz request count = %v, want %v", c, tt.wantCalls)
+			}
+
+			// the new service created on reload does not inherit the cached errors
+			dst := &roleService{
+				domainRoleCache: gache.New(),
+			}
+			dst.negativeCache, _ = newNegativeCache(config.NegativeCache{
+				Enable: true,
+			})
+			InheritRoleTokenCache(context.Background(), dst, r)
+			if err := dst.negativeCache.get(encode("dummyDomain", "dummyRole", "")); err != nil {
+				t.Errorf("InheritRoleTokenCache() copied the cached error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_roleService_cacheLimit(t *testing.T) {
+	var calls int32
+	dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(w, `{"token":"%s", "expiryTime": %d}`, r.URL.Query().Get("role"), fastime.Now().Add(time.Hour).Unix())
+	}))
+	defer dummyServer.Close()
+
+	var httpClient atomic.Value
+	httpClient.Store(dummyServer.Client())
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		athenzURL:             dummyServer.URL,
+		athenzPrincipleHeader: "Athenz-Principal",
+		domainRoleCache:       gache.New(),
+		httpClient:            httpClient,
+		cacheLimit: newCacheLimit(config.CacheLimit{
+			MaxEntries:  2,
+			IdlePeriods: 1,
+		}, time.Millisecond*100, nil),
+	}
+
+	ctx := context.Background()
+	for _, role := range []string{"role1", "role2", "role1", "role3"} {
+		if _, err := r.getRoleToken(ctx, "dummyDomain", role, "", 0, 0); err != nil {
+			t.Errorf("roleService.getRoleToken() error = %v", err)
+		}
+	}
+
+	// role2 is the least recently requested
+	if _, ok := r.getCache("dummyDomain", "role2", ""); ok {
+		t.Errorf("roleService.getRoleToken() did not evict the least recently requested token")
+	}
+	if r.domainRoleCache.Len() != 2 {
+		t.Errorf("roleService.getRoleToken() cached %d tokens, want %d", r.domainRoleCache.Len(), 2)
+	}
+
+	// role1 is not requested within the idle timeout
+	time.Sleep(time.Millisecond * 150)
+	if _, err := r.getRoleToken(ctx, "dummyDomain", "role3", "", 0, 0); err != nil {
+		t.Errorf("roleService.getRoleToken() error = %v", err)
+	}
+	atomic.StoreInt32(&calls, 0)
+	for err := range r.RefreshRoleTokenCache(ctx) {
+		t.Errorf("roleService.RefreshRoleTokenCache() error = %v", err)
+	}
+	if _, ok := r.getCache("dummyDomain", "role1", ""); ok {
+		t.Errorf("roleService.RefreshRoleTokenCache() did not drop the idle token")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("roleService.RefreshRoleTokenCache() Athenz request count = %v, want %v", got, 1)
+	}
+}
+
 func Test_roleService_RefreshRoleTokenCache(t *testing.T) {
 	type fields struct {
 		cfg                   config.RoleToken
@@ -1183,7 +1543,7 @@ func Test_roleService_RefreshRoleTokenCache(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrRoleTokenRequestFailed {
+						if errors.Cause(err) != ErrRoleTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
@@ -1256,7 +1616,7 @@ func Test_roleService_RefreshRoleTokenCache(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrRoleTokenRequestFailed {
+						if errors.Cause(err) != ErrRoleTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
@@ -1311,6 +1671,108 @@ func Test_roleService_RefreshRoleTokenCache(t *testing.T) {
 	}
 }
 
+func Test_roleService_PrefetchRoleTokens(t *testing.T) {
+	type test struct {
+		name      string
+		handler   http.HandlerFunc
+		prefetch  []*cacheData
+		checkFunc func(r *roleService, errs []error) error
+	}
+	tests := []test{
+		{
+			name: "PrefetchRoleTokens fetches all the declared tokens",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"token":"%s", "expiryTime": %d}`, r.URL.Query().Get("role"), fastime.Now().Add(time.Hour).Unix())
+			},
+			prefetch: []*cacheData{
+				{domain: "dummyDomain", role: "role1"},
+				{domain: "dummyDomain", role: "role2,role3", proxyForPrincipal: "dummyPrincipal", minExpiry: 600},
+			},
+			checkFunc: func(r *roleService, errs []error) error {
+				if len(errs) != 0 {
+					return fmt.Errorf("unexpected errors: %v", errs)
+				}
+				if rd := r.Readiness(); !rd.Ready || rd.LastRefresh == "" {
+					return fmt.Errorf("not ready after prefetch: %+v", rd)
+				}
+				if tok, ok := r.getCache("dummyDomain", "role1", ""); !ok || tok.Token != "role1" {
+					return fmt.Errorf("role1 is not prefetched, got: %v", tok)
+				}
+				if tok, ok := r.getCache("dummyDomain", "role2,role3", "dummyPrincipal"); !ok || tok.Token != "role2,role3" {
+					return fmt.Errorf("role2,role3 is not prefetched, got: %v", tok)
+				}
+				return nil
+			},
+		},
+		{
+			name: "PrefetchRoleTokens returns errors after retry",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			prefetch: []*cacheData{
+				{domain: "dummyDomain", role: "role1"},
+			},
+			checkFunc: func(r *roleService, errs []error) error {
+				if len(errs) != 2 {
+					return fmt.Errorf("unexpected errors: %v", errs)
+				}
+				if rd := r.Readiness(); rd.Ready {
+					return fmt.Errorf("ready after all the prefetch failed: %+v", rd)
+				}
+				if r.domainRoleCache.Len() != 0 {
+					return fmt.Errorf("token is cached")
+				}
+				return nil
+			},
+		},
+		{
+			name: "PrefetchRoleTokens does not retry client errors",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+			prefetch: []*cacheData{
+				{domain: "dummyDomain", role: "role1"},
+			},
+			checkFunc: func(r *roleService, errs []error) error {
+				if len(errs) != 1 {
+					return fmt.Errorf("unexpected errors: %v", errs)
+				}
+				if rd := r.Readiness(); rd.Ready {
+					return fmt.Errorf("ready after all the prefetch failed: %+v", rd)
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dummyServer := httptest.NewTLSServer(tt.handler)
+			defer dummyServer.Close()
+
+			r := &roleService{
+				token: func() (string, error) {
+					return "dummyToken", nil
+				},
+				athenzURL:             dummyServer.URL,
+				athenzPrincipleHeader: "Athenz-Principal",
+				domainRoleCache:       gache.New(),
+				errRetryMaxCount:      1,
+				errRetryInterval:      time.Millisecond,
+				prefetch:              tt.prefetch,
+			}
+			r.httpClient.Store(dummyServer.Client())
+
+			var errs []error
+			for err := range r.PrefetchRoleTokens(context.Background()) {
+				errs = append(errs, err)
+			}
+			if err := tt.checkFunc(r, errs); err != nil {
+				t.Errorf("PrefetchRoleTokens() error = %v", err)
+			}
+		})
+	}
+}
+
 func Test_roleService_updateRoleTokenWithRetry(t *testing.T) {
 	type fields struct {
 		cfg                   config.RoleToken
@@ -1445,7 +1907,7 @@ func Test_roleService_updateRoleTokenWithRetry(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrRoleTokenRequestFailed {
+						if errors.Cause(err) != ErrRoleTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
@@ -1510,7 +1972,7 @@ func Test_roleService_updateRoleTokenWithRetry(t *testing.T) {
 
 					// check errors
 					for _, err := range errs {
-						if err != ErrRoleTokenRequestFailed {
+						if errors.Cause(err) != ErrRoleTokenRequestFailed {
 							return errors.Errorf("Unexpected error: %v", err)
 						}
 					}
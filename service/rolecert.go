@@ -0,0 +1,338 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+)
+
+// RoleCertService represents an interface to automatically refresh the role certificates, and a role certificate provider function pointer.
+type RoleCertService interface {
+	StartRoleCertUpdater(context.Context) <-chan error
+	RefreshRoleCertCache(ctx context.Context) <-chan error
+	GetRoleCertProvider() RoleCertProvider
+	Readiness() Readiness
+}
+
+// RoleCertProvider represents a function pointer to get the role certificate of the role in the domain, and its private key in PEM format.
+// The private key is nil unless the client sidecar generates a new private key for each role certificate.
+type RoleCertProvider func(ctx context.Context, domain, role string) (cert []byte, key []byte, err error)
+
+// roleCertService represents the implementation of Athenz RoleCertService
+type roleCertService struct {
+	cfg    config.RoleCert
+	token  ntokend.TokenProvider
+	client *zts.ZTSClient
+	certs  *credentialCache
+
+	// principal represents the service identity requesting the role certificates, "<domain>.<service>".
+	principal string
+	// subj represents the subject of the CSR. The common name is set for each role.
+	subj pkix.Name
+	// signer signs the CSR with the private key of nToken.privateKeyPath. It is nil if the private key is generated for each role certificate.
+	signer *signer
+	// expiry represents the requested expiry of the role certificates in minutes. 0 implies the default of the Athenz server.
+	expiry int64
+
+	// expiryMargin represents the duration before the role certificates expire to refresh them.
+	expiryMargin time.Duration
+}
+
+// roleCertCache represents the cached role certificate, and the request fetching it.
+type roleCertCache struct {
+	cert     []byte
+	key      []byte
+	domain   string
+	role     string
+	notAfter time.Time
+}
+
+var (
+	// defaultRoleCertRefreshPeriod represents the default duration between the checks of the role certificates to refresh.
+	defaultRoleCertRefreshPeriod = time.Hour
+
+	// defaultRoleCertExpiryMargin represents the default duration before the role certificates expire to refresh them.
+	defaultRoleCertExpiryMargin = time.Hour
+
+	// ErrRoleCertRequestFailed represents an error when failed to fetch the role certificate from RoleCertProvider.
+	ErrRoleCertRequestFailed = errors.New("Failed to fetch role cert")
+)
+
+// NewRoleCertService returns a RoleCertService to update and get the role certificates from Athenz.
+func NewRoleCertService(cfg config.Config, token ntokend.TokenProvider) (RoleCertService, error) {
+	rc := cfg.RoleCert
+	if !rc.Enable {
+		return nil, ErrDisabled
+	}
+
+	var (
+		err           error
+		expiry        time.Duration
+		refreshPeriod = defaultRoleCertRefreshPeriod
+		expiryMargin  = defaultRoleCertExpiryMargin
+	)
+	if rc.Expiry != "" {
+		if expiry, err = time.ParseDuration(rc.Expiry); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Expiry: "+err.Error())
+		}
+	}
+	if rc.RefreshPeriod != "" {
+		if refreshPeriod, err = time.ParseDuration(rc.RefreshPeriod); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshPeriod: "+err.Error())
+		}
+	}
+	if rc.ExpiryMargin != "" {
+		if expiryMargin, err = time.ParseDuration(rc.ExpiryMargin); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "ExpiryMargin: "+err.Error())
+		}
+	}
+
+	r := &roleCertService{
+		cfg:          rc,
+		token:        token,
+		expiry:       int64(expiry / time.Minute),
+		expiryMargin: expiryMargin,
+	}
+	if r.certs, err = newCredentialCache("role certificate", metrics.RoleCert, refreshPeriod, rc.Retry, rc.RefreshPool, r.fetchRoleCert); err != nil {
+		return nil, err
+	}
+	// only the role certificates entering the expiry margin before the next refresh are refreshed
+	r.certs.refreshable = func(entry credentialRequest) bool {
+		return entry.(*roleCertCache).notAfter.Before(fastime.Now().Add(refreshPeriod + expiryMargin))
+	}
+
+	if err := validateKeyType(rc.KeyType, rc.KeySize); err != nil {
+		return nil, err
+	}
+	// ZTS identifies the principal of the role certificate by the email SAN
+	if rc.DNSSuffix == "" {
+		return nil, errors.Wrap(ErrInvalidSetting, "DNSSuffix is empty")
+	}
+
+	domain := config.GetActualValue(cfg.NToken.AthenzDomain)
+	service := config.GetActualValue(cfg.NToken.ServiceName)
+	if !isValidDomain(domain) {
+		return nil, errors.Wrap(ErrInvalidParameter, "invalid Athenz domain")
+	}
+
+	// the csr is signed by a new private key for each role certificate if the key type is set
+	var keySigner *signer
+	if rc.KeyType == "" {
+		keyBytes, err := ioutil.ReadFile(config.GetActualValue(cfg.NToken.PrivateKeyPath))
+		if err != nil {
+			return nil, ErrLoadPrivateKey
+		}
+		if keySigner, err = newSigner(keyBytes); err != nil {
+			return nil, ErrFailedToInitialize
+		}
+	}
+
+	if r.client, err = ztsClient(rc.AthenzURL, rc.AthenzCAPath); err != nil {
+		return nil, ErrFailedToInitialize
+	}
+
+	r.principal = fmt.Sprintf("%s.%s", domain, service)
+	r.subj = pkix.Name{
+		OrganizationalUnit: []string{rc.Subject.OrganizationalUnit},
+		Organization:       []string{rc.Subject.Organization},
+		Province:           []string{rc.Subject.Province},
+		Country:            []string{rc.Subject.Country},
+	}
+	r.signer = keySigner
+	return r, nil
+}
+
+// StartRoleCertUpdater returns the error channel of the background refreshes.
+// This function will periodically refresh the role certificates expiring before the next check.
+func (r *roleCertService) StartRoleCertUpdater(ctx context.Context) <-chan error {
+	return r.certs.start(ctx)
+}
+
+// InheritRoleCertCache copies the role certificates cached in src, which are not expired yet, to dst.
+// It returns the number of role certificates copied. Nothing is copied if either service is not created by NewRoleCertService.
+func InheritRoleCertCache(ctx context.Context, dst, src RoleCertService) int {
+	d, ok := dst.(*roleCertService)
+	if !ok {
+		return 0
+	}
+	s, ok := src.(*roleCertService)
+	if !ok {
+		return 0
+	}
+	return d.certs.inherit(ctx, s.certs)
+}
+
+// GetRoleCertProvider returns a function pointer to get the role certificate.
+func (r *roleCertService) GetRoleCertProvider() RoleCertProvider {
+	return r.getRoleCert
+}
+
+// Readiness returns the readiness of the role certificate service based on the result of the last cache refresh.
+func (r *roleCertService) Readiness() Readiness {
+	return r.certs.readiness()
+}
+
+// getRoleCert returns the role certificate and its private key.
+// This function will return the role certificate stored inside the cache unless it is within the expiry margin,
+// or fetch the role certificate from Athenz. The cached role certificate is returned if the fetch is failed and it is not expired yet.
+func (r *roleCertService) getRoleCert(ctx context.Context, domain, role string) ([]byte, []byte, error) {
+	val, ok := r.certs.cache.Get(roleCertKey(domain, role))
+	if ok {
+		cache := val.(*roleCertCache)
+		if cache.notAfter.Add(-r.expiryMargin).After(fastime.Now()) {
+			metrics.CacheHit(metrics.RoleCert)
+			return cache.cert, cache.key, nil
+		}
+	}
+	metrics.CacheMiss(metrics.RoleCert)
+
+	refreshed, err := r.certs.update(ctx, &roleCertCache{
+		domain: domain,
+		role:   role,
+	})
+	if err != nil {
+		if ok {
+			glg.Warnf("Cached role certificate is not expired. Return from cache. Error: %s", err.Error())
+			cache := val.(*roleCertCache)
+			return cache.cert, cache.key, nil
+		}
+		return nil, nil, err
+	}
+	cache := refreshed.(*roleCertCache)
+	return cache.cert, cache.key, nil
+}
+
+// RefreshRoleCertCache refreshes the cached role certificates, which enter the expiry margin before the next refresh, and returns the error channel.
+func (r *roleCertService) RefreshRoleCertCache(ctx context.Context) <-chan error {
+	return r.certs.refresh(ctx)
+}
+
+// fetchRoleCert requests the role certificate with a CSR identifying the role and the principal, and returns the cache entry of it and the duration until it expires.
+// P.S. Do not call fetchRoleCert() outside singleflight group.
+func (r *roleCertService) fetchRoleCert(ctx context.Context, req credentialRequest) (credentialRequest, time.Duration, error) {
+	domain, role := req.(*roleCertCache).domain, req.(*roleCertCache).role
+	keySigner, keyPEM := r.signer, []byte(nil)
+	if keySigner == nil {
+		var err error
+		if keySigner, keyPEM, err = generateKey(r.cfg.KeyType, r.cfg.KeySize); err != nil {
+			return nil, 0, errors.Wrap(err, "failed to generate private key")
+		}
+	}
+	csr, err := r.generateRoleCertCSR(keySigner, domain, role)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nToken, err := r.token()
+	if err != nil {
+		return nil, 0, err
+	}
+	// the client is copied, so that the concurrent requests do not share the credentials
+	client := *r.client
+	client.AddCredentials(r.cfg.PrincipalAuthHeader, nToken)
+
+	start := time.Now()
+	rc, err := client.PostRoleCertificateRequestExt(&zts.RoleCertificateRequest{
+		Csr:        csr,
+		ExpiryTime: r.expiry,
+	})
+	metrics.ObserveAthenzRequest(metrics.RoleCert, ztsStatusCode(err), start)
+	if re, ok := err.(rdl.ResourceError); ok {
+		return nil, 0, &UpstreamError{
+			Err:     ErrRoleCertRequestFailed,
+			Code:    re.Code,
+			Message: re.Message,
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	block, _ := pem.Decode([]byte(rc.X509Certificate))
+	if block == nil {
+		return nil, 0, ErrInvalidCert
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, 0, ErrInvalidCert
+	}
+
+	return &roleCertCache{
+		cert:     []byte(rc.X509Certificate),
+		key:      keyPEM,
+		domain:   domain,
+		role:     role,
+		notAfter: certificate.NotAfter,
+	}, certificate.NotAfter.Sub(fastime.Now()), nil
+}
+
+// generateRoleCertCSR returns the CSR of the role certificate.
+// The common name is the role name "<domain>:role.<role>", and the SAN contains the SPIFFE ID of the role, the principal URI,
+// and the email of the principal "<principal>@<DNS suffix>".
+func (r *roleCertService) generateRoleCertCSR(keySigner *signer, domain, role string) (string, error) {
+	subj := r.subj
+	subj.CommonName = roleCertKey(domain, role)
+
+	template := x509.CertificateRequest{
+		Subject:            subj,
+		SignatureAlgorithm: keySigner.algorithm,
+	}
+	// the SPIFFE ID must be the first URI
+	for _, u := range []string{
+		fmt.Sprintf("spiffe://%s/ra/%s", domain, role),
+		fmt.Sprintf("athenz://principal/%s", r.principal),
+	} {
+		uri, err := url.Parse(u)
+		if err != nil {
+			return "", err
+		}
+		template.URIs = append(template.URIs, uri)
+	}
+	template.EmailAddresses = []string{fmt.Sprintf("%s@%s", r.principal, r.cfg.DNSSuffix)}
+	return createCSR(keySigner, template)
+}
+
+// cacheKey returns the cache key of the role certificate.
+func (c *roleCertCache) cacheKey() string {
+	return roleCertKey(c.domain, c.role)
+}
+
+// String returns the request of the role certificate in the error messages.
+func (c *roleCertCache) String() string {
+	return fmt.Sprintf("domain: %s, role: %s", c.domain, c.role)
+}
+
+// roleCertKey returns the Athenz role name, which is the cache key of the role certificate.
+func roleCertKey(domain, role string) string {
+	return fmt.Sprintf("%s:role.%s", domain, role)
+}
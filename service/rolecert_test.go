@@ -0,0 +1,338 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/pkg/errors"
+)
+
+func TestNewRoleCertService(t *testing.T) {
+	token := func() (string, error) {
+		return "dummyNToken", nil
+	}
+	ntoken := config.NToken{
+		AthenzDomain:   "dummyDomain",
+		ServiceName:    "dummyService",
+		PrivateKeyPath: "../test/data/dummyServer.key",
+	}
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr error
+	}{
+		{
+			name:    "Check disabled",
+			cfg:     config.Config{NToken: ntoken},
+			wantErr: ErrDisabled,
+		},
+		{
+			name: "Check invalid expiry margin",
+			cfg: config.Config{
+				NToken: ntoken,
+				RoleCert: config.RoleCert{
+					Enable:       true,
+					ExpiryMargin: "invalid",
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `ExpiryMargin: time: invalid duration "invalid"`),
+		},
+		{
+			name: "Check invalid key type",
+			cfg: config.Config{
+				NToken: ntoken,
+				RoleCert: config.RoleCert{
+					Enable:  true,
+					KeyType: "DSA",
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidParameter, "KeyType: unsupported key type DSA"),
+		},
+		{
+			name: "Check private key not found",
+			cfg: config.Config{
+				NToken: config.NToken{
+					AthenzDomain:   "dummyDomain",
+					ServiceName:    "dummyService",
+					PrivateKeyPath: "../test/data/non_exist.key",
+				},
+				RoleCert: config.RoleCert{
+					Enable:    true,
+					DNSSuffix: "athenz.cloud",
+				},
+			},
+			wantErr: ErrLoadPrivateKey,
+		},
+		{
+			name: "Check empty DNS suffix",
+			cfg: config.Config{
+				NToken: ntoken,
+				RoleCert: config.RoleCert{
+					Enable: true,
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "DNSSuffix is empty"),
+		},
+		{
+			name: "Check success",
+			cfg: config.Config{
+				NToken: ntoken,
+				RoleCert: config.RoleCert{
+					Enable:    true,
+					AthenzURL: "athenz.io/zts/v1",
+					Expiry:    "24h",
+					DNSSuffix: "athenz.cloud",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRoleCertService(tt.cfg, token)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("NewRoleCertService() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			r := got.(*roleCertService)
+			if r.expiry != 24*60 || r.certs.refreshPeriod != time.Hour || r.certs.refreshPool == nil || r.expiryMargin != time.Hour || r.principal != "dummyDomain.dummyService" || r.signer == nil {
+				t.Errorf("NewRoleCertService() = %+v", r)
+			}
+		})
+	}
+}
+
+// newTestRoleCertService returns the role certificate service requesting to the server, which signs the CSR with a test CA
+// valid for the given duration, or calls h if it is not nil.
+func newTestRoleCertService(t *testing.T, rc config.RoleCert, validity time.Duration, h http.HandlerFunc) (*roleCertService, *int64) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	var cnt int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&cnt, 1)
+		if h != nil {
+			h(w, r)
+			return
+		}
+		if r.URL.Path != "/rolecert" || r.Header.Get("Athenz-Principal") != "dummyNToken" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var req zts.RoleCertificateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		block, _ := pem.Decode([]byte(req.Csr))
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber:   big.NewInt(2),
+			Subject:        csr.Subject,
+			URIs:           csr.URIs,
+			EmailAddresses: csr.EmailAddresses,
+			NotBefore:      time.Now().Add(-time.Minute),
+			NotAfter:       time.Now().Add(validity),
+		}, ca, csr.PublicKey, caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(zts.RoleCertificate{
+			X509Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	rc.Enable = true
+	rc.AthenzURL = srv.URL
+	rc.PrincipalAuthHeader = "Athenz-Principal"
+	rc.DNSSuffix = "athenz.cloud"
+	rc.Retry = config.Retry{
+		Attempts: 1,
+		Delay:    "1ms",
+	}
+	svc, err := NewRoleCertService(config.Config{
+		NToken: config.NToken{
+			AthenzDomain:   "dummyDomain",
+			ServiceName:    "dummyService",
+			PrivateKeyPath: "../test/data/dummyServer.key",
+		},
+		RoleCert: rc,
+	}, func() (string, error) {
+		return "dummyNToken", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := svc.(*roleCertService)
+	r.client.Transport = srv.Client().Transport
+	return r, &cnt
+}
+
+func Test_roleCertService_getRoleCert(t *testing.T) {
+	tests := []struct {
+		name      string
+		rc        config.RoleCert
+		wantEmail []string
+		wantKey   bool
+	}{
+		{
+			name:      "Check role certificate signed by the private key of the N-token",
+			wantEmail: []string{"dummyDomain.dummyService@athenz.cloud"},
+		},
+		{
+			name: "Check role certificate with generated key",
+			rc: config.RoleCert{
+				KeyType: "ECDSA",
+			},
+			wantEmail: []string{"dummyDomain.dummyService@athenz.cloud"},
+			wantKey:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, cnt := newTestRoleCertService(t, tt.rc, 24*time.Hour, nil)
+			for i := 0; i < 2; i++ {
+				cert, key, err := r.GetRoleCertProvider()(context.Background(), "domain", "reader")
+				if err != nil {
+					t.Fatalf("getRoleCert() error = %v", err)
+				}
+				if (key != nil) != tt.wantKey {
+					t.Errorf("getRoleCert() key = %s, want key %v", key, tt.wantKey)
+				}
+				block, _ := pem.Decode(cert)
+				c, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.Subject.CommonName != "domain:role.reader" || len(c.URIs) != 2 ||
+					c.URIs[0].String() != "spiffe://domain/ra/reader" || c.URIs[1].String() != "athenz://principal/dummyDomain.dummyService" ||
+					fmt.Sprint(c.EmailAddresses) != fmt.Sprint(tt.wantEmail) {
+					t.Errorf("getRoleCert() subject = %v, uris = %v, emails = %v", c.Subject, c.URIs, c.EmailAddresses)
+				}
+			}
+			if c := atomic.LoadInt64(cnt); c != 1 {
+				t.Errorf("getRoleCert() request count = %v, want 1", c)
+			}
+		})
+	}
+}
+
+func Test_roleCertService_getRoleCert_error(t *testing.T) {
+	r, cnt := newTestRoleCertService(t, config.RoleCert{}, 24*time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"code":403,"message":"forbidden"}`)
+	})
+	_, _, err := r.getRoleCert(context.Background(), "domain", "reader")
+	var ue *UpstreamError
+	if !errors.As(err, &ue) || ue.Code != http.StatusForbidden || !errors.Is(err, ErrRoleCertRequestFailed) {
+		t.Errorf("getRoleCert() error = %v, want upstream error", err)
+	}
+	if c := atomic.LoadInt64(cnt); c != 1 {
+		t.Errorf("getRoleCert() request count = %v, want 1", c)
+	}
+}
+
+func Test_roleCertService_RefreshRoleCertCache(t *testing.T) {
+	// the role certificates are within the expiry margin, and refreshed on every request
+	r, cnt := newTestRoleCertService(t, config.RoleCert{}, 30*time.Minute, nil)
+	for _, role := range []string{"reader", "writer"} {
+		if _, _, err := r.getRoleCert(context.Background(), "domain", role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for err := range r.RefreshRoleCertCache(context.Background()) {
+		t.Errorf("RefreshRoleCertCache() error = %v", err)
+	}
+	if c := atomic.LoadInt64(cnt); c != 4 {
+		t.Errorf("RefreshRoleCertCache() request count = %v, want 4", c)
+	}
+	if rd := r.Readiness(); !rd.Ready {
+		t.Errorf("Readiness() = %+v, want ready", rd)
+	}
+
+	// the cached role certificates are returned while they are not expired
+	failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+	r.client.URL = failing.URL
+	var errs int
+	for range r.RefreshRoleCertCache(context.Background()) {
+		errs++
+	}
+	if errs != 4 {
+		t.Errorf("RefreshRoleCertCache() errors = %v, want 4", errs)
+	}
+	if rd := r.Readiness(); rd.Ready {
+		t.Errorf("Readiness() = %+v, want not ready", rd)
+	}
+	if _, _, err := r.getRoleCert(context.Background(), "domain", "reader"); err != nil {
+		t.Errorf("getRoleCert() error = %v, want the cached role certificate", err)
+	}
+}
+
+func TestInheritRoleCertCache(t *testing.T) {
+	src, _ := newTestRoleCertService(t, config.RoleCert{}, 24*time.Hour, nil)
+	if _, _, err := src.getRoleCert(context.Background(), "domain", "reader"); err != nil {
+		t.Fatal(err)
+	}
+	dst, cnt := newTestRoleCertService(t, config.RoleCert{}, 24*time.Hour, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	if n := InheritRoleCertCache(context.Background(), dst, src); n != 1 {
+		t.Errorf("InheritRoleCertCache() = %v, want 1", n)
+	}
+	if _, _, err := dst.getRoleCert(context.Background(), "domain", "reader"); err != nil || atomic.LoadInt64(cnt) != 0 {
+		t.Errorf("getRoleCert() error = %v, request count = %v, want the inherited role certificate", err, atomic.LoadInt64(cnt))
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultScheduleRatio represents the default fraction of the remaining lifetime after which the token is refreshed.
+	defaultScheduleRatio = 0.75
+
+	// defaultScheduleJitter represents the default random variation of the refresh time as a fraction of the remaining lifetime.
+	defaultScheduleJitter = 0.1
+)
+
+// refreshScheduler schedules the refresh of each cached token at a fraction of its remaining lifetime with jitter,
+// so that the short-lived tokens are refreshed before they expire, the long-lived tokens are not refreshed too often,
+// and the refreshes are spread out. It is safe for concurrent use.
+type refreshScheduler struct {
+	ratio  float64
+	jitter float64
+
+	mu    sync.Mutex
+	queue scheduleQueue
+	items map[string]*scheduleItem
+
+	// counter counts the results of the scheduled refreshes since the last call of results.
+	counter *refreshCounter
+
+	// wake notifies the running scheduler that the earliest refresh time may be changed.
+	wake chan struct{}
+}
+
+// scheduleItem represents the refresh time of a cached token.
+type scheduleItem struct {
+	key   string
+	due   time.Time
+	index int
+}
+
+// scheduleQueue implements heap.Interface of the scheduled refreshes, ordered by the refresh time.
+type scheduleQueue []*scheduleItem
+
+// newRefreshScheduler returns the refresh scheduler of the configuration, or nil if it is disabled.
+func newRefreshScheduler(cfg config.Schedule) (*refreshScheduler, error) {
+	if !cfg.Enable {
+		return nil, nil
+	}
+
+	s := &refreshScheduler{
+		ratio:   defaultScheduleRatio,
+		jitter:  defaultScheduleJitter,
+		items:   make(map[string]*scheduleItem),
+		counter: new(refreshCounter),
+		wake:    make(chan struct{}, 1),
+	}
+	if cfg.Ratio != 0 {
+		if cfg.Ratio < 0 || cfg.Ratio > 1 {
+			return nil, errors.Wrap(ErrInvalidSetting, "Schedule.Ratio is not between 0 and 1")
+		}
+		s.ratio = cfg.Ratio
+	}
+	if cfg.Jitter != 0 {
+		if cfg.Jitter < 0 || cfg.Jitter > 1 {
+			return nil, errors.Wrap(ErrInvalidSetting, "Schedule.Jitter is not between 0 and 1")
+		}
+		s.jitter = cfg.Jitter
+	}
+	return s, nil
+}
+
+// schedule schedules the refresh of the token of the key expiring at exp, replacing the previous schedule of the key.
+func (s *refreshScheduler) schedule(key string, exp time.Time) {
+	if s == nil {
+		return
+	}
+
+	now := fastime.Now()
+	life := float64(exp.Sub(now))
+	if life <= 0 {
+		s.remove(key)
+		return
+	}
+	d := life * s.ratio
+	if s.jitter > 0 {
+		d += life * s.jitter * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		d = 0
+	} else if d > life {
+		d = life
+	}
+	due := now.Add(time.Duration(d))
+
+	s.mu.Lock()
+	if it, ok := s.items[key]; ok {
+		it.due = due
+		heap.Fix(&s.queue, it.index)
+	} else {
+		it = &scheduleItem{
+			key: key,
+			due: due,
+		}
+		heap.Push(&s.queue, it)
+		s.items[key] = it
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove cancels the scheduled refresh of the key.
+func (s *refreshScheduler) remove(key string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if it, ok := s.items[key]; ok {
+		heap.Remove(&s.queue, it.index)
+		delete(s.items, key)
+	}
+}
+
+// scheduled returns whether the refresh of the key is scheduled.
+func (s *refreshScheduler) scheduled(key string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.items[key]
+	return ok
+}
+
+// run calls refresh with the key of each scheduled refresh when it is due, until ctx is done.
+// The refreshes run concurrently, and run returns after all of them are finished.
+func (s *refreshScheduler) run(ctx context.Context, refresh func(ctx context.Context, key string)) {
+	if s == nil {
+		return
+	}
+
+	wg := new(sync.WaitGroup)
+	defer wg.Wait()
+	for {
+		var due <-chan time.Time
+		var timer *time.Timer
+		if d, ok := s.next(); ok {
+			timer = time.NewTimer(d)
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.wake:
+		case <-due:
+			for _, key := range s.popDue() {
+				wg.Add(1)
+				go func(key string) {
+					defer wg.Done()
+					refresh(ctx, key)
+				}(key)
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// record counts the result of a scheduled refresh.
+func (s *refreshScheduler) record(errs []error, maxRetry int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	c := s.counter
+	s.mu.Unlock()
+	c.add(errs, maxRetry)
+}
+
+// results returns the counter of the scheduled refreshes since the last call, and starts a new counter.
+// It returns an empty counter if the scheduler is disabled.
+func (s *refreshScheduler) results() *refreshCounter {
+	if s == nil {
+		return new(refreshCounter)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.counter
+	s.counter = new(refreshCounter)
+	return c
+}
+
+// next returns the duration until the earliest scheduled refresh, and whether any refresh is scheduled.
+func (s *refreshScheduler) next() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return 0, false
+	}
+	d := s.queue[0].due.Sub(fastime.Now())
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// popDue removes the scheduled refreshes which are due, and returns their keys.
+func (s *refreshScheduler) popDue() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	now := fastime.Now()
+	for len(s.queue) > 0 && !s.queue[0].due.After(now) {
+		it := heap.Pop(&s.queue).(*scheduleItem)
+		delete(s.items, it.key)
+		keys = append(keys, it.key)
+	}
+	return keys
+}
+
+func (q scheduleQueue) Len() int { return len(q) }
+
+func (q scheduleQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x interface{}) {
+	it := x.(*scheduleItem)
+	it.index = len(*q)
+	*q = append(*q, it)
+}
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return it
+}
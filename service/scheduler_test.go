@@ -0,0 +1,240 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+)
+
+func Test_newRefreshScheduler(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.Schedule
+		wantNil    bool
+		wantErr    bool
+		wantRatio  float64
+		wantJitter float64
+	}{
+		{
+			name:    "Check disabled",
+			wantNil: true,
+		},
+		{
+			name: "Check default",
+			cfg: config.Schedule{
+				Enable: true,
+			},
+			wantRatio:  defaultScheduleRatio,
+			wantJitter: defaultScheduleJitter,
+		},
+		{
+			name: "Check custom",
+			cfg: config.Schedule{
+				Enable: true,
+				Ratio:  0.5,
+				Jitter: 0.2,
+			},
+			wantRatio:  0.5,
+			wantJitter: 0.2,
+		},
+		{
+			name: "Check invalid ratio",
+			cfg: config.Schedule{
+				Enable: true,
+				Ratio:  1.5,
+			},
+			wantNil: true,
+			wantErr: true,
+		},
+		{
+			name: "Check invalid jitter",
+			cfg: config.Schedule{
+				Enable: true,
+				Jitter: -0.1,
+			},
+			wantNil: true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newRefreshScheduler(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newRefreshScheduler() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("newRefreshScheduler() = %v, want nil: %v", got, tt.wantNil)
+				return
+			}
+			if got == nil {
+				return
+			}
+			if got.ratio != tt.wantRatio {
+				t.Errorf("newRefreshScheduler() ratio = %v, want %v", got.ratio, tt.wantRatio)
+			}
+			if got.jitter != tt.wantJitter {
+				t.Errorf("newRefreshScheduler() jitter = %v, want %v", got.jitter, tt.wantJitter)
+			}
+		})
+	}
+}
+
+func Test_refreshScheduler_schedule(t *testing.T) {
+	s, err := newRefreshScheduler(config.Schedule{
+		Enable: true,
+		Ratio:  0.5,
+		Jitter: 0.2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := fastime.Now()
+	s.schedule("short", now.Add(time.Minute*5))
+	s.schedule("long", now.Add(time.Hour))
+	s.schedule("expired", now.Add(-time.Second))
+
+	if s.scheduled("expired") {
+		t.Error("scheduled() expired = true, want false")
+	}
+	for _, tt := range []struct {
+		key      string
+		min, max time.Duration
+	}{
+		{key: "short", min: time.Second * 90, max: time.Second * 210},
+		{key: "long", min: time.Minute * 18, max: time.Minute * 42},
+	} {
+		it, ok := s.items[tt.key]
+		if !ok {
+			t.Errorf("scheduled() %s = false, want true", tt.key)
+			continue
+		}
+		if d := it.due.Sub(now); d < tt.min || d > tt.max {
+			t.Errorf("schedule() %s due in %v, want between %v and %v", tt.key, d, tt.min, tt.max)
+		}
+	}
+	if got := s.queue[0].key; got != "short" {
+		t.Errorf("schedule() earliest = %v, want short", got)
+	}
+
+	// rescheduling replaces the previous schedule
+	s.schedule("short", now.Add(time.Hour*10))
+	if got := s.queue[0].key; got != "long" {
+		t.Errorf("schedule() earliest after reschedule = %v, want long", got)
+	}
+
+	s.remove("long")
+	if s.scheduled("long") {
+		t.Error("scheduled() long after remove = true, want false")
+	}
+	if len(s.queue) != 1 || len(s.items) != 1 {
+		t.Errorf("remove() queue length = %d, items length = %d, want 1", len(s.queue), len(s.items))
+	}
+}
+
+func Test_refreshScheduler_nil(t *testing.T) {
+	var s *refreshScheduler
+	s.schedule("key", fastime.Now().Add(time.Hour))
+	s.remove("key")
+	s.record(nil, 0)
+	s.run(context.Background(), func(context.Context, string) {
+		t.Error("run() refreshed with nil scheduler")
+	})
+	if s.scheduled("key") {
+		t.Error("scheduled() = true, want false")
+	}
+	if got := s.results(); got == nil {
+		t.Error("results() = nil, want empty counter")
+	}
+}
+
+func Test_refreshScheduler_run(t *testing.T) {
+	s, err := newRefreshScheduler(config.Schedule{
+		Enable: true,
+		Ratio:  0.5,
+		Jitter: 0.01,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mu := new(sync.Mutex)
+	var got []string
+	refreshed := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, func(ctx context.Context, key string) {
+			mu.Lock()
+			got = append(got, key)
+			mu.Unlock()
+			refreshed <- struct{}{}
+		})
+	}()
+
+	now := fastime.Now()
+	s.schedule("later", now.Add(time.Millisecond*400))
+	s.schedule("sooner", now.Add(time.Millisecond*100))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-refreshed:
+		case <-time.After(time.Second * 2):
+			t.Fatal("run() did not refresh the scheduled keys")
+		}
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || got[0] != "sooner" || got[1] != "later" {
+		t.Errorf("run() refreshed %v, want [sooner later]", got)
+	}
+	if s.scheduled("sooner") || s.scheduled("later") {
+		t.Error("run() did not remove the refreshed keys from the schedule")
+	}
+}
+
+func Test_refreshScheduler_results(t *testing.T) {
+	s, err := newRefreshScheduler(config.Schedule{
+		Enable: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.record(nil, 1)
+	s.record([]error{ErrRoleTokenRequestFailed, ErrRoleTokenRequestFailed}, 1)
+
+	c := s.results()
+	if c.total != 2 || c.failed != 1 {
+		t.Errorf("results() total = %d, failed = %d, want 2, 1", c.total, c.failed)
+	}
+	if c = s.results(); c.total != 0 {
+		t.Errorf("results() after reset total = %d, want 0", c.total)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
 	"github.com/kpango/glg"
 )
 
@@ -43,6 +44,9 @@ type server struct {
 	hcsrv     *http.Server
 	hcrunning bool
 
+	// readiness returns the readiness of the subsystems for the readiness endpoint
+	readiness ReadinessChecker
+
 	cfg config.Server
 
 	// ShutdownDelay
@@ -64,6 +68,9 @@ const (
 
 	// CharsetUTF8 represents a UTF-8 charset for HTTP response "charset=UTF-8"
 	CharsetUTF8 = "charset=UTF-8"
+
+	// defaultMetricsEndpoint represents the default Prometheus metrics endpoint of the health check server
+	defaultMetricsEndpoint = "/metrics"
 )
 
 var (
@@ -77,6 +84,7 @@ var (
 //
 // The health check server is a http.Server instance, which the port number is read from "config.Server.HealthCheck.Port"
 // , and the handler is as follow - Handle HTTP GET request and always return HTTP Status OK (200) response.
+// The health check server also exports the Prometheus metrics on "config.Server.HealthCheck.MetricsEndpoint".
 func NewServer(opts ...Option) Server {
 	var err error
 
@@ -94,7 +102,7 @@ func NewServer(opts ...Option) Server {
 	if s.hcSrvEnable() {
 		s.hcsrv = &http.Server{
 			Addr:    fmt.Sprintf("%s:%d", s.cfg.HealthCheck.Address, s.cfg.HealthCheck.Port),
-			Handler: createHealthCheckServiceMux(s.cfg.HealthCheck.Endpoint),
+			Handler: createHealthCheckServiceMux(s.cfg.HealthCheck, s.readiness),
 		}
 		s.hcsrv.SetKeepAlivesEnabled(true)
 	}
@@ -239,14 +247,31 @@ func (s *server) apiShutdown(ctx context.Context) error {
 }
 
 // createHealthCheckServiceMux return a *http.ServeMux object
-// The function will register the health check server handler for given pattern, and return
-func createHealthCheckServiceMux(pattern string) *http.ServeMux {
+// The function will register the health check server handler for given pattern, and the Prometheus metrics handler for given metricsPattern (default: "/metrics"), and return
+func createHealthCheckServiceMux(cfg config.HealthCheck, check ReadinessChecker) *http.ServeMux {
+	metricsPattern := cfg.MetricsEndpoint
+	if metricsPattern == "" {
+		metricsPattern = defaultMetricsEndpoint
+	}
+	readinessPattern := cfg.ReadinessEndpoint
+	if readinessPattern == "" {
+		readinessPattern = defaultReadinessEndpoint
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc(pattern, handleHealthCheckRequest)
+	mux.HandleFunc(cfg.Endpoint, handleHealthCheckRequest)
+	if metricsPattern != cfg.Endpoint {
+		mux.Handle(metricsPattern, metrics.Handler())
+	}
+	// the conflicting endpoints are rejected by the configuration validation
+	if readinessPattern != cfg.Endpoint && readinessPattern != metricsPattern {
+		mux.HandleFunc(readinessPattern, handleReadinessRequest(check))
+	} else {
+		glg.Warnf("readiness endpoint %s is not registered, since it conflicts with the other endpoint", readinessPattern)
+	}
 	return mux
 }
 
-// handleHealthCheckRequest is a handler function for and health check request, which always a HTTP Status OK (200) result
 func handleHealthCheckRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		w.WriteHeader(http.StatusOK)
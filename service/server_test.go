@@ -533,7 +533,8 @@ func Test_server_ListenAndServe(t *testing.T) {
 
 func Test_server_createHealthCheckServiceMux(t *testing.T) {
 	type args struct {
-		pattern string
+		cfg   config.HealthCheck
+		check ReadinessChecker
 	}
 	type test struct {
 		name       string
@@ -547,7 +548,9 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 			return test{
 				name: "Test create server mux",
 				args: args{
-					pattern: ":8080",
+					cfg: config.HealthCheck{
+						Endpoint: ":8080",
+					},
 				},
 				checkFunc: func(got *http.ServeMux) error {
 					if got == nil {
@@ -557,6 +560,82 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 				},
 			}
 		}(),
+		func() test {
+			return test{
+				name: "Test create server mux with default metrics endpoint",
+				args: args{
+					cfg: config.HealthCheck{
+						Endpoint: "/healthz",
+					},
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					rw := httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+					if rw.Code != http.StatusOK {
+						return fmt.Errorf("metrics status code is not correct, got: %v", rw.Code)
+					}
+					if body := rw.Body.String(); !strings.Contains(body, "go_goroutines") {
+						return fmt.Errorf("metrics body is not correct, got: %v", body)
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			return test{
+				name: "Test create server mux with custom metrics endpoint",
+				args: args{
+					cfg: config.HealthCheck{
+						Endpoint:        "/healthz",
+						MetricsEndpoint: "/custom-metrics",
+					},
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					rw := httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/custom-metrics", nil))
+					if rw.Code != http.StatusOK {
+						return fmt.Errorf("metrics status code is not correct, got: %v", rw.Code)
+					}
+					rw = httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+					if rw.Code != http.StatusNotFound {
+						return fmt.Errorf("default metrics endpoint should not be registered, got: %v", rw.Code)
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			return test{
+				name: "Test create server mux with readiness endpoint",
+				args: args{
+					cfg: config.HealthCheck{
+						Endpoint:          "/healthz",
+						ReadinessEndpoint: "/ready",
+					},
+					check: func() map[string]Readiness {
+						return map[string]Readiness{
+							"ntoken": {
+								Ready: true,
+							},
+						}
+					},
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					rw := httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/ready", nil))
+					if rw.Code != http.StatusOK {
+						return fmt.Errorf("readiness status code is not correct, got: %v", rw.Code)
+					}
+					rw = httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+					if rw.Code != http.StatusNotFound {
+						return fmt.Errorf("default readiness endpoint should not be registered, got: %v", rw.Code)
+					}
+					return nil
+				},
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -576,7 +655,7 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 				}
 			}
 
-			got := createHealthCheckServiceMux(tt.args.pattern)
+			got := createHealthCheckServiceMux(tt.args.cfg, tt.args.check)
 			if err := tt.checkFunc(got); err != nil {
 				t.Errorf("server.listenAndServeAPI() Error = %v", err)
 			}
@@ -0,0 +1,452 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSnapshotPeriod represents the default duration between each cache snapshot.
+	defaultSnapshotPeriod = time.Minute * 5
+
+	// snapshotFileMode represents the file permission of the snapshot file, which contains the credentials.
+	snapshotFileMode os.FileMode = 0600
+
+	// snapshotVersion represents the format version of the snapshot file. The snapshot of the other versions is ignored.
+	snapshotVersion = 1
+
+	// snapshotKeyInfo represents the HKDF context label of the snapshot encryption key,
+	// so that the same key file does not derive the same key for any other purpose.
+	snapshotKeyInfo = "athenz-client-sidecar cache snapshot v1"
+)
+
+var (
+	// ErrInvalidSnapshot represents an error when the snapshot file cannot be decrypted or decoded.
+	ErrInvalidSnapshot = errors.New("Invalid cache snapshot")
+)
+
+// CacheSnapshot saves the role token, access token and svccert caches to an encrypted file, and restores them on startup.
+type CacheSnapshot struct {
+	path   string
+	period time.Duration
+	aead   cipher.AEAD
+
+	// mu serializes the writes of the snapshot file.
+	mu sync.Mutex
+}
+
+// SnapshotTarget represents the services of an identity whose caches are saved to the snapshot.
+type SnapshotTarget struct {
+	// Principal identifies the credentials to fetch the cached entries. The entries are restored only to the target with the same principal.
+	Principal string
+	Access    AccessService
+	Role      RoleService
+	SvcCert   SvcCertService
+}
+
+// snapshotData represents the decrypted content of the snapshot file.
+type snapshotData struct {
+	Version    int                          `json:"version"`
+	Identities map[string]*identitySnapshot `json:"identities"`
+}
+
+// identitySnapshot represents the cached entries of an identity. The default identity has the empty name.
+type identitySnapshot struct {
+	Principal    string             `json:"principal"`
+	RoleTokens   []roleTokenEntry   `json:"roleTokens,omitempty"`
+	AccessTokens []accessTokenEntry `json:"accessTokens,omitempty"`
+	SvcCert      *svcCertEntry      `json:"svcCert,omitempty"`
+}
+
+// roleTokenEntry represents a role token cache entry. Expiry is the cache expiry in Unix nanoseconds.
+type roleTokenEntry struct {
+	Key               string     `json:"key"`
+	Token             *RoleToken `json:"token"`
+	Domain            string     `json:"domain"`
+	Role              string     `json:"role"`
+	ProxyForPrincipal string     `json:"proxyForPrincipal"`
+	MinExpiry         int64      `json:"minExpiry"`
+	MaxExpiry         int64      `json:"maxExpiry"`
+	Expiry            int64      `json:"expiry"`
+}
+
+// accessTokenEntry represents an access token cache entry. Expiry is the cache expiry in Unix nanoseconds.
+type accessTokenEntry struct {
+	Key               string               `json:"key"`
+	Token             *AccessTokenResponse `json:"token"`
+	Domain            string               `json:"domain"`
+	Role              string               `json:"role"`
+	ProxyForPrincipal string               `json:"proxyForPrincipal"`
+	ExpiresIn         int64                `json:"expiresIn"`
+	Expiry            int64                `json:"expiry"`
+}
+
+// svcCertEntry represents the svccert cache. Expiry is the cache expiry in Unix nanoseconds.
+type svcCertEntry struct {
+	Cert   []byte `json:"cert"`
+	Key    []byte `json:"key,omitempty"`
+	Expiry int64  `json:"expiry"`
+}
+
+// NewCacheSnapshot returns a CacheSnapshot with the encryption key derived from the content of the key file by HKDF-SHA256.
+func NewCacheSnapshot(cfg config.CacheSnapshot) (*CacheSnapshot, error) {
+	if !cfg.Enable {
+		return nil, ErrDisabled
+	}
+	if cfg.Path == "" {
+		return nil, errors.Wrap(ErrInvalidSetting, "Path is empty")
+	}
+
+	period := defaultSnapshotPeriod
+	if cfg.Period != "" {
+		var err error
+		if period, err = time.ParseDuration(cfg.Period); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Period: "+err.Error())
+		}
+		if period <= 0 {
+			return nil, errors.Wrap(ErrInvalidSetting, "Period: must be positive")
+		}
+	}
+
+	secret, err := ioutil.ReadFile(config.GetActualValue(cfg.KeyPath))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, "KeyPath: "+err.Error())
+	}
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) == 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "KeyPath: the key file is empty")
+	}
+
+	block, err := aes.NewCipher(hkdfSHA256(secret, nil, []byte(snapshotKeyInfo), 32))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, err.Error())
+	}
+
+	return &CacheSnapshot{
+		path:   config.GetActualValue(cfg.Path),
+		period: period,
+		aead:   aead,
+	}, nil
+}
+
+// hkdfSHA256 derives a key of the length from the secret by HKDF-SHA256 (RFC 5869), with the salt and the context info.
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	// extract
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	// expand
+	okm := make([]byte, 0, length+sha256.Size)
+	var t []byte
+	for i := byte(1); len(okm) < length; i++ {
+		expander := hmac.New(sha256.New, prk)
+		expander.Write(t)
+		expander.Write(info)
+		expander.Write([]byte{i})
+		t = expander.Sum(nil)
+		okm = append(okm, t...)
+	}
+	return okm[:length]
+}
+
+// StartSnapshotUpdater saves the caches of the targets periodically, and once more when the context is canceled.
+// The targets function is called on each snapshot, since the services are replaced on reload.
+// The returned error channel is closed after the last snapshot.
+func (s *CacheSnapshot) StartSnapshotUpdater(ctx context.Context, targets func() map[string]SnapshotTarget) <-chan error {
+	glg.Info("Starting cache snapshot updater")
+
+	ech := make(chan error, 100)
+	go func() {
+		defer close(ech)
+
+		ticker := time.NewTicker(s.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				glg.Info("Stopping cache snapshot updater...")
+				// the caches are still readable after the context is canceled
+				if err := s.Save(context.Background(), targets()); err != nil {
+					ech <- errors.Wrap(err, "error save cache snapshot")
+				}
+				return
+			case <-ticker.C:
+				if err := s.Save(ctx, targets()); err != nil {
+					ech <- errors.Wrap(err, "error save cache snapshot")
+				}
+			}
+		}
+	}()
+	return ech
+}
+
+// Save writes the caches of the targets, which are keyed by the identity name, to the snapshot file.
+func (s *CacheSnapshot) Save(ctx context.Context, targets map[string]SnapshotTarget) error {
+	data := &snapshotData{
+		Version:    snapshotVersion,
+		Identities: make(map[string]*identitySnapshot, len(targets)),
+	}
+	for name, t := range targets {
+		is := &identitySnapshot{
+			Principal: t.Principal,
+		}
+		if r, ok := t.Role.(*roleService); ok {
+			is.RoleTokens = r.snapshot(ctx)
+		}
+		if a, ok := t.Access.(*accessService); ok {
+			is.AccessTokens = a.snapshot(ctx)
+		}
+		if c, ok := t.SvcCert.(*svcCertService); ok {
+			is.SvcCert = c.snapshot()
+		}
+		data.Identities[name] = is
+	}
+
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeFileAtomic(s.path, s.aead.Seal(nonce, nonce, plain, nil), snapshotFileMode); err != nil {
+		return errors.Wrap(err, "failed to write cache snapshot")
+	}
+	glg.Debugf("cache snapshot is saved to %s", s.path)
+	return nil
+}
+
+// Restore loads the snapshot file and copies the entries, which are not expired yet, to the caches of the targets with the same identity name and principal.
+// It returns the number of entries restored. It is not an error if the snapshot file does not exist.
+func (s *CacheSnapshot) Restore(targets map[string]SnapshotTarget) (int, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	ns := s.aead.NonceSize()
+	if len(b) < ns {
+		return 0, errors.Wrap(ErrInvalidSnapshot, "too short")
+	}
+	plain, err := s.aead.Open(nil, b[:ns], b[ns:], nil)
+	if err != nil {
+		return 0, errors.Wrap(ErrInvalidSnapshot, err.Error())
+	}
+	var data snapshotData
+	if err := json.Unmarshal(plain, &data); err != nil {
+		return 0, errors.Wrap(ErrInvalidSnapshot, err.Error())
+	}
+	if data.Version != snapshotVersion {
+		glg.Warnf("cache snapshot version %d is not supported, ignored", data.Version)
+		return 0, nil
+	}
+
+	var n int
+	for name, t := range targets {
+		is, ok := data.Identities[name]
+		if !ok || is.Principal != t.Principal {
+			continue
+		}
+		if r, ok := t.Role.(*roleService); ok {
+			n += r.restore(is.RoleTokens)
+		}
+		if a, ok := t.Access.(*accessService); ok {
+			n += a.restore(is.AccessTokens)
+		}
+		if c, ok := t.SvcCert.(*svcCertService); ok && c.restore(is.SvcCert) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// snapshot returns the role token cache entries.
+func (r *roleService) snapshot(ctx context.Context) []roleTokenEntry {
+	var mu sync.Mutex
+	entries := make([]roleTokenEntry, 0, r.domainRoleCache.Len())
+	r.domainRoleCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		cd := val.(*cacheData)
+		mu.Lock()
+		entries = append(entries, roleTokenEntry{
+			Key:               key,
+			Token:             cd.token,
+			Domain:            cd.domain,
+			Role:              cd.role,
+			ProxyForPrincipal: cd.proxyForPrincipal,
+			MinExpiry:         cd.minExpiry,
+			MaxExpiry:         cd.maxExpiry,
+			Expiry:            exp,
+		})
+		mu.Unlock()
+		return true
+	})
+	return entries
+}
+
+// restore copies the role token cache entries, which are not expired yet, and returns the number of entries copied.
+// The entries are tracked by the cache limit and scheduled to refresh, the same as the fetched tokens.
+func (r *roleService) restore(entries []roleTokenEntry) int {
+	var n int
+	for _, e := range entries {
+		dur := time.Duration(e.Expiry - fastime.UnixNanoNow())
+		if e.Token == nil || dur <= 0 {
+			continue
+		}
+		r.domainRoleCache.SetWithExpire(e.Key, &cacheData{
+			token:             e.Token,
+			domain:            e.Domain,
+			role:              e.Role,
+			proxyForPrincipal: e.ProxyForPrincipal,
+			minExpiry:         e.MinExpiry,
+			maxExpiry:         e.MaxExpiry,
+		}, dur)
+		r.limitCache(e.Key)
+		r.scheduler.schedule(e.Key, time.Unix(0, e.Expiry))
+		n++
+	}
+	return n
+}
+
+// snapshot returns the access token cache entries.
+// The expiry of the access token is taken from the "exp" claim if the cache entry has no expiry.
+func (a *accessService) snapshot(ctx context.Context) []accessTokenEntry {
+	var mu sync.Mutex
+	entries := make([]accessTokenEntry, 0, a.tokenCache.Len())
+	a.tokenCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		cd := val.(*accessCacheData)
+		if exp <= 0 && cd.token != nil {
+			if claim := jwtExpiry(cd.token.AccessToken); claim > 0 {
+				exp = time.Unix(claim, 0).Add(-time.Minute).UnixNano()
+			}
+		}
+		mu.Lock()
+		entries = append(entries, accessTokenEntry{
+			Key:               key,
+			Token:             cd.token,
+			Domain:            cd.domain,
+			Role:              cd.role,
+			ProxyForPrincipal: cd.proxyForPrincipal,
+			ExpiresIn:         cd.expiresIn,
+			Expiry:            exp,
+		})
+		mu.Unlock()
+		return true
+	})
+	return entries
+}
+
+// restore copies the access token cache entries, which are not expired yet, and returns the number of entries copied.
+// The entries without expiry are not copied, since their validity is unknown.
+// The entries are tracked by the cache limit and scheduled to refresh, the same as the fetched tokens.
+func (a *accessService) restore(entries []accessTokenEntry) int {
+	var n int
+	for _, e := range entries {
+		dur := time.Duration(e.Expiry - fastime.UnixNanoNow())
+		if e.Token == nil || e.Expiry <= 0 || dur <= 0 {
+			continue
+		}
+		cd := &accessCacheData{
+			token:             e.Token,
+			domain:            e.Domain,
+			role:              e.Role,
+			proxyForPrincipal: e.ProxyForPrincipal,
+			expiresIn:         e.ExpiresIn,
+			expiry:            jwtExpiry(e.Token.AccessToken),
+		}
+		a.tokenCache.SetWithExpire(e.Key, cd, dur)
+		a.limitCache(e.Key)
+		if cd.expiry > 0 {
+			a.scheduler.schedule(e.Key, time.Unix(cd.expiry, 0))
+		}
+		n++
+	}
+	return n
+}
+
+// snapshot returns the svccert cache, or nil if the certificate is not fetched.
+func (s *svcCertService) snapshot() *svcCertEntry {
+	cache := s.certCache.Load().(certCache)
+	if cache.cert == nil {
+		return nil
+	}
+	return &svcCertEntry{
+		Cert:   cache.cert,
+		Key:    cache.key,
+		Expiry: cache.exp.UnixNano(),
+	}
+}
+
+// restore copies the svccert cache if it is not expired yet, and returns whether it is copied.
+func (s *svcCertService) restore(e *svcCertEntry) bool {
+	if e == nil || e.Cert == nil || e.Expiry <= fastime.UnixNanoNow() {
+		return false
+	}
+	s.certCache.Store(certCache{
+		cert: e.Cert,
+		key:  e.Key,
+		exp:  time.Unix(0, e.Expiry),
+	})
+	return true
+}
+
+// jwtExpiry returns the "exp" claim of the JWT without verifying the signature, or 0 if it is not found.
+func jwtExpiry(token string) int64 {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return 0
+	}
+	return claims.Exp
+}
@@ -0,0 +1,431 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/kpango/fastime"
+	"github.com/kpango/gache"
+	"github.com/pkg/errors"
+)
+
+// dummyJWT returns an unsigned JWT with the exp claim.
+func dummyJWT(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return "eyJhbGciOiJub25lIn0." + payload + ".signature"
+}
+
+// writeSnapshotKey writes the secret to a key file in dir and returns the file path.
+func writeSnapshotKey(t *testing.T, dir, secret string) string {
+	path := filepath.Join(dir, "snapshot-"+secret+".key")
+	if err := ioutil.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewCacheSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath := writeSnapshotKey(t, dir, "secret")
+	emptyKeyPath := filepath.Join(dir, "empty.key")
+	if err := ioutil.WriteFile(emptyKeyPath, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		name       string
+		cfg        config.CacheSnapshot
+		wantPeriod time.Duration
+		wantErr    error
+	}
+	tests := []test{
+		{
+			name: "Check disabled",
+			cfg: config.CacheSnapshot{
+				Path:    filepath.Join(dir, "snapshot"),
+				KeyPath: keyPath,
+			},
+			wantErr: ErrDisabled,
+		},
+		{
+			name: "Check default period",
+			cfg: config.CacheSnapshot{
+				Enable:  true,
+				Path:    filepath.Join(dir, "snapshot"),
+				KeyPath: keyPath,
+			},
+			wantPeriod: defaultSnapshotPeriod,
+		},
+		{
+			name: "Check period",
+			cfg: config.CacheSnapshot{
+				Enable:  true,
+				Path:    filepath.Join(dir, "snapshot"),
+				KeyPath: keyPath,
+				Period:  "1m",
+			},
+			wantPeriod: time.Minute,
+		},
+		{
+			name: "Check invalid period",
+			cfg: config.CacheSnapshot{
+				Enable:  true,
+				Path:    filepath.Join(dir, "snapshot"),
+				KeyPath: keyPath,
+				Period:  "0s",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "Period: must be positive"),
+		},
+		{
+			name: "Check empty path",
+			cfg: config.CacheSnapshot{
+				Enable:  true,
+				KeyPath: keyPath,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "Path is empty"),
+		},
+		{
+			name: "Check empty key file",
+			cfg: config.CacheSnapshot{
+				Enable:  true,
+				Path:    filepath.Join(dir, "snapshot"),
+				KeyPath: emptyKeyPath,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "KeyPath: the key file is empty"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCacheSnapshot(tt.cfg)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("NewCacheSnapshot() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewCacheSnapshot() error = %v", err)
+				return
+			}
+			if got.period != tt.wantPeriod {
+				t.Errorf("NewCacheSnapshot() period = %v, want %v", got.period, tt.wantPeriod)
+			}
+		})
+	}
+}
+
+func TestCacheSnapshot_SaveRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newSnapshot := func(secret string) *CacheSnapshot {
+		s, err := NewCacheSnapshot(config.CacheSnapshot{
+			Enable:  true,
+			Path:    filepath.Join(dir, "snapshot"),
+			KeyPath: writeSnapshotKey(t, dir, secret),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	newTarget := func(principal string) SnapshotTarget {
+		cache := &atomic.Value{}
+		cache.Store(certCache{})
+		scheduler := func() *refreshScheduler {
+			s, err := newRefreshScheduler(config.Schedule{Enable: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}
+		return SnapshotTarget{
+			Principal: principal,
+			Access: &accessService{
+				tokenCache: gache.New(),
+				scheduler:  scheduler(),
+				cacheLimit: newCacheLimit(config.CacheLimit{MaxEntries: 10}, time.Minute, nil),
+			},
+			Role: &roleService{
+				domainRoleCache: gache.New(),
+				scheduler:       scheduler(),
+				cacheLimit:      newCacheLimit(config.CacheLimit{MaxEntries: 10}, time.Minute, nil),
+			},
+			SvcCert: &svcCertService{certCache: cache},
+		}
+	}
+
+	// the source caches contain one valid entry and one expired entry each
+	src := newTarget("domain.service")
+	src.Role.(*roleService).domainRoleCache.SetWithExpire("dummyDomain;dummyRole", &cacheData{
+		token:  &RoleToken{Token: "role", ExpiryTime: time.Now().Add(time.Hour).Unix()},
+		domain: "dummyDomain",
+		role:   "dummyRole",
+	}, time.Hour)
+	src.Role.(*roleService).domainRoleCache.SetWithExpire("dummyDomain;expiredRole", &cacheData{
+		token: &RoleToken{Token: "expired"},
+	}, time.Millisecond)
+	src.Access.(*accessService).tokenCache.SetWithExpire("dummyDomain;dummyRole", &accessCacheData{
+		token:  &AccessTokenResponse{AccessToken: dummyJWT(time.Now().Add(time.Hour))},
+		domain: "dummyDomain",
+		role:   "dummyRole",
+	}, -1)
+	src.Access.(*accessService).tokenCache.SetWithExpire("dummyDomain;expiredRole", &accessCacheData{
+		token: &AccessTokenResponse{AccessToken: dummyJWT(time.Now().Add(-time.Hour))},
+	}, -1)
+	src.SvcCert.(*svcCertService).certCache.Store(certCache{
+		cert: []byte("cert"),
+		key:  []byte("key"),
+		exp:  time.Now().Add(time.Hour),
+	})
+	time.Sleep(time.Millisecond * 200)
+
+	if err := newSnapshot("secret").Save(context.Background(), map[string]SnapshotTarget{"": src}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "dummyDomain") {
+		t.Errorf("Save() snapshot is not encrypted")
+	}
+	if info, err := os.Stat(filepath.Join(dir, "snapshot")); err != nil || info.Mode().Perm() != snapshotFileMode {
+		t.Errorf("Save() snapshot file mode = %v, err = %v", info.Mode().Perm(), err)
+	}
+
+	type test struct {
+		name      string
+		snapshot  *CacheSnapshot
+		targets   map[string]SnapshotTarget
+		want      int
+		wantErr   error
+		checkFunc func(map[string]SnapshotTarget) error
+	}
+	tests := []test{
+		{
+			name:     "Restore only unexpired entries",
+			snapshot: newSnapshot("secret"),
+			targets:  map[string]SnapshotTarget{"": newTarget("domain.service")},
+			want:     3,
+			checkFunc: func(targets map[string]SnapshotTarget) error {
+				dst := targets[""]
+				if tok, ok := dst.Role.(*roleService).getCache("dummyDomain", "dummyRole", ""); !ok || tok.Token != "role" {
+					return fmt.Errorf("role token is not restored, got: %v", tok)
+				}
+				if _, ok := dst.Role.(*roleService).getCache("dummyDomain", "expiredRole", ""); ok {
+					return fmt.Errorf("expired role token is restored")
+				}
+				if _, ok := dst.Access.(*accessService).getCache("dummyDomain", "dummyRole", ""); !ok {
+					return fmt.Errorf("access token is not restored")
+				}
+				if _, ok := dst.Access.(*accessService).getCache("dummyDomain", "expiredRole", ""); ok {
+					return fmt.Errorf("expired access token is restored")
+				}
+				role, access := dst.Role.(*roleService), dst.Access.(*accessService)
+				if !role.scheduler.scheduled("dummyDomain;dummyRole") || !access.scheduler.scheduled("dummyDomain;dummyRole") {
+					return fmt.Errorf("restored tokens are not scheduled to refresh")
+				}
+				if _, ok := role.cacheLimit.items["dummyDomain;dummyRole"]; !ok {
+					return fmt.Errorf("restored role token is not tracked by the cache limit")
+				}
+				if _, ok := access.cacheLimit.items["dummyDomain;dummyRole"]; !ok {
+					return fmt.Errorf("restored access token is not tracked by the cache limit")
+				}
+				cache := dst.SvcCert.(*svcCertService).certCache.Load().(certCache)
+				if string(cache.cert) != "cert" || string(cache.key) != "key" || !cache.exp.After(fastime.Now()) {
+					return fmt.Errorf("service certificate is not restored, got: %+v", cache)
+				}
+				return nil
+			},
+		},
+		{
+			name:     "Restore nothing to the identity with different principal",
+			snapshot: newSnapshot("secret"),
+			targets:  map[string]SnapshotTarget{"": newTarget("domain.other")},
+			want:     0,
+		},
+		{
+			name:     "Restore nothing to the identity not in the snapshot",
+			snapshot: newSnapshot("secret"),
+			targets:  map[string]SnapshotTarget{"other": newTarget("domain.service")},
+			want:     0,
+		},
+		{
+			name:     "Restore fails with different key",
+			snapshot: newSnapshot("other"),
+			targets:  map[string]SnapshotTarget{"": newTarget("domain.service")},
+			wantErr:  errors.Wrap(ErrInvalidSnapshot, "cipher: message authentication failed"),
+		},
+		func() test {
+			s := newSnapshot("secret")
+			s.path = filepath.Join(dir, "non_exist")
+			return test{
+				name:     "Restore nothing without snapshot file",
+				snapshot: s,
+				targets:  map[string]SnapshotTarget{"": newTarget("domain.service")},
+				want:     0,
+			}
+		}(),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.snapshot.Restore(tt.targets)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("Restore() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Restore() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Restore() = %v, want %v", got, tt.want)
+			}
+			if tt.checkFunc != nil {
+				if err := tt.checkFunc(tt.targets); err != nil {
+					t.Errorf("Restore() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_hkdfSHA256(t *testing.T) {
+	// test vectors of RFC 5869 Appendix A
+	hexBytes := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		name   string
+		secret []byte
+		salt   []byte
+		info   []byte
+		length int
+		want   string
+	}{
+		{
+			name:   "Test case 1",
+			secret: hexBytes("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b"),
+			salt:   hexBytes("000102030405060708090a0b0c"),
+			info:   hexBytes("f0f1f2f3f4f5f6f7f8f9"),
+			length: 42,
+			want:   "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		},
+		{
+			name:   "Test case 3, without salt and info",
+			secret: hexBytes("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b"),
+			length: 42,
+			want:   "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(hkdfSHA256(tt.secret, tt.salt, tt.info, tt.length)); got != tt.want {
+				t.Errorf("hkdfSHA256() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheSnapshot_StartSnapshotUpdater(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewCacheSnapshot(config.CacheSnapshot{
+		Enable:  true,
+		Path:    filepath.Join(dir, "snapshot"),
+		KeyPath: writeSnapshotKey(t, dir, "secret"),
+		Period:  "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ech := s.StartSnapshotUpdater(ctx, func() map[string]SnapshotTarget {
+		return map[string]SnapshotTarget{"": {Role: &roleService{domainRoleCache: gache.New()}}}
+	})
+	cancel()
+	for err := range ech {
+		t.Errorf("StartSnapshotUpdater() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshot")); err != nil {
+		t.Errorf("StartSnapshotUpdater() snapshot is not saved on shutdown, err: %v", err)
+	}
+}
+
+func Test_jwtExpiry(t *testing.T) {
+	exp := time.Unix(1600000000, 0)
+	tests := []struct {
+		name  string
+		token string
+		want  int64
+	}{
+		{
+			name:  "Check exp claim",
+			token: dummyJWT(exp),
+			want:  exp.Unix(),
+		},
+		{
+			name:  "Check malformed token",
+			token: "token",
+			want:  0,
+		},
+		{
+			name:  "Check malformed payload",
+			token: "header.payload.signature",
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jwtExpiry(tt.token); got != tt.want {
+				t.Errorf("jwtExpiry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,7 +20,10 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -35,7 +38,9 @@ import (
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
 	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/ardielle/ardielle-go/rdl"
 	"github.com/kpango/fastime"
 	"github.com/kpango/glg"
 	"github.com/kpango/ntokend"
@@ -50,6 +55,12 @@ var (
 	// defaultSvcCertExpiryMargin represents the default value of ExpiryMargin.
 	defaultSvcCertExpiryMargin = time.Hour * 24 * 10
 
+	// defaultSvcCertRetryInterval represents the default duration before the first retry of the failed refresh.
+	defaultSvcCertRetryInterval = time.Minute
+
+	// defaultSvcCertRetryMaxInterval represents the default maximum duration between each retry of the failed refresh.
+	defaultSvcCertRetryMaxInterval = time.Minute * 10
+
 	// defaultSvcCertExpiry represents the default value of Expiry
 	defaultSvcCertExpiry int32
 
@@ -72,6 +83,17 @@ var (
 	ErrInvalidParameter = errors.New("Invalid parameter")
 )
 
+const (
+	// keyTypeECDSA represents the ECDSA private key type generated on each refresh.
+	keyTypeECDSA = "ECDSA"
+
+	// keyTypeRSA represents the RSA private key type generated on each refresh.
+	keyTypeRSA = "RSA"
+
+	// minRSAKeySize represents the minimum and the default size of the RSA private key generated on each refresh.
+	minRSAKeySize = 2048
+)
+
 type signer struct {
 	key       crypto.Signer
 	algorithm x509.SignatureAlgorithm
@@ -81,6 +103,11 @@ type requestTemplate struct {
 	req          *zts.InstanceRefreshRequest
 	compoundName zts.CompoundName
 	simpleName   zts.SimpleName
+
+	// subj, host and uri are used to generate the CSR with a new private key on each refresh.
+	subj pkix.Name
+	host string
+	uri  string
 }
 
 // SvcCertService represents an interface to automatically refresh the certificate.
@@ -88,11 +115,15 @@ type SvcCertService interface {
 	StartSvcCertUpdater(context.Context) SvcCertService
 	GetSvcCertProvider() SvcCertProvider
 	RefreshSvcCert() ([]byte, error)
+	GetSvcCertKeyProvider() SvcCertKeyProvider
+	Readiness() Readiness
 }
 
 type certCache struct {
 	cert []byte
-	exp  time.Time
+	// key represents the private key generated for cert in PEM format. It is nil if the key is not generated by the client sidecar.
+	key []byte
+	exp time.Time
 }
 
 // svcCertService represents the implementation of Athenz RoleService
@@ -105,11 +136,22 @@ type svcCertService struct {
 	expireMargin    time.Duration
 	client          *zts.ZTSClient
 	refreshRequest  *requestTemplate
+
+	retryMaxCount int
+	retryInterval time.Duration
+	retryBackoff  backoff
+
+	// writer writes the certificate and the private key to files after every successful refresh. It is nil if the output is disabled.
+	writer *certWriter
 }
 
 // SvcCertProvider represents a function pointer to get the svccert.
 type SvcCertProvider func() ([]byte, error)
 
+// SvcCertKeyProvider represents a function pointer to get the svccert and its private key in PEM format.
+// The private key is nil unless the client sidecar generates a new private key on each refresh.
+type SvcCertKeyProvider func() (cert []byte, key []byte, err error)
+
 // NewSvcCertService returns a SvcCertService to update and get the svccert from Athenz.
 func NewSvcCertService(cfg config.Config, token ntokend.TokenProvider) (SvcCertService, error) {
 
@@ -141,11 +183,42 @@ func NewSvcCertService(cfg config.Config, token ntokend.TokenProvider) (SvcCertS
 		expireInt = int32(expireDur.Minutes())
 	}
 
+	// NOTE: The N-token signing key is never written to the output key file,
+	// so a new private key is generated on each refresh if the output is enabled without the key type.
+	if cfg.ServiceCert.Output.Enable && cfg.ServiceCert.KeyType == "" {
+		cfg.ServiceCert.KeyType = keyTypeECDSA
+	}
+	if err := validateKeyType(cfg.ServiceCert.KeyType, cfg.ServiceCert.KeySize); err != nil {
+		return nil, err
+	}
+
+	if cfg.ServiceCert.Retry.Attempts < 0 {
+		return nil, errors.Wrap(ErrInvalidParameter, "ErrRetryMaxCount < 0")
+	}
+	retryInterval := defaultSvcCertRetryInterval
+	if cfg.ServiceCert.Retry.Delay != "" {
+		if retryInterval, err = time.ParseDuration(cfg.ServiceCert.Retry.Delay); err != nil {
+			return nil, errors.Wrap(ErrInvalidParameter, "ErrRetryInterval: "+err.Error())
+		}
+	}
+	retryBackoff, err := newBackoff(cfg.ServiceCert.Retry, defaultSvcCertRetryMaxInterval)
+	if err != nil {
+		return nil, err
+	}
+
 	reqTemp, client, err := setup(cfg, expireInt)
 	if err != nil {
 		return nil, err
 	}
 
+	var writer *certWriter
+	if cfg.ServiceCert.Output.Enable {
+		writer, err = newCertWriter(cfg.ServiceCert.Output)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	cache := &atomic.Value{}
 	cache.Store(
 		certCache{
@@ -162,6 +235,10 @@ func NewSvcCertService(cfg config.Config, token ntokend.TokenProvider) (SvcCertS
 		expireMargin:    beforeDur,
 		client:          client,
 		refreshRequest:  reqTemp,
+		retryMaxCount:   cfg.ServiceCert.Retry.Attempts,
+		retryInterval:   retryInterval,
+		retryBackoff:    retryBackoff,
+		writer:          writer,
 	}, nil
 }
 
@@ -170,18 +247,6 @@ func isValidDomain(domain string) bool {
 }
 
 func setup(cfg config.Config, expiry int32) (*requestTemplate, *zts.ZTSClient, error) {
-	// load private key
-	keyBytes, err := ioutil.ReadFile(cfg.NToken.PrivateKeyPath)
-	if err != nil {
-		return nil, nil, ErrLoadPrivateKey
-	}
-
-	// get our private key signer for csr
-	pkSigner, err := newSigner(keyBytes)
-	if err != nil {
-		return nil, nil, ErrFailedToInitialize
-	}
-
 	// generate a csr for this service
 	// note: RFC 6125 states that if the SAN (Subject Alternative Name) exists,
 	// it is used, not the CA. So, we will always put the Athenz name in the CN
@@ -208,9 +273,25 @@ func setup(cfg config.Config, expiry int32) (*requestTemplate, *zts.ZTSClient, e
 		uri = fmt.Sprintf("spiffe://%s/sa/%s", cfg.NToken.AthenzDomain, cfg.NToken.ServiceName)
 	}
 
-	csrData, err := generateCSR(pkSigner, subj, host, uri)
-	if err != nil {
-		return nil, nil, ErrFailedToInitialize
+	// the csr is generated on each refresh with a new private key if the key type is set
+	var csrData string
+	if cfg.ServiceCert.KeyType == "" {
+		// load private key
+		keyBytes, err := ioutil.ReadFile(cfg.NToken.PrivateKeyPath)
+		if err != nil {
+			return nil, nil, ErrLoadPrivateKey
+		}
+
+		// get our private key signer for csr
+		pkSigner, err := newSigner(keyBytes)
+		if err != nil {
+			return nil, nil, ErrFailedToInitialize
+		}
+
+		csrData, err = generateCSR(pkSigner, subj, host, uri)
+		if err != nil {
+			return nil, nil, ErrFailedToInitialize
+		}
 	}
 
 	// if we're given a certificate then we'll use that otherwise
@@ -218,7 +299,7 @@ func setup(cfg config.Config, expiry int32) (*requestTemplate, *zts.ZTSClient, e
 	// we're using copper argos which only uses tls and the attestation
 	// data contains the authentication details
 
-	client, err := ztsClient(cfg.ServiceCert)
+	client, err := ztsClient(cfg.ServiceCert.AthenzURL, cfg.ServiceCert.AthenzCAPath)
 	if err != nil {
 		return nil, nil, ErrFailedToInitialize
 	}
@@ -234,6 +315,9 @@ func setup(cfg config.Config, expiry int32) (*requestTemplate, *zts.ZTSClient, e
 		req:          req,
 		compoundName: zts.CompoundName(cfg.NToken.AthenzDomain),
 		simpleName:   zts.SimpleName(cfg.NToken.ServiceName),
+		subj:         subj,
+		host:         host,
+		uri:          uri,
 	}, client, nil
 }
 
@@ -261,6 +345,66 @@ func newSigner(privateKeyPEM []byte) (*signer, error) {
 	}
 }
 
+// validateKeyType validates the type and the size of the private key generated on each refresh.
+func validateKeyType(keyType string, size int) error {
+	switch keyType {
+	case "":
+	case keyTypeECDSA:
+		switch size {
+		case 0, 256, 384, 521:
+		default:
+			return errors.Wrapf(ErrInvalidParameter, "KeySize: unsupported ECDSA key size %d", size)
+		}
+	case keyTypeRSA:
+		if size != 0 && size < minRSAKeySize {
+			return errors.Wrapf(ErrInvalidParameter, "KeySize: RSA key size must be at least %d, got %d", minRSAKeySize, size)
+		}
+	default:
+		return errors.Wrapf(ErrInvalidParameter, "KeyType: unsupported key type %s", keyType)
+	}
+	return nil
+}
+
+// generateKey generates a new private key of the type and the size, and returns its signer and the private key in PEM format.
+// The size of 0 implies the default size, P-256 for ECDSA and 2048 bits for RSA.
+func generateKey(keyType string, size int) (*signer, []byte, error) {
+	switch keyType {
+	case keyTypeECDSA:
+		var curve elliptic.Curve
+		switch size {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, nil, fmt.Errorf("Unsupported ECDSA key size: %d", size)
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &signer{key: key, algorithm: x509.ECDSAWithSHA256}, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case keyTypeRSA:
+		if size == 0 {
+			size = minRSAKeySize
+		}
+		key, err := rsa.GenerateKey(rand.Reader, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		der := x509.MarshalPKCS1PrivateKey(key)
+		return &signer{key: key, algorithm: x509.SHA256WithRSA}, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, nil, fmt.Errorf("Unsupported key type: %s", keyType)
+	}
+}
+
 func generateCSR(keySigner *signer, subj pkix.Name, host, uri string) (string, error) {
 	template := x509.CertificateRequest{
 		Subject:            subj,
@@ -276,6 +420,11 @@ func generateCSR(keySigner *signer, subj pkix.Name, host, uri string) (string, e
 		}
 	}
 
+	return createCSR(keySigner, template)
+}
+
+// createCSR signs the CSR template with the key, and returns the CSR in PEM format.
+func createCSR(keySigner *signer, template x509.CertificateRequest) (string, error) {
 	csr, err := x509.CreateCertificateRequest(rand.Reader, &template, keySigner.key)
 	if err != nil {
 		return "", fmt.Errorf("Cannot create CSR: %v", err)
@@ -293,8 +442,9 @@ func generateCSR(keySigner *signer, subj pkix.Name, host, uri string) (string, e
 	return buf.String(), nil
 }
 
-func ztsClient(cfg config.ServiceCert) (*zts.ZTSClient, error) {
-	_, err := url.Parse(cfg.AthenzURL)
+// ztsClient returns the ZTS client of the Athenz URL, trusting the CA certificates in athenzCAPath if it is set.
+func ztsClient(athenzURL, athenzCAPath string) (*zts.ZTSClient, error) {
+	_, err := url.Parse(athenzURL)
 	if err != nil {
 		return nil, ErrInvalidParameter
 	}
@@ -305,10 +455,10 @@ func ztsClient(cfg config.ServiceCert) (*zts.ZTSClient, error) {
 	}
 
 	// TODO: refactor use NewX509CertPool() in tls.go
-	if cfg.AthenzCAPath != "" {
+	if athenzCAPath != "" {
 		config := &tls.Config{}
 		certPool := x509.NewCertPool()
-		caCert, err := ioutil.ReadFile(cfg.AthenzCAPath)
+		caCert, err := ioutil.ReadFile(athenzCAPath)
 		if err != nil {
 			return nil, err
 		}
@@ -317,34 +467,24 @@ func ztsClient(cfg config.ServiceCert) (*zts.ZTSClient, error) {
 		transport.TLSClientConfig = config
 	}
 
-	client := zts.NewClient(cfg.AthenzURL, transport)
+	client := zts.NewClient(athenzURL, transport)
 
 	return &client, nil
 }
 
 func (s *svcCertService) StartSvcCertUpdater(ctx context.Context) SvcCertService {
 	go func() {
-		var err error
-		fch := make(chan struct{}, 1)
-
 		ticker := time.NewTicker(s.refreshDuration)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
-				ticker.Stop()
 				return
-			case <-fch:
-				_, err = s.RefreshSvcCert()
-				if err != nil {
-					glg.Error(err)
-					time.Sleep(time.Minute * 10)
-					fch <- struct{}{}
-				}
 			case <-ticker.C:
-				_, err = s.RefreshSvcCert()
-				if err != nil {
+				if _, err := s.RefreshSvcCert(); err != nil {
 					glg.Error(err)
-					fch <- struct{}{}
+					metrics.RefreshFailure(metrics.SvcCert)
+					s.retryRefresh(ctx, err)
 				}
 			}
 		}
@@ -352,48 +492,147 @@ func (s *svcCertService) StartSvcCertUpdater(ctx context.Context) SvcCertService
 	return s
 }
 
+// retryRefresh retries the failed refresh with backoff until it succeeds, the retry count is exceeded, the error is not retryable, or the context is done.
+// The retry count 0 means retrying until it succeeds.
+func (s *svcCertService) retryRefresh(ctx context.Context, err error) {
+	for i := 0; s.retryMaxCount == 0 || i < s.retryMaxCount; i++ {
+		if !retryable(err) {
+			glg.Warnf("service certificate refresh is not retried until the next refresh: %s", err.Error())
+			return
+		}
+		if !sleep(ctx, s.retryBackoff.interval(i, s.retryInterval, err)) {
+			return
+		}
+		if _, err = s.RefreshSvcCert(); err == nil {
+			return
+		}
+		glg.Error(err)
+		metrics.RetryFailure(metrics.SvcCert)
+	}
+}
+
+// InheritSvcCertCache copies the service certificate cached in src to dst, if it is not expired yet.
+// It returns whether the certificate is copied. Nothing is copied if either service is not created by NewSvcCertService.
+func InheritSvcCertCache(dst, src SvcCertService) bool {
+	d, ok := dst.(*svcCertService)
+	if !ok {
+		return false
+	}
+	s, ok := src.(*svcCertService)
+	if !ok {
+		return false
+	}
+
+	cache := s.certCache.Load().(certCache)
+	if cache.cert == nil || cache.exp.Before(fastime.Now()) {
+		return false
+	}
+	d.certCache.Store(cache)
+	return true
+}
+
 // GetSvcCertProvider returns a function pointer to get the svccert.
 func (s *svcCertService) GetSvcCertProvider() SvcCertProvider {
 	return s.getSvcCert
 }
 
+// Readiness returns the readiness of the svccert service based on the expiry of the cached certificate.
+// The certificate within the expiry margin is still ready, since it is returned when the refresh is failed.
+func (s *svcCertService) Readiness() Readiness {
+	cache := s.certCache.Load().(certCache)
+	if cache.cert == nil {
+		return Readiness{
+			Message: "service certificate is not fetched",
+		}
+	}
+
+	notAfter := cache.exp.Add(s.expireMargin)
+	rd := Readiness{
+		Ready:  notAfter.After(fastime.Now()),
+		Expiry: notAfter.Format(time.RFC3339),
+	}
+	if !rd.Ready {
+		rd.Message = "service certificate is expired"
+	}
+	return rd
+}
+
+// GetSvcCertKeyProvider returns a function pointer to get the svccert and its private key.
+func (s *svcCertService) GetSvcCertKeyProvider() SvcCertKeyProvider {
+	return s.getSvcCertKey
+}
+
 // getSvcCert return a token string or error
 // This function is thread-safe. This function will return the svccert stored in the atomic variable,
 // or return the error when the svccert is not initialized or cannot be generated
 func (s *svcCertService) getSvcCert() ([]byte, error) {
+	cert, _, err := s.getSvcCertKey()
+	return cert, err
+}
+
+// getSvcCertKey returns the svccert and its private key like getSvcCert. The private key is nil if it is not generated by the client sidecar.
+func (s *svcCertService) getSvcCertKey() ([]byte, []byte, error) {
 	cache := s.certCache.Load().(certCache)
 
 	if cache.cert == nil || cache.exp.Before(fastime.Now()) {
-		cert, err := s.RefreshSvcCert()
+		refreshed, err := s.refresh()
 		if err != nil {
 			//  NOTE: When RefreshSvcCert is failed, return the cached certificate if it is not expired
 			if cache.cert != nil && cache.exp.Add(s.expireMargin).After(fastime.Now()) {
 				glg.Warn("Cached certificate is not expired. Return from cache. Error: " + err.Error())
-				return cache.cert, nil
+				return cache.cert, cache.key, nil
 			}
 			glg.Error(err)
-			return nil, err
+			return nil, nil, err
 		}
-		return cert, nil
+		return refreshed.cert, refreshed.key, nil
 	}
-	return cache.cert, nil
+	return cache.cert, cache.key, nil
 }
 
 func (s *svcCertService) RefreshSvcCert() ([]byte, error) {
-	svccert, err, _ := s.group.Do("", func() (interface{}, error) {
+	cache, err := s.refresh()
+	if err != nil {
+		return nil, err
+	}
+	return cache.cert, nil
+}
+
+// refresh requests a new svccert to Athenz, and returns the cached svccert and its private key.
+func (s *svcCertService) refresh() (certCache, error) {
+	refreshed, err, _ := s.group.Do("", func() (interface{}, error) {
 		nToken, err := s.token()
 		if err != nil {
 			return nil, err
 		}
 
+		// generate a new private key and csr for this refresh if the key type is set
+		req := s.refreshRequest.req
+		var generatedKey []byte
+		if s.cfg.KeyType != "" {
+			req, generatedKey, err = s.newRefreshRequest()
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		s.client.AddCredentials(s.cfg.PrincipalAuthHeader, nToken)
 
 		// request a tls certificate for this service
+		start := time.Now()
 		identity, err := s.client.PostInstanceRefreshRequest(
 			s.refreshRequest.compoundName,
 			s.refreshRequest.simpleName,
-			s.refreshRequest.req,
+			req,
 		)
+		metrics.ObserveAthenzRequest(metrics.SvcCert, ztsStatusCode(err), start)
+		if re, ok := err.(rdl.ResourceError); ok {
+			return nil, &UpstreamError{
+				Err:     ErrCertNotFound,
+				Code:    re.Code,
+				Message: re.Message,
+			}
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -418,16 +657,52 @@ func (s *svcCertService) RefreshSvcCert() ([]byte, error) {
 		// update cert cache and expiry
 		cache := certCache{
 			cert: cert,
+			key:  generatedKey,
 			exp:  certificate.NotAfter.Add(-s.expireMargin),
 		}
 		s.certCache.Store(cache)
 
-		return cert, nil
+		// NOTE: The refreshed certificate is returned even if it is failed to write, since it is already cached.
+		if s.writer != nil {
+			if err := s.writer.write(cert, []byte(identity.CaCertBundle), generatedKey); err != nil {
+				glg.Errorf("failed to output service certificate: %s", err.Error())
+			}
+		}
+
+		return cache, nil
 	})
 
 	if err != nil {
-		return nil, err
+		return certCache{}, err
+	}
+
+	return refreshed.(certCache), nil
+}
+
+// newRefreshRequest returns a copy of the refresh request template with the csr of a newly generated private key, and the private key in PEM format.
+func (s *svcCertService) newRefreshRequest() (*zts.InstanceRefreshRequest, []byte, error) {
+	keySigner, keyPEM, err := generateKey(s.cfg.KeyType, s.cfg.KeySize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate private key")
 	}
 
-	return svccert.([]byte), nil
+	csr, err := generateCSR(keySigner, s.refreshRequest.subj, s.refreshRequest.host, s.refreshRequest.uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := *s.refreshRequest.req
+	req.Csr = csr
+	return &req, keyPEM, nil
+}
+
+// ztsStatusCode returns the metrics status label value of the error returned by zts.ZTSClient.
+func ztsStatusCode(err error) string {
+	if err == nil {
+		return metrics.StatusCode(http.StatusOK)
+	}
+	if re, ok := err.(interface{ StatusCode() int }); ok {
+		return metrics.StatusCode(re.StatusCode())
+	}
+	return metrics.StatusError
 }
@@ -18,19 +18,26 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
 	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/ardielle/ardielle-go/rdl"
 	"github.com/kpango/fastime"
 	"github.com/kpango/glg"
 	"github.com/kpango/ntokend"
+	"github.com/pkg/errors"
 )
 
 func init() {
@@ -713,6 +720,106 @@ func (m *mockTransporter) RoundTrip(req *http.Request) (*http.Response, error) {
 	return res, m.Error
 }
 
+func TestInheritSvcCertCache(t *testing.T) {
+	newService := func(cache certCache) *svcCertService {
+		v := &atomic.Value{}
+		v.Store(cache)
+		return &svcCertService{certCache: v}
+	}
+	type test struct {
+		name string
+		dst  SvcCertService
+		src  SvcCertService
+		want bool
+	}
+	tests := []test{
+		{
+			name: "Inherit unexpired certificate",
+			dst:  newService(certCache{exp: fastime.Now()}),
+			src:  newService(certCache{cert: []byte("cert"), exp: fastime.Now().Add(time.Hour)}),
+			want: true,
+		},
+		{
+			name: "Not inherit expired certificate",
+			dst:  newService(certCache{exp: fastime.Now()}),
+			src:  newService(certCache{cert: []byte("cert"), exp: fastime.Now().Add(-time.Hour)}),
+			want: false,
+		},
+		{
+			name: "Not inherit empty certificate",
+			dst:  newService(certCache{exp: fastime.Now()}),
+			src:  newService(certCache{exp: fastime.Now().Add(time.Hour)}),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := InheritSvcCertCache(tt.dst, tt.src)
+			if got != tt.want {
+				t.Errorf("InheritSvcCertCache() = %v, want %v", got, tt.want)
+			}
+			gotCert := tt.dst.(*svcCertService).certCache.Load().(certCache).cert
+			if got && !reflect.DeepEqual(gotCert, tt.src.(*svcCertService).certCache.Load().(certCache).cert) {
+				t.Errorf("InheritSvcCertCache() cert = %s", gotCert)
+			}
+		})
+	}
+}
+
+func TestSvcCertService_Readiness(t *testing.T) {
+	exp := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)
+	newService := func(cache certCache) *svcCertService {
+		v := &atomic.Value{}
+		v.Store(cache)
+		return &svcCertService{certCache: v, expireMargin: time.Hour}
+	}
+	type test struct {
+		name string
+		s    *svcCertService
+		want Readiness
+	}
+	tests := []test{
+		{
+			name: "Ready with unexpired certificate",
+			s:    newService(certCache{cert: []byte("cert"), exp: exp.Add(-time.Hour)}),
+			want: Readiness{
+				Ready:  true,
+				Expiry: exp.Format(time.RFC3339),
+			},
+		},
+		{
+			name: "Ready with certificate within expiry margin",
+			s:    newService(certCache{cert: []byte("cert"), exp: fastime.Now().Add(-time.Minute).Truncate(time.Second)}),
+			want: Readiness{
+				Ready:  true,
+				Expiry: fastime.Now().Add(-time.Minute).Truncate(time.Second).Add(time.Hour).Format(time.RFC3339),
+			},
+		},
+		{
+			name: "Not ready with expired certificate",
+			s:    newService(certCache{cert: []byte("cert"), exp: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}),
+			want: Readiness{
+				Message: "service certificate is expired",
+				Expiry:  time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			},
+		},
+		{
+			name: "Not ready without certificate",
+			s:    newService(certCache{exp: fastime.Now()}),
+			want: Readiness{
+				Message: "service certificate is not fetched",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.Readiness(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Readiness() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestSvcCertService_GetSvcCert(t *testing.T) {
 	type test struct {
 		name           string
@@ -1138,6 +1245,74 @@ func TestSvcCertService_StartSvcCertUpdater(t *testing.T) {
 
 }
 
+func TestSvcCertService_retryRefresh(t *testing.T) {
+	type test struct {
+		name          string
+		ctx           context.Context
+		err           error
+		retryMaxCount int
+		tokenErr      func(n int32) error
+		wantCalls     int32
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []test{
+		{
+			name:          "Retry until the retry count is exceeded",
+			ctx:           context.Background(),
+			err:           errors.New("dummy error"),
+			retryMaxCount: 3,
+			tokenErr: func(int32) error {
+				return errors.New("dummy error")
+			},
+			wantCalls: 3,
+		},
+		{
+			name: "Retry until the client error is returned",
+			ctx:  context.Background(),
+			err:  errors.New("dummy error"),
+			tokenErr: func(n int32) error {
+				if n < 2 {
+					return errors.New("dummy error")
+				}
+				return rdl.ResourceError{Code: http.StatusForbidden, Message: "forbidden"}
+			},
+			wantCalls: 2,
+		},
+		{
+			name:      "Do not retry the client error",
+			ctx:       context.Background(),
+			err:       rdl.ResourceError{Code: http.StatusForbidden, Message: "forbidden"},
+			wantCalls: 0,
+		},
+		{
+			name:          "Do not retry after the context is canceled",
+			ctx:           canceled,
+			err:           errors.New("dummy error"),
+			retryMaxCount: 3,
+			wantCalls:     0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			s := &svcCertService{
+				token: func() (string, error) {
+					return "", tt.tokenErr(atomic.AddInt32(&calls, 1))
+				},
+				retryMaxCount: tt.retryMaxCount,
+				retryInterval: time.Millisecond,
+			}
+			s.retryRefresh(tt.ctx, tt.err)
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("retryRefresh() refresh count = %v, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
 func TestSvcCertService_RefreshSvcCert(t *testing.T) {
 	type test struct {
 		name           string
@@ -1330,6 +1505,47 @@ func TestSvcCertService_RefreshSvcCert(t *testing.T) {
 				wantErr:        wantErr,
 			}
 		}(),
+		func() test {
+			token := func() (string, error) { return "dummyToken", nil }
+
+			transpoter := &mockTransporter{
+				StatusCode: http.StatusForbidden,
+				Body:       [][]byte{[]byte(`{"code":403,"message":"forbidden"}`)},
+				Method:     "GET",
+			}
+
+			cfg := config.Config{
+				NToken: config.NToken{
+					PrivateKeyPath: "../test/data/dummyServer.key",
+					AthenzDomain:   "dummyDomain",
+					ServiceName:    "dummyService",
+				},
+				ServiceCert: config.ServiceCert{
+					Enable:              true,
+					AthenzCAPath:        "../test/data/dummyCa.pem",
+					AthenzURL:           "http://dummy",
+					RefreshPeriod:       "30m",
+					PrincipalAuthHeader: "Athenz-Principal",
+					IntermediateCert:    false,
+				},
+			}
+
+			s, _ := NewSvcCertService(cfg, token)
+			svcCertService := s.(*svcCertService)
+
+			svcCertService.client.Transport = transpoter
+
+			return test{
+				name:           "RefreshSvcCert fail when Athenz returns error response",
+				svcCertService: svcCertService,
+				want:           nil,
+				wantErr: &UpstreamError{
+					Err:     ErrCertNotFound,
+					Code:    http.StatusForbidden,
+					Message: "forbidden",
+				},
+			}
+		}(),
 		func() test {
 			dummyCertBytes, _ := ioutil.ReadFile("../test/data/invalid_dummyServer.crt")
 			dummyCaCertBytes, _ := ioutil.ReadFile("../test/data/dummyCa.pem")
@@ -1394,3 +1610,184 @@ func TestSvcCertService_RefreshSvcCert(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateKeyType(t *testing.T) {
+	type args struct {
+		keyType string
+		size    int
+	}
+	type test struct {
+		name    string
+		args    args
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "key type is not set",
+		},
+		{
+			name: "ECDSA with default size",
+			args: args{keyType: "ECDSA"},
+		},
+		{
+			name: "ECDSA with P-384",
+			args: args{keyType: "ECDSA", size: 384},
+		},
+		{
+			name:    "ECDSA with unsupported size",
+			args:    args{keyType: "ECDSA", size: 2048},
+			wantErr: "KeySize: unsupported ECDSA key size 2048: Invalid parameter",
+		},
+		{
+			name: "RSA with 4096 bits",
+			args: args{keyType: "RSA", size: 4096},
+		},
+		{
+			name:    "RSA with weak size",
+			args:    args{keyType: "RSA", size: 1024},
+			wantErr: "KeySize: RSA key size must be at least 2048, got 1024: Invalid parameter",
+		},
+		{
+			name:    "unsupported key type",
+			args:    args{keyType: "DSA"},
+			wantErr: "KeyType: unsupported key type DSA: Invalid parameter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKeyType(tt.args.keyType, tt.args.size)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("validateKeyType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	type test struct {
+		name      string
+		keyType   string
+		size      int
+		wantBlock string
+		wantAlgo  x509.SignatureAlgorithm
+	}
+	tests := []test{
+		{
+			name:      "generate ECDSA key",
+			keyType:   "ECDSA",
+			wantBlock: "EC PRIVATE KEY",
+			wantAlgo:  x509.ECDSAWithSHA256,
+		},
+		{
+			name:      "generate RSA key",
+			keyType:   "RSA",
+			wantBlock: "RSA PRIVATE KEY",
+			wantAlgo:  x509.SHA256WithRSA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSigner, gotPEM, err := generateKey(tt.keyType, tt.size)
+			if err != nil {
+				t.Fatalf("generateKey() error = %v", err)
+			}
+			if gotSigner.algorithm != tt.wantAlgo {
+				t.Errorf("generateKey() algorithm = %v, want %v", gotSigner.algorithm, tt.wantAlgo)
+			}
+			block, _ := pem.Decode(gotPEM)
+			if block == nil || block.Type != tt.wantBlock {
+				t.Fatalf("generateKey() PEM = %s", gotPEM)
+			}
+			// the PEM must be loadable as the configured private key
+			parsed, err := newSigner(gotPEM)
+			if err != nil {
+				t.Fatalf("newSigner() error = %v", err)
+			}
+			if !reflect.DeepEqual(parsed.key.Public(), gotSigner.key.Public()) {
+				t.Errorf("generateKey() PEM does not match the signer")
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSvcCertService_RefreshSvcCert_keyRotation(t *testing.T) {
+	dummyCertBytes, _ := ioutil.ReadFile("../test/data/dummyServer.crt")
+	dummyResponse := fmt.Sprintf(`{"name": "dummy", "certificate":"%s"}`, strings.ReplaceAll(string(dummyCertBytes), "\n", "\\n"))
+
+	cfg := config.Config{
+		NToken: config.NToken{
+			PrivateKeyPath: "../test/data/non_exist.key",
+			AthenzDomain:   "dummyDomain",
+			ServiceName:    "dummyService",
+		},
+		ServiceCert: config.ServiceCert{
+			Enable:              true,
+			AthenzURL:           "http://dummy",
+			RefreshPeriod:       "30m",
+			PrincipalAuthHeader: "Athenz-Principal",
+			KeyType:             "ECDSA",
+		},
+	}
+	s, err := NewSvcCertService(cfg, func() (string, error) { return "dummyToken", nil })
+	if err != nil {
+		t.Fatalf("NewSvcCertService() error = %v", err)
+	}
+
+	var csrs []*x509.CertificateRequest
+	s.(*svcCertService).client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var body zts.InstanceRefreshRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode([]byte(body.Csr))
+		if block == nil {
+			return nil, fmt.Errorf("invalid csr: %s", body.Csr)
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		csrs = append(csrs, csr)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(dummyResponse)),
+		}, nil
+	})
+
+	var keys [][]byte
+	for i := 0; i < 2; i++ {
+		if _, err := s.RefreshSvcCert(); err != nil {
+			t.Fatalf("RefreshSvcCert() error = %v", err)
+		}
+		cert, key, err := s.GetSvcCertKeyProvider()()
+		if err != nil || !reflect.DeepEqual(cert, dummyCertBytes) {
+			t.Fatalf("GetSvcCertKeyProvider() cert = %s, err = %v", cert, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(csrs) != 2 {
+		t.Fatalf("refresh request count = %d, want 2", len(csrs))
+	}
+	for i, key := range keys {
+		keySigner, err := newSigner(key)
+		if err != nil {
+			t.Fatalf("generated key %d is invalid: %v", i, err)
+		}
+		if !reflect.DeepEqual(keySigner.key.Public(), csrs[i].PublicKey) {
+			t.Errorf("CSR %d is not signed by the generated key", i)
+		}
+		if csrs[i].Subject.CommonName != "dummyDomain.dummyService" {
+			t.Errorf("CSR %d common name = %s", i, csrs[i].Subject.CommonName)
+		}
+	}
+	if reflect.DeepEqual(keys[0], keys[1]) {
+		t.Errorf("private key is not rotated")
+	}
+}
@@ -17,10 +17,12 @@ limitations under the License.
 package service
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
 	"github.com/pkg/errors"
@@ -31,6 +33,18 @@ var (
 	ErrTLSCertOrKeyNotFound = errors.New("Cert/Key path not found")
 )
 
+// svcCertKeyPair parses the service certificate and its private key into the client certificate of the TLS handshakes.
+// The parsed certificate is reused until the service certificate is rotated.
+type svcCertKeyPair struct {
+	svcCert SvcCertKeyProvider
+	// key represents the private key in PEM format used when svcCert does not provide the private key.
+	key []byte
+
+	mu   sync.Mutex
+	cert []byte
+	pair *tls.Certificate
+}
+
 // NewTLSConfig returns a *tls.Config struct or error.
 // It reads TLS configuration and initializes *tls.Config struct.
 // It initializes TLS configuration, for example the CA certificate and key to start TLS server.
@@ -120,3 +134,60 @@ func NewTLSClientConfig(rootCAs *x509.CertPool, certPath, certKeyPath string) (*
 
 	return t, nil
 }
+
+// NewSvcCertTLSClientConfig returns a client *tls.Config or error, which presents the service certificate as the client certificate to the mTLS-protected upstream servers.
+// The service certificate is read from svcCert on each handshake, so that the rotated certificate is used without restart.
+// The upstream servers are verified with the CA certificates of the mTLS proxy configuration in addition to the system root CAs.
+func NewSvcCertTLSClientConfig(cfg config.Config, svcCert SvcCertKeyProvider) (*tls.Config, error) {
+	t := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.Proxy.MTLS.CAPath != "" {
+		pool, err := NewX509CertPool(config.GetActualValue(cfg.Proxy.MTLS.CAPath))
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Proxy.MTLS.CAPath: "+err.Error())
+		}
+		t.RootCAs = pool
+	}
+
+	p := &svcCertKeyPair{
+		svcCert: svcCert,
+	}
+	// the generated private key is provided by svcCert if the key type is set or the output is enabled
+	if cfg.ServiceCert.KeyType == "" && !cfg.ServiceCert.Output.Enable {
+		key, err := ioutil.ReadFile(config.GetActualValue(cfg.NToken.PrivateKeyPath))
+		if err != nil {
+			return nil, ErrLoadPrivateKey
+		}
+		p.key = key
+	}
+	t.GetClientCertificate = p.get
+
+	return t, nil
+}
+
+// get returns the client certificate of the current service certificate.
+func (p *svcCertKeyPair) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, key, err := p.svcCert()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		key = p.key
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pair != nil && bytes.Equal(p.cert, cert) {
+		return p.pair, nil
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCert, err.Error())
+	}
+	p.cert = cert
+	p.pair = &pair
+	return p.pair, nil
+}
@@ -562,3 +562,132 @@ func TestNewTLSClientConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestNewSvcCertTLSClientConfig(t *testing.T) {
+	crt, err := os.ReadFile("../test/data/dummyServer.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := os.ReadFile("../test/data/dummyClient.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKey, err := os.ReadFile("../test/data/dummyClient.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		cfg     config.Config
+		svcCert SvcCertKeyProvider
+	}
+	type test struct {
+		name      string
+		args      args
+		wantErr   bool
+		checkFunc func(*tls.Config) error
+	}
+	tests := []test{
+		func() test {
+			cert, key := crt, []byte(nil)
+			return test{
+				name: "Check the client certificate follows the rotated service certificate",
+				args: args{
+					cfg: config.Config{
+						NToken: config.NToken{
+							PrivateKeyPath: "../test/data/dummyServer.key",
+						},
+						Proxy: config.Proxy{
+							MTLS: config.ProxyMTLS{
+								CAPath: "../test/data/dummyCa.pem",
+							},
+						},
+					},
+					svcCert: func() ([]byte, []byte, error) {
+						return cert, key, nil
+					},
+				},
+				checkFunc: func(got *tls.Config) error {
+					if got.RootCAs == nil {
+						return errors.New("RootCAs is not set")
+					}
+					first, err := got.GetClientCertificate(nil)
+					if err != nil {
+						return err
+					}
+					if second, _ := got.GetClientCertificate(nil); second != first {
+						return errors.New("the client certificate is parsed again without rotation")
+					}
+
+					// the generated private key is provided with the rotated certificate
+					cert, key = rotated, rotatedKey
+					third, err := got.GetClientCertificate(nil)
+					if err != nil {
+						return err
+					}
+					if third == first {
+						return errors.New("the rotated client certificate is not used")
+					}
+					return nil
+				},
+			}
+		}(),
+		{
+			name: "Check the error of the service certificate is returned",
+			args: args{
+				cfg: config.Config{
+					NToken: config.NToken{
+						PrivateKeyPath: "../test/data/dummyServer.key",
+					},
+				},
+				svcCert: func() ([]byte, []byte, error) {
+					return nil, nil, ErrCertNotFound
+				},
+			},
+			checkFunc: func(got *tls.Config) error {
+				if _, err := got.GetClientCertificate(nil); err != ErrCertNotFound {
+					return fmt.Errorf("GetClientCertificate() error = %v, want %v", err, ErrCertNotFound)
+				}
+				return nil
+			},
+		},
+		{
+			name: "Check the invalid CA certificate returns error",
+			args: args{
+				cfg: config.Config{
+					Proxy: config.Proxy{
+						MTLS: config.ProxyMTLS{
+							CAPath: "../test/data/invalid_dummyCa.pem",
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Check the missing private key returns error",
+			args: args{
+				cfg: config.Config{
+					NToken: config.NToken{
+						PrivateKeyPath: "../test/data/not_found.key",
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSvcCertTLSClientConfig(tt.args.cfg, tt.args.svcCert)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSvcCertTLSClientConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.checkFunc != nil {
+				if err := tt.checkFunc(got); err != nil {
+					t.Errorf("NewSvcCertTLSClientConfig() %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,531 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/AthenZ/athenz-client-sidecar/v2/metrics"
+	"github.com/AthenZ/athenz/clients/go/zts"
+	"github.com/ardielle/ardielle-go/rdl"
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// VerifyService represents an interface to automatically refresh the public keys of the Athenz server, and a token verifier function pointer.
+type VerifyService interface {
+	StartKeyUpdater(context.Context) <-chan error
+	RefreshKeys(ctx context.Context) <-chan error
+	GetTokenVerifier() TokenVerifier
+	Readiness() Readiness
+}
+
+// TokenVerifier represents a function pointer to verify the signature, the expiry and the audience of the role token or the access token.
+// The audience is the domain of the token. It returns the claims of the token, or an error wrapping ErrInvalidToken if the verification failed.
+type TokenVerifier func(ctx context.Context, token, audience string) (*TokenClaims, error)
+
+// verifyService represents the implementation of VerifyService
+type verifyService struct {
+	client *zts.ZTSClient
+	group  singleflight.Group
+
+	// issuer represents the issuer of the access tokens without the trailing slash.
+	issuer string
+
+	// keys stores the current publicKeys.
+	keys atomic.Value
+
+	// lastFetch represents the time of the last attempt to fetch the public keys in Unix nanoseconds, regardless of its result.
+	// It limits the refreshes triggered by the unknown key IDs, so that the tokens signed by an unknown key do not flood the Athenz server while it is failing.
+	lastFetch int64
+
+	refreshPeriod time.Duration
+	retryMaxCount int
+	retryInterval time.Duration
+	retryBackoff  backoff
+
+	// lastRefresh records the result of the last key refresh.
+	lastRefresh refreshRecorder
+}
+
+// publicKeys represents the public keys of the Athenz server keyed by the key ID.
+type publicKeys struct {
+	// roleToken represents the ZTS public keys verifying the role tokens.
+	roleToken map[string]crypto.PublicKey
+	// accessToken represents the keys of the JWKS verifying the access tokens.
+	accessToken map[string]crypto.PublicKey
+	// fetched represents the time when the keys are fetched. The zero value implies the keys are not fetched yet.
+	fetched time.Time
+}
+
+var (
+	// defaultKeyRefreshPeriod represents the default duration between the refreshes of the public keys.
+	defaultKeyRefreshPeriod = time.Hour
+
+	// minKeyRefreshInterval represents the minimum duration between the refreshes triggered by the unknown key ID.
+	minKeyRefreshInterval = time.Minute
+
+	// ErrKeyRequestFailed represents an error when failed to fetch the public keys from the Athenz server.
+	ErrKeyRequestFailed = errors.New("Failed to fetch public keys")
+
+	// ybase64 represents the base64 variant encoding the role token signatures and the ZTS public keys.
+	ybase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789._").WithPadding('-')
+)
+
+const (
+	// ztsDomain and ztsService represent the service identity of ZTS, which holds the public keys signing the role tokens.
+	ztsDomain  = "sys.auth"
+	ztsService = "zts"
+
+	// roleTokenSignatureField represents the field of the role token signature, which follows the signed part of the role token.
+	roleTokenSignatureField = ";s="
+)
+
+// NewVerifyService returns a VerifyService to verify the tokens with the public keys of the Athenz server.
+func NewVerifyService(cfg config.Verify) (VerifyService, error) {
+	if !cfg.Enable {
+		return nil, ErrDisabled
+	}
+
+	var (
+		err           error
+		refreshPeriod = defaultKeyRefreshPeriod
+		retryInterval = defaultErrRetryInterval
+	)
+	if cfg.RefreshPeriod != "" {
+		if refreshPeriod, err = time.ParseDuration(cfg.RefreshPeriod); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshPeriod: "+err.Error())
+		}
+	}
+	if cfg.Retry.Delay != "" {
+		if retryInterval, err = time.ParseDuration(cfg.Retry.Delay); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryInterval: "+err.Error())
+		}
+	}
+
+	retryMaxCount := defaultErrRetryMaxCount
+	if cfg.Retry.Attempts > 0 {
+		retryMaxCount = cfg.Retry.Attempts
+	} else if cfg.Retry.Attempts != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
+	}
+	retryBackoff, err := newBackoff(cfg.Retry, defaultErrRetryMaxInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ztsClient(cfg.AthenzURL, config.GetActualValue(cfg.AthenzCAPath))
+	if err != nil {
+		return nil, ErrFailedToInitialize
+	}
+
+	// ZTS issues the access tokens with its URL by default
+	issuer := cfg.Issuer
+	if issuer == "" {
+		issuer = "https://" + strings.TrimPrefix(strings.TrimPrefix(cfg.AthenzURL, "https://"), "http://")
+	}
+
+	v := &verifyService{
+		client:        client,
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		refreshPeriod: refreshPeriod,
+		retryMaxCount: retryMaxCount,
+		retryInterval: retryInterval,
+		retryBackoff:  retryBackoff,
+	}
+	v.keys.Store(publicKeys{})
+	return v, nil
+}
+
+// StartKeyUpdater returns the error channel of the key refreshes.
+// This function will fetch the public keys immediately, and periodically refresh them.
+func (v *verifyService) StartKeyUpdater(ctx context.Context) <-chan error {
+	glg.Info("Starting public key updater")
+
+	ech := make(chan error, 100)
+	go func() {
+		defer close(ech)
+
+		for err := range v.RefreshKeys(ctx) {
+			ech <- errors.Wrap(err, "error update public keys")
+		}
+
+		ticker := time.NewTicker(v.refreshPeriod)
+		for {
+			select {
+			case <-ctx.Done():
+				glg.Info("Stopping public key updater...")
+				ticker.Stop()
+				ech <- ctx.Err()
+				return
+			case <-ticker.C:
+				for err := range v.RefreshKeys(ctx) {
+					ech <- errors.Wrap(err, "error update public keys")
+				}
+			}
+		}
+	}()
+	return ech
+}
+
+// RefreshKeys refreshes the public keys with retry, and returns the error channel.
+func (v *verifyService) RefreshKeys(ctx context.Context) <-chan error {
+	glg.Info("RefreshKeys started")
+
+	echan := make(chan error, v.retryMaxCount+1)
+	go func() {
+		defer close(echan)
+
+		cnt := new(refreshCounter)
+		errs := make([]error, 0, v.retryMaxCount+1)
+		defer func() {
+			cnt.add(errs, v.retryMaxCount)
+			cnt.store(&v.lastRefresh)
+		}()
+
+		for i := 0; i <= v.retryMaxCount; i++ {
+			err := v.updateKeys()
+			if err == nil {
+				return
+			}
+			echan <- err
+			errs = append(errs, err)
+			metrics.RetryFailure(metrics.Verify)
+
+			// the client errors will never succeed by retrying
+			if !retryable(err) || i == v.retryMaxCount {
+				break
+			}
+			if !sleep(ctx, v.retryBackoff.interval(i, v.retryInterval, err)) {
+				return
+			}
+		}
+		metrics.RefreshFailure(metrics.Verify)
+	}()
+
+	return echan
+}
+
+// GetTokenVerifier returns a function pointer to verify the tokens.
+func (v *verifyService) GetTokenVerifier() TokenVerifier {
+	return v.verifyToken
+}
+
+// Readiness returns the readiness of the verify service. It is not ready until the public keys are fetched.
+func (v *verifyService) Readiness() Readiness {
+	if v.keys.Load().(publicKeys).fetched.IsZero() {
+		return Readiness{
+			Message: "public keys are not fetched",
+		}
+	}
+	return v.lastRefresh.readiness()
+}
+
+// verifyToken verifies the role token, which starts with "v=", or the access token.
+func (v *verifyService) verifyToken(ctx context.Context, token, audience string) (*TokenClaims, error) {
+	var (
+		claims *TokenClaims
+		err    error
+	)
+	if strings.HasPrefix(token, "v=") {
+		claims, err = v.verifyRoleToken(token)
+	} else {
+		claims, err = v.verifyAccessToken(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ExpiryTime <= fastime.UnixNow() {
+		return nil, errors.Wrap(ErrInvalidToken, "token is expired")
+	}
+	if claims.Domain != audience {
+		return nil, errors.Wrapf(ErrInvalidToken, "audience %q does not match %q", claims.Domain, audience)
+	}
+	return claims, nil
+}
+
+// verifyRoleToken verifies the signature of the role token, which is the signature of the part before the signature field.
+func (v *verifyService) verifyRoleToken(token string) (*TokenClaims, error) {
+	claims, err := ParseRoleTokenClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	idx := strings.LastIndex(token, roleTokenSignatureField)
+	if idx < 0 {
+		return nil, errors.Wrap(ErrInvalidToken, "role token without signature")
+	}
+	sig, err := ybase64.DecodeString(token[idx+len(roleTokenSignatureField):])
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidToken, "role token signature: %s", err.Error())
+	}
+
+	// the key ID is omitted for the key "0"
+	kid := claims.KeyID
+	if kid == "" {
+		kid = "0"
+	}
+	key, err := v.publicKey(kid, func(k publicKeys) map[string]crypto.PublicKey {
+		return k.roleToken
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(key, crypto.SHA256, []byte(token[:idx]), sig, false); err != nil {
+		return nil, errors.Wrapf(ErrInvalidToken, "role token signature: %s", err.Error())
+	}
+	return claims, nil
+}
+
+// verifyAccessToken verifies the JWS signature and the issuer of the access token.
+func (v *verifyService) verifyAccessToken(token string) (*TokenClaims, error) {
+	claims, err := ParseAccessTokenClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	idx := strings.LastIndex(token, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(token[idx+1:])
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidToken, "access token signature: %s", err.Error())
+	}
+
+	var h accessTokenHeader
+	if err := decodeJWTSegment(token[:strings.Index(token, ".")], &h); err != nil {
+		return nil, errors.Wrapf(ErrInvalidToken, "access token header: %s", err.Error())
+	}
+	var hash crypto.Hash
+	switch h.Algorithm {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	default:
+		return nil, errors.Wrapf(ErrInvalidToken, "unsupported access token algorithm %q", h.Algorithm)
+	}
+
+	key, err := v.publicKey(h.KeyID, func(k publicKeys) map[string]crypto.PublicKey {
+		return k.accessToken
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*ecdsa.PublicKey); ok != strings.HasPrefix(h.Algorithm, "ES") {
+		return nil, errors.Wrapf(ErrInvalidToken, "access token algorithm %q does not match the key %s", h.Algorithm, h.KeyID)
+	}
+	if err := verifySignature(key, hash, []byte(token[:idx]), sig, true); err != nil {
+		return nil, errors.Wrapf(ErrInvalidToken, "access token signature: %s", err.Error())
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != v.issuer {
+		return nil, errors.Wrapf(ErrInvalidToken, "issuer %q does not match %q", claims.Issuer, v.issuer)
+	}
+	return claims, nil
+}
+
+// publicKey returns the public key of the key ID selected by keys.
+// The public keys are refreshed if the key ID is not found, and no fetch of them is attempted within minKeyRefreshInterval.
+func (v *verifyService) publicKey(kid string, keys func(publicKeys) map[string]crypto.PublicKey) (crypto.PublicKey, error) {
+	if key, ok := keys(v.keys.Load().(publicKeys))[kid]; ok {
+		return key, nil
+	}
+	if time.Duration(fastime.UnixNanoNow()-atomic.LoadInt64(&v.lastFetch)) < minKeyRefreshInterval {
+		return nil, errors.Wrapf(ErrInvalidToken, "unknown key ID %q", kid)
+	}
+
+	glg.Infof("public key %q is not found, refreshing public keys", kid)
+	if err := v.updateKeys(); err != nil {
+		return nil, err
+	}
+	if key, ok := keys(v.keys.Load().(publicKeys))[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.Wrapf(ErrInvalidToken, "unknown key ID %q", kid)
+}
+
+// updateKeys fetches the ZTS public keys and the JWKS, and replaces the current public keys with them.
+func (v *verifyService) updateKeys() error {
+	_, err, _ := v.group.Do("keys", func() (interface{}, error) {
+		atomic.StoreInt64(&v.lastFetch, fastime.UnixNanoNow())
+		k, err := v.fetchKeys()
+		if err != nil {
+			return nil, err
+		}
+		v.keys.Store(*k)
+		glg.Debugf("public keys are updated, role token keys: %d, access token keys: %d", len(k.roleToken), len(k.accessToken))
+		return nil, nil
+	})
+	return err
+}
+
+// fetchKeys fetches the public keys of the ZTS service identity, and the JWKS of the access tokens.
+// P.S. Do not call fetchKeys() outside singleflight group.
+func (v *verifyService) fetchKeys() (*publicKeys, error) {
+	start := time.Now()
+	si, err := v.client.GetServiceIdentity(ztsDomain, ztsService)
+	metrics.ObserveAthenzRequest(metrics.Verify, ztsStatusCode(err), start)
+	if err != nil {
+		return nil, keyRequestError(err)
+	}
+
+	rfc := true
+	start = time.Now()
+	jwks, err := v.client.GetJWKList(&rfc)
+	metrics.ObserveAthenzRequest(metrics.Verify, ztsStatusCode(err), start)
+	if err != nil {
+		return nil, keyRequestError(err)
+	}
+
+	k := &publicKeys{
+		roleToken:   make(map[string]crypto.PublicKey, len(si.PublicKeys)),
+		accessToken: make(map[string]crypto.PublicKey, len(jwks.Keys)),
+		fetched:     fastime.Now(),
+	}
+	for _, pk := range si.PublicKeys {
+		key, err := parseYBase64PublicKey(pk.Key)
+		if err != nil {
+			glg.Warnf("failed to parse ZTS public key %s: %s", pk.Id, err.Error())
+			continue
+		}
+		k.roleToken[pk.Id] = key
+	}
+	for _, jwk := range jwks.Keys {
+		key, err := parseJWK(jwk)
+		if err != nil {
+			glg.Warnf("failed to parse JWK %s: %s", jwk.Kid, err.Error())
+			continue
+		}
+		k.accessToken[jwk.Kid] = key
+	}
+	return k, nil
+}
+
+// keyRequestError maps the error of the ZTS client to UpstreamError.
+func keyRequestError(err error) error {
+	if re, ok := err.(rdl.ResourceError); ok {
+		return &UpstreamError{
+			Err:     ErrKeyRequestFailed,
+			Code:    re.Code,
+			Message: re.Message,
+		}
+	}
+	return err
+}
+
+// parseYBase64PublicKey parses the ZTS public key, which is the ybase64 encoded PEM.
+func parseYBase64PublicKey(s string) (crypto.PublicKey, error) {
+	b, err := ybase64.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// parseJWK parses the RSA or EC public key of the JWK.
+func parseJWK(jwk *zts.JWK) (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256", "prime256v1":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+// verifySignature verifies the signature of the message with the RSA or ECDSA public key.
+// The ECDSA signature is the concatenated r and s if raw is true as JWS, otherwise it is ASN.1 DER encoded.
+func verifySignature(key crypto.PublicKey, hash crypto.Hash, msg, sig []byte, raw bool) error {
+	h := hash.New()
+	h.Write(msg)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if raw {
+			if len(sig)%2 != 0 {
+				return errors.New("invalid ECDSA signature length")
+			}
+			r := new(big.Int).SetBytes(sig[:len(sig)/2])
+			s := new(big.Int).SetBytes(sig[len(sig)/2:])
+			if !ecdsa.Verify(k, digest, r, s) {
+				return errors.New("invalid ECDSA signature")
+			}
+			return nil
+		}
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
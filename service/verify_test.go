@@ -0,0 +1,388 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AthenZ/athenz-client-sidecar/v2/config"
+	"github.com/pkg/errors"
+)
+
+func TestNewVerifyService(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.Verify
+		wantIssuer string
+		wantErr    error
+	}{
+		{
+			name:    "Check disabled",
+			cfg:     config.Verify{},
+			wantErr: ErrDisabled,
+		},
+		{
+			name: "Check invalid refresh period",
+			cfg: config.Verify{
+				Enable:        true,
+				RefreshPeriod: "invalid",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `RefreshPeriod: time: invalid duration "invalid"`),
+		},
+		{
+			name: "Check invalid retry attempts",
+			cfg: config.Verify{
+				Enable: true,
+				Retry: config.Retry{
+					Attempts: -1,
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0"),
+		},
+		{
+			name: "Check success",
+			cfg: config.Verify{
+				Enable:        true,
+				AthenzURL:     "athenz.io/zts/v1",
+				RefreshPeriod: "30m",
+			},
+			wantIssuer: "https://athenz.io/zts/v1",
+		},
+		{
+			name: "Check success with issuer",
+			cfg: config.Verify{
+				Enable:        true,
+				AthenzURL:     "athenz.io/zts/v1",
+				Issuer:        "https://zts.athenz.io/zts/v1/",
+				RefreshPeriod: "30m",
+			},
+			wantIssuer: "https://zts.athenz.io/zts/v1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewVerifyService(tt.cfg)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("NewVerifyService() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			v := got.(*verifyService)
+			if v.refreshPeriod != time.Minute*30 || v.issuer != tt.wantIssuer {
+				t.Errorf("NewVerifyService() = %+v", v)
+			}
+			if rd := v.Readiness(); rd.Ready {
+				t.Errorf("Readiness() = %+v, want not ready", rd)
+			}
+		})
+	}
+}
+
+// testZTSIssuer represents the issuer of the test access tokens.
+const testZTSIssuer = "https://zts.athenz.io/zts/v1"
+
+// testSigner represents the ZTS private keys signing the test tokens.
+type testSigner struct {
+	roleTokenKey   *rsa.PrivateKey
+	accessTokenKey *ecdsa.PrivateKey
+	// issuer represents the iss claim of the access tokens.
+	issuer string
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{
+		roleTokenKey:   rk,
+		accessTokenKey: ak,
+		issuer:         testZTSIssuer,
+	}
+}
+
+// roleToken returns the role token signed by the key "0", which is issued an hour before the expiry.
+func (s *testSigner) roleToken(t *testing.T, domain string, expiry time.Time) string {
+	unsigned := fmt.Sprintf("v=Z1;d=%s;r=reader;p=client.service;t=%d;e=%d;k=0", domain, expiry.Add(-time.Hour).Unix(), expiry.Unix())
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.roleTokenKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsigned + ";s=" + ybase64.EncodeToString(sig)
+}
+
+// accessToken returns the access token signed by the key "ec", which is issued an hour before the expiry.
+func (s *testSigner) accessToken(t *testing.T, domain string, expiry time.Time) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	unsigned := enc([]byte(`{"kid":"ec","typ":"at+jwt","alg":"ES256"}`)) + "." +
+		enc([]byte(fmt.Sprintf(`{"aud":%q,"scp":["reader"],"sub":"client.service","iat":%d,"exp":%d,"iss":%q}`, domain, expiry.Add(-time.Hour).Unix(), expiry.Unix(), s.issuer)))
+	digest := sha256.Sum256([]byte(unsigned))
+	r, ss, err := ecdsa.Sign(rand.Reader, s.accessTokenKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	ss.FillBytes(sig[32:])
+	return unsigned + "." + enc(sig)
+}
+
+// newTestVerifyService returns the verify service requesting to the server, which serves the public keys of the signer.
+func newTestVerifyService(t *testing.T, s *testSigner, fail *int32) (*verifyService, *int64) {
+	der, err := x509.MarshalPKIXPublicKey(&s.roleTokenKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := ybase64.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	enc := base64.RawURLEncoding.EncodeToString
+
+	var cnt int64
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&cnt, 1)
+		if fail != nil && atomic.LoadInt32(fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/domain/sys.auth/service/zts":
+			fmt.Fprintf(w, `{"name":"sys.auth.zts","publicKeys":[{"id":"0","key":%q}]}`, pemKey)
+		case "/oauth2/keys":
+			fmt.Fprintf(w, `{"keys":[{"kty":"EC","kid":"ec","alg":"ES256","use":"sig","crv":"P-256","x":%q,"y":%q}]}`,
+				enc(s.accessTokenKey.X.FillBytes(make([]byte, 32))), enc(s.accessTokenKey.Y.FillBytes(make([]byte, 32))))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	svc, err := NewVerifyService(config.Verify{
+		Enable:    true,
+		AthenzURL: srv.URL,
+		Issuer:    testZTSIssuer,
+		Retry: config.Retry{
+			Attempts: 1,
+			Delay:    "1ms",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := svc.(*verifyService)
+	v.client.Transport = srv.Client().Transport
+	return v, &cnt
+}
+
+func Test_verifyService_verifyToken(t *testing.T) {
+	s := newTestSigner(t)
+	v, cnt := newTestVerifyService(t, s, nil)
+	for err := range v.RefreshKeys(context.Background()) {
+		t.Fatalf("RefreshKeys() error = %v", err)
+	}
+
+	exp := time.Now().Add(time.Hour)
+	other := newTestSigner(t)
+	otherIssuer := *s
+	otherIssuer.issuer = "https://other.athenz.io/zts/v1"
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		want     *TokenClaims
+		wantErr  bool
+	}{
+		{
+			name:     "Check role token",
+			token:    s.roleToken(t, "domain", exp),
+			audience: "domain",
+			want: &TokenClaims{
+				Domain:     "domain",
+				Roles:      []string{"reader"},
+				Principal:  "client.service",
+				IssueTime:  exp.Add(-time.Hour).Unix(),
+				ExpiryTime: exp.Unix(),
+				KeyID:      "0",
+			},
+		},
+		{
+			name:     "Check access token",
+			token:    s.accessToken(t, "domain", exp),
+			audience: "domain",
+			want: &TokenClaims{
+				Domain:     "domain",
+				Roles:      []string{"reader"},
+				Principal:  "client.service",
+				IssueTime:  exp.Add(-time.Hour).Unix(),
+				ExpiryTime: exp.Unix(),
+				KeyID:      "ec",
+				Issuer:     testZTSIssuer,
+			},
+		},
+		{
+			name:     "Check access token of other issuer",
+			token:    otherIssuer.accessToken(t, "domain", exp),
+			audience: "domain",
+			wantErr:  true,
+		},
+		{
+			name:     "Check audience mismatch",
+			token:    s.roleToken(t, "domain", exp),
+			audience: "other",
+			wantErr:  true,
+		},
+		{
+			name:     "Check expired token",
+			token:    s.accessToken(t, "domain", time.Now().Add(-time.Minute)),
+			audience: "domain",
+			wantErr:  true,
+		},
+		{
+			name:     "Check role token signed by other key",
+			token:    other.roleToken(t, "domain", exp),
+			audience: "domain",
+			wantErr:  true,
+		},
+		{
+			name:     "Check access token signed by other key",
+			token:    other.accessToken(t, "domain", exp),
+			audience: "domain",
+			wantErr:  true,
+		},
+		{
+			name:     "Check malformed token",
+			token:    "token",
+			audience: "domain",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.GetTokenVerifier()(context.Background(), tt.token, tt.audience)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidToken)) {
+				t.Errorf("verifyToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("verifyToken() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// the keys fetched within minKeyRefreshInterval are not refreshed by the unknown key ID
+	if c := atomic.LoadInt64(cnt); c != 2 {
+		t.Errorf("verifyToken() request count = %v, want 2", c)
+	}
+}
+
+func Test_verifyService_publicKey(t *testing.T) {
+	s := newTestSigner(t)
+	v, cnt := newTestVerifyService(t, s, nil)
+
+	// the keys are fetched on demand
+	if _, err := v.GetTokenVerifier()(context.Background(), s.roleToken(t, "domain", time.Now().Add(time.Hour)), "domain"); err != nil {
+		t.Errorf("verifyToken() error = %v", err)
+	}
+	if c := atomic.LoadInt64(cnt); c != 2 {
+		t.Errorf("verifyToken() request count = %v, want 2", c)
+	}
+
+	// the unknown key ID refreshes the keys after minKeyRefreshInterval
+	roleTokenKeys := func(k publicKeys) map[string]crypto.PublicKey {
+		return k.roleToken
+	}
+	atomic.AddInt64(&v.lastFetch, -int64(minKeyRefreshInterval))
+	if _, err := v.publicKey("unknown", roleTokenKeys); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("publicKey() error = %v, want %v", err, ErrInvalidToken)
+	}
+	if c := atomic.LoadInt64(cnt); c != 4 {
+		t.Errorf("publicKey() request count = %v, want 4", c)
+	}
+}
+
+func Test_verifyService_publicKey_failed(t *testing.T) {
+	fail := int32(1)
+	v, cnt := newTestVerifyService(t, newTestSigner(t), &fail)
+	roleTokenKeys := func(k publicKeys) map[string]crypto.PublicKey {
+		return k.roleToken
+	}
+
+	// the failed fetch is also limited, so that the unknown key IDs do not send a request for every token
+	for i := 0; i < 3; i++ {
+		if _, err := v.publicKey("0", roleTokenKeys); err == nil {
+			t.Errorf("publicKey() error = %v, want error", err)
+		}
+	}
+	if c := atomic.LoadInt64(cnt); c != 1 {
+		t.Errorf("publicKey() request count = %v, want 1", c)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	atomic.AddInt64(&v.lastFetch, -int64(minKeyRefreshInterval))
+	if _, err := v.publicKey("0", roleTokenKeys); err != nil {
+		t.Errorf("publicKey() error = %v", err)
+	}
+}
+
+func Test_verifyService_RefreshKeys(t *testing.T) {
+	var fail int32
+	v, cnt := newTestVerifyService(t, newTestSigner(t), &fail)
+
+	for err := range v.RefreshKeys(context.Background()) {
+		t.Errorf("RefreshKeys() error = %v", err)
+	}
+	if rd := v.Readiness(); !rd.Ready {
+		t.Errorf("Readiness() = %+v, want ready", rd)
+	}
+
+	// the keys failed after retry
+	atomic.StoreInt32(&fail, 1)
+	var errs int
+	for err := range v.RefreshKeys(context.Background()) {
+		var ue *UpstreamError
+		if !errors.Is(err, ErrKeyRequestFailed) || !errors.As(err, &ue) || ue.Code != http.StatusInternalServerError {
+			t.Errorf("RefreshKeys() error = %v, want %v", err, ErrKeyRequestFailed)
+		}
+		errs++
+	}
+	if errs != 2 || atomic.LoadInt64(cnt) != 4 {
+		t.Errorf("RefreshKeys() errors = %v, request count = %v, want 2, 4", errs, atomic.LoadInt64(cnt))
+	}
+	if rd := v.Readiness(); rd.Ready {
+		t.Errorf("Readiness() = %+v, want not ready", rd)
+	}
+}
@@ -18,8 +18,13 @@ package usecase
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AthenZ/athenz-client-sidecar/v2/config"
@@ -32,32 +37,170 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	// defaultPrefetchTimeout represents the default maximum duration of the prefetch, so that the client sidecar becomes ready even if the Athenz server is unavailable.
+	defaultPrefetchTimeout = 30 * time.Second
+
+	// nTokenPollInterval represents the interval to check whether the N-token updater generated the first N-token.
+	nTokenPollInterval = 100 * time.Millisecond
+)
+
 // Tenant represents a client sidecar behavior
 type Tenant interface {
 	Start(ctx context.Context) chan []error
+	Reload(cfg config.Config) error
 }
 
 type clientd struct {
-	cfg     config.Config
-	token   ntokend.TokenService
-	server  service.Server
-	access  service.AccessService
-	role    service.RoleService
-	svccert service.SvcCertService
+	cfg      config.Config
+	token    ntokend.TokenService
+	server   service.Server
+	access   service.AccessService
+	role     service.RoleService
+	idToken  service.IDTokenService
+	svccert  service.SvcCertService
+	roleCert service.RoleCertService
+	awsCreds service.AWSCredentialsService
+	verify   service.VerifyService
+	mux      *serveMux
+
+	// identities represents the services of the additional identities, keyed by the identity name.
+	identities map[string]*components
+
+	// snapshot saves the caches of all the identities to the snapshot file. It is nil if the cache snapshot is disabled.
+	snapshot *service.CacheSnapshot
+
+	// ctx is the context given to Start. The updaters of the reloaded services are started with its child context.
+	ctx context.Context
+	// tokenCancel stops the current N-token updaters.
+	tokenCancel context.CancelFunc
+	// cancel stops the current access token, role token, ID token, svccert, rolecert, AWS credentials and public key updaters.
+	cancel context.CancelFunc
+	// mu guards the services and the contexts above.
+	mu sync.Mutex
+
+	// prefetching is 1 while the prefetch on startup is running. The client sidecar is not ready until it finishes.
+	prefetching int32
+}
+
+// components represents the services and the router created from the configuration.
+type components struct {
+	token    ntokend.TokenService
+	access   service.AccessService
+	role     service.RoleService
+	idToken  service.IDTokenService
+	svccert  service.SvcCertService
+	roleCert service.RoleCertService
+	awsCreds service.AWSCredentialsService
+	verify   service.VerifyService
+	handler  handler.Handler
+	router   http.Handler
+
+	// identities represents the services of the additional identities, keyed by the identity name. Their router is nil.
+	identities map[string]*components
+}
+
+// serveMux is a http.Handler that delegates requests to the current router, which is swapped atomically on reload.
+type serveMux struct {
+	h atomic.Value
+}
+
+// ServeHTTP delegates the request to the current router.
+func (m *serveMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.h.Load().(http.Handler).ServeHTTP(w, r)
 }
 
 // New returns a client sidecar daemon, or any error occurred.
 // Client sidecar daemon contains token service, role token service, host certificate service, user database client and client sidecar service.
 func New(cfg config.Config) (t Tenant, err error) {
+	c, err := newComponents(cfg, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := new(serveMux)
+	mux.h.Store(c.router)
+	cd := &clientd{
+		cfg:        cfg,
+		token:      c.token,
+		access:     c.access,
+		role:       c.role,
+		idToken:    c.idToken,
+		svccert:    c.svccert,
+		roleCert:   c.roleCert,
+		awsCreds:   c.awsCreds,
+		verify:     c.verify,
+		mux:        mux,
+		identities: c.identities,
+	}
+	cd.server = service.NewServer(
+		service.WithServerConfig(cfg.Server),
+		service.WithServerHandler(mux),
+		service.WithReadinessChecker(cd.readiness),
+	)
+
+	if cfg.CacheSnapshot.Enable {
+		cd.snapshot, err = service.NewCacheSnapshot(cfg.CacheSnapshot)
+		if err != nil {
+			return nil, errors.Wrap(err, "cache snapshot error")
+		}
+		// the caches are fetched from Athenz as usual if the snapshot is not available
+		n, err := cd.snapshot.Restore(cd.snapshotTargets())
+		if err != nil {
+			glg.Warnf("failed to restore cache snapshot: %s", err.Error())
+		} else {
+			glg.Infof("%d cache entries are restored from the snapshot", n)
+		}
+	}
+
+	return cd, nil
+}
+
+// newComponents creates the services of the default identity and the additional identities, and the router from the configuration.
+// The given token services are reused if they are not nil, see newServices.
+func newComponents(cfg config.Config, token ntokend.TokenService, identityTokens map[string]ntokend.TokenService) (*components, error) {
+	c, err := newServices(cfg, token)
+	if err != nil {
+		return nil, err
+	}
+
+	h := c.handler
+	if len(cfg.Identities) != 0 {
+		c.identities = make(map[string]*components, len(cfg.Identities))
+		hs := make(map[string]handler.Handler, len(cfg.Identities))
+		for _, id := range cfg.Identities {
+			ic, err := newServices(config.IdentityConfig(cfg, id), identityTokens[id.Name])
+			if err != nil {
+				return nil, errors.Wrapf(err, "identity %s", id.Name)
+			}
+			c.identities[id.Name] = ic
+			hs[id.Name] = ic.handler
+		}
+		h = handler.NewIdentityHandler(h, hs)
+	}
+
+	c.router = router.New(cfg, h)
+	if cfg.Proxy.Enable && cfg.Proxy.Forward.Enable {
+		c.router = router.WithForwardProxy(cfg, h, c.router)
+	}
+	return c, nil
+}
+
+// newServices creates the services and the handler from the configuration.
+// The given token service is reused if it is not nil and N-token is required, otherwise a new token service is created when required.
+func newServices(cfg config.Config, token ntokend.TokenService) (c *components, err error) {
+	c = new(components)
 
 	// create token service
-	var token ntokend.TokenService
 	var tokenProvider ntokend.TokenProvider
 	if requireNtokend(cfg) {
-		token, err = createNtokend(cfg.NToken)
-		if err != nil {
-			return nil, errors.Wrap(err, "ntokend error")
+		if token == nil {
+			token, err = createNtokend(cfg.NToken)
+			if err != nil {
+				return nil, errors.Wrap(err, "ntokend error")
+			}
 		}
+		c.token = token
 		tokenProvider = token.GetTokenProvider()
 		glg.Info("ntokend is enabled. we’re going to use ntoken to interact with Athenz server.")
 	} else {
@@ -65,100 +208,561 @@ func New(cfg config.Config) (t Tenant, err error) {
 	}
 
 	// create access service
-	var access service.AccessService
 	var accessProvider service.AccessProvider
 	if cfg.AccessToken.Enable {
-		access, err = service.NewAccessService(cfg.AccessToken, tokenProvider)
+		c.access, err = service.NewAccessService(cfg.AccessToken, tokenProvider)
 		if err != nil {
 			return nil, errors.Wrap(err, "access token service error")
 		}
-		accessProvider = access.GetAccessProvider()
+		accessProvider = c.access.GetAccessProvider()
 	}
 
 	// create role service
-	var role service.RoleService
 	var roleProvider service.RoleProvider
 	if cfg.RoleToken.Enable {
-		role, err = service.NewRoleService(cfg.RoleToken, tokenProvider)
+		c.role, err = service.NewRoleService(cfg.RoleToken, tokenProvider)
 		if err != nil {
 			return nil, errors.Wrap(err, "role token service error")
 		}
-		roleProvider = role.GetRoleProvider()
+		roleProvider = c.role.GetRoleProvider()
+	}
+
+	// create ID token service
+	var idTokenProvider service.IDTokenProvider
+	if cfg.IDToken.Enable {
+		c.idToken, err = service.NewIDTokenService(cfg.IDToken, tokenProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "ID token service error")
+		}
+		idTokenProvider = c.idToken.GetIDTokenProvider()
 	}
 
 	// create svccert service
-	var svccert service.SvcCertService
-	var svccertProvider service.SvcCertProvider
+	var svccertProvider service.SvcCertKeyProvider
 	if cfg.ServiceCert.Enable {
-		svccert, err = service.NewSvcCertService(cfg, tokenProvider)
+		c.svccert, err = service.NewSvcCertService(cfg, tokenProvider)
 		if err != nil {
 			return nil, errors.Wrap(err, "service certificate service error")
 		}
-		svccertProvider = svccert.GetSvcCertProvider()
+		svccertProvider = c.svccert.GetSvcCertKeyProvider()
+	}
+
+	// create rolecert service
+	var roleCertProvider service.RoleCertProvider
+	if cfg.RoleCert.Enable {
+		c.roleCert, err = service.NewRoleCertService(cfg, tokenProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "role certificate service error")
+		}
+		roleCertProvider = c.roleCert.GetRoleCertProvider()
+	}
+
+	// create AWS credentials service
+	var awsCredsProvider service.AWSCredentialsProvider
+	if cfg.AWSCredentials.Enable {
+		c.awsCreds, err = service.NewAWSCredentialsService(cfg.AWSCredentials, tokenProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "AWS credentials service error")
+		}
+		awsCredsProvider = c.awsCreds.GetAWSCredentialsProvider()
+	}
+
+	// create verify service
+	var verifier service.TokenVerifier
+	if cfg.Verify.Enable {
+		c.verify, err = service.NewVerifyService(cfg.Verify)
+		if err != nil {
+			return nil, errors.Wrap(err, "verify service error")
+		}
+		verifier = c.verify.GetTokenVerifier()
+	}
+
+	// create the TLS config presenting the service certificate to the upstream servers
+	var mtls *tls.Config
+	if cfg.Proxy.Enable && cfg.Proxy.MTLS.Enable && svccertProvider != nil {
+		mtls, err = service.NewSvcCertTLSClientConfig(cfg, svccertProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "mTLS proxy error")
+		}
 	}
 
 	// create handler
-	h := handler.New(
+	c.handler = handler.New(
 		cfg.Proxy,
 		infra.NewBuffer(cfg.Proxy.BufferSize),
-		tokenProvider,
-		accessProvider,
-		roleProvider,
-		svccertProvider,
-	)
-
-	serveMux := router.New(cfg, h)
-	srv := service.NewServer(
-		service.WithServerConfig(cfg.Server),
-		service.WithServerHandler(serveMux),
+		handler.WithNTokenProvider(tokenProvider),
+		handler.WithAccessProvider(accessProvider),
+		handler.WithRoleProvider(roleProvider),
+		handler.WithSvcCertProvider(svccertProvider),
+		handler.WithIDTokenProvider(idTokenProvider),
+		handler.WithRoleCertProvider(roleCertProvider),
+		handler.WithAWSCredentialsProvider(awsCredsProvider),
+		handler.WithTokenVerifier(verifier),
+		handler.WithMTLSConfig(mtls),
 	)
-
-	return &clientd{
-		cfg:     cfg,
-		token:   token,
-		access:  access,
-		role:    role,
-		svccert: svccert,
-		server:  srv,
-	}, nil
+	return c, nil
 }
 
 // Start returns a error slice channel. This error channel contains the error returned by client sidecar daemon.
 func (t *clientd) Start(ctx context.Context) chan []error {
-	if t.token != nil {
-		t.token.StartTokenUpdater(ctx)
+	t.mu.Lock()
+	t.ctx = ctx
+	t.startTokenUpdaters()
+	t.startUpdaters()
+	services := t.services()
+	timeout := prefetchTimeout(t.cfg)
+	t.mu.Unlock()
+
+	// the servers start before the prefetch, so that the liveness probe is answered while the declared tokens are fetched,
+	// and the readiness reports not ready until the prefetch finishes
+	atomic.StoreInt32(&t.prefetching, 1)
+	go func() {
+		defer atomic.StoreInt32(&t.prefetching, 0)
+		wg := new(sync.WaitGroup)
+		for _, c := range services {
+			wg.Add(1)
+			go func(c *components) {
+				defer wg.Done()
+				prefetch(ctx, timeout, c.token, c.access, c.role, c.svccert)
+			}(c)
+		}
+		wg.Wait()
+	}()
+
+	ech := t.server.ListenAndServe(ctx)
+	if t.snapshot == nil {
+		return ech
+	}
+
+	// the snapshot is saved on shutdown before the errors are returned, since the process exits after that
+	sch := t.snapshot.StartSnapshotUpdater(ctx, t.snapshotTargets)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range sch {
+			glg.Errorf("StartSnapshotUpdater error: %s", err.Error())
+		}
+	}()
+	errs := make(chan []error, 1)
+	go func() {
+		e := <-ech
+		<-done
+		errs <- e
+	}()
+	return errs
+}
+
+// Reload validates the new configuration, creates the services and the router from it, and swaps them with the running ones atomically.
+// The cached tokens and certificate are inherited if they are still valid with the new configuration.
+// The running services are kept if any error occurred.
+// Changes on the server configuration, except the request timeout, requires restart to take effect.
+func (t *clientd) Reload(cfg config.Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ctx == nil {
+		return errors.New("client sidecar is not started")
 	}
 
-	// t.svccert only is null when the configuration of ServiceCert is disabled
-	if t.svccert != nil {
-		t.svccert.StartSvcCertUpdater(ctx)
+	// reuse the running token services if the N-token configuration is not changed
+	var token ntokend.TokenService
+	if t.token != nil && t.cfg.NToken == cfg.NToken {
+		token = t.token
+	}
+	oldIdentities := identitiesByName(t.cfg)
+	identityTokens := make(map[string]ntokend.TokenService, len(cfg.Identities))
+	for _, id := range cfg.Identities {
+		old, ok := oldIdentities[id.Name]
+		if oc := t.identities[id.Name]; ok && oc != nil && oc.token != nil && old.NToken == id.NToken {
+			identityTokens[id.Name] = oc.token
+		}
 	}
 
-	// t.access only is null when the configuration of Access is disabled
-	if t.access != nil {
-		go func() {
-			for err := range t.access.StartAccessUpdater(ctx) {
-				if err == ctx.Err() {
-					glg.Info("Stopped access token updater")
-					continue
+	c, err := newComponents(cfg, token, identityTokens)
+	if err != nil {
+		return err
+	}
+
+	if withoutTimeout(t.cfg.Server) != withoutTimeout(cfg.Server) {
+		glg.Warn("server configuration is changed, restart is required to take effect")
+	}
+	if t.cfg.CacheSnapshot != cfg.CacheSnapshot {
+		glg.Warn("cache snapshot configuration is changed, restart is required to take effect")
+	}
+
+	services := t.services()
+	inheritCaches(t.ctx, "", t.cfg, cfg, services[""], c)
+	for _, id := range cfg.Identities {
+		if old, ok := oldIdentities[id.Name]; ok && services[id.Name] != nil {
+			inheritCaches(t.ctx, id.Name, config.IdentityConfig(t.cfg, old), config.IdentityConfig(cfg, id), services[id.Name], c.identities[id.Name])
+		}
+	}
+
+	// the N-token updaters are restarted only if any token service is changed
+	tokenChanged := c.token != t.token || len(c.identities) != len(t.identities)
+	for name, ic := range c.identities {
+		if oc, ok := t.identities[name]; !ok || oc.token != ic.token {
+			tokenChanged = true
+		}
+	}
+
+	// swap the router and the services, then restart the updaters
+	if t.mux != nil {
+		t.mux.h.Store(c.router)
+	}
+	if tokenChanged && t.tokenCancel != nil {
+		t.tokenCancel()
+		t.tokenCancel = nil
+	}
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.cfg = cfg
+	t.token = c.token
+	t.access = c.access
+	t.role = c.role
+	t.idToken = c.idToken
+	t.svccert = c.svccert
+	t.roleCert = c.roleCert
+	t.awsCreds = c.awsCreds
+	t.verify = c.verify
+	t.identities = c.identities
+	if tokenChanged {
+		t.startTokenUpdaters()
+	}
+	t.startUpdaters()
+	for _, c := range t.services() {
+		go prefetch(t.ctx, prefetchTimeout(cfg), c.token, c.access, c.role, c.svccert)
+	}
+
+	glg.Info("client sidecar configuration is reloaded")
+	return nil
+}
+
+// inheritCaches copies the cached tokens and certificate of the identity from the running services to the new services,
+// if they are still valid with the new configuration. The identity name is empty for the default identity.
+func inheritCaches(ctx context.Context, name string, oldCfg, newCfg config.Config, from, to *components) {
+	if from == nil || to == nil || !sameIdentity(oldCfg, newCfg) {
+		return
+	}
+	if name != "" {
+		name = " of identity " + name
+	}
+
+	if to.role != nil && from.role != nil && oldCfg.RoleToken.AthenzURL == newCfg.RoleToken.AthenzURL && oldCfg.RoleToken.CertPath == newCfg.RoleToken.CertPath {
+		glg.Infof("%d role token cache entries%s are inherited", service.InheritRoleTokenCache(ctx, to.role, from.role), name)
+	}
+	if to.access != nil && from.access != nil && oldCfg.AccessToken.AthenzURL == newCfg.AccessToken.AthenzURL && oldCfg.AccessToken.CertPath == newCfg.AccessToken.CertPath {
+		glg.Infof("%d access token cache entries%s are inherited", service.InheritAccessTokenCache(ctx, to.access, from.access), name)
+	}
+	if to.idToken != nil && from.idToken != nil && oldCfg.IDToken.AthenzURL == newCfg.IDToken.AthenzURL && oldCfg.IDToken.CertPath == newCfg.IDToken.CertPath {
+		glg.Infof("%d ID token cache entries%s are inherited", service.InheritIDTokenCache(ctx, to.idToken, from.idToken), name)
+	}
+	if to.roleCert != nil && from.roleCert != nil && oldCfg.NToken.PrivateKeyPath == newCfg.NToken.PrivateKeyPath && oldCfg.RoleCert == newCfg.RoleCert {
+		glg.Infof("%d role certificate cache entries%s are inherited", service.InheritRoleCertCache(ctx, to.roleCert, from.roleCert), name)
+	}
+	if to.awsCreds != nil && from.awsCreds != nil && oldCfg.AWSCredentials.AthenzURL == newCfg.AWSCredentials.AthenzURL && oldCfg.AWSCredentials.CertPath == newCfg.AWSCredentials.CertPath {
+		glg.Infof("%d AWS credentials cache entries%s are inherited", service.InheritAWSCredentialsCache(ctx, to.awsCreds, from.awsCreds), name)
+	}
+	if to.svccert != nil && from.svccert != nil && oldCfg.NToken.PrivateKeyPath == newCfg.NToken.PrivateKeyPath && oldCfg.ServiceCert == newCfg.ServiceCert {
+		if service.InheritSvcCertCache(to.svccert, from.svccert) {
+			glg.Infof("service certificate cache%s is inherited", name)
+		}
+	}
+}
+
+// services returns the services of all the identities keyed by the identity name. The default identity has the empty name. The caller must hold t.mu.
+func (t *clientd) services() map[string]*components {
+	s := make(map[string]*components, len(t.identities)+1)
+	s[""] = &components{
+		token:    t.token,
+		access:   t.access,
+		role:     t.role,
+		idToken:  t.idToken,
+		svccert:  t.svccert,
+		roleCert: t.roleCert,
+		awsCreds: t.awsCreds,
+		verify:   t.verify,
+	}
+	for name, c := range t.identities {
+		s[name] = c
+	}
+	return s
+}
+
+// startTokenUpdaters starts the N-token updaters of all the identities with a new child context of t.ctx. The caller must hold t.mu.
+func (t *clientd) startTokenUpdaters() {
+	var ctx context.Context
+	ctx, t.tokenCancel = context.WithCancel(t.ctx)
+	for _, c := range t.services() {
+		if c.token != nil {
+			c.token.StartTokenUpdater(ctx)
+		}
+	}
+}
+
+// startUpdaters starts the access token, role token, ID token, svccert, rolecert and AWS credentials updaters of all the identities with a new child context of t.ctx. The caller must hold t.mu.
+func (t *clientd) startUpdaters() {
+	var ctx context.Context
+	ctx, t.cancel = context.WithCancel(t.ctx)
+
+	for _, c := range t.services() {
+		// c.svccert only is null when the configuration of ServiceCert is disabled
+		if c.svccert != nil {
+			c.svccert.StartSvcCertUpdater(ctx)
+		}
+
+		// c.access only is null when the configuration of Access is disabled
+		if c.access != nil {
+			go func(access service.AccessService) {
+				for err := range access.StartAccessUpdater(ctx) {
+					if err == ctx.Err() {
+						glg.Info("Stopped access token updater")
+						continue
+					}
+					glg.Errorf("StartAccessUpdater error: %s", err.Error())
+				}
+			}(c.access)
+		}
+
+		if c.role != nil {
+			go func(role service.RoleService) {
+				for err := range role.StartRoleUpdater(ctx) {
+					if err == ctx.Err() {
+						glg.Info("Stopped role token updater")
+						continue
+					}
+					glg.Errorf("StartRoleUpdater error: %s", err.Error())
 				}
-				glg.Errorf("StartAccessUpdater error: %s", err.Error())
+			}(c.role)
+		}
+
+		if c.idToken != nil {
+			go func(idToken service.IDTokenService) {
+				for err := range idToken.StartIDTokenUpdater(ctx) {
+					if err == ctx.Err() {
+						glg.Info("Stopped ID token updater")
+						continue
+					}
+					glg.Errorf("StartIDTokenUpdater error: %s", err.Error())
+				}
+			}(c.idToken)
+		}
+
+		if c.roleCert != nil {
+			go func(roleCert service.RoleCertService) {
+				for err := range roleCert.StartRoleCertUpdater(ctx) {
+					if err == ctx.Err() {
+						glg.Info("Stopped role certificate updater")
+						continue
+					}
+					glg.Errorf("StartRoleCertUpdater error: %s", err.Error())
+				}
+			}(c.roleCert)
+		}
+
+		if c.awsCreds != nil {
+			go func(awsCreds service.AWSCredentialsService) {
+				for err := range awsCreds.StartAWSCredentialsUpdater(ctx) {
+					if err == ctx.Err() {
+						glg.Info("Stopped AWS credentials updater")
+						continue
+					}
+					glg.Errorf("StartAWSCredentialsUpdater error: %s", err.Error())
+				}
+			}(c.awsCreds)
+		}
+
+		if c.verify != nil {
+			go func(verify service.VerifyService) {
+				for err := range verify.StartKeyUpdater(ctx) {
+					if err == ctx.Err() {
+						glg.Info("Stopped public key updater")
+						continue
+					}
+					glg.Errorf("StartKeyUpdater error: %s", err.Error())
+				}
+			}(c.verify)
+		}
+	}
+}
+
+// prefetchTimeout returns the maximum duration of the prefetch in the configuration, or defaultPrefetchTimeout if it is not set.
+func prefetchTimeout(cfg config.Config) time.Duration {
+	if cfg.Server.PrefetchTimeout == "" {
+		return defaultPrefetchTimeout
+	}
+	d, err := time.ParseDuration(cfg.Server.PrefetchTimeout)
+	if err != nil || d <= 0 {
+		glg.Warnf("invalid prefetch timeout %q, %s is used", cfg.Server.PrefetchTimeout, defaultPrefetchTimeout)
+		return defaultPrefetchTimeout
+	}
+	return d
+}
+
+// prefetch fetches the access tokens and role tokens declared in the prefetch configuration and the service certificate, and waits until all of them are fetched or failed after retry, or the timeout passed.
+// The failed tokens are fetched again by the updaters.
+func prefetch(ctx context.Context, timeout time.Duration, token ntokend.TokenService, access service.AccessService, role service.RoleService, svccert service.SvcCertService) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the N-token updater generates the first N-token asynchronously, and it must not be updated concurrently
+	if token != nil && !waitNToken(ctx, token) {
+		glg.Error("N-token for prefetch is not generated before timeout")
+		return
+	}
+
+	wg := new(sync.WaitGroup)
+	if access != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for err := range access.PrefetchAccessTokens(ctx) {
+				glg.Errorf("PrefetchAccessTokens error: %s", err.Error())
 			}
 		}()
 	}
-
-	if t.role != nil {
+	if role != nil {
+		wg.Add(1)
 		go func() {
-			for err := range t.role.StartRoleUpdater(ctx) {
-				if err == ctx.Err() {
-					glg.Info("Stopped role token updater")
-					continue
-				}
-				glg.Errorf("StartRoleUpdater error: %s", err.Error())
+			defer wg.Done()
+			for err := range role.PrefetchRoleTokens(ctx) {
+				glg.Errorf("PrefetchRoleTokens error: %s", err.Error())
+			}
+		}()
+	}
+	// the service certificate is not available for the readiness check until it is fetched
+	if svccert != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svccert.GetSvcCertProvider()(); err != nil {
+				glg.Errorf("failed to fetch service certificate: %s", err.Error())
 			}
 		}()
 	}
-	return t.server.ListenAndServe(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		glg.Warn("prefetch is not completed before timeout, the remaining tokens are fetched by the updaters")
+	}
+}
+
+// waitNToken waits until the N-token updater generates the first N-token, and returns whether the N-token exists.
+func waitNToken(ctx context.Context, token ntokend.TokenService) bool {
+	ticker := time.NewTicker(nTokenPollInterval)
+	defer ticker.Stop()
+	for !token.TokenExists() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// readiness returns the readiness of the enabled subsystems, keyed by the subsystem name.
+// The subsystems of the additional identities are prefixed by the identity name, e.g. "name/ntoken".
+func (t *clientd) readiness() map[string]service.Readiness {
+	t.mu.Lock()
+	services := t.services()
+	t.mu.Unlock()
+
+	rs := make(map[string]service.Readiness, 8*len(services)+1)
+	if atomic.LoadInt32(&t.prefetching) == 1 {
+		rs["prefetch"] = service.Readiness{
+			Message: "prefetch is in progress",
+		}
+	}
+	for name, c := range services {
+		if name != "" {
+			name += "/"
+		}
+		if c.token != nil {
+			rs[name+"ntoken"] = service.NTokenReadiness(c.token)
+		}
+		if c.access != nil {
+			rs[name+"accesstoken"] = c.access.Readiness()
+		}
+		if c.role != nil {
+			rs[name+"roletoken"] = c.role.Readiness()
+		}
+		if c.idToken != nil {
+			rs[name+"idtoken"] = c.idToken.Readiness()
+		}
+		if c.svccert != nil {
+			rs[name+"svccert"] = c.svccert.Readiness()
+		}
+		if c.roleCert != nil {
+			rs[name+"rolecert"] = c.roleCert.Readiness()
+		}
+		if c.awsCreds != nil {
+			rs[name+"awscreds"] = c.awsCreds.Readiness()
+		}
+		if c.verify != nil {
+			rs[name+"verify"] = c.verify.Readiness()
+		}
+	}
+	return rs
+}
+
+// snapshotTargets returns the services of all the identities to save or restore the cache snapshot, keyed by the identity name.
+func (t *clientd) snapshotTargets() map[string]service.SnapshotTarget {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cfgs := identitiesByName(t.cfg)
+	targets := make(map[string]service.SnapshotTarget, len(t.identities)+1)
+	for name, c := range t.services() {
+		cfg := t.cfg
+		if name != "" {
+			cfg = config.IdentityConfig(t.cfg, cfgs[name])
+		}
+		targets[name] = service.SnapshotTarget{
+			Principal: snapshotPrincipal(cfg),
+			Access:    c.access,
+			Role:      c.role,
+			SvcCert:   c.svccert,
+		}
+	}
+	return targets
+}
+
+// snapshotPrincipal returns the credentials of the identity in the configuration, which the cache snapshot entries are bound to.
+func snapshotPrincipal(cfg config.Config) string {
+	return strings.Join([]string{
+		config.GetActualValue(cfg.NToken.AthenzDomain),
+		config.GetActualValue(cfg.NToken.ServiceName),
+		cfg.AccessToken.CertPath,
+		cfg.RoleToken.CertPath,
+	}, ";")
+}
+
+// identitiesByName returns the additional identities in the configuration keyed by the identity name.
+func identitiesByName(cfg config.Config) map[string]config.Identity {
+	ids := make(map[string]config.Identity, len(cfg.Identities))
+	for _, id := range cfg.Identities {
+		ids[id.Name] = id
+	}
+	return ids
+}
+
+// sameIdentity returns whether the client sidecar authenticates to Athenz with the same N-token identity in both configurations.
+func sameIdentity(a, b config.Config) bool {
+	return a.NToken.AthenzDomain == b.NToken.AthenzDomain && a.NToken.ServiceName == b.NToken.ServiceName
+}
+
+// withoutTimeout returns the server configuration without the request timeout, which is applied by the router on reload.
+func withoutTimeout(cfg config.Server) config.Server {
+	cfg.Timeout = ""
+	return cfg
 }
 
 // createNtokend returns a TokenService object or any error
@@ -214,10 +818,22 @@ func requireNtokend(cfg config.Config) bool {
 		glg.Info("Requires ntokend as role token endpoint is enabled, and client certificate is not set")
 		return true
 	}
+	if cfg.IDToken.Enable && cfg.IDToken.CertPath == "" {
+		glg.Info("Requires ntokend as ID token endpoint is enabled, and client certificate is not set")
+		return true
+	}
 	if cfg.ServiceCert.Enable {
 		glg.Info("Requires ntokend as service certificate endpoint is enabled")
 		return true
 	}
+	if cfg.RoleCert.Enable {
+		glg.Info("Requires ntokend as role certificate endpoint is enabled")
+		return true
+	}
+	if cfg.AWSCredentials.Enable && cfg.AWSCredentials.CertPath == "" {
+		glg.Info("Requires ntokend as AWS credentials endpoint is enabled, and client certificate is not set")
+		return true
+	}
 	if cfg.Proxy.Enable {
 		glg.Info("Requires ntokend as proxy endpoint is enabled")
 		return true
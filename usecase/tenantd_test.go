@@ -23,6 +23,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -221,6 +222,109 @@ func TestNew(t *testing.T) {
 				},
 			}
 		}(),
+		func() test {
+			cfg := config.Config{
+				NToken: dummyNTokenConfig,
+				Server: dummyServerConfig,
+				RoleToken: config.RoleToken{
+					Enable:        true,
+					AthenzURL:     "https://athenz.io/zts/v1",
+					Expiry:        "1m",
+					RefreshPeriod: "1m",
+				},
+				Identities: []config.Identity{
+					{
+						Name:   "other",
+						NToken: dummyNTokenConfig,
+					},
+				},
+			}
+
+			return test{
+				name: "Check success with identities",
+				args: args{
+					cfg: cfg,
+				},
+				checkFunc: func(got Tenant) error {
+					other := got.(*clientd).identities["other"]
+					if other == nil || other.token == nil || other.role == nil {
+						return fmt.Errorf("Got: %v", got)
+					}
+					if _, ok := got.(*clientd).readiness()["other/roletoken"]; !ok {
+						return fmt.Errorf("readiness of the identity not found, got: %v", got.(*clientd).readiness())
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			cfg := config.Config{
+				NToken: dummyNTokenConfig,
+				Server: dummyServerConfig,
+				Identities: []config.Identity{
+					{
+						Name: "other",
+						NToken: config.NToken{
+							Enable: true,
+						},
+					},
+				},
+			}
+
+			return test{
+				name: "Check failure when the identity is invalid",
+				args: args{
+					cfg: cfg,
+				},
+				wantErr: fmt.Errorf("identity other: ntokend error: invalid token refresh period , time: invalid duration \"\""),
+			}
+		}(),
+		func() test {
+			cfg := config.Config{
+				NToken: dummyNTokenConfig,
+				Server: dummyServerConfig,
+				CacheSnapshot: config.CacheSnapshot{
+					Enable:  true,
+					Path:    "../test/data/non_exist.snapshot",
+					KeyPath: "../test/data/dummyServer.key",
+				},
+			}
+
+			return test{
+				name: "Check success with cache snapshot",
+				args: args{
+					cfg: cfg,
+				},
+				checkFunc: func(got Tenant) error {
+					if got.(*clientd).snapshot == nil {
+						return fmt.Errorf("Got: %v", got)
+					}
+					if _, ok := got.(*clientd).snapshotTargets()[""]; !ok {
+						return fmt.Errorf("snapshot target of the default identity not found")
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			cfg := config.Config{
+				NToken: dummyNTokenConfig,
+				Server: dummyServerConfig,
+				CacheSnapshot: config.CacheSnapshot{
+					Enable:  true,
+					Path:    "../test/data/non_exist.snapshot",
+					KeyPath: "../test/data/non_exist.key",
+				},
+			}
+
+			return test{
+				name: "Check failure when the cache snapshot key file does not exist",
+				args: args{
+					cfg: cfg,
+				},
+				wantErr: fmt.Errorf("cache snapshot error: KeyPath: open ../test/data/non_exist.key: no such file or directory: Invalid config"),
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -348,10 +452,10 @@ func Test_clientd_Start(t *testing.T) {
 					h := handler.New(
 						cfg.Proxy,
 						infra.NewBuffer(cfg.Proxy.BufferSize),
-						token.GetTokenProvider(),
-						access.GetAccessProvider(),
-						role.GetRoleProvider(),
-						svccert.GetSvcCertProvider(),
+						handler.WithNTokenProvider(token.GetTokenProvider()),
+						handler.WithAccessProvider(access.GetAccessProvider()),
+						handler.WithRoleProvider(role.GetRoleProvider()),
+						handler.WithSvcCertProvider(svccert.GetSvcCertKeyProvider()),
 					)
 
 					serveMux := router.New(cfg, h)
@@ -426,6 +530,12 @@ func Test_clientd_Start(t *testing.T) {
 							}()
 							return errCh
 						},
+						PrefetchAccessTokensFunc: func(ctx context.Context) <-chan error {
+							errCh := make(chan error, 1)
+							errCh <- errors.New("dummy error in PrefetchAccessTokensFunc")
+							close(errCh)
+							return errCh
+						},
 					}
 					role := &service.RoleServiceMock{
 						StartRoleUpdaterFunc: func(ctx context.Context) <-chan error {
@@ -435,15 +545,17 @@ func Test_clientd_Start(t *testing.T) {
 							}()
 							return errCh
 						},
+						PrefetchRoleTokensFunc: func(ctx context.Context) <-chan error {
+							errCh := make(chan error, 1)
+							errCh <- errors.New("dummy error in PrefetchRoleTokensFunc")
+							close(errCh)
+							return errCh
+						},
 					}
 
 					h := handler.New(
 						cfg.Proxy,
 						infra.NewBuffer(cfg.Proxy.BufferSize),
-						nil,
-						nil,
-						nil,
-						nil,
 					)
 
 					serveMux := router.New(cfg, h)
@@ -508,6 +620,320 @@ func Test_clientd_Start(t *testing.T) {
 	}
 }
 
+func Test_clientd_Reload(t *testing.T) {
+	dummyNTokenConfig := config.NToken{
+		Enable:         true,
+		AthenzDomain:   "dummyDomain",
+		ServiceName:    "dummyService",
+		PrivateKeyPath: "../test/data/dummyServer.key",
+		RefreshPeriod:  "1m",
+		KeyVersion:     "1",
+		Expiry:         "1m",
+	}
+	dummyCfg := config.Config{
+		NToken: dummyNTokenConfig,
+		Server: config.Server{
+			ShutdownTimeout: "10s",
+			ShutdownDelay:   "10s",
+		},
+		RoleToken: config.RoleToken{
+			Enable: true,
+		},
+	}
+
+	type args struct {
+		cfg config.Config
+	}
+	type test struct {
+		name      string
+		args      args
+		start     bool
+		checkFunc func(before, after *clientd) error
+		wantErr   error
+	}
+	tests := []test{
+		{
+			name: "Check error when the daemon is not started",
+			args: args{
+				cfg: dummyCfg,
+			},
+			wantErr: fmt.Errorf("client sidecar is not started"),
+		},
+		func() test {
+			cfg := dummyCfg
+			cfg.RoleToken = config.RoleToken{
+				Enable: true,
+				Expiry: "invalid_rt_exp",
+			}
+			return test{
+				name: "Check running services are kept when the new config is invalid",
+				args: args{
+					cfg: cfg,
+				},
+				start: true,
+				checkFunc: func(before, after *clientd) error {
+					if after.role != before.role || after.cfg.RoleToken.Expiry != "" {
+						return fmt.Errorf("role service is swapped")
+					}
+					return nil
+				},
+				wantErr: fmt.Errorf(`role token service error: Expiry: time: invalid duration "invalid_rt_exp": Invalid config`),
+			}
+		}(),
+		func() test {
+			cfg := dummyCfg
+			cfg.RoleToken = config.RoleToken{
+				Enable: true,
+				Expiry: "1h",
+			}
+			cfg.AccessToken = config.AccessToken{
+				Enable: true,
+			}
+			return test{
+				name: "Check services are swapped and token service is reused",
+				args: args{
+					cfg: cfg,
+				},
+				start: true,
+				checkFunc: func(before, after *clientd) error {
+					if after.role == before.role || after.cfg.RoleToken.Expiry != "1h" {
+						return fmt.Errorf("role service is not swapped")
+					}
+					if after.access == nil {
+						return fmt.Errorf("access service is not created")
+					}
+					if after.token != before.token {
+						return fmt.Errorf("token service is not reused")
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			cfg := dummyCfg
+			cfg.NToken.Expiry = "2m"
+			return test{
+				name: "Check token service is recreated when N-token config is changed",
+				args: args{
+					cfg: cfg,
+				},
+				start: true,
+				checkFunc: func(before, after *clientd) error {
+					if after.token == before.token {
+						return fmt.Errorf("token service is reused")
+					}
+					return nil
+				},
+			}
+		}(),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant, err := New(dummyCfg)
+			if err != nil {
+				t.Errorf("New() error: %v", err)
+				return
+			}
+			cd := tenant.(*clientd)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.start {
+				// start the updaters only, without listening
+				cd.ctx = ctx
+				cd.startUpdaters()
+			}
+			before := &clientd{
+				cfg:   cd.cfg,
+				token: cd.token,
+				role:  cd.role,
+			}
+
+			err = cd.Reload(tt.args.cfg)
+			if err != nil {
+				if tt.wantErr == nil || tt.wantErr.Error() != err.Error() {
+					t.Errorf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+			} else if tt.wantErr != nil {
+				t.Errorf("Reload() error = nil, wantErr %v", tt.wantErr)
+				return
+			}
+
+			if tt.checkFunc != nil {
+				if err := tt.checkFunc(before, cd); err != nil {
+					t.Errorf("Reload() check failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_clientd_readiness(t *testing.T) {
+	type fields struct {
+		access      service.AccessService
+		role        service.RoleService
+		prefetching int32
+	}
+	type test struct {
+		name   string
+		fields fields
+		want   map[string]service.Readiness
+	}
+	tests := []test{
+		{
+			name: "readiness of the enabled subsystems",
+			fields: fields{
+				access: &service.AccessServiceMock{
+					ReadinessFunc: func() service.Readiness {
+						return service.Readiness{Ready: true}
+					},
+				},
+				role: &service.RoleServiceMock{
+					ReadinessFunc: func() service.Readiness {
+						return service.Readiness{Message: "dummy"}
+					},
+				},
+			},
+			want: map[string]service.Readiness{
+				"accesstoken": {
+					Ready: true,
+				},
+				"roletoken": {
+					Message: "dummy",
+				},
+			},
+		},
+		{
+			name:   "no subsystems enabled",
+			fields: fields{},
+			want:   map[string]service.Readiness{},
+		},
+		{
+			name: "not ready while prefetching",
+			fields: fields{
+				prefetching: 1,
+			},
+			want: map[string]service.Readiness{
+				"prefetch": {
+					Message: "prefetch is in progress",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientd{
+				access:      tt.fields.access,
+				role:        tt.fields.role,
+				prefetching: tt.fields.prefetching,
+			}
+			if got := c.readiness(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clientd.readiness() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_prefetchTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want time.Duration
+	}{
+		{
+			name: "default",
+			want: defaultPrefetchTimeout,
+		},
+		{
+			name: "configured",
+			cfg: config.Config{
+				Server: config.Server{
+					PrefetchTimeout: "5s",
+				},
+			},
+			want: 5 * time.Second,
+		},
+		{
+			name: "invalid",
+			cfg: config.Config{
+				Server: config.Server{
+					PrefetchTimeout: "invalid",
+				},
+			},
+			want: defaultPrefetchTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prefetchTimeout(tt.cfg); got != tt.want {
+				t.Errorf("prefetchTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// tokenMock represents the N-token service, whose N-token is generated by the updater after the delay.
+type tokenMock struct {
+	ntokend.TokenService
+	exists  int32
+	updates int32
+}
+
+func (t *tokenMock) StartTokenUpdater(ctx context.Context) ntokend.TokenService {
+	time.AfterFunc(200*time.Millisecond, func() {
+		atomic.StoreInt32(&t.exists, 1)
+	})
+	return t
+}
+
+func (t *tokenMock) Update() error {
+	atomic.AddInt32(&t.updates, 1)
+	return nil
+}
+
+func (t *tokenMock) TokenExists() bool {
+	return atomic.LoadInt32(&t.exists) == 1
+}
+
+func Test_prefetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		start   bool
+		want    time.Duration
+	}{
+		{
+			name:    "Check prefetch times out without N-token",
+			timeout: 100 * time.Millisecond,
+			want:    100 * time.Millisecond,
+		},
+		{
+			name:    "Check prefetch waits for the N-token of the updater",
+			timeout: time.Second,
+			start:   true,
+			want:    200 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := new(tokenMock)
+			if tt.start {
+				token.StartTokenUpdater(context.Background())
+			}
+
+			start := time.Now()
+			prefetch(context.Background(), tt.timeout, token, nil, nil, nil)
+			if d := time.Since(start); d < tt.want || d > tt.want+500*time.Millisecond {
+				t.Errorf("prefetch() took %v, want %v", d, tt.want)
+			}
+			if n := atomic.LoadInt32(&token.updates); n != 0 {
+				t.Errorf("prefetch() updated N-token %d times, want 0", n)
+			}
+		})
+	}
+}
+
 func Test_createNtokend(t *testing.T) {
 	type args struct {
 		cfg config.NToken